@@ -0,0 +1,138 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// accessTokenPrefixLength is the number of the first characters of a token shown to recognize it
+const accessTokenPrefixLength = 8
+
+// AccessTokenInput struct for data needed when user creates a personal access token
+type AccessTokenInput struct {
+	Name   string   `json:"name" binding:"required"`
+	Scopes []string `json:"scopes" binding:"required"`
+	// the token never expires if it is not set
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreatedAccessToken struct holds a new personal access token, the token is only shown once
+type CreatedAccessToken struct {
+	models.AccessToken
+	Token string `json:"token"`
+}
+
+// CreateAccessTokenHandler creates a personal access token for the user
+func (a *App) CreateAccessTokenHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	var input AccessTokenInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read access token data"))
+	}
+
+	if strings.TrimSpace(input.Name) == "" {
+		return nil, BadRequest(errors.New("access token name is required"))
+	}
+
+	if len(input.Scopes) == 0 {
+		return nil, BadRequest(errors.New("access token needs at least one scope"))
+	}
+	for _, scope := range input.Scopes {
+		if !internal.Contains(models.AccessTokenScopes, scope) {
+			return nil, BadRequest(fmt.Errorf("invalid access token scope '%s'", scope))
+		}
+	}
+
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		return nil, BadRequest(errors.New("access token expiry must be in the future"))
+	}
+
+	token, hash, err := internal.GenerateAccessToken()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	accessToken := models.AccessToken{
+		UserID:    userID,
+		Name:      strings.TrimSpace(input.Name),
+		Prefix:    token[:len(internal.AccessTokenPrefix)+accessTokenPrefixLength],
+		Hash:      hash,
+		Scopes:    input.Scopes,
+		ExpiresAt: input.ExpiresAt,
+	}
+
+	err = a.db.CreateAccessToken(&accessToken)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Access token is created successfully, copy it now as it won't be shown again",
+		Data:    CreatedAccessToken{AccessToken: accessToken, Token: token},
+	}, Created()
+}
+
+// ListAccessTokensHandler lists the personal access tokens of the user
+func (a *App) ListAccessTokensHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	tokens, err := a.db.ListAccessTokens(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Access tokens are found",
+		Data:    tokens,
+	}, Ok()
+}
+
+// DeleteAccessTokenHandler revokes a personal access token of the user
+func (a *App) DeleteAccessTokenHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read access token id"))
+	}
+
+	token, err := a.db.GetAccessToken(id)
+	if err == gorm.ErrRecordNotFound || token.UserID != userID {
+		return nil, NotFound(errors.New("access token is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.db.DeleteAccessToken(token.ID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Access token is revoked successfully",
+		Data:    nil,
+	}, Ok()
+}
@@ -0,0 +1,168 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessTokensHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
+	assert.NoError(t, err)
+
+	createRequest := func(input AccessTokenInput) authHandlerConfig {
+		body, err := json.Marshal(input)
+		assert.NoError(t, err)
+
+		return authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: app.CreateAccessTokenHandler,
+				api:         fmt.Sprintf("/%s/user/access_tokens", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+	}
+
+	var created struct {
+		Data CreatedAccessToken `json:"data"`
+	}
+
+	t.Run("Create access token: invalid scope", func(t *testing.T) {
+		response := authorizedHandler(createRequest(AccessTokenInput{Name: "ci", Scopes: []string{"root"}}))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Create access token: expiry in the past", func(t *testing.T) {
+		expiresAt := time.Now().Add(-time.Hour)
+		response := authorizedHandler(createRequest(AccessTokenInput{Name: "ci", Scopes: []string{models.ScopeRead}, ExpiresAt: &expiresAt}))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Create access token: success", func(t *testing.T) {
+		response := authorizedHandler(createRequest(AccessTokenInput{Name: "ci", Scopes: []string{models.ScopeRead}}))
+		assert.Equal(t, http.StatusCreated, response.Code)
+
+		err := json.Unmarshal(response.Body.Bytes(), &created)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, created.Data.Token)
+	})
+
+	listRequest := authHandlerConfig{
+		unAuthHandlerConfig: unAuthHandlerConfig{
+			handlerFunc: app.ListAccessTokensHandler,
+			api:         fmt.Sprintf("/%s/user/access_tokens", app.config.Version),
+		},
+		config: app.config,
+		db:     app.db,
+	}
+
+	t.Run("List access tokens: success", func(t *testing.T) {
+		listRequest.token = token
+		response := authorizedHandler(listRequest)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), created.Data.Prefix)
+		assert.NotContains(t, response.Body.String(), created.Data.Token)
+	})
+
+	t.Run("List access tokens: with the access token", func(t *testing.T) {
+		// access tokens can't read the tokens of the account
+		listRequest.token = created.Data.Token
+		response := authorizedHandler(listRequest)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("List access tokens: access token without read scope", func(t *testing.T) {
+		response := authorizedHandler(createRequest(AccessTokenInput{Name: "deployer", Scopes: []string{models.ScopeDeploy}}))
+		assert.Equal(t, http.StatusCreated, response.Code)
+
+		var deployer struct {
+			Data CreatedAccessToken `json:"data"`
+		}
+		err := json.Unmarshal(response.Body.Bytes(), &deployer)
+		assert.NoError(t, err)
+
+		listRequest.token = deployer.Data.Token
+		response = authorizedHandler(listRequest)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("Admin requests: read only access token of an admin", func(t *testing.T) {
+		admin := models.User{Name: "admin", Email: "tokens-admin@gmail.com", Verified: true, Roles: []string{models.SuperAdminRole}}
+		err := app.db.CreateUser(&admin)
+		assert.NoError(t, err)
+
+		adminToken, _, err := app.startSession(admin.ID.String(), admin.Email, "", "")
+		assert.NoError(t, err)
+
+		createToken := func(scopes ...string) string {
+			req := createRequest(AccessTokenInput{Name: "admin script", Scopes: scopes})
+			req.token = adminToken
+			response := authorizedHandler(req)
+			assert.Equal(t, http.StatusCreated, response.Code)
+
+			var res struct {
+				Data CreatedAccessToken `json:"data"`
+			}
+			err := json.Unmarshal(response.Body.Bytes(), &res)
+			assert.NoError(t, err)
+			return res.Data.Token
+		}
+
+		usersRequest := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.GetAllUsersHandler,
+				api:         fmt.Sprintf("/%s/user/all", app.config.Version),
+			},
+			permission: models.PermissionReadUsers,
+			config:     app.config,
+			db:         app.db,
+		}
+
+		usersRequest.token = createToken(models.ScopeRead)
+		response := adminHandler(usersRequest)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+
+		usersRequest.token = createToken(models.ScopeRead, models.ScopeAdmin)
+		response = adminHandler(usersRequest)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("Delete access token: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.DeleteAccessTokenHandler,
+				api:         fmt.Sprintf("/%s/user/access_tokens/%d", app.config.Version, created.Data.ID),
+			},
+			token:  token,
+			varID:  created.Data.ID,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		response = authorizedHandler(req)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+
+		listRequest.token = created.Data.Token
+		response = authorizedHandler(listRequest)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	})
+}
@@ -2,6 +2,7 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,21 +13,20 @@ import (
 	"github.com/codescalers/cloud4students/internal"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/rs/zerolog/log"
-	"gopkg.in/validator.v2"
 	"gorm.io/gorm"
 )
 
-// AdminAnnouncement struct for data needed when admin sends new announcement
-type AdminAnnouncement struct {
-	Subject string `json:"subject"  binding:"required"`
-	Body    string `json:"announcement" binding:"required"`
-}
-
 // UpdateMaintenanceInput struct for data needed when user update maintenance
 type UpdateMaintenanceInput struct {
 	ON bool `json:"on" binding:"required"`
 }
 
+// MaintenanceStatus struct holds the maintenance status with the public announcements
+type MaintenanceStatus struct {
+	models.Maintenance
+	Announcements []AnnouncementFeedItem `json:"announcements"`
+}
+
 // SetAdminInput struct for setting users as admins
 type SetAdminInput struct {
 	Email string `json:"email" binding:"required"`
@@ -89,6 +89,24 @@ func (a *App) GetBalanceHandler(req *http.Request) (interface{}, Response) {
 	}, Ok()
 }
 
+// GetLeadersHandler returns the instances leading the background jobs
+func (a *App) GetLeadersHandler(req *http.Request) (interface{}, Response) {
+	leaders := map[string]string{}
+	for _, role := range []string{workerRole, schedulerRole} {
+		instance, err := a.redis.Leader(role)
+		if err != nil {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+		leaders[role] = instance
+	}
+
+	return ResponseMsg{
+		Message: "Leaders are found",
+		Data:    leaders,
+	}, Ok()
+}
+
 func (a *App) ResetUsersQuota(req *http.Request) (interface{}, Response) {
 	users, err := a.db.ListAllUsers()
 	if err == gorm.ErrRecordNotFound || len(users) == 0 {
@@ -269,13 +287,19 @@ func (a *App) GetMaintenanceHandler(req *http.Request) (interface{}, Response) {
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	announcements, err := a.publicAnnouncementsFeed()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
 	return ResponseMsg{
 		Message: fmt.Sprintf("Maintenance is set with %v", maintenance.Active),
-		Data:    maintenance,
+		Data:    MaintenanceStatus{Maintenance: maintenance, Announcements: announcements},
 	}, Ok()
 }
 
-// SetAdmin sets a user as an admin
+// SetAdmin gives a user the super admin role or removes it
 func (a *App) SetAdmin(req *http.Request) (interface{}, Response) {
 	input := SetAdminInput{}
 	err := json.NewDecoder(req.Body).Decode(&input)
@@ -294,26 +318,26 @@ func (a *App) SetAdmin(req *http.Request) (interface{}, Response) {
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
-	if user.Admin && input.Admin {
+	superAdmin := internal.Contains(user.Roles, models.SuperAdminRole)
+	if superAdmin && input.Admin {
 		return ResponseMsg{
 			Message: "User is already an admin",
 		}, Ok()
 	}
 
-	if !user.Admin && !input.Admin {
+	if !superAdmin && !input.Admin {
 		return ResponseMsg{
 			Message: "User is not already an admin",
 		}, Ok()
 	}
 
-	err = a.db.UpdateAdminUserByID(user.ID.String(), input.Admin)
-	if err == gorm.ErrRecordNotFound {
-		return nil, NotFound(errors.New("user is not found"))
+	roles := withoutRole(user.Roles, models.SuperAdminRole)
+	if input.Admin {
+		roles = append(roles, models.SuperAdminRole)
 	}
 
-	if err != nil {
-		log.Error().Err(err).Send()
-		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	if res := a.updateUserRoles(user, roles); res != nil {
+		return nil, res
 	}
 
 	return ResponseMsg{
@@ -322,14 +346,15 @@ func (a *App) SetAdmin(req *http.Request) (interface{}, Response) {
 }
 
 // NotifyAdmins is used to notify admins that there are new vouchers requests
-func (a *App) notifyAdmins() {
+func (a *App) notifyAdmins(ctx context.Context) {
 	ticker := time.NewTicker(time.Hour * time.Duration(a.config.NotifyAdminsIntervalHours))
+	defer ticker.Stop()
 
-	for range ticker.C {
-		// get admins
-		admins, err := a.db.ListAdmins()
-		if err != nil {
-			log.Error().Err(err).Send()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 
 		// check pending voucher requests
@@ -339,14 +364,14 @@ func (a *App) notifyAdmins() {
 		}
 
 		if len(pending) > 0 {
-			subject, body := internal.NotifyAdminsMailContent(len(pending), a.config.Server.Host)
-
-			for _, admin := range admins {
-				err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, admin.Email, subject, body)
-				if err != nil {
-					log.Error().Err(err).Send()
-				}
+			reviewers, err := a.db.ListUsersWithPermission(models.PermissionReviewVouchers)
+			if err != nil {
+				log.Error().Err(err).Send()
 			}
+
+			a.queueAdminsMail(reviewers, internal.PendingVouchersMail, func(t internal.MailTemplate) (string, string, error) {
+				return internal.NotifyAdminsMailContent(t, len(pending), a.config.Server.Host)
+			})
 		}
 
 		// check account balance
@@ -356,60 +381,44 @@ func (a *App) notifyAdmins() {
 		}
 
 		if int(balance) < a.config.BalanceThreshold {
-			subject, body := internal.NotifyAdminsMailLowBalanceContent(balance, a.config.Server.Host)
-
-			for _, admin := range admins {
-				err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, admin.Email, subject, body)
-				if err != nil {
-					log.Error().Err(err).Send()
-				}
+			admins, err := a.db.ListUsersWithPermission(models.PermissionReadBalance)
+			if err != nil {
+				log.Error().Err(err).Send()
 			}
+
+			a.notifyLowBalance(admins, balance)
 		}
 	}
 }
 
-// CreateNewAnnouncement creates a new administrator announcement and sends it to all users as an email and notification
-func (a *App) CreateNewAnnouncement(req *http.Request) (interface{}, Response) {
-	var adminAnnouncement AdminAnnouncement
-	err := json.NewDecoder(req.Body).Decode(&adminAnnouncement)
-
-	if err != nil {
-		log.Error().Err(err).Send()
-		return nil, BadRequest(errors.New("failed to read announcement data"))
-	}
-
-	err = validator.Validate(adminAnnouncement)
-	if err != nil {
-		log.Error().Err(err).Send()
-		return nil, BadRequest(errors.New("invalid announcement data"))
-	}
-
-	users, err := a.db.ListAllUsers()
-	if err == gorm.ErrRecordNotFound || len(users) == 0 {
-		return ResponseMsg{
-			Message: "Users are not found",
-			Data:    users,
-		}, Ok()
+// notifyLowBalance notifies the admins who can read the balance on the channels they prefer for expiry warnings,
+// the deployments expire once the account can't pay for them
+func (a *App) notifyLowBalance(admins []models.User, balance float64) {
+	n := models.Notification{
+		Msg:  fmt.Sprintf("The account balance %v is below the threshold %d, deployments expire if it runs out", balance, a.config.BalanceThreshold),
+		Type: models.BalanceType,
 	}
+	data := map[string]interface{}{"balance": balance, "threshold": a.config.BalanceThreshold}
 
-	for _, user := range users {
-		subject, body := internal.AdminAnnouncementMailContent(adminAnnouncement.Subject, adminAnnouncement.Body, a.config.Server.Host, user.Name)
-
-		err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, user.Email, subject, body)
+	for _, admin := range admins {
+		err := a.notify(admin, n, models.EventBalanceLow, data, internal.LowBalanceMail, func(t internal.MailTemplate) (string, string, error) {
+			return internal.NotifyAdminsMailLowBalanceContent(t, balance, a.config.Server.Host)
+		})
 		if err != nil {
-			log.Error().Err(err).Send()
-			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+			log.Error().Err(err).Msgf("failed to notify admin %s with low balance", admin.ID.String())
 		}
+	}
+}
 
-		notification := models.Notification{UserID: user.UserID, Msg: fmt.Sprintf("Announcement: %s", adminAnnouncement.Body)}
-		err = a.db.CreateNotification(&notification)
+// queueAdminsMail queues a mail to all admins, rendered in the language of each admin
+func (a *App) queueAdminsMail(admins []models.User, name string, content func(internal.MailTemplate) (string, string, error)) {
+	for _, admin := range admins {
+		subject, body, err := content(a.mailTemplate(name, admin.Language))
+		if err == nil {
+			err = a.db.EnqueueMail(admin.Email, subject, body)
+		}
 		if err != nil {
 			log.Error().Err(err).Send()
-			return nil, InternalServerError(errors.New(internalServerErrorMsg))
 		}
 	}
-
-	return ResponseMsg{
-		Message: "new announcement is sent successfully",
-	}, Created()
 }
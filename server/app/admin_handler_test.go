@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"testing"
 
-	"github.com/codescalers/cloud4students/internal"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/stretchr/testify/assert"
 )
@@ -19,7 +18,7 @@ func TestGetAllUsersHandler(t *testing.T) {
 		Name:     "admin",
 		Email:    "admin@gmail.com",
 		Verified: true,
-		Admin:    true,
+		Roles:    []string{models.SuperAdminRole},
 	}
 	err := app.db.CreateUser(&admin)
 	assert.NoError(t, err)
@@ -27,7 +26,7 @@ func TestGetAllUsersHandler(t *testing.T) {
 	user, err := app.db.GetUserByEmail(admin.Email)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
 	t.Run("Get all users: success", func(t *testing.T) {
@@ -59,7 +58,7 @@ func TestGetAllUsersHandler(t *testing.T) {
 		user, err := app.db.GetUserByEmail(u.Email)
 		assert.NoError(t, err)
 
-		token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+		token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 		assert.NoError(t, err)
 
 		req := authHandlerConfig{
@@ -74,10 +73,12 @@ func TestGetAllUsersHandler(t *testing.T) {
 			db:     app.db,
 		}
 
+		req.permission = models.PermissionReadUsers
+
 		response := adminHandler(req)
-		want := `{"err":"user 'name' doesn't have an admin access"}` + "\n"
+		want := `{"err":"user 'name' doesn't have the 'users:read' permission"}` + "\n"
 		assert.Equal(t, response.Body.String(), want)
-		assert.Equal(t, response.Code, http.StatusUnauthorized)
+		assert.Equal(t, response.Code, http.StatusForbidden)
 	})
 
 	t.Run("Get maintenance: success", func(t *testing.T) {
@@ -155,7 +156,7 @@ func TestCreateNewAnnouncement(t *testing.T) {
 		Name:     "admin",
 		Email:    "admin@gmail.com",
 		Verified: true,
-		Admin:    true,
+		Roles:    []string{models.SuperAdminRole},
 	}
 	err := app.db.CreateUser(&admin)
 	assert.NoError(t, err)
@@ -163,7 +164,7 @@ func TestCreateNewAnnouncement(t *testing.T) {
 	user, err := app.db.GetUserByEmail(admin.Email)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 	t.Run("announcement created successfully", func(t *testing.T) {
 		adminAnnouncement := []byte(`{
@@ -0,0 +1,293 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/validator.v2"
+	"gorm.io/gorm"
+)
+
+const (
+	// announcementsInterval is the interval of checking for due announcements
+	announcementsInterval = 30 * time.Second
+	// announcementsBatchSize is the number of users an announcement is sent to before saving its progress
+	announcementsBatchSize = 50
+	// announcementClaimTimeout is the time after which an announcement claimed by a stopped worker is resumed
+	announcementClaimTimeout = 5 * time.Minute
+)
+
+// AdminAnnouncement struct for data needed when admin sends new announcement
+type AdminAnnouncement struct {
+	Subject string `json:"subject"  binding:"required" validate:"nonzero"`
+	Body    string `json:"announcement" binding:"required" validate:"nonzero"`
+	// targeted users, all verified users are targeted if no filter is set
+	College           string   `json:"college"`
+	MinTeamSize       int      `json:"min_team_size" validate:"min=0"`
+	MaxTeamSize       int      `json:"max_team_size" validate:"min=0"`
+	ActiveDeployments bool     `json:"active_deployments"`
+	Emails            []string `json:"emails"`
+	// the announcement is sent now if send time is not set
+	SendAt time.Time `json:"send_at"`
+	// the announcement is hidden from the announcements feed after it expires
+	ExpiresAt *time.Time `json:"expires_at"`
+	Pinned    bool       `json:"pinned"`
+	// public announcements are shown on the maintenance status too
+	Public bool `json:"public"`
+}
+
+// AnnouncementFeedItem struct holds an announcement shown in the announcements feed
+type AnnouncementFeedItem struct {
+	ID          int        `json:"id"`
+	Title       string     `json:"title"`
+	Body        string     `json:"body"`
+	PublishedAt time.Time  `json:"published_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Pinned      bool       `json:"pinned"`
+}
+
+func newAnnouncementFeedItem(a models.Announcement) AnnouncementFeedItem {
+	return AnnouncementFeedItem{
+		ID:          a.ID,
+		Title:       a.Subject,
+		Body:        a.Body,
+		PublishedAt: a.SendAt,
+		ExpiresAt:   a.ExpiresAt,
+		Pinned:      a.Pinned,
+	}
+}
+
+// CreateNewAnnouncement creates a new administrator announcement to be sent to the targeted users as an email and notification
+func (a *App) CreateNewAnnouncement(req *http.Request) (interface{}, Response) {
+	var adminAnnouncement AdminAnnouncement
+	err := json.NewDecoder(req.Body).Decode(&adminAnnouncement)
+
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read announcement data"))
+	}
+
+	err = validator.Validate(adminAnnouncement)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("invalid announcement data"))
+	}
+
+	if adminAnnouncement.MaxTeamSize > 0 && adminAnnouncement.MinTeamSize > adminAnnouncement.MaxTeamSize {
+		return nil, BadRequest(errors.New("min team size can't be more than max team size"))
+	}
+
+	if adminAnnouncement.SendAt.IsZero() {
+		adminAnnouncement.SendAt = time.Now()
+	}
+
+	if adminAnnouncement.ExpiresAt != nil && !adminAnnouncement.ExpiresAt.After(adminAnnouncement.SendAt) {
+		return nil, BadRequest(errors.New("announcement can't expire before it is sent"))
+	}
+
+	announcement := models.Announcement{
+		Subject:           adminAnnouncement.Subject,
+		Body:              adminAnnouncement.Body,
+		College:           adminAnnouncement.College,
+		MinTeamSize:       adminAnnouncement.MinTeamSize,
+		MaxTeamSize:       adminAnnouncement.MaxTeamSize,
+		ActiveDeployments: adminAnnouncement.ActiveDeployments,
+		Emails:            adminAnnouncement.Emails,
+		SendAt:            adminAnnouncement.SendAt,
+		ExpiresAt:         adminAnnouncement.ExpiresAt,
+		Pinned:            adminAnnouncement.Pinned,
+		Public:            adminAnnouncement.Public,
+	}
+
+	total, err := a.db.CountAnnouncementUsers(announcement)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if total == 0 {
+		return nil, BadRequest(errors.New("no users match the announcement targets"))
+	}
+
+	err = a.db.CreateAnnouncement(&announcement)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "new announcement is scheduled to be sent",
+		Data:    announcement,
+	}, Created()
+}
+
+// ListAnnouncementsHandler lists announcements with their progress
+func (a *App) ListAnnouncementsHandler(req *http.Request) (interface{}, Response) {
+	announcements, err := a.db.ListAnnouncements()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Announcements are found",
+		Data:    announcements,
+	}, Ok()
+}
+
+// GetAnnouncementHandler returns an announcement with its progress
+func (a *App) GetAnnouncementHandler(req *http.Request) (interface{}, Response) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read announcement id"))
+	}
+
+	announcement, err := a.db.GetAnnouncement(id)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("announcement is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Announcement is found",
+		Data:    announcement,
+	}, Ok()
+}
+
+// ListAnnouncementsFeedHandler lists the published announcements of the user and the public announcements
+func (a *App) ListAnnouncementsFeedHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	user, err := a.db.GetUserByID(userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	announcements, err := a.db.ListUserAnnouncements(user)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	feed := []AnnouncementFeedItem{}
+	for _, announcement := range announcements {
+		feed = append(feed, newAnnouncementFeedItem(announcement))
+	}
+
+	return ResponseMsg{
+		Message: "Announcements are found",
+		Data:    feed,
+	}, Ok()
+}
+
+// publicAnnouncementsFeed returns the public announcements shown to unauthenticated visitors
+func (a *App) publicAnnouncementsFeed() ([]AnnouncementFeedItem, error) {
+	announcements, err := a.db.ListPublicAnnouncements()
+	if err != nil {
+		return nil, err
+	}
+
+	feed := []AnnouncementFeedItem{}
+	for _, announcement := range announcements {
+		feed = append(feed, newAnnouncementFeedItem(announcement))
+	}
+	return feed, nil
+}
+
+// sendAnnouncements sends the due announcements until the context is done
+func (a *App) sendAnnouncements(ctx context.Context) {
+	ticker := time.NewTicker(announcementsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		announcements, err := a.db.ClaimDueAnnouncements(announcementClaimTimeout)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to claim due announcements")
+		}
+
+		for _, announcement := range announcements {
+			err := a.sendAnnouncement(ctx, announcement)
+			if err != nil {
+				log.Error().Err(err).Msgf("failed to send announcement with ID: %d", announcement.ID)
+			}
+		}
+	}
+}
+
+// sendAnnouncement sends an announcement to its users in batches, it resumes after the last user it was sent to
+func (a *App) sendAnnouncement(ctx context.Context, announcement models.Announcement) error {
+	if announcement.Status == models.AnnouncementScheduled {
+		total, err := a.db.CountAnnouncementUsers(announcement)
+		if err != nil {
+			return err
+		}
+
+		err = a.db.SetAnnouncementTotal(announcement.ID, int(total))
+		if err != nil {
+			return err
+		}
+	}
+
+	lastUserID := announcement.LastUserID
+	for ctx.Err() == nil {
+		users, err := a.db.ListAnnouncementUsers(announcement, lastUserID, announcementsBatchSize)
+		if err != nil {
+			return err
+		}
+
+		if len(users) == 0 {
+			return a.db.FinishAnnouncement(announcement.ID)
+		}
+
+		var sent, failed int
+		for _, user := range users {
+			if err := a.announceToUser(announcement, user); err != nil {
+				log.Error().Err(err).Msgf("failed to send announcement with ID: %d to user %s", announcement.ID, user.ID)
+				failed++
+			} else {
+				sent++
+			}
+		}
+
+		lastUserID = users[len(users)-1].ID.String()
+		err = a.db.UpdateAnnouncementProgress(announcement.ID, lastUserID, sent, failed, time.Now().Add(announcementClaimTimeout))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// announceToUser sends the announcement to a user on the channels they prefer for announcements
+func (a *App) announceToUser(announcement models.Announcement, user models.User) error {
+	n := models.Notification{Msg: fmt.Sprintf("Announcement: %s", announcement.Body), Type: models.AnnouncementType, AnnouncementID: announcement.ID}
+	return a.notify(user, n, "", nil, internal.AnnouncementMail, func(t internal.MailTemplate) (string, string, error) {
+		return internal.AdminAnnouncementMailContent(t, announcement.Subject, announcement.Body, a.config.Server.Host, user.Name)
+	})
+}
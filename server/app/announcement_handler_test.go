@@ -0,0 +1,192 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnouncementsHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	admin := models.User{
+		Name:     "admin",
+		Email:    "admin@gmail.com",
+		College:  "Cairo",
+		Verified: true,
+		Roles:    []string{models.SuperAdminRole},
+	}
+	err := app.db.CreateUser(&admin)
+	assert.NoError(t, err)
+
+	student := models.User{
+		Name:     "student",
+		Email:    "student@gmail.com",
+		College:  "Paris",
+		Verified: true,
+	}
+	err = app.db.CreateUser(&student)
+	assert.NoError(t, err)
+
+	token, _, err := app.startSession(admin.ID.String(), admin.Email, "", "")
+	assert.NoError(t, err)
+
+	t.Run("Create announcement: no matching users", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer([]byte(`{"subject":"subject","announcement":"announcement","college":"Berlin"}`)),
+				handlerFunc: app.CreateNewAnnouncement,
+				api:         fmt.Sprintf("/%s/announcement", app.config.Version),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Create announcement: invalid team size", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer([]byte(`{"subject":"subject","announcement":"announcement","min_team_size":5,"max_team_size":2}`)),
+				handlerFunc: app.CreateNewAnnouncement,
+				api:         fmt.Sprintf("/%s/announcement", app.config.Version),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Create announcement: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer([]byte(`{"subject":"subject","announcement":"announcement","college":"paris"}`)),
+				handlerFunc: app.CreateNewAnnouncement,
+				api:         fmt.Sprintf("/%s/announcement", app.config.Version),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, http.StatusCreated, response.Code)
+		assert.Contains(t, response.Body.String(), `"status":"scheduled"`)
+	})
+
+	announcements, err := app.db.ListAnnouncements()
+	assert.NoError(t, err)
+	assert.Len(t, announcements, 1)
+
+	t.Run("Send announcement: only targeted users", func(t *testing.T) {
+		claimed, err := app.db.ClaimDueAnnouncements(announcementClaimTimeout)
+		assert.NoError(t, err)
+		assert.Len(t, claimed, 1)
+
+		err = app.sendAnnouncement(context.Background(), claimed[0])
+		assert.NoError(t, err)
+
+		notifications, err := app.db.ListNotifications(student.ID.String(), models.NotificationsFilter{})
+		assert.NoError(t, err)
+		assert.Len(t, notifications, 1)
+
+		notifications, err = app.db.ListNotifications(admin.ID.String(), models.NotificationsFilter{})
+		assert.NoError(t, err)
+		assert.Empty(t, notifications)
+	})
+
+	t.Run("Get announcement: progress", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.GetAnnouncementHandler,
+				api:         fmt.Sprintf("/%s/announcement/%d", app.config.Version, announcements[0].ID),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			varID:  announcements[0].ID,
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"status":"sent"`)
+		assert.Contains(t, response.Body.String(), `"total":1,"sent":1,"failed":0`)
+	})
+
+	t.Run("Announcements feed: targeted and public announcements", func(t *testing.T) {
+		expired := time.Now().Add(-time.Minute)
+		public := models.Announcement{Subject: "public", Body: "body", College: "Cairo", Public: true, SendAt: time.Now().Add(-time.Hour)}
+		err := app.db.CreateAnnouncement(&public)
+		assert.NoError(t, err)
+
+		old := models.Announcement{Subject: "old", Body: "body", SendAt: time.Now().Add(-time.Hour), ExpiresAt: &expired}
+		err = app.db.CreateAnnouncement(&old)
+		assert.NoError(t, err)
+
+		studentToken, _, err := app.startSession(student.ID.String(), student.Email, "", "")
+		assert.NoError(t, err)
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ListAnnouncementsFeedHandler,
+				api:         fmt.Sprintf("/%s/announcements", app.config.Version),
+			},
+			userID: student.ID.String(),
+			token:  studentToken,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"title":"subject"`)
+		assert.Contains(t, response.Body.String(), `"title":"public"`)
+		assert.NotContains(t, response.Body.String(), `"title":"old"`)
+	})
+
+	t.Run("Get maintenance: public announcements", func(t *testing.T) {
+		req := unAuthHandlerConfig{
+			handlerFunc: app.GetMaintenanceHandler,
+			api:         fmt.Sprintf("/%s/maintenance", app.config.Version),
+		}
+
+		response := unAuthorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"title":"public"`)
+		assert.NotContains(t, response.Body.String(), `"title":"subject"`)
+	})
+
+	t.Run("Get announcement: not found", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.GetAnnouncementHandler,
+				api:         fmt.Sprintf("/%s/announcement/%d", app.config.Version, 100),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			varID:  100,
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
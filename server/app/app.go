@@ -3,7 +3,11 @@ package app
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 
 	c4sDeployer "github.com/codescalers/cloud4students/deployer"
 	"github.com/codescalers/cloud4students/internal"
@@ -13,16 +17,35 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
 )
 
+const (
+	// workerRole is the leadership role of deploying requests
+	workerRole = "worker"
+	// schedulerRole is the leadership role of periodic jobs
+	schedulerRole = "scheduler"
+)
+
 // App for all dependencies of backend server
 type App struct {
-	config   internal.Configuration
-	server   server
-	db       models.DB
-	redis    streams.RedisClient
+	config internal.Configuration
+	server server
+	db     models.DB
+	redis  streams.RedisClient
+	// limiter counts requests and failed attempts of the authentication routes
+	limiter  middlewares.RateLimitStore
 	deployer c4sDeployer.Deployer
+	mailer   internal.Mailer
+	// webhookClient sends webhook deliveries
+	webhookClient *http.Client
+	// oidcClients are the clients of the sign in providers by their names
+	oidcClients map[string]*internal.OIDCClient
+	// trustedProxies are the proxies the client ip is read from
+	trustedProxies internal.TrustedProxies
+	// instance identifies the app process in leader elections
+	instance string
 }
 
 // NewApp creates new server app all configurations
@@ -42,11 +65,28 @@ func NewApp(ctx context.Context, configFile string) (app *App, err error) {
 		return
 	}
 
+	err = seedEmailTemplates(db)
+	if err != nil {
+		return
+	}
+
+	err = bootstrapSuperAdmins(db, config.Admins)
+	if err != nil {
+		return
+	}
+
 	redis, err := streams.NewRedisClient(config)
 	if err != nil {
 		return
 	}
 
+	// publish notifications to the users streaming them
+	db.OnNotificationCreated(func(n models.Notification) {
+		if err := redis.PublishNotification(n); err != nil {
+			log.Error().Err(err).Msgf("failed to publish notification with ID: %d", n.ID)
+		}
+	})
+
 	tfPluginClient, err := deployer.NewTFPluginClient(
 		config.Account.Mnemonics,
 		deployer.WithNetwork(config.Account.Network),
@@ -55,41 +95,145 @@ func NewApp(ctx context.Context, configFile string) (app *App, err error) {
 		return
 	}
 
-	newDeployer, err := c4sDeployer.NewDeployer(db, redis, tfPluginClient)
+	newDeployer, err := c4sDeployer.NewDeployer(db, redis, tfPluginClient, config.Deployment)
+	if err != nil {
+		return
+	}
+
+	mailer, err := internal.NewMailer(config.MailSender)
 	if err != nil {
 		return
 	}
 
 	server := newServer(config.Server.Host, config.Server.Port)
 
-	return &App{
+	trustedProxies, err := internal.ParseTrustedProxies(config.Server.TrustedProxies)
+	if err != nil {
+		return
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return
+	}
+
+	app = &App{
 		config:   config,
 		server:   *server,
 		db:       db,
 		redis:    redis,
+		limiter:  &redis,
 		deployer: newDeployer,
-	}, nil
+		mailer:   mailer,
+		webhookClient: internal.NewWebhookClient(
+			time.Duration(config.Webhooks.TimeoutSeconds)*time.Second, config.Webhooks.AllowPrivateNetworks,
+		),
+		oidcClients:    newOIDCClients(config.OIDC),
+		trustedProxies: trustedProxies,
+		instance:       fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+	}
+
+	// deployment results are sent on the channels users prefer
+	app.deployer.SetNotifier(app.notifyDeploymentResult)
+	// each instance consumes the deployment streams with its own name
+	app.deployer.SetConsumer(app.instance)
+
+	return app, nil
 }
 
-// Start starts the app
+// Start starts the api server, the deployment worker and the scheduled jobs in a single process
 func (a *App) Start(ctx context.Context) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	a.startWorker(ctx)
+	a.startScheduler(ctx)
+
+	return a.Serve(ctx)
+}
+
+// Serve starts the api server only, deployment requests are deployed by a separate worker
+func (a *App) Serve(ctx context.Context) error {
 	a.registerHandlers()
-	a.startBackgroundWorkers(ctx)
+	return a.server.start(ctx)
+}
+
+// Work starts the deployment worker until the context is done
+func (a *App) Work(ctx context.Context) error {
+	a.startWorker(ctx)
+	log.Info().Msg("Deployment worker is started")
 
-	return a.server.start()
+	<-ctx.Done()
+	log.Info().Msg("Deployment worker is stopped")
+	return nil
 }
 
-func (a *App) startBackgroundWorkers(ctx context.Context) {
-	// notify admins
-	go a.notifyAdmins()
+// Schedule starts the periodic jobs until the context is done
+func (a *App) Schedule(ctx context.Context) error {
+	a.startScheduler(ctx)
+	log.Info().Msg("Scheduler is started")
 
-	// periodic deployments
-	go a.deployer.PeriodicRequests(ctx, substrateBlockDiffInSeconds)
-	go a.deployer.PeriodicDeploy(ctx, substrateBlockDiffInSeconds)
+	<-ctx.Done()
+	log.Info().Msg("Scheduler is stopped")
+	return nil
+}
+
+// startWorker consumes deployment requests on every worker, batches of deployments are deployed
+// and the requests of stopped workers are claimed while the instance is the worker leader
+func (a *App) startWorker(ctx context.Context) {
+	ttl := time.Duration(a.config.LeaderTTLSeconds) * time.Second
+	go a.deployer.KeepAlive(ctx, ttl)
+
+	go func() {
+		// check pending deployments
+		a.deployer.ConsumeVMRequest(ctx, true)
+		a.deployer.ConsumeK8sRequest(ctx, true)
 
-	// check pending deployments
-	a.deployer.ConsumeVMRequest(ctx, true)
-	a.deployer.ConsumeK8sRequest(ctx, true)
+		// periodic deployment requests
+		a.deployer.PeriodicRequests(ctx)
+	}()
+
+	go a.runAsLeader(ctx, workerRole,
+		a.deployer.PeriodicDeploy,
+		func(ctx context.Context) { a.deployer.PeriodicReclaim(ctx, ttl) },
+	)
+}
+
+// startScheduler runs the periodic jobs while the instance is the scheduler leader
+func (a *App) startScheduler(ctx context.Context) {
+	go a.runAsLeader(ctx, schedulerRole,
+		// notify admins
+		a.notifyAdmins,
+		// send queued mails
+		a.sendMails,
+		// delete old sent and failed mails
+		a.purgeMails,
+		// send due announcements
+		a.sendAnnouncements,
+		// send queued webhook deliveries
+		a.sendWebhooks,
+		// send daily digests of notifications
+		a.sendDigests,
+	)
+}
+
+// runAsLeader runs the jobs while the instance leads the role, the leadership is released once all jobs return
+func (a *App) runAsLeader(ctx context.Context, role string, jobs ...func(ctx context.Context)) {
+	election := streams.NewLeaderElection(a.redis, role, a.instance, time.Duration(a.config.LeaderTTLSeconds)*time.Second)
+	election.Run(ctx, func(ctx context.Context) {
+		middlewares.Leader.WithLabelValues(role, a.instance).Set(1)
+		defer middlewares.Leader.WithLabelValues(role, a.instance).Set(0)
+
+		var wg sync.WaitGroup
+		for _, job := range jobs {
+			wg.Add(1)
+			go func(job func(ctx context.Context)) {
+				defer wg.Done()
+				job(ctx)
+			}(job)
+		}
+		wg.Wait()
+	})
 }
 
 func (a *App) registerHandlers() {
@@ -106,76 +250,143 @@ func (a *App) registerHandlers() {
 	notificationRouter := authRouter.PathPrefix("/notification").Subrouter()
 	vmRouter := authRouter.PathPrefix("/vm").Subrouter()
 	k8sRouter := authRouter.PathPrefix("/k8s").Subrouter()
+	requestRouter := authRouter.PathPrefix("/requests").Subrouter()
+	announcementRouter := authRouter.PathPrefix("/announcements").Subrouter()
+	webhookRouter := authRouter.PathPrefix("/webhooks").Subrouter()
 
 	// sub routes with no authorization
 	unAuthUserRouter := versionRouter.PathPrefix("/user").Subrouter()
 	unAuthMaintenanceRouter := versionRouter.PathPrefix("/maintenance").Subrouter()
 
-	// sub routes with admin access
+	// sub routes with admin access, each route requires its permission
 	voucherRouter := adminRouter.PathPrefix("/voucher").Subrouter()
 	maintenanceRouter := adminRouter.PathPrefix("/maintenance").Subrouter()
 	balanceRouter := adminRouter.PathPrefix("/balance").Subrouter()
 	deploymentsRouter := adminRouter.PathPrefix("/deployments").Subrouter()
+	mailRouter := adminRouter.PathPrefix("/mails").Subrouter()
+	emailTemplateRouter := adminRouter.PathPrefix("/email_templates").Subrouter()
+
+	// retried requests with the same idempotency key get the original response
+	idempotent := middlewares.Idempotency(a.db, time.Duration(a.config.IdempotencyKeyTTLHours)*time.Hour)
+
+	// authentication routes are limited per client ip and per email
+	limited := func(route string, handler Handler) http.Handler {
+		return middlewares.RateLimit(a.limiter, route, a.config.RateLimits.Routes[route])(WrapFunc(handler))
+	}
 
-	unAuthUserRouter.HandleFunc("/signup", WrapFunc(a.SignUpHandler)).Methods("POST", "OPTIONS")
-	unAuthUserRouter.HandleFunc("/signup/verify_email", WrapFunc(a.VerifySignUpCodeHandler)).Methods("POST", "OPTIONS")
-	unAuthUserRouter.HandleFunc("/signin", WrapFunc(a.SignInHandler)).Methods("POST", "OPTIONS")
-	unAuthUserRouter.HandleFunc("/refresh_token", WrapFunc(a.RefreshJWTHandler)).Methods("POST", "OPTIONS")
-	unAuthUserRouter.HandleFunc("/forgot_password", WrapFunc(a.ForgotPasswordHandler)).Methods("POST", "OPTIONS")
-	unAuthUserRouter.HandleFunc("/forget_password/verify_email", WrapFunc(a.VerifyForgetPasswordCodeHandler)).Methods("POST", "OPTIONS")
+	unAuthUserRouter.Handle("/signup", limited(internal.SignUpRoute, a.SignUpHandler)).Methods("POST", "OPTIONS")
+	unAuthUserRouter.Handle("/signup/verify_email", limited(internal.VerifySignUpRoute, a.VerifySignUpCodeHandler)).Methods("POST", "OPTIONS")
+	unAuthUserRouter.Handle("/signin", limited(internal.SignInRoute, a.SignInHandler)).Methods("POST", "OPTIONS")
+	unAuthUserRouter.Handle("/signin/2fa", limited(internal.SignInTwoFactorRoute, a.SignInTwoFactorHandler)).Methods("POST", "OPTIONS")
+	unAuthUserRouter.HandleFunc("/oidc/providers", WrapFunc(a.ListOIDCProvidersHandler)).Methods("GET", "OPTIONS")
+	unAuthUserRouter.HandleFunc("/languages", WrapFunc(a.ListLanguagesHandler)).Methods("GET", "OPTIONS")
+	unAuthUserRouter.HandleFunc("/oidc/callback", WrapFunc(a.OIDCCallbackHandler)).Methods("POST", "OPTIONS")
+	unAuthUserRouter.HandleFunc("/oidc/{provider}/login", WrapFunc(a.OIDCLoginHandler)).Methods("POST", "OPTIONS")
+	unAuthUserRouter.Handle("/refresh_token", limited(internal.RefreshTokenRoute, a.RefreshJWTHandler)).Methods("POST", "OPTIONS")
+	unAuthUserRouter.Handle("/forgot_password", limited(internal.ForgotPasswordRoute, a.ForgotPasswordHandler)).Methods("POST", "OPTIONS")
+	unAuthUserRouter.Handle("/forget_password/verify_email", limited(internal.VerifyForgotPasswordRoute, a.VerifyForgetPasswordCodeHandler)).Methods("POST", "OPTIONS")
+	unAuthUserRouter.Handle("/verification/link", limited(internal.VerifyLinkRoute, a.VerifyLinkHandler)).Methods("POST", "OPTIONS")
+	unAuthUserRouter.Handle("/verification/resend", limited(internal.ResendVerificationRoute, a.ResendVerificationHandler)).Methods("POST", "OPTIONS")
 
 	userRouter.HandleFunc("/change_password", WrapFunc(a.ChangePasswordHandler)).Methods("PUT", "OPTIONS")
+	userRouter.HandleFunc("/email", WrapFunc(a.ChangeEmailHandler)).Methods("POST", "OPTIONS")
+	userRouter.HandleFunc("/email/verify", WrapFunc(a.VerifyEmailChangeHandler)).Methods("POST", "OPTIONS")
+	userRouter.HandleFunc("/logout", WrapFunc(a.LogoutHandler)).Methods("POST", "OPTIONS")
+	userRouter.HandleFunc("/logout_all", WrapFunc(a.LogoutAllHandler)).Methods("POST", "OPTIONS")
+	userRouter.HandleFunc("/2fa/enroll", WrapFunc(a.EnrollTwoFactorHandler)).Methods("POST", "OPTIONS")
+	userRouter.HandleFunc("/2fa/confirm", WrapFunc(a.ConfirmTwoFactorHandler)).Methods("POST", "OPTIONS")
+	userRouter.HandleFunc("/2fa/disable", WrapFunc(a.DisableTwoFactorHandler)).Methods("POST", "OPTIONS")
+	userRouter.HandleFunc("/2fa/recovery_codes", WrapFunc(a.RegenerateRecoveryCodesHandler)).Methods("POST", "OPTIONS")
+	userRouter.HandleFunc("/access_tokens", WrapFunc(a.CreateAccessTokenHandler)).Methods("POST", "OPTIONS")
+	userRouter.HandleFunc("/access_tokens", WrapFunc(a.ListAccessTokensHandler)).Methods("GET", "OPTIONS")
+	userRouter.HandleFunc("/access_tokens/{id}", WrapFunc(a.DeleteAccessTokenHandler)).Methods("DELETE", "OPTIONS")
+	userRouter.HandleFunc("/sessions", WrapFunc(a.ListSessionsHandler)).Methods("GET", "OPTIONS")
+	userRouter.HandleFunc("/sessions/{id}", WrapFunc(a.RevokeSessionHandler)).Methods("DELETE", "OPTIONS")
 	userRouter.HandleFunc("", WrapFunc(a.UpdateUserHandler)).Methods("PUT", "OPTIONS")
 	userRouter.HandleFunc("", WrapFunc(a.GetUserHandler)).Methods("GET", "OPTIONS")
-	userRouter.HandleFunc("/apply_voucher", WrapFunc(a.ApplyForVoucherHandler)).Methods("POST", "OPTIONS")
+	userRouter.Handle("/apply_voucher", idempotent(WrapFunc(a.ApplyForVoucherHandler))).Methods("POST", "OPTIONS")
 	userRouter.HandleFunc("/activate_voucher", WrapFunc(a.ActivateVoucherHandler)).Methods("PUT", "OPTIONS")
 
 	quotaRouter.HandleFunc("", WrapFunc(a.GetQuotaHandler)).Methods("GET", "OPTIONS")
 
 	notificationRouter.HandleFunc("", WrapFunc(a.ListNotificationsHandler)).Methods("GET", "OPTIONS")
+	notificationRouter.HandleFunc("/stream", a.StreamNotificationsHandler).Methods("GET", "OPTIONS")
+	notificationRouter.HandleFunc("/unread", WrapFunc(a.CountUnreadNotificationsHandler)).Methods("GET", "OPTIONS")
+	notificationRouter.HandleFunc("", WrapFunc(a.MarkAllNotificationsSeenHandler)).Methods("PUT", "OPTIONS")
+	notificationRouter.HandleFunc("/preferences", WrapFunc(a.GetNotificationPreferencesHandler)).Methods("GET", "OPTIONS")
+	notificationRouter.HandleFunc("/preferences", WrapFunc(a.UpdateNotificationPreferencesHandler)).Methods("PUT", "OPTIONS")
 	notificationRouter.HandleFunc("/{id}", WrapFunc(a.UpdateNotificationsHandler)).Methods("PUT", "OPTIONS")
+	notificationRouter.HandleFunc("/{id}", WrapFunc(a.DeleteNotificationHandler)).Methods("DELETE", "OPTIONS")
 
-	vmRouter.HandleFunc("", WrapFunc(a.DeployVMHandler)).Methods("POST", "OPTIONS")
+	vmRouter.Handle("", idempotent(WrapFunc(a.DeployVMHandler))).Methods("POST", "OPTIONS")
 	vmRouter.HandleFunc("/validate/{name}", WrapFunc(a.ValidateVMNameHandler)).Methods("Get", "OPTIONS")
 	vmRouter.HandleFunc("/{id}", WrapFunc(a.GetVMHandler)).Methods("GET", "OPTIONS")
 	vmRouter.HandleFunc("/{id}", WrapFunc(a.DeleteVMHandler)).Methods("DELETE", "OPTIONS")
 	vmRouter.HandleFunc("", WrapFunc(a.ListVMsHandler)).Methods("GET", "OPTIONS")
 	vmRouter.HandleFunc("", WrapFunc(a.DeleteAllVMsHandler)).Methods("DELETE", "OPTIONS")
 
-	k8sRouter.HandleFunc("", WrapFunc(a.K8sDeployHandler)).Methods("POST", "OPTIONS")
+	k8sRouter.Handle("", idempotent(WrapFunc(a.K8sDeployHandler))).Methods("POST", "OPTIONS")
 	k8sRouter.HandleFunc("/validate/{name}", WrapFunc(a.ValidateK8sNameHandler)).Methods("Get", "OPTIONS")
 	k8sRouter.HandleFunc("/{id}", WrapFunc(a.K8sGetHandler)).Methods("GET", "OPTIONS")
 	k8sRouter.HandleFunc("/{id}", WrapFunc(a.K8sDeleteHandler)).Methods("DELETE", "OPTIONS")
 	k8sRouter.HandleFunc("", WrapFunc(a.K8sGetAllHandler)).Methods("GET", "OPTIONS")
 	k8sRouter.HandleFunc("", WrapFunc(a.K8sDeleteAllHandler)).Methods("DELETE", "OPTIONS")
 
+	requestRouter.HandleFunc("/{id}", WrapFunc(a.GetDeploymentRequestHandler)).Methods("GET", "OPTIONS")
+
+	announcementRouter.HandleFunc("", WrapFunc(a.ListAnnouncementsFeedHandler)).Methods("GET", "OPTIONS")
+
+	webhookRouter.HandleFunc("", WrapFunc(a.CreateWebhookHandler)).Methods("POST", "OPTIONS")
+	webhookRouter.HandleFunc("", WrapFunc(a.ListWebhooksHandler)).Methods("GET", "OPTIONS")
+	webhookRouter.HandleFunc("/{id}", WrapFunc(a.DeleteWebhookHandler)).Methods("DELETE", "OPTIONS")
+	webhookRouter.HandleFunc("/{id}/deliveries", WrapFunc(a.ListWebhookDeliveriesHandler)).Methods("GET", "OPTIONS")
+	webhookRouter.HandleFunc("/{id}/ping", WrapFunc(a.PingWebhookHandler)).Methods("POST", "OPTIONS")
+
 	unAuthMaintenanceRouter.HandleFunc("", WrapFunc(a.GetMaintenanceHandler)).Methods("GET", "OPTIONS")
 
 	// ADMIN ACCESS
-	adminRouter.HandleFunc("/user/all", WrapFunc(a.GetAllUsersHandler)).Methods("GET", "OPTIONS")
-	adminRouter.HandleFunc("/quota/reset", WrapFunc(a.ResetUsersQuota)).Methods("PUT", "OPTIONS")
-	adminRouter.HandleFunc("/deployment/count", WrapFunc(a.GetDlsCountHandler)).Methods("GET", "OPTIONS")
-	adminRouter.HandleFunc("/announcement", WrapFunc(a.CreateNewAnnouncement)).Methods("POST", "OPTIONS")
-	adminRouter.HandleFunc("/set_admin", WrapFunc(a.SetAdmin)).Methods("PUT", "OPTIONS")
-	balanceRouter.HandleFunc("", WrapFunc(a.GetBalanceHandler)).Methods("GET", "OPTIONS")
-	maintenanceRouter.HandleFunc("", WrapFunc(a.UpdateMaintenanceHandler)).Methods("PUT", "OPTIONS")
-	deploymentsRouter.HandleFunc("", WrapFunc(a.DeleteAllDeployments)).Methods("DELETE", "OPTIONS")
-	deploymentsRouter.HandleFunc("", WrapFunc(a.ListDeployments)).Methods("GET", "OPTIONS")
-
-	voucherRouter.HandleFunc("", WrapFunc(a.GenerateVoucherHandler)).Methods("POST", "OPTIONS")
-	voucherRouter.HandleFunc("", WrapFunc(a.ListVouchersHandler)).Methods("GET", "OPTIONS")
-	voucherRouter.HandleFunc("/{id}", WrapFunc(a.UpdateVoucherHandler)).Methods("PUT", "OPTIONS")
-	voucherRouter.HandleFunc("", WrapFunc(a.ApproveAllVouchersHandler)).Methods("PUT", "OPTIONS")
+	// admin routes are allowed for users with a role having their permission
+	permitted := func(permission string, handler http.Handler) http.Handler {
+		return middlewares.RequirePermission(a.db, permission, a.config.TwoFactor.RequireForAdmins)(handler)
+	}
+
+	adminRouter.Handle("/user/all", permitted(models.PermissionReadUsers, WrapFunc(a.GetAllUsersHandler))).Methods("GET", "OPTIONS")
+	adminRouter.Handle("/quota/reset", permitted(models.PermissionManageUsers, WrapFunc(a.ResetUsersQuota))).Methods("PUT", "OPTIONS")
+	adminRouter.Handle("/deployment/count", permitted(models.PermissionReadDeployments, WrapFunc(a.GetDlsCountHandler))).Methods("GET", "OPTIONS")
+	adminRouter.Handle("/announcement", permitted(models.PermissionManageAnnouncements, WrapFunc(a.CreateNewAnnouncement))).Methods("POST", "OPTIONS")
+	adminRouter.Handle("/announcement", permitted(models.PermissionManageAnnouncements, WrapFunc(a.ListAnnouncementsHandler))).Methods("GET", "OPTIONS")
+	adminRouter.Handle("/announcement/{id}", permitted(models.PermissionManageAnnouncements, WrapFunc(a.GetAnnouncementHandler))).Methods("GET", "OPTIONS")
+	adminRouter.Handle("/set_admin", permitted(models.PermissionManageRoles, WrapFunc(a.SetAdmin))).Methods("PUT", "OPTIONS")
+	adminRouter.Handle("/roles", permitted(models.PermissionManageRoles, WrapFunc(a.ListRolesHandler))).Methods("GET", "OPTIONS")
+	adminRouter.Handle("/user/{id}/roles", permitted(models.PermissionManageRoles, WrapFunc(a.UpdateUserRolesHandler))).Methods("PUT", "OPTIONS")
+	adminRouter.Handle("/user/{id}/logout", permitted(models.PermissionManageUsers, WrapFunc(a.ForceLogoutUserHandler))).Methods("POST", "OPTIONS")
+	balanceRouter.Handle("", permitted(models.PermissionReadBalance, WrapFunc(a.GetBalanceHandler))).Methods("GET", "OPTIONS")
+	maintenanceRouter.Handle("", permitted(models.PermissionManageMaintenance, WrapFunc(a.UpdateMaintenanceHandler))).Methods("PUT", "OPTIONS")
+	deploymentsRouter.Handle("", permitted(models.PermissionDeleteDeployments, WrapFunc(a.DeleteAllDeployments))).Methods("DELETE", "OPTIONS")
+	deploymentsRouter.Handle("", permitted(models.PermissionReadDeployments, WrapFunc(a.ListDeployments))).Methods("GET", "OPTIONS")
+	mailRouter.Handle("", permitted(models.PermissionReadMails, WrapFunc(a.ListMailsHandler))).Methods("GET", "OPTIONS")
+	mailRouter.Handle("/{id}/resend", permitted(models.PermissionManageMails, WrapFunc(a.ResendMailHandler))).Methods("PUT", "OPTIONS")
+	emailTemplateRouter.Handle("", permitted(models.PermissionReadMails, WrapFunc(a.ListEmailTemplatesHandler))).Methods("GET", "OPTIONS")
+	emailTemplateRouter.Handle("/{name}/{language}", permitted(models.PermissionReadMails, WrapFunc(a.GetEmailTemplateHandler))).Methods("GET", "OPTIONS")
+	emailTemplateRouter.Handle("/{name}/{language}", permitted(models.PermissionManageMails, WrapFunc(a.UpdateEmailTemplateHandler))).Methods("PUT", "OPTIONS")
+	emailTemplateRouter.Handle("/{name}/{language}", permitted(models.PermissionManageMails, WrapFunc(a.DeleteEmailTemplateHandler))).Methods("DELETE", "OPTIONS")
+	emailTemplateRouter.Handle("/{name}/{language}/preview", permitted(models.PermissionReadMails, WrapFunc(a.PreviewEmailTemplateHandler))).Methods("POST", "OPTIONS")
+	adminRouter.Handle("/leader", permitted(models.PermissionReadCluster, WrapFunc(a.GetLeadersHandler))).Methods("GET", "OPTIONS")
+
+	voucherRouter.Handle("", permitted(models.PermissionReviewVouchers, idempotent(WrapFunc(a.GenerateVoucherHandler)))).Methods("POST", "OPTIONS")
+	voucherRouter.Handle("", permitted(models.PermissionReadVouchers, WrapFunc(a.ListVouchersHandler))).Methods("GET", "OPTIONS")
+	voucherRouter.Handle("/{id}", permitted(models.PermissionReviewVouchers, WrapFunc(a.UpdateVoucherHandler))).Methods("PUT", "OPTIONS")
+	voucherRouter.Handle("", permitted(models.PermissionReviewVouchers, WrapFunc(a.ApproveAllVouchersHandler))).Methods("PUT", "OPTIONS")
 
 	// middlewares
+	r.Use(middlewares.RealIP(a.trustedProxies))
 	r.Use(middlewares.LoggingMW)
 	r.Use(middlewares.EnableCors)
 
-	authRouter.Use(middlewares.Authorization(a.db, a.config.Token.Secret, a.config.Token.Timeout))
-	adminRouter.Use(middlewares.AdminAccess(a.db))
+	authRouter.Use(middlewares.Authorization(a.db, a.config.Token.Secret))
 
 	// prometheus registration
-	prometheus.MustRegister(middlewares.Requests, middlewares.UserCreations, middlewares.VoucherActivated, middlewares.VoucherApplied, middlewares.Deployments, middlewares.Deletions)
+	prometheus.MustRegister(middlewares.Requests, middlewares.UserCreations, middlewares.VoucherActivated, middlewares.VoucherApplied, middlewares.Deployments, middlewares.Deletions, middlewares.Leader)
 	http.Handle("/metrics", promhttp.Handler())
 
 	http.Handle("/", r)
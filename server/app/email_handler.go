@@ -0,0 +1,274 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/validator.v2"
+	"gorm.io/gorm"
+)
+
+var errEmailUsed = errors.New("email is already used")
+
+// ChangeEmailInput struct for user to change their email
+type ChangeEmailInput struct {
+	Email    string `json:"email" binding:"required" validate:"mail"`
+	Password string `json:"password"`
+	// two-factor code or recovery code of users signing in with a provider if they enabled 2FA
+	Code string `json:"code"`
+}
+
+// VerifyEmailChangeInput struct takes the code mailed to the new email of a user
+type VerifyEmailChangeInput struct {
+	Code int `json:"code" binding:"required"`
+}
+
+// ChangeEmailHandler starts changing the email of a user, a code and a link to confirm it are mailed to the new email
+// and a link to revert it is mailed to the current one
+func (a *App) ChangeEmailHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	var input ChangeEmailInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read email data"))
+	}
+
+	input.Email = strings.TrimSpace(input.Email)
+	err = validator.Validate(input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("invalid email"))
+	}
+
+	user, err := a.db.GetUserByID(userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if input.Email == user.Email {
+		return nil, BadRequest(errors.New("new email is the same as the current one"))
+	}
+
+	// users signing in with a provider don't have a password to confirm
+	if len(user.HashedPassword) != 0 {
+		if res := a.lockedOut(signInAttempts, user.Email); res != nil {
+			return nil, res
+		}
+		if !internal.VerifyPassword(user.HashedPassword, input.Password) {
+			return nil, a.failedAttempt(signInAttempts, user.Email, BadRequest(errors.New("password is not correct")), nil)
+		}
+		a.resetAttempts(signInAttempts, user.Email)
+	} else if res := a.reauthenticated(req, user, input.Code); res != nil {
+		return nil, res
+	}
+
+	_, err = a.db.GetUserByEmail(input.Email)
+	if err == nil {
+		return nil, BadRequest(errEmailUsed)
+	}
+	if err != gorm.ErrRecordNotFound {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if res := a.emailRecentlyChanged(user); res != nil {
+		return nil, res
+	}
+	if res := a.verificationCooldown(user.ID.String(), models.EmailChangePurpose); res != nil {
+		return nil, res
+	}
+
+	if err := a.sendVerification(user, models.EmailChangePurpose, input.Email); err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if err := a.sendEmailRevert(user, input.Email); err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Verification code has been sent to " + input.Email,
+		Data:    map[string]int{"timeout": a.config.MailSender.Timeout},
+	}, Ok()
+}
+
+// VerifyEmailChangeHandler changes the email of a user with the code mailed to their new email
+func (a *App) VerifyEmailChangeHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	var input VerifyEmailChangeInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read code data"))
+	}
+
+	user, err := a.db.GetUserByID(userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if res := a.lockedOut(codeAttempts, user.Email); res != nil {
+		return nil, res
+	}
+
+	token, res := a.useVerificationCode(user, models.EmailChangePurpose, input.Code)
+	if res != nil {
+		return nil, res
+	}
+
+	return a.completeEmailChange(user, token)
+}
+
+// reauthenticated checks that a user without a password confirmed who they are: with a two-factor code if they enabled 2FA,
+// otherwise the session of the request must be signed in with their provider within the re-authentication minutes
+func (a *App) reauthenticated(req *http.Request, user models.User, code string) Response {
+	if user.TOTPEnabled {
+		_, res := a.checkSecondFactor(user, code, a.verifySecondFactor, BadRequest(errors.New("invalid two-factor code")))
+		return res
+	}
+
+	sessionID, _ := req.Context().Value(middlewares.SessionIDKey("SessionID")).(string)
+	session, err := a.db.GetActiveSession(sessionID)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		log.Error().Err(err).Send()
+		return InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	reauthWindow := time.Duration(a.config.OIDC.ReauthMinutes) * time.Minute
+	if err == gorm.ErrRecordNotFound || time.Since(session.CreatedAt) > reauthWindow {
+		return Forbidden(errors.New("please sign in with your provider again to change your email"))
+	}
+
+	return nil
+}
+
+// emailRecentlyChanged refuses changing the email of a user while the revert link of their last change is valid,
+// so a new change can't replace the link mailed to the old email
+func (a *App) emailRecentlyChanged(user models.User) Response {
+	token, err := a.db.GetActiveVerificationToken(user.ID.String(), models.EmailRevertPurpose)
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	// the last change isn't confirmed yet, so its revert link is sent to the current email again
+	if token.Email == user.Email {
+		return nil
+	}
+
+	return TooManyRequests(errors.New("email was changed recently, please try again later"), time.Until(token.ExpiresAt))
+}
+
+// sendEmailRevert mails a link to revert changing the email of a user to their current email
+func (a *App) sendEmailRevert(user models.User, newEmail string) error {
+	link, linkHash, err := a.verificationLink()
+	if err != nil {
+		return err
+	}
+
+	subject, body, err := internal.EmailChangeNoticeMailContent(a.mailTemplate(internal.EmailChangeNotice, user.Language), newEmail, link, a.config.MailSender.EmailRevertHours, user.Name, a.config.Server.Host)
+	if err != nil {
+		return err
+	}
+
+	err = a.db.CreateVerificationToken(&models.VerificationToken{
+		UserID:    user.ID.String(),
+		Purpose:   models.EmailRevertPurpose,
+		Email:     user.Email,
+		LinkHash:  linkHash,
+		ExpiresAt: time.Now().Add(time.Duration(a.config.MailSender.EmailRevertHours) * time.Hour),
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.db.EnqueueMail(user.Email, subject, body)
+}
+
+// completeEmailChange changes the email of a user to the one the token is sent to and signs them out of all sessions
+func (a *App) completeEmailChange(user models.User, token models.VerificationToken) (interface{}, Response) {
+	_, err := a.db.GetUserByEmail(token.Email)
+	if err == nil {
+		return nil, BadRequest(errEmailUsed)
+	}
+	if err != gorm.ErrRecordNotFound {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.db.UpdateUserEmail(user.ID.String(), token.Email)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.db.RevokeUserSessions(user.ID.String(), "")
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Email is changed successfully, please sign in again",
+		Data:    map[string]string{"email": token.Email},
+	}, Ok()
+}
+
+// revertEmailChange cancels changing the email of a user or changes it back to the email the revert link is sent to,
+// all sessions of the user are revoked as the change may not be theirs
+func (a *App) revertEmailChange(user models.User, token models.VerificationToken) (interface{}, Response) {
+	err := a.db.DeleteVerificationTokens(user.ID.String(), models.EmailChangePurpose)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if user.Email != token.Email {
+		_, err := a.db.GetUserByEmail(token.Email)
+		if err == nil {
+			return nil, BadRequest(errEmailUsed)
+		}
+		if err != gorm.ErrRecordNotFound {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+
+		err = a.db.UpdateUserEmail(user.ID.String(), token.Email)
+		if err != nil {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+	}
+
+	err = a.db.RevokeUserSessions(user.ID.String(), "")
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Email change is reverted, please reset your password if you didn't request it",
+		Data:    map[string]string{"email": token.Email},
+	}, Ok()
+}
@@ -0,0 +1,231 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestEmailChangeHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	oldEmail := user.Email
+	newEmail := "new@gmail.com"
+	t.Cleanup(func() { user.Email = oldEmail })
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	signIn := func(t *testing.T) string {
+		token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
+		assert.NoError(t, err)
+		return token
+	}
+	token := signIn(t)
+
+	authorized := func(token string, handlerFunc Handler, api string, body string) *httptest.ResponseRecorder {
+		return authorizedHandler(authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer([]byte(body)),
+				handlerFunc: handlerFunc,
+				api:         fmt.Sprintf("/%s/user/%s", app.config.Version, api),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		})
+	}
+
+	changeEmail := func(token, email, password string) *httptest.ResponseRecorder {
+		return authorized(token, app.ChangeEmailHandler, "email", fmt.Sprintf(`{"email": "%s", "password": "%s"}`, email, password))
+	}
+
+	verifyLink := func(token string) *httptest.ResponseRecorder {
+		return unAuthorizedHandler(unAuthHandlerConfig{
+			body:        bytes.NewBuffer([]byte(fmt.Sprintf(`{"token": "%s"}`, token))),
+			handlerFunc: app.VerifyLinkHandler,
+			api:         fmt.Sprintf("/%s/user/verification/link", app.config.Version),
+		})
+	}
+
+	t.Run("Change email: wrong password", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, changeEmail(token, newEmail, "wrong").Code)
+	})
+
+	t.Run("Change email: invalid email", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, changeEmail(token, "new", password).Code)
+	})
+
+	t.Run("Change email: same email", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, changeEmail(token, oldEmail, password).Code)
+	})
+
+	t.Run("Change email: email is used", func(t *testing.T) {
+		other := models.User{Name: "other", Email: "other@gmail.com", Verified: true}
+		err := app.db.CreateUser(&other)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, changeEmail(token, other.Email, password).Code)
+	})
+
+	t.Run("Change email: confirmation and notice are mailed", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, changeEmail(token, newEmail, password).Code)
+
+		mails, err := app.db.ListOutgoingMails(models.MailsFilter{Status: models.MailPending})
+		assert.NoError(t, err)
+		assert.Len(t, mails, 2)
+		assert.ElementsMatch(t, []string{newEmail, oldEmail}, []string{mails[0].Receiver, mails[1].Receiver})
+
+		// the email is switched only after it is confirmed
+		got, err := app.db.GetUserByID(user.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, oldEmail, got.Email)
+	})
+
+	t.Run("Change email: code was sent recently", func(t *testing.T) {
+		assert.Equal(t, http.StatusTooManyRequests, changeEmail(token, newEmail, password).Code)
+	})
+
+	t.Run("Verify email change: wrong code", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, authorized(token, app.VerifyEmailChangeHandler, "email/verify", `{"code": 0}`).Code)
+	})
+
+	t.Run("Verify email change: success", func(t *testing.T) {
+		err := app.db.CreateVerificationToken(&models.VerificationToken{
+			UserID:    user.ID.String(),
+			Purpose:   models.EmailChangePurpose,
+			Email:     newEmail,
+			CodeHash:  verificationCodeHash(user.ID.String(), 1234),
+			LinkHash:  "link",
+			ExpiresAt: time.Now().Add(time.Minute),
+		})
+		assert.NoError(t, err)
+
+		response := authorized(token, app.VerifyEmailChangeHandler, "email/verify", `{"code": 1234}`)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		got, err := app.db.GetUserByID(user.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, newEmail, got.Email)
+
+		// sessions are revoked after the email is changed
+		assert.Equal(t, http.StatusUnauthorized, authorized(token, app.GetUserHandler, "", "").Code)
+	})
+
+	t.Run("Change email: revert link of the last change is valid", func(t *testing.T) {
+		user.Email = newEmail
+		token = signIn(t)
+		app.config.MailSender.ResendCooldownSeconds = 0
+
+		assert.Equal(t, http.StatusTooManyRequests, changeEmail(token, "another@gmail.com", password).Code)
+	})
+
+	t.Run("Revert email change: email is changed back", func(t *testing.T) {
+		// the revert link is mailed to the old email
+		user.Email = oldEmail
+		link := lastVerificationLink(t, app)
+
+		response := verifyLink(link)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		got, err := app.db.GetUserByID(user.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, oldEmail, got.Email)
+
+		assert.Equal(t, http.StatusUnauthorized, authorized(token, app.GetUserHandler, "", "").Code)
+		assert.Equal(t, http.StatusBadRequest, verifyLink(link).Code)
+	})
+
+	t.Run("Revert email change: pending change is canceled", func(t *testing.T) {
+		token := signIn(t)
+		assert.Equal(t, http.StatusOK, changeEmail(token, newEmail, password).Code)
+		link := lastVerificationLink(t, app)
+
+		assert.Equal(t, http.StatusOK, verifyLink(link).Code)
+
+		_, err := app.db.GetVerificationToken(user.ID.String(), models.EmailChangePurpose)
+		assert.Equal(t, gorm.ErrRecordNotFound, err)
+
+		got, err := app.db.GetUserByID(user.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, oldEmail, got.Email)
+	})
+}
+
+func TestEmailChangeWithProvider(t *testing.T) {
+	app := SetUp(t)
+
+	// users signing in with a provider don't have a password
+	student := models.User{Name: "student", Email: "student@university.edu", Verified: true}
+	err := app.db.CreateUser(&student)
+	assert.NoError(t, err)
+
+	changeEmail := func(token, email, code string) *httptest.ResponseRecorder {
+		return authorizedHandler(authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer([]byte(fmt.Sprintf(`{"email": "%s", "code": "%s"}`, email, code))),
+				handlerFunc: app.ChangeEmailHandler,
+				api:         fmt.Sprintf("/%s/user/email", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		})
+	}
+
+	t.Run("Change email: sign in is not recent", func(t *testing.T) {
+		token, _, err := app.startSession(student.ID.String(), student.Email, "", "")
+		assert.NoError(t, err)
+
+		reauthMinutes := app.config.OIDC.ReauthMinutes
+		app.config.OIDC.ReauthMinutes = 0
+		defer func() { app.config.OIDC.ReauthMinutes = reauthMinutes }()
+
+		response := changeEmail(token, "new@university.edu", "")
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("Change email: recent sign in", func(t *testing.T) {
+		token, _, err := app.startSession(student.ID.String(), student.Email, "", "")
+		assert.NoError(t, err)
+
+		response := changeEmail(token, "new@university.edu", "")
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("Change email: two-factor code is required", func(t *testing.T) {
+		other := models.User{Name: "other", Email: "other@university.edu", Verified: true}
+		err := app.db.CreateUser(&other)
+		assert.NoError(t, err)
+
+		secret, err := internal.GenerateTOTPSecret()
+		assert.NoError(t, err)
+		err = app.db.SetTOTPSecret(other.ID.String(), secret)
+		assert.NoError(t, err)
+		err = app.db.EnableTOTP(other.ID.String(), 0, []string{internal.HashToken("recovery")})
+		assert.NoError(t, err)
+
+		// a recent sign in isn't enough with 2FA enabled
+		token, _, err := app.startSession(other.ID.String(), other.Email, "", "")
+		assert.NoError(t, err)
+
+		response := changeEmail(token, "another@university.edu", "")
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		code, err := internal.TOTPCode(secret, internal.TOTPStep(time.Now()))
+		assert.NoError(t, err)
+
+		response = changeEmail(token, "another@university.edu", code)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+}
@@ -0,0 +1,255 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/validator.v2"
+	"gorm.io/gorm"
+)
+
+// EmailTemplateInput struct for data needed when admin updates or previews a mail template
+type EmailTemplateInput struct {
+	Subject string `json:"subject" binding:"required" validate:"nonzero"`
+	Body    string `json:"body" binding:"required" validate:"nonzero"`
+}
+
+// EmailPreview struct holds a mail rendered with sample data
+type EmailPreview struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+// seedEmailTemplates stores the built in mail templates, templates edited by admins are kept
+func seedEmailTemplates(db models.DB) error {
+	var templates []models.EmailTemplate
+	for _, name := range internal.MailTemplateNames() {
+		t, err := internal.DefaultMailTemplate(name)
+		if err != nil {
+			return err
+		}
+
+		templates = append(templates, models.EmailTemplate{Name: t.Name, Language: t.Language, Subject: t.Subject, Body: t.Body})
+	}
+
+	return db.SeedEmailTemplates(templates)
+}
+
+// mailLanguages returns the languages users can choose, a language is available once all mails
+// have templates in it so users don't silently get mails in the default language
+func (a *App) mailLanguages() ([]string, error) {
+	counts, err := a.db.CountEmailTemplatesByLanguage()
+	if err != nil {
+		return nil, err
+	}
+
+	// the default language falls back to the built in templates
+	languages := []string{internal.DefaultLanguage}
+	for _, language := range internal.Languages {
+		if language != internal.DefaultLanguage && counts[language] >= len(internal.MailTemplateNames()) {
+			languages = append(languages, language)
+		}
+	}
+	return languages, nil
+}
+
+// validateLanguage checks that users can choose the language
+func (a *App) validateLanguage(language string) Response {
+	if !internal.Contains(internal.Languages, language) {
+		return BadRequest(fmt.Errorf("language '%s' is not supported", language))
+	}
+
+	languages, err := a.mailLanguages()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if !internal.Contains(languages, language) {
+		return BadRequest(fmt.Errorf("language '%s' isn't available yet, not all mails are translated", language))
+	}
+	return nil
+}
+
+// ListLanguagesHandler lists the languages users can choose for their mails
+func (a *App) ListLanguagesHandler(req *http.Request) (interface{}, Response) {
+	languages, err := a.mailLanguages()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Languages are found",
+		Data:    languages,
+	}, Ok()
+}
+
+// mailTemplate returns the template of a mail in the given language,
+// it falls back to the default language then to the built in template
+func (a *App) mailTemplate(name, language string) internal.MailTemplate {
+	for _, l := range []string{language, internal.DefaultLanguage} {
+		t, err := a.db.GetEmailTemplate(name, l)
+		if err == nil {
+			return internal.MailTemplate{Name: t.Name, Language: t.Language, Subject: t.Subject, Body: t.Body}
+		}
+		if err != gorm.ErrRecordNotFound {
+			log.Error().Err(err).Msgf("failed to get mail template %s", name)
+			break
+		}
+	}
+
+	t, err := internal.DefaultMailTemplate(name)
+	if err != nil {
+		log.Error().Err(err).Send()
+	}
+	return t
+}
+
+// ListEmailTemplatesHandler lists all mail templates
+func (a *App) ListEmailTemplatesHandler(req *http.Request) (interface{}, Response) {
+	templates, err := a.db.ListEmailTemplates()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Mail templates are found",
+		Data:    templates,
+	}, Ok()
+}
+
+// GetEmailTemplateHandler returns a mail template in a language
+func (a *App) GetEmailTemplateHandler(req *http.Request) (interface{}, Response) {
+	name, language := mux.Vars(req)["name"], mux.Vars(req)["language"]
+
+	t, err := a.db.GetEmailTemplate(name, language)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("mail template is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Mail template is found",
+		Data:    t,
+	}, Ok()
+}
+
+// UpdateEmailTemplateHandler creates or updates a mail template in a language
+func (a *App) UpdateEmailTemplateHandler(req *http.Request) (interface{}, Response) {
+	name, language := mux.Vars(req)["name"], mux.Vars(req)["language"]
+	if !internal.Contains(internal.MailTemplateNames(), name) {
+		return nil, NotFound(errors.New("mail template is not found"))
+	}
+	if !internal.Contains(internal.Languages, language) {
+		return nil, BadRequest(fmt.Errorf("language '%s' is not supported", language))
+	}
+
+	var input EmailTemplateInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read mail template data"))
+	}
+
+	err = validator.Validate(input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("invalid mail template data"))
+	}
+
+	err = internal.ValidateMailTemplate(internal.MailTemplate{Name: name, Language: language, Subject: input.Subject, Body: input.Body})
+	if err != nil {
+		return nil, BadRequest(fmt.Errorf("invalid mail template: %w", err))
+	}
+
+	t := models.EmailTemplate{Name: name, Language: language, Subject: input.Subject, Body: input.Body}
+	err = a.db.UpsertEmailTemplate(&t)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Mail template is updated successfully",
+		Data:    t,
+	}, Ok()
+}
+
+// DeleteEmailTemplateHandler deletes a mail template in a language, mails in this language use the default language instead
+func (a *App) DeleteEmailTemplateHandler(req *http.Request) (interface{}, Response) {
+	name, language := mux.Vars(req)["name"], mux.Vars(req)["language"]
+	if language == internal.DefaultLanguage {
+		return nil, BadRequest(errors.New("mail templates in the default language can't be deleted"))
+	}
+
+	err := a.db.DeleteEmailTemplate(name, language)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("mail template is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Mail template is deleted successfully",
+		Data:    nil,
+	}, Ok()
+}
+
+// PreviewEmailTemplateHandler renders a mail template with sample data,
+// a draft subject and body can be sent to preview them before saving
+func (a *App) PreviewEmailTemplateHandler(req *http.Request) (interface{}, Response) {
+	name, language := mux.Vars(req)["name"], mux.Vars(req)["language"]
+	if !internal.Contains(internal.MailTemplateNames(), name) {
+		return nil, NotFound(errors.New("mail template is not found"))
+	}
+	if !internal.Contains(internal.Languages, language) {
+		return nil, BadRequest(fmt.Errorf("language '%s' is not supported", language))
+	}
+
+	var input EmailTemplateInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil && err != io.EOF {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read mail template data"))
+	}
+
+	t := a.mailTemplate(name, language)
+	if input.Subject != "" {
+		t.Subject = input.Subject
+	}
+	if input.Body != "" {
+		t.Body = input.Body
+	}
+	t.Language = language
+
+	subject, body, err := internal.RenderMail(t, internal.MailSampleData(name))
+	if err != nil {
+		return nil, BadRequest(fmt.Errorf("invalid mail template: %w", err))
+	}
+
+	text, err := internal.PlainText(body)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Mail template is rendered with sample data",
+		Data:    EmailPreview{Subject: subject, HTML: body, Text: text},
+	}, Ok()
+}
@@ -0,0 +1,233 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailTemplatesHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	admin := models.User{
+		Name:     "admin",
+		Email:    "admin@gmail.com",
+		Verified: true,
+		Roles:    []string{models.SuperAdminRole},
+	}
+	err := app.db.CreateUser(&admin)
+	assert.NoError(t, err)
+
+	token, _, err := app.startSession(admin.ID.String(), admin.Email, "", "")
+	assert.NoError(t, err)
+
+	frenchWelcome := EmailTemplateInput{
+		Subject: "Bienvenue sur Cloud4Students",
+		Body:    `{{define "title"}}Bienvenue{{end}}{{define "content"}}Bonjour {{.Name}}{{end}}{{define "reason"}}{{end}}`,
+	}
+
+	t.Run("List templates: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ListEmailTemplatesHandler,
+				api:         fmt.Sprintf("/%s/email_templates", app.config.Version),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+		assert.Contains(t, response.Body.String(), `"name":"welcome"`)
+	})
+
+	t.Run("Update template: invalid body", func(t *testing.T) {
+		body, err := json.Marshal(EmailTemplateInput{Subject: "subject", Body: `{{define "content"}}{{.Voucher}}{{end}}`})
+		assert.NoError(t, err)
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: app.UpdateEmailTemplateHandler,
+				api:         fmt.Sprintf("/%s/email_templates/welcome/fr", app.config.Version),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			vars:   map[string]string{"name": internal.WelcomeMail, "language": "fr"},
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("Update template: unsupported language", func(t *testing.T) {
+		body, err := json.Marshal(frenchWelcome)
+		assert.NoError(t, err)
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: app.UpdateEmailTemplateHandler,
+				api:         fmt.Sprintf("/%s/email_templates/welcome/de", app.config.Version),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			vars:   map[string]string{"name": internal.WelcomeMail, "language": "de"},
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("Update template: success", func(t *testing.T) {
+		body, err := json.Marshal(frenchWelcome)
+		assert.NoError(t, err)
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: app.UpdateEmailTemplateHandler,
+				api:         fmt.Sprintf("/%s/email_templates/welcome/fr", app.config.Version),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			vars:   map[string]string{"name": internal.WelcomeMail, "language": "fr"},
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		tmpl := app.mailTemplate(internal.WelcomeMail, "fr")
+		assert.Equal(t, frenchWelcome.Subject, tmpl.Subject)
+
+		// languages without templates use the default language
+		tmpl = app.mailTemplate(internal.WelcomeMail, "ar")
+		assert.Equal(t, internal.DefaultLanguage, tmpl.Language)
+	})
+
+	t.Run("Preview template: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.PreviewEmailTemplateHandler,
+				api:         fmt.Sprintf("/%s/email_templates/welcome/fr/preview", app.config.Version),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			vars:   map[string]string{"name": internal.WelcomeMail, "language": "fr"},
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+		assert.Contains(t, response.Body.String(), "Bonjour Student")
+	})
+
+	t.Run("Delete template: default language", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.DeleteEmailTemplateHandler,
+				api:         fmt.Sprintf("/%s/email_templates/welcome/en", app.config.Version),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			vars:   map[string]string{"name": internal.WelcomeMail, "language": internal.DefaultLanguage},
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("Delete template: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.DeleteEmailTemplateHandler,
+				api:         fmt.Sprintf("/%s/email_templates/welcome/fr", app.config.Version),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			vars:   map[string]string{"name": internal.WelcomeMail, "language": "fr"},
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		tmpl := app.mailTemplate(internal.WelcomeMail, "fr")
+		assert.Equal(t, internal.DefaultLanguage, tmpl.Language)
+	})
+}
+
+func TestLanguages(t *testing.T) {
+	app := SetUp(t)
+
+	student := models.User{Name: "student", Email: "student@gmail.com", Verified: true, Language: internal.DefaultLanguage}
+	err := app.db.CreateUser(&student)
+	assert.NoError(t, err)
+
+	token, _, err := app.startSession(student.ID.String(), student.Email, "", "")
+	assert.NoError(t, err)
+
+	updateLanguage := func(language string) int {
+		return authorizedHandler(authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer([]byte(fmt.Sprintf(`{"language": "%s"}`, language))),
+				handlerFunc: app.UpdateUserHandler,
+				api:         fmt.Sprintf("/%s/user", app.config.Version),
+			},
+			userID: student.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}).Code
+	}
+
+	listLanguages := func() string {
+		response := unAuthorizedHandler(unAuthHandlerConfig{
+			handlerFunc: app.ListLanguagesHandler,
+			api:         fmt.Sprintf("/%s/user/languages", app.config.Version),
+		})
+		assert.Equal(t, http.StatusOK, response.Code)
+		return response.Body.String()
+	}
+
+	t.Run("Untranslated language is refused", func(t *testing.T) {
+		assert.Contains(t, listLanguages(), `"data":["en"]`)
+
+		// a single translated mail isn't enough
+		err := app.db.UpsertEmailTemplate(&models.EmailTemplate{Name: internal.WelcomeMail, Language: "fr", Subject: "Bienvenue", Body: "body"})
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, updateLanguage("fr"))
+		assert.Equal(t, http.StatusBadRequest, updateLanguage("de"))
+	})
+
+	t.Run("Translated language is accepted", func(t *testing.T) {
+		for _, name := range internal.MailTemplateNames() {
+			err := app.db.UpsertEmailTemplate(&models.EmailTemplate{Name: name, Language: "fr", Subject: "sujet", Body: "body"})
+			assert.NoError(t, err)
+		}
+
+		assert.Contains(t, listLanguages(), `"data":["en","fr"]`)
+		assert.Equal(t, http.StatusOK, updateLanguage("fr"))
+		assert.Equal(t, http.StatusBadRequest, updateLanguage("ar"))
+	})
+}
@@ -75,15 +75,25 @@ func (a *App) K8sDeployHandler(req *http.Request) (interface{}, Response) {
 		return nil, BadRequest(errors.New("kubernetes master name is not available, please choose a different name"))
 	}
 
-	err = a.deployer.Redis.PushK8sRequest(streams.K8sDeployRequest{User: user, Input: k8sDeployInput, AdminSSHKey: a.config.AdminSSHKey})
+	request := models.DeploymentRequest{UserID: userID, Type: models.K8sType, Name: k8sDeployInput.MasterName}
+	err = a.db.CreateDeploymentRequest(&request)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	err = a.deployer.Redis.PushK8sRequest(streams.K8sDeployRequest{ID: request.ID, User: user, Input: k8sDeployInput, AdminSSHKey: a.config.AdminSSHKey})
+	if err != nil {
+		log.Error().Err(err).Send()
+		if err := a.db.FailDeploymentRequest(request.ID, "failed to queue the request"); err != nil {
+			log.Error().Err(err).Send()
+		}
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
 	return ResponseMsg{
 		Message: "Kubernetes cluster request is being deployed, you'll receive a confirmation notification soon",
-		Data:    nil,
+		Data:    map[string]string{"request_id": request.ID},
 	}, Created()
 }
 
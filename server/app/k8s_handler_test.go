@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"testing"
 
-	"github.com/codescalers/cloud4students/internal"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/stretchr/testify/assert"
 )
@@ -19,7 +18,7 @@ func TestK8sGetAllHandler(t *testing.T) {
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
 	t.Run("Get all k8s: no clusters for user", func(t *testing.T) {
@@ -89,7 +88,7 @@ func TestK8sDeleteAllHandler(t *testing.T) {
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
 	t.Run("Delete all k8s: no clusters found", func(t *testing.T) {
@@ -156,7 +155,7 @@ func TestK8sGetAndDeleteHandler(t *testing.T) {
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
 	t.Run("Get k8s: cluster not found", func(t *testing.T) {
@@ -0,0 +1,81 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// signInAttempts are the attempts of signing in with a password
+	signInAttempts = "signin"
+	// codeAttempts are the attempts of verifying the codes mailed to users
+	codeAttempts = "code"
+	// twoFactorAttempts are the attempts of entering a second factor code of a user
+	twoFactorAttempts = "2fa"
+)
+
+var errTooManyFailedAttempts = errors.New("too many failed attempts, please try again later")
+
+func attemptsKeys(attempts, email string) (failuresKey, lockoutKey string) {
+	email = middlewares.NormalizeEmail(email)
+	return fmt.Sprintf("failures:%s:%s", attempts, email), fmt.Sprintf("lockout:%s:%s", attempts, email)
+}
+
+// lockedOut refuses the attempts of an email while it is locked out
+func (a *App) lockedOut(attempts, email string) Response {
+	_, lockoutKey := attemptsKeys(attempts, email)
+	retryAfter, err := a.limiter.LockedFor(lockoutKey)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to check lockout of %s", lockoutKey)
+		return nil
+	}
+
+	if retryAfter > 0 {
+		return TooManyRequests(errTooManyFailedAttempts, retryAfter)
+	}
+	return nil
+}
+
+// failedAttempt counts a failed attempt of an email and returns its response,
+// once the email reaches the max failed attempts it is locked out and onLockout is called
+func (a *App) failedAttempt(attempts, email string, res Response, onLockout func() error) Response {
+	failuresKey, lockoutKey := attemptsKeys(attempts, email)
+	lockout := time.Duration(a.config.RateLimits.LockoutMinutes) * time.Minute
+
+	count, _, err := a.limiter.Hit(failuresKey, lockout)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to count failed attempt of %s", failuresKey)
+		return res
+	}
+
+	if count < int64(a.config.RateLimits.MaxFailedAttempts) {
+		return res
+	}
+
+	if err := a.limiter.Lock(lockoutKey, lockout); err != nil {
+		log.Error().Err(err).Msgf("failed to lock out %s", lockoutKey)
+		return res
+	}
+	a.resetAttempts(attempts, email)
+
+	if onLockout != nil {
+		if err := onLockout(); err != nil {
+			log.Error().Err(err).Msgf("failed to lock out %s", lockoutKey)
+		}
+	}
+
+	return TooManyRequests(errTooManyFailedAttempts, lockout)
+}
+
+// resetAttempts forgets the failed attempts of an email after a successful attempt
+func (a *App) resetAttempts(attempts, email string) {
+	failuresKey, _ := attemptsKeys(attempts, email)
+	if err := a.limiter.Reset(failuresKey); err != nil {
+		log.Error().Err(err).Msgf("failed to reset %s", failuresKey)
+	}
+}
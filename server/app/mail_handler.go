@@ -0,0 +1,179 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	// mailsInterval is the interval of checking for queued mails
+	mailsInterval = 10 * time.Second
+	// mailsBatchSize is the max number of mails sent every interval
+	mailsBatchSize = 50
+	// mailClaimTimeout is the time after which a mail claimed by a stopped worker is sent again
+	mailClaimTimeout = 5 * time.Minute
+	// mailsPurgeInterval is the interval of deleting old sent and failed mails
+	mailsPurgeInterval = time.Hour
+	// defaultMailsLimit is the number of mails listed in a page if no limit is given
+	defaultMailsLimit = 50
+	// maxMailsLimit is the max number of mails listed in a page
+	maxMailsLimit = 100
+	// maxRetryDelay is the max delay between the attempts of a mail or a webhook delivery
+	maxRetryDelay = 6 * time.Hour
+)
+
+// ListMailsHandler lists queued and sent mails newest first, it can be filtered by status.
+// The next page is listed with the id of the last mail as the before query parameter
+func (a *App) ListMailsHandler(req *http.Request) (interface{}, Response) {
+	filter := models.MailsFilter{Status: req.URL.Query().Get("status")}
+	switch filter.Status {
+	case "", models.MailPending, models.MailSending, models.MailSent, models.MailFailed:
+	default:
+		return nil, BadRequest(fmt.Errorf("invalid mail status '%s'", filter.Status))
+	}
+
+	var err error
+	filter.Before, filter.Limit, err = readPage(req, defaultMailsLimit, maxMailsLimit)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	mails, err := a.db.ListOutgoingMails(filter)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Mails are found",
+		Data:    mails,
+	}, Ok()
+}
+
+// ResendMailHandler queues a mail to be sent again
+func (a *App) ResendMailHandler(req *http.Request) (interface{}, Response) {
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read mail id"))
+	}
+
+	mail, err := a.db.GetOutgoingMail(id)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("mail is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if mail.Status == models.MailPending || mail.Status == models.MailSending {
+		return nil, BadRequest(errors.New("mail is already queued"))
+	}
+
+	if mail.Status == models.MailSent {
+		return nil, BadRequest(errors.New("mail is already sent"))
+	}
+
+	err = a.db.ResendMail(id)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Mail is queued to be sent again",
+		Data:    nil,
+	}, Ok()
+}
+
+// sendMails sends the queued mails until the context is done
+func (a *App) sendMails(ctx context.Context) {
+	ticker := time.NewTicker(mailsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		mails, err := a.db.ClaimDueMails(mailsBatchSize, mailClaimTimeout)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to claim queued mails")
+		}
+
+		for _, mail := range mails {
+			a.sendMail(mail)
+		}
+	}
+}
+
+// purgeMails deletes the sent and failed mails older than the retention until the context is done
+func (a *App) purgeMails(ctx context.Context) {
+	ticker := time.NewTicker(mailsPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		retention := time.Duration(a.config.MailSender.RetentionDays) * 24 * time.Hour
+		deleted, err := a.db.DeleteOldMails(time.Now().Add(-retention))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to delete old mails")
+			continue
+		}
+		if deleted > 0 {
+			log.Info().Msgf("deleted %d old mails", deleted)
+		}
+	}
+}
+
+func (a *App) sendMail(mail models.OutgoingMail) {
+	sendErr := a.mailer.SendMail(mail.Receiver, mail.Subject, mail.Body)
+
+	var err error
+	switch {
+	case sendErr == nil:
+		err = a.db.MarkMailSent(mail.ID)
+	case mail.Attempts+1 >= a.config.MailSender.MaxAttempts:
+		log.Error().Err(sendErr).Msgf("failed to send mail with ID: %d, no attempts left", mail.ID)
+		err = a.db.FailMail(mail.ID, sendErr.Error())
+	default:
+		log.Error().Err(sendErr).Msgf("failed to send mail with ID: %d, it will be retried", mail.ID)
+		delay := retryDelay(time.Duration(a.config.MailSender.RetryBaseSeconds)*time.Second, mail.Attempts)
+		err = a.db.RetryMail(mail.ID, sendErr.Error(), time.Now().Add(delay))
+	}
+
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to update mail with ID: %d", mail.ID)
+	}
+}
+
+// retryDelay returns the delay before the next attempt, it doubles with every failed attempt
+func retryDelay(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 0; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,216 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingMailer struct{}
+
+func (m failingMailer) SendMail(receiver, subject, body string) error {
+	return errors.New("mail server is down")
+}
+
+func TestMailsHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	admin := models.User{
+		Name:     "admin",
+		Email:    "admin@gmail.com",
+		Verified: true,
+		Roles:    []string{models.SuperAdminRole},
+	}
+	err := app.db.CreateUser(&admin)
+	assert.NoError(t, err)
+
+	token, _, err := app.startSession(admin.ID.String(), admin.Email, "", "")
+	assert.NoError(t, err)
+
+	err = app.db.EnqueueMail("user@gmail.com", "subject", "body")
+	assert.NoError(t, err)
+
+	mails, err := app.db.ListOutgoingMails(models.MailsFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, mails, 1)
+
+	t.Run("List mails: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ListMailsHandler,
+				api:         fmt.Sprintf("/%s/mails?status=pending", app.config.Version),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+		assert.Contains(t, response.Body.String(), `"receiver":"user@gmail.com"`)
+	})
+
+	t.Run("List mails: invalid status", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ListMailsHandler,
+				api:         fmt.Sprintf("/%s/mails?status=lost", app.config.Version),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("Resend mail: already queued", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ResendMailHandler,
+				api:         fmt.Sprintf("/%s/mails/%d/resend", app.config.Version, mails[0].ID),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			varID:  mails[0].ID,
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	t.Run("Send mail: retried then failed", func(t *testing.T) {
+		mailer := app.mailer
+		app.mailer = failingMailer{}
+		app.config.MailSender.MaxAttempts = 2
+		defer func() { app.mailer = mailer }()
+
+		app.sendMail(mails[0])
+		mail, err := app.db.GetOutgoingMail(mails[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.MailPending, mail.Status)
+		assert.True(t, mail.NextAttemptAt.After(time.Now()))
+
+		app.sendMail(mail)
+		mail, err = app.db.GetOutgoingMail(mails[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.MailFailed, mail.Status)
+		assert.Equal(t, "mail server is down", mail.Error)
+	})
+
+	t.Run("Send mail: rejected by sendgrid", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"errors":[{"message":"too many requests"}]}`))
+		}))
+		defer server.Close()
+
+		mailer := app.mailer
+		app.mailer = internal.NewSendGridMailer("sender@gmail.com", "1234", server.URL)
+		app.config.MailSender.MaxAttempts = 5
+		defer func() { app.mailer = mailer }()
+
+		err := app.db.EnqueueMail("rejected@gmail.com", "subject", "body")
+		assert.NoError(t, err)
+
+		pending, err := app.db.ListOutgoingMails(models.MailsFilter{Status: models.MailPending})
+		assert.NoError(t, err)
+		assert.Len(t, pending, 1)
+
+		app.sendMail(pending[0])
+		mail, err := app.db.GetOutgoingMail(pending[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.MailPending, mail.Status)
+		assert.Equal(t, 1, mail.Attempts)
+		assert.True(t, mail.NextAttemptAt.After(time.Now()))
+		assert.Contains(t, mail.Error, "429")
+	})
+
+	t.Run("Resend mail: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ResendMailHandler,
+				api:         fmt.Sprintf("/%s/mails/%d/resend", app.config.Version, mails[0].ID),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			varID:  mails[0].ID,
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		app.sendMail(mails[0])
+		mail, err := app.db.GetOutgoingMail(mails[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.MailSent, mail.Status)
+		assert.Empty(t, mail.Body)
+
+		// the body of a sent mail is deleted
+		response = adminHandler(req)
+		assert.Equal(t, response.Code, http.StatusBadRequest)
+	})
+
+	listMails := func(query string) *httptest.ResponseRecorder {
+		return adminHandler(authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ListMailsHandler,
+				api:         fmt.Sprintf("/%s/mails?%s", app.config.Version, query),
+			},
+			userID: admin.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		})
+	}
+
+	t.Run("List mails: paginated", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			err := app.db.EnqueueMail("other@gmail.com", "subject", "body")
+			assert.NoError(t, err)
+		}
+
+		response := listMails("limit=2")
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, 2, strings.Count(response.Body.String(), `"receiver":"other@gmail.com"`))
+		assert.NotContains(t, response.Body.String(), `"receiver":"user@gmail.com"`)
+
+		page, err := app.db.ListOutgoingMails(models.MailsFilter{Limit: 2})
+		assert.NoError(t, err)
+
+		response = listMails(fmt.Sprintf("limit=2&before=%d", page[1].ID))
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"receiver":"user@gmail.com"`)
+		assert.NotContains(t, response.Body.String(), `"receiver":"other@gmail.com"`)
+	})
+
+	t.Run("List mails: invalid limit", func(t *testing.T) {
+		response := listMails(fmt.Sprintf("limit=%d", maxMailsLimit+1))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = listMails("before=last")
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Minute, retryDelay(time.Minute, 0))
+	assert.Equal(t, 4*time.Minute, retryDelay(time.Minute, 2))
+	assert.Equal(t, maxRetryDelay, retryDelay(time.Minute, 100))
+}
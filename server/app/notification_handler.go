@@ -2,48 +2,146 @@
 package app
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/codescalers/cloud4students/internal"
 	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
 
-// ListNotificationsHandler lists notifications for a user
+const (
+	// notificationsKeepAliveInterval is the interval of sending comments to keep notification streams open
+	notificationsKeepAliveInterval = 30 * time.Second
+	// defaultNotificationsLimit is the number of notifications listed in a page if no limit is given
+	defaultNotificationsLimit = 50
+	// maxNotificationsLimit is the max number of notifications listed in a page
+	maxNotificationsLimit = 100
+)
+
+var notificationTypes = []string{models.VMsType, models.K8sType, models.VoucherType, models.AnnouncementType, models.BalanceType}
+
+// readPage reads the before cursor and the limit of a listed page, before is the id of the last item of the previous page
+func readPage(req *http.Request, defaultLimit, maxLimit int) (before int, limit int, err error) {
+	query := req.URL.Query()
+	if cursor := query.Get("before"); cursor != "" {
+		before, err = strconv.Atoi(cursor)
+		if err != nil {
+			return 0, 0, errors.New("failed to read before cursor")
+		}
+	}
+
+	limit = defaultLimit
+	if l := query.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 || limit > maxLimit {
+			return 0, 0, fmt.Errorf("limit should be between 1 and %d", maxLimit)
+		}
+	}
+
+	return before, limit, nil
+}
+
+// UnreadNotifications struct holds the number of unseen notifications of a user
+type UnreadNotifications struct {
+	Unread int64 `json:"unread"`
+}
+
+// notificationType reads the notification type filter from the request query
+func notificationType(req *http.Request) (string, error) {
+	t := req.URL.Query().Get("type")
+	if t != "" && !internal.Contains(notificationTypes, t) {
+		return "", fmt.Errorf("invalid notification type '%s'", t)
+	}
+	return t, nil
+}
+
+// ListNotificationsHandler lists notifications for a user, newest first.
+// The next page is listed with the id of the last notification as the before query parameter
 func (a *App) ListNotificationsHandler(req *http.Request) (interface{}, Response) {
 	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
 
-	notifications, err := a.db.ListNotifications(userID)
-	if errors.Is(err, gorm.ErrRecordNotFound) || len(notifications) == 0 {
+	var filter models.NotificationsFilter
+	var err error
+	filter.Type, err = notificationType(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	query := req.URL.Query()
+	if unread := query.Get("unread"); unread != "" {
+		filter.Unread, err = strconv.ParseBool(unread)
+		if err != nil {
+			return nil, BadRequest(errors.New("failed to read unread filter"))
+		}
+	}
+
+	filter.Before, filter.Limit, err = readPage(req, defaultNotificationsLimit, maxNotificationsLimit)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	notifications, err := a.db.ListNotifications(userID, filter)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if len(notifications) == 0 {
 		return ResponseMsg{
 			Message: "You don't have any notifications yet",
 			Data:    notifications,
 		}, Ok()
 	}
 
+	return ResponseMsg{
+		Message: "You have notifications",
+		Data:    notifications,
+	}, Ok()
+}
+
+// CountUnreadNotificationsHandler returns the number of unseen notifications of a user
+func (a *App) CountUnreadNotificationsHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	t, err := notificationType(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	unread, err := a.db.CountUnreadNotifications(userID, t)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
 	return ResponseMsg{
-		Message: "You have notifications",
-		Data:    notifications,
+		Message: fmt.Sprintf("You have %d unread notifications", unread),
+		Data:    UnreadNotifications{Unread: unread},
 	}, Ok()
 }
 
-// UpdateNotificationsHandler updates notifications for a user
+// UpdateNotificationsHandler marks a notification of the user as seen
 func (a *App) UpdateNotificationsHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
 	id, err := strconv.Atoi(mux.Vars(req)["id"])
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, BadRequest(errors.New("failed to read notification id"))
 	}
 
-	err = a.db.UpdateNotification(id, true)
+	err = a.db.UpdateNotification(userID, id, true)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("notification is not found"))
+	}
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
@@ -54,3 +152,150 @@ func (a *App) UpdateNotificationsHandler(req *http.Request) (interface{}, Respon
 		Data:    nil,
 	}, Ok()
 }
+
+// MarkAllNotificationsSeenHandler marks all notifications of the user as seen, it can be filtered by type
+func (a *App) MarkAllNotificationsSeenHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	t, err := notificationType(req)
+	if err != nil {
+		return nil, BadRequest(err)
+	}
+
+	err = a.db.MarkAllNotificationsSeen(userID, t)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "All notifications are marked as seen",
+		Data:    nil,
+	}, Ok()
+}
+
+// DeleteNotificationHandler deletes a notification of the user
+func (a *App) DeleteNotificationHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read notification id"))
+	}
+
+	err = a.db.DeleteNotification(userID, id)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("notification is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Notification is deleted successfully",
+		Data:    nil,
+	}, Ok()
+}
+
+// StreamNotificationsHandler streams the new notifications of a user as server-sent events,
+// notifications created after the one in the Last-Event-ID header are sent first
+func (a *App) StreamNotificationsHandler(w http.ResponseWriter, req *http.Request) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeResponse(w, req, nil, InternalServerError(errors.New("streaming is not supported")))
+		return
+	}
+
+	var lastID int
+	if lastEventID := req.Header.Get("Last-Event-ID"); lastEventID != "" {
+		var err error
+		lastID, err = strconv.Atoi(lastEventID)
+		if err != nil {
+			writeResponse(w, req, nil, BadRequest(errors.New("failed to read last event id")))
+			return
+		}
+	}
+
+	// subscribe before reading the missed notifications so no notification is lost in between
+	pubsub, err := a.redis.SubscribeNotifications(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		writeResponse(w, req, nil, InternalServerError(errors.New(internalServerErrorMsg)))
+		return
+	}
+	defer pubsub.Close()
+
+	var missed []models.Notification
+	if lastID != 0 {
+		missed, err = a.db.ListNotificationsAfter(userID, lastID)
+		if err != nil {
+			log.Error().Err(err).Send()
+			writeResponse(w, req, nil, InternalServerError(errors.New(internalServerErrorMsg)))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, n := range missed {
+		if err := writeNotificationEvent(w, n); err != nil {
+			return
+		}
+		lastID = n.ID
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(notificationsKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			var n models.Notification
+			if err := json.Unmarshal([]byte(msg.Payload), &n); err != nil {
+				log.Error().Err(err).Msg("failed to read published notification")
+				continue
+			}
+
+			// already sent from the missed notifications
+			if n.ID <= lastID {
+				continue
+			}
+
+			if err := writeNotificationEvent(w, n); err != nil {
+				return
+			}
+			lastID = n.ID
+		}
+		flusher.Flush()
+	}
+}
+
+// writeNotificationEvent writes a notification as a server-sent event with the notification id as the event id
+func writeNotificationEvent(w http.ResponseWriter, n models.Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: notification\ndata: %s\n\n", n.ID, data)
+	return err
+}
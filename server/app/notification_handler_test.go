@@ -0,0 +1,142 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationsHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
+	assert.NoError(t, err)
+
+	for _, notificationType := range []string{models.VMsType, models.VoucherType, models.VMsType} {
+		err = app.db.CreateNotification(&models.Notification{UserID: user.ID.String(), Msg: "msg", Type: notificationType})
+		assert.NoError(t, err)
+	}
+
+	others := models.Notification{UserID: "another user", Msg: "msg", Type: models.VMsType}
+	err = app.db.CreateNotification(&others)
+	assert.NoError(t, err)
+
+	t.Run("List notifications: filtered by type", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ListNotificationsHandler,
+				api:         fmt.Sprintf("/%s/notification?type=%s&limit=1", app.config.Version, models.VMsType),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"id":3`)
+		assert.NotContains(t, response.Body.String(), `"id":1`)
+	})
+
+	t.Run("List notifications: invalid limit", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ListNotificationsHandler,
+				api:         fmt.Sprintf("/%s/notification?limit=1000", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Update notification: not owned", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.UpdateNotificationsHandler,
+				api:         fmt.Sprintf("/%s/notification/%d", app.config.Version, others.ID),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			varID:  others.ID,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("Mark all notifications seen: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.MarkAllNotificationsSeenHandler,
+				api:         fmt.Sprintf("/%s/notification?type=%s", app.config.Version, models.VMsType),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("Count unread notifications: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.CountUnreadNotificationsHandler,
+				api:         fmt.Sprintf("/%s/notification/unread", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"unread":1`)
+	})
+
+	t.Run("Delete notification: not owned", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.DeleteNotificationHandler,
+				api:         fmt.Sprintf("/%s/notification/%d", app.config.Version, others.ID),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			varID:  others.ID,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("Delete notification: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.DeleteNotificationHandler,
+				api:         fmt.Sprintf("/%s/notification/1", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			varID:  1,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+}
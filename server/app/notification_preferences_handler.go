@@ -0,0 +1,244 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// digestsInterval is the interval of checking for due digests
+	digestsInterval = time.Hour
+	// digestPeriod is the time between the digests of a user
+	digestPeriod = 24 * time.Hour
+)
+
+// NotificationPreferencesInput struct for data needed when user updates their notification preferences
+type NotificationPreferencesInput struct {
+	// channels of each category, categories that are not set keep their channels
+	Categories map[string]models.NotificationChannels `json:"categories"`
+	// the digest keeps its state if it is not set
+	EmailDigest *bool `json:"email_digest"`
+}
+
+// GetNotificationPreferencesHandler returns the channels of each notification category of the user
+func (a *App) GetNotificationPreferencesHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	preferences, err := a.db.GetNotificationPreferences(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Notification preferences are found",
+		Data:    preferencesWithDefaults(preferences),
+	}, Ok()
+}
+
+// UpdateNotificationPreferencesHandler updates the notification preferences of the user
+func (a *App) UpdateNotificationPreferencesHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	var input NotificationPreferencesInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read notification preferences data"))
+	}
+
+	for category := range input.Categories {
+		if !internal.Contains(models.NotificationCategories, category) {
+			return nil, BadRequest(fmt.Errorf("invalid notification category '%s'", category))
+		}
+	}
+
+	preferences, err := a.db.GetNotificationPreferences(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if preferences.Categories == nil {
+		preferences.Categories = map[string]models.NotificationChannels{}
+	}
+	for category, channels := range input.Categories {
+		preferences.Categories[category] = channels
+	}
+
+	emailDigest := preferences.EmailDigest
+	if input.EmailDigest != nil {
+		emailDigest = *input.EmailDigest
+	}
+
+	// digests are made of the in-app notifications, mails of categories without them would be lost
+	if emailDigest {
+		for _, category := range models.NotificationCategories {
+			if channels := preferences.Channels(category); channels.Email && !channels.InApp {
+				return nil, BadRequest(fmt.Errorf("email digest needs in-app notifications, enable in-app notifications of '%s' or disable email digest", category))
+			}
+		}
+	}
+
+	// the first digest has the notifications since the digest is enabled
+	if emailDigest && !preferences.EmailDigest {
+		latest, err := a.db.ListNotifications(userID, models.NotificationsFilter{Limit: 1})
+		if err != nil {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+
+		preferences.LastDigestNotificationID = 0
+		if len(latest) != 0 {
+			preferences.LastDigestNotificationID = latest[0].ID
+		}
+		preferences.LastDigestAt = time.Now()
+	}
+	preferences.EmailDigest = emailDigest
+
+	err = a.db.UpdateNotificationPreferences(preferences)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Notification preferences are updated successfully",
+		Data:    preferencesWithDefaults(preferences),
+	}, Ok()
+}
+
+// preferencesWithDefaults sets the channels of all categories so users see the defaults too
+func preferencesWithDefaults(p models.NotificationPreferences) models.NotificationPreferences {
+	categories := make(map[string]models.NotificationChannels, len(models.NotificationCategories))
+	for _, category := range models.NotificationCategories {
+		categories[category] = p.Channels(category)
+	}
+	p.Categories = categories
+	return p
+}
+
+// notify sends a notification to a user on the channels they prefer for its category:
+// it is stored in-app, the event is emitted to their webhooks
+// and the mail is queued unless they get their notifications in a daily digest
+func (a *App) notify(user models.User, n models.Notification, event string, data interface{}, mail string, content func(internal.MailTemplate) (string, string, error)) error {
+	preferences, err := a.db.GetNotificationPreferences(user.ID.String())
+	if err != nil {
+		return err
+	}
+	channels := preferences.Channels(models.NotificationCategory(n.Type))
+
+	if channels.InApp {
+		n.UserID = user.ID.String()
+		if err := a.db.CreateNotification(&n); err != nil {
+			return err
+		}
+	}
+
+	if channels.Webhook && event == models.EventBalanceLow {
+		// events of the grid account are delivered to the webhooks of the notified admin only
+		if err := a.db.EmitAdminEvent(user.ID.String(), event, data); err != nil {
+			log.Error().Err(err).Msgf("failed to emit event %s", event)
+		}
+	} else if channels.Webhook && event != "" {
+		a.emitEvent(user.ID.String(), event, data)
+	}
+
+	if !channels.Email || preferences.EmailDigest || mail == "" {
+		return nil
+	}
+
+	subject, body, err := content(a.mailTemplate(mail, user.Language))
+	if err != nil {
+		return err
+	}
+
+	return a.db.EnqueueMail(user.Email, subject, body)
+}
+
+// notifyDeploymentResult notifies a user with the result of their deployment
+func (a *App) notifyDeploymentResult(user models.User, n models.Notification, event string, data map[string]interface{}) {
+	title := "Your deployment is ready 🎆"
+	if event == models.EventVMFailed || event == models.EventK8sFailed {
+		title = "Your deployment failed 😔"
+	}
+
+	err := a.notify(user, n, event, data, internal.NotificationMail, func(t internal.MailTemplate) (string, string, error) {
+		return internal.NotificationMailContent(t, title, n.Msg, user.Name, a.config.Server.Host)
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to notify user %s with deployment result", user.ID.String())
+	}
+}
+
+// sendDigests queues the daily digests of users until the context is done
+func (a *App) sendDigests(ctx context.Context) {
+	ticker := time.NewTicker(digestsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		due, err := a.db.ListDueDigests(time.Now().Add(-digestPeriod))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to list due digests")
+			continue
+		}
+
+		for _, preferences := range due {
+			if err := a.sendDigest(preferences); err != nil {
+				log.Error().Err(err).Msgf("failed to send digest of user %s", preferences.UserID)
+			}
+		}
+	}
+}
+
+// sendDigest queues a mail with the unread notifications of a user since their last digest,
+// only the notifications of categories the user gets emails of are included
+func (a *App) sendDigest(preferences models.NotificationPreferences) error {
+	notifications, err := a.db.ListNotificationsAfter(preferences.UserID, preferences.LastDigestNotificationID)
+	if err != nil {
+		return err
+	}
+
+	lastID := preferences.LastDigestNotificationID
+	var messages []string
+	for _, n := range notifications {
+		lastID = n.ID
+		if !n.Seen && preferences.Channels(models.NotificationCategory(n.Type)).Email {
+			messages = append(messages, n.Msg)
+		}
+	}
+
+	if len(messages) != 0 {
+		user, err := a.db.GetUserByID(preferences.UserID)
+		if err != nil {
+			return err
+		}
+
+		subject, body, err := internal.DigestMailContent(a.mailTemplate(internal.DigestMail, user.Language), messages, user.Name, a.config.Server.Host)
+		if err != nil {
+			return err
+		}
+
+		if err := a.db.EnqueueMail(user.Email, subject, body); err != nil {
+			return err
+		}
+	}
+
+	return a.db.SetLastDigest(preferences.UserID, lastID, time.Now())
+}
@@ -0,0 +1,244 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationPreferencesHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
+	assert.NoError(t, err)
+
+	t.Run("Get preferences: defaults", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.GetNotificationPreferencesHandler,
+				api:         fmt.Sprintf("/%s/notification/preferences", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"announcements":{"in_app":true,"email":true,"webhook":true}`)
+		assert.Contains(t, response.Body.String(), `"expiry":{"in_app":true,"email":true,"webhook":true}`)
+	})
+
+	t.Run("Update preferences: invalid category", func(t *testing.T) {
+		body, err := json.Marshal(NotificationPreferencesInput{Categories: map[string]models.NotificationChannels{"games": {}}})
+		assert.NoError(t, err)
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: app.UpdateNotificationPreferencesHandler,
+				api:         fmt.Sprintf("/%s/notification/preferences", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Update preferences: success", func(t *testing.T) {
+		body, err := json.Marshal(NotificationPreferencesInput{
+			Categories: map[string]models.NotificationChannels{models.VouchersCategory: {Email: true}},
+		})
+		assert.NoError(t, err)
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: app.UpdateNotificationPreferencesHandler,
+				api:         fmt.Sprintf("/%s/notification/preferences", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"vouchers":{"in_app":false,"email":true,"webhook":false}`)
+	})
+
+	updatePreferences := func(input NotificationPreferencesInput) *httptest.ResponseRecorder {
+		body, err := json.Marshal(input)
+		assert.NoError(t, err)
+
+		return authorizedHandler(authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: app.UpdateNotificationPreferencesHandler,
+				api:         fmt.Sprintf("/%s/notification/preferences", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		})
+	}
+
+	t.Run("Update preferences: digest needs in-app notifications", func(t *testing.T) {
+		enabled := true
+		response := updatePreferences(NotificationPreferencesInput{EmailDigest: &enabled})
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+		assert.Contains(t, response.Body.String(), "vouchers")
+
+		preferences, err := app.db.GetNotificationPreferences(user.ID.String())
+		assert.NoError(t, err)
+		assert.False(t, preferences.EmailDigest)
+	})
+
+	t.Run("Update preferences: digest is kept if not set", func(t *testing.T) {
+		enabled := true
+		response := updatePreferences(NotificationPreferencesInput{
+			Categories:  map[string]models.NotificationChannels{models.DeploymentsCategory: {InApp: true, Email: true}},
+			EmailDigest: &enabled,
+		})
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = updatePreferences(NotificationPreferencesInput{
+			Categories:  map[string]models.NotificationChannels{models.VouchersCategory: {InApp: true, Email: true}},
+			EmailDigest: &enabled,
+		})
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		response = updatePreferences(NotificationPreferencesInput{
+			Categories: map[string]models.NotificationChannels{models.AnnouncementsCategory: {InApp: true}},
+		})
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"email_digest":true`)
+
+		// email only categories are refused while the digest is enabled
+		response = updatePreferences(NotificationPreferencesInput{
+			Categories: map[string]models.NotificationChannels{models.VouchersCategory: {Email: true}},
+		})
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		disabled := false
+		response = updatePreferences(NotificationPreferencesInput{
+			Categories:  map[string]models.NotificationChannels{models.VouchersCategory: {Email: true}},
+			EmailDigest: &disabled,
+		})
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"email_digest":false`)
+	})
+
+	t.Run("Notify: preferred channels only", func(t *testing.T) {
+		n := models.Notification{Msg: "Your voucher request is rejected", Type: models.VoucherType}
+		err := app.notify(*user, n, models.EventVoucherRejected, nil, internal.RejectedVoucherMail, func(t internal.MailTemplate) (string, string, error) {
+			return internal.RejectedVoucherMailContent(t, user.Name, app.config.Server.Host)
+		})
+		assert.NoError(t, err)
+
+		notifications, err := app.db.ListNotifications(user.ID.String(), models.NotificationsFilter{})
+		assert.NoError(t, err)
+		assert.Empty(t, notifications)
+
+		mails, err := app.db.ListOutgoingMails(models.MailsFilter{Status: models.MailPending})
+		assert.NoError(t, err)
+		assert.Len(t, mails, 1)
+	})
+
+	t.Run("Digest: unread notifications in one mail", func(t *testing.T) {
+		preferences, err := app.db.GetNotificationPreferences(user.ID.String())
+		assert.NoError(t, err)
+		preferences.EmailDigest = true
+		preferences.LastDigestAt = time.Now().Add(-2 * digestPeriod)
+		err = app.db.UpdateNotificationPreferences(preferences)
+		assert.NoError(t, err)
+
+		n := models.Notification{Msg: "Your virtual machine 'vm' is deployed successfully", Type: models.VMsType}
+		app.notifyDeploymentResult(*user, n, models.EventVMDeployed, nil)
+
+		// mails are batched in the digest
+		mails, err := app.db.ListOutgoingMails(models.MailsFilter{Status: models.MailPending})
+		assert.NoError(t, err)
+		assert.Len(t, mails, 1)
+
+		due, err := app.db.ListDueDigests(time.Now().Add(-digestPeriod))
+		assert.NoError(t, err)
+		assert.Len(t, due, 1)
+
+		err = app.sendDigest(due[0])
+		assert.NoError(t, err)
+
+		mails, err = app.db.ListOutgoingMails(models.MailsFilter{Status: models.MailPending})
+		assert.NoError(t, err)
+		assert.Len(t, mails, 2)
+
+		due, err = app.db.ListDueDigests(time.Now().Add(-digestPeriod))
+		assert.NoError(t, err)
+		assert.Empty(t, due)
+	})
+
+	t.Run("Notify: low balance on expiry channels", func(t *testing.T) {
+		admin := models.User{Name: "admin", Email: "balance@gmail.com", Verified: true, Roles: []string{models.SuperAdminRole}}
+		err := app.db.CreateUser(&admin)
+		assert.NoError(t, err)
+
+		hook := models.Webhook{UserID: admin.ID.String(), URL: "https://admin.com", Secret: "secret"}
+		err = app.db.CreateWebhook(&hook)
+		assert.NoError(t, err)
+
+		err = app.db.UpdateNotificationPreferences(models.NotificationPreferences{
+			UserID:     admin.ID.String(),
+			Categories: map[string]models.NotificationChannels{models.ExpiryCategory: {InApp: true}},
+		})
+		assert.NoError(t, err)
+
+		app.notifyLowBalance([]models.User{admin}, 5)
+
+		notifications, err := app.db.ListNotifications(admin.ID.String(), models.NotificationsFilter{Type: models.BalanceType})
+		assert.NoError(t, err)
+		assert.Len(t, notifications, 1)
+
+		deliveries, err := app.db.ListWebhookDeliveries(hook.ID, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, deliveries)
+
+		mails, err := app.db.ListOutgoingMails(models.MailsFilter{})
+		assert.NoError(t, err)
+		for _, mail := range mails {
+			assert.NotEqual(t, admin.Email, mail.Receiver)
+		}
+
+		err = app.db.UpdateNotificationPreferences(models.NotificationPreferences{
+			UserID:     admin.ID.String(),
+			Categories: map[string]models.NotificationChannels{models.ExpiryCategory: {Email: true, Webhook: true}},
+		})
+		assert.NoError(t, err)
+
+		app.notifyLowBalance([]models.User{admin}, 5)
+
+		deliveries, err = app.db.ListWebhookDeliveries(hook.ID, 10)
+		assert.NoError(t, err)
+		assert.Len(t, deliveries, 1)
+		assert.Equal(t, models.EventBalanceLow, deliveries[0].Event)
+
+		mails, err = app.db.ListOutgoingMails(models.MailsFilter{})
+		assert.NoError(t, err)
+		assert.Equal(t, admin.Email, mails[0].Receiver)
+	})
+}
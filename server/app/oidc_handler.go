@@ -0,0 +1,249 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	// oidcLoginTimeout is the time a user has to sign in at the provider
+	oidcLoginTimeout = 10 * time.Minute
+	// oidcClientTimeout is the timeout of requests to the providers
+	oidcClientTimeout = 10 * time.Second
+)
+
+// OIDCCallbackInput struct for data the provider sends back to the redirect url after a user signs in
+type OIDCCallbackInput struct {
+	State string `json:"state" binding:"required"`
+	Code  string `json:"code" binding:"required"`
+}
+
+// newOIDCClients creates the clients of the configured providers by their names
+func newOIDCClients(config internal.OIDC) map[string]*internal.OIDCClient {
+	client := &http.Client{Timeout: oidcClientTimeout}
+
+	clients := make(map[string]*internal.OIDCClient, len(config.Providers))
+	for _, provider := range config.Providers {
+		clients[provider.Name] = internal.NewOIDCClient(provider, config.RedirectURL, client)
+	}
+	return clients
+}
+
+// ListOIDCProvidersHandler lists the names of the providers users can sign in with
+func (a *App) ListOIDCProvidersHandler(req *http.Request) (interface{}, Response) {
+	names := make([]string, 0, len(a.config.OIDC.Providers))
+	for _, provider := range a.config.OIDC.Providers {
+		names = append(names, provider.Name)
+	}
+
+	return ResponseMsg{
+		Message: "Sign in providers are found",
+		Data:    names,
+	}, Ok()
+}
+
+// OIDCLoginHandler starts signing in a user with a provider, it returns the provider's url the user is redirected to
+func (a *App) OIDCLoginHandler(req *http.Request) (interface{}, Response) {
+	name := mux.Vars(req)["provider"]
+	client, ok := a.oidcClients[name]
+	if !ok {
+		return nil, NotFound(fmt.Errorf("sign in provider '%s' is not found", name))
+	}
+
+	state, hash, err := internal.GenerateToken()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	nonce, _, err := internal.GenerateToken()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	codeVerifier, _, err := internal.GenerateToken()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	authURL, err := client.AuthCodeURL(req.Context(), state, nonce, codeVerifier)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(fmt.Errorf("sign in with '%s' is not available now", name))
+	}
+
+	err = a.db.CreateOIDCLoginState(&models.OIDCLoginState{
+		Hash:         hash,
+		Provider:     name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().Add(oidcLoginTimeout),
+	})
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Redirect the user to the authorization url",
+		Data:    map[string]string{"authorization_url": authURL},
+	}, Ok()
+}
+
+// OIDCCallbackHandler completes signing in a user with the code their provider sent to the redirect url,
+// new users are signed up and existing users are linked by their verified email
+func (a *App) OIDCCallbackHandler(req *http.Request) (interface{}, Response) {
+	var input OIDCCallbackInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read sign in data"))
+	}
+
+	if strings.TrimSpace(input.State) == "" || strings.TrimSpace(input.Code) == "" {
+		return nil, BadRequest(errors.New("state and code are required"))
+	}
+
+	state, err := a.db.PopOIDCLoginState(internal.HashToken(input.State))
+	if err == gorm.ErrRecordNotFound {
+		return nil, Unauthorized(errors.New("sign in is expired, please try again"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	client, ok := a.oidcClients[state.Provider]
+	if !ok {
+		return nil, NotFound(fmt.Errorf("sign in provider '%s' is not found", state.Provider))
+	}
+
+	claims, err := client.Exchange(req.Context(), input.Code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to sign in with provider %s", state.Provider)
+		return nil, Unauthorized(fmt.Errorf("failed to sign in with '%s'", state.Provider))
+	}
+
+	user, res := a.oidcUser(client.Provider(), claims)
+	if res != nil {
+		return nil, res
+	}
+
+	if user.TOTPEnabled {
+		return a.twoFactorChallenge(user)
+	}
+
+	accessToken, refreshToken, err := a.startSession(user.ID.String(), user.Email, internal.ClientIP(req), req.UserAgent())
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "You are signed in successfully",
+		Data:    map[string]string{"user_id": user.ID.String(), "access_token": accessToken, "refresh_token": refreshToken},
+	}, Ok()
+}
+
+// oidcUser returns the user of a provider's account, linking it to the user with its email or signing up a new user
+func (a *App) oidcUser(provider internal.OIDCProvider, claims internal.OIDCClaims) (models.User, Response) {
+	if !internal.EmailDomainAllowed(claims.Email, provider.AllowedDomains) {
+		return models.User{}, Forbidden(fmt.Errorf("only emails of %s can sign in with '%s'", strings.Join(provider.AllowedDomains, ", "), provider.Name))
+	}
+
+	identity, err := a.db.GetOIDCIdentity(provider.Name, claims.Subject)
+	if err == nil {
+		user, err := a.db.GetUserByID(identity.UserID)
+		if err != nil {
+			log.Error().Err(err).Send()
+			return models.User{}, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+		return user, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		log.Error().Err(err).Send()
+		return models.User{}, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	// accounts are linked and created by email only if the provider verified it
+	if claims.Email == "" || !claims.EmailVerified {
+		return models.User{}, Forbidden(fmt.Errorf("'%s' didn't share a verified email", provider.Name))
+	}
+
+	user, err := a.db.GetUserByEmail(claims.Email)
+	switch {
+	case err == gorm.ErrRecordNotFound:
+		user, err = a.signUpOIDCUser(provider, claims)
+	case err == nil && !user.Verified:
+		err = a.db.VerifyUserWithoutPassword(user.ID.String())
+		user.Verified = true
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return models.User{}, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.db.CreateOIDCIdentity(&models.OIDCIdentity{UserID: user.ID.String(), Provider: provider.Name, Subject: claims.Subject})
+	if err != nil {
+		log.Error().Err(err).Send()
+		return models.User{}, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return user, nil
+}
+
+// signUpOIDCUser creates a verified user without a password with the claims of their provider's account
+func (a *App) signUpOIDCUser(provider internal.OIDCProvider, claims internal.OIDCClaims) (models.User, error) {
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name = claims.Email[:strings.LastIndex(claims.Email, "@")]
+	}
+
+	college := provider.College
+	if claimed, ok := claims.Raw[provider.CollegeClaim].(string); ok && provider.CollegeClaim != "" && claimed != "" {
+		college = claimed
+	}
+	if college == "" {
+		college = provider.Name
+	}
+
+	user := models.User{
+		Name:     name,
+		Email:    claims.Email,
+		Verified: true,
+		College:  college,
+		Language: internal.DefaultLanguage,
+		Roles:    a.signUpRoles(claims.Email),
+	}
+	if err := a.db.CreateUser(&user); err != nil {
+		return models.User{}, err
+	}
+
+	if err := a.db.CreateQuota(&models.Quota{UserID: user.ID.String()}); err != nil {
+		return models.User{}, err
+	}
+	middlewares.UserCreations.WithLabelValues(user.ID.String(), user.Email, user.College, fmt.Sprint(user.TeamSize)).Inc()
+
+	// the account is already created, a missing welcome mail shouldn't fail the sign in
+	subject, body, err := internal.WelcomeMailContent(a.mailTemplate(internal.WelcomeMail, user.Language), user.Name, a.config.Server.Host)
+	if err == nil {
+		err = a.db.EnqueueMail(user.Email, subject, body)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to queue welcome mail of user %s", user.ID.String())
+	}
+
+	return user, nil
+}
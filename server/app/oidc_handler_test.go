@@ -0,0 +1,252 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// standInIssuer is a local OpenID Connect provider that issues codes for the claims the tests choose
+type standInIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]issuedCode
+}
+
+type issuedCode struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newStandInIssuer(t *testing.T) *standInIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	issuer := &standInIssuer{key: key, codes: map[string]issuedCode{}}
+
+	r := http.NewServeMux()
+	r.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.URL,
+			"authorization_endpoint": issuer.URL + "/authorize",
+			"token_endpoint":         issuer.URL + "/token",
+			"jwks_uri":               issuer.URL + "/jwks",
+		})
+	})
+	r.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string][]internal.JWK{"keys": {{
+			Kty: "RSA",
+			Kid: "key",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	r.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		code, ok := issuer.codes[r.FormValue("code")]
+		delete(issuer.codes, r.FormValue("code"))
+		issuer.mu.Unlock()
+
+		if !ok || internal.PKCEChallenge(r.FormValue("code_verifier")) != code.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, code.claims)
+		token.Header["kid"] = "key"
+		idToken, err := token.SignedString(key)
+		assert.NoError(t, err)
+
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+
+	issuer.Server = httptest.NewServer(r)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// authorize issues a code for the sign in started with the authorization url as the user signs in at the provider
+func (s *standInIssuer) authorize(t *testing.T, authURL string, claims jwt.MapClaims) (state, code string) {
+	u, err := url.Parse(authURL)
+	assert.NoError(t, err)
+	query := u.Query()
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	claims["iss"] = s.URL
+	claims["aud"] = query.Get("client_id")
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	claims["nonce"] = query.Get("nonce")
+
+	code, _, err = internal.GenerateToken()
+	assert.NoError(t, err)
+
+	s.mu.Lock()
+	s.codes[code] = issuedCode{challenge: query.Get("code_challenge"), claims: claims}
+	s.mu.Unlock()
+
+	return query.Get("state"), code
+}
+
+func TestOIDCHandlers(t *testing.T) {
+	app := SetUp(t)
+	issuer := newStandInIssuer(t)
+
+	app.config.OIDC = internal.OIDC{
+		RedirectURL: "http://localhost/oidc/callback",
+		Providers: []internal.OIDCProvider{{
+			Name:           "uni",
+			Issuer:         issuer.URL,
+			ClientID:       "c4s",
+			ClientSecret:   "secret",
+			AllowedDomains: []string{"uni.edu"},
+			CollegeClaim:   "school",
+		}},
+	}
+	app.oidcClients = newOIDCClients(app.config.OIDC)
+
+	login := func(t *testing.T) string {
+		request := httptest.NewRequest("POST", fmt.Sprintf("/%s/user/oidc/uni/login", app.config.Version), nil)
+		request = mux.SetURLVars(request, map[string]string{"provider": "uni"})
+		response := httptest.NewRecorder()
+		WrapFunc(app.OIDCLoginHandler).ServeHTTP(response, request)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		var res struct {
+			Data map[string]string `json:"data"`
+		}
+		err := json.Unmarshal(response.Body.Bytes(), &res)
+		assert.NoError(t, err)
+		return res.Data["authorization_url"]
+	}
+
+	callback := func(state, code string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(OIDCCallbackInput{State: state, Code: code})
+		assert.NoError(t, err)
+
+		return unAuthorizedHandler(unAuthHandlerConfig{
+			body:        bytes.NewBuffer(body),
+			handlerFunc: app.OIDCCallbackHandler,
+			api:         fmt.Sprintf("/%s/user/oidc/callback", app.config.Version),
+		})
+	}
+
+	signIn := func(t *testing.T, claims jwt.MapClaims) *httptest.ResponseRecorder {
+		state, code := issuer.authorize(t, login(t), claims)
+		return callback(state, code)
+	}
+
+	t.Run("List providers", func(t *testing.T) {
+		response := unAuthorizedHandler(unAuthHandlerConfig{
+			handlerFunc: app.ListOIDCProvidersHandler,
+			api:         fmt.Sprintf("/%s/user/oidc/providers", app.config.Version),
+		})
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), `"uni"`)
+	})
+
+	t.Run("Login: unknown provider", func(t *testing.T) {
+		request := httptest.NewRequest("POST", fmt.Sprintf("/%s/user/oidc/other/login", app.config.Version), nil)
+		request = mux.SetURLVars(request, map[string]string{"provider": "other"})
+		response := httptest.NewRecorder()
+		WrapFunc(app.OIDCLoginHandler).ServeHTTP(response, request)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("Callback: new user is signed up", func(t *testing.T) {
+		response := signIn(t, jwt.MapClaims{"sub": "student", "email": "student@uni.edu", "email_verified": true, "name": "Student", "school": "Engineering"})
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), "access_token")
+
+		user, err := app.db.GetUserByEmail("student@uni.edu")
+		assert.NoError(t, err)
+		assert.True(t, user.Verified)
+		assert.Equal(t, "Engineering", user.College)
+		assert.Empty(t, user.HashedPassword)
+
+		_, err = app.db.GetUserQuota(user.ID.String())
+		assert.NoError(t, err)
+	})
+
+	t.Run("Callback: linked user signs in again", func(t *testing.T) {
+		response := signIn(t, jwt.MapClaims{"sub": "student", "email": "renamed@uni.edu", "email_verified": true})
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		_, err := app.db.GetUserByEmail("renamed@uni.edu")
+		assert.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("Callback: existing user is linked by email", func(t *testing.T) {
+		existing := models.User{Name: "existing", Email: "existing@uni.edu", Verified: true, HashedPassword: user.HashedPassword}
+		err := app.db.CreateUser(&existing)
+		assert.NoError(t, err)
+
+		response := signIn(t, jwt.MapClaims{"sub": "existing", "email": "existing@uni.edu", "email_verified": true})
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), existing.ID.String())
+
+		identity, err := app.db.GetOIDCIdentity("uni", "existing")
+		assert.NoError(t, err)
+		assert.Equal(t, existing.ID.String(), identity.UserID)
+
+		got, err := app.db.GetUserByID(existing.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, existing.HashedPassword, got.HashedPassword)
+	})
+
+	t.Run("Callback: password of an unverified user is removed", func(t *testing.T) {
+		pending := models.User{Name: "pending", Email: "pending@uni.edu", HashedPassword: user.HashedPassword}
+		err := app.db.CreateUser(&pending)
+		assert.NoError(t, err)
+
+		response := signIn(t, jwt.MapClaims{"sub": "pending", "email": "pending@uni.edu", "email_verified": true})
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		got, err := app.db.GetUserByID(pending.ID.String())
+		assert.NoError(t, err)
+		assert.True(t, got.Verified)
+		assert.Empty(t, got.HashedPassword)
+	})
+
+	t.Run("Callback: email domain is not allowed", func(t *testing.T) {
+		response := signIn(t, jwt.MapClaims{"sub": "outsider", "email": "outsider@gmail.com", "email_verified": true})
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("Callback: email is not verified", func(t *testing.T) {
+		response := signIn(t, jwt.MapClaims{"sub": "unverified", "email": "unverified@uni.edu", "email_verified": false})
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("Callback: state is used once", func(t *testing.T) {
+		state, code := issuer.authorize(t, login(t), jwt.MapClaims{"sub": "student", "email": "student@uni.edu", "email_verified": true})
+		assert.Equal(t, http.StatusOK, callback(state, code).Code)
+		assert.Equal(t, http.StatusUnauthorized, callback(state, code).Code)
+	})
+
+	t.Run("Callback: code of another sign in", func(t *testing.T) {
+		_, code := issuer.authorize(t, login(t), jwt.MapClaims{"sub": "student", "email": "student@uni.edu", "email_verified": true})
+		state, _ := issuer.authorize(t, login(t), jwt.MapClaims{"sub": "student", "email": "student@uni.edu", "email_verified": true})
+		assert.Equal(t, http.StatusUnauthorized, callback(state, code).Code)
+	})
+}
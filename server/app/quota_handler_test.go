@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"testing"
 
-	"github.com/codescalers/cloud4students/internal"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/stretchr/testify/assert"
 )
@@ -18,7 +17,7 @@ func TestQuotaRouter(t *testing.T) {
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
 	t.Run("get quota: not found", func(t *testing.T) {
@@ -0,0 +1,77 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/codescalers/cloud4students/deployer"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/codescalers/cloud4students/streams"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// DeploymentRequestStatus holds the status of a deployment request with its place in the queue
+type DeploymentRequestStatus struct {
+	models.DeploymentRequest
+	// number of requests to be deployed before this one, in the order of the workers' schedulers
+	QueuePosition int64 `json:"queue_position"`
+	// estimated seconds left for the request to be deployed
+	ETA int64 `json:"eta,omitempty"`
+}
+
+// GetDeploymentRequestHandler returns the status of a deployment request
+func (a *App) GetDeploymentRequestHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	id := mux.Vars(req)["id"]
+
+	request, err := a.db.GetDeploymentRequest(id)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("deployment request is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if request.UserID != userID {
+		return nil, NotFound(errors.New("deployment request is not found"))
+	}
+
+	status := DeploymentRequestStatus{DeploymentRequest: request}
+	if request.Finished() {
+		return ResponseMsg{
+			Message: "Deployment request is " + request.Phase,
+			Data:    status,
+		}, Ok()
+	}
+
+	status.QueuePosition, err = a.deployer.QueuePosition(request)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	timingsKey := streams.VMBatchTimingsKey
+	if request.Type == models.K8sType {
+		timingsKey = streams.K8sBatchTimingsKey
+	}
+
+	// the estimation is optional, the status is still useful without it
+	timings, err := a.redis.BatchTimings(timingsKey)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get batch deployment timings")
+	} else {
+		interval := time.Duration(a.config.Deployment.BatchIntervalSeconds) * time.Second
+		eta := deployer.EstimateETA(status.QueuePosition, interval, a.config.Deployment.BatchSize, timings)
+		status.ETA = int64(eta.Seconds())
+	}
+
+	return ResponseMsg{
+		Message: "Deployment request is " + request.Phase,
+		Data:    status,
+	}, Ok()
+}
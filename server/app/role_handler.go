@@ -0,0 +1,142 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// RoleInfo struct holds a role with its permissions
+type RoleInfo struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// UpdateUserRolesInput struct for replacing the roles of a user
+type UpdateUserRolesInput struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+// bootstrapSuperAdmins gives the super admin role to the users of the configured admins emails
+func bootstrapSuperAdmins(db models.DB, emails []string) error {
+	for _, email := range emails {
+		user, err := db.GetUserByEmail(email)
+		if err == gorm.ErrRecordNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if internal.Contains(user.Roles, models.SuperAdminRole) {
+			continue
+		}
+		if err := db.UpdateUserRoles(user.ID.String(), append(user.Roles, models.SuperAdminRole)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signUpRoles returns the roles of a new user, the configured admins are super admins
+func (a *App) signUpRoles(email string) []string {
+	if internal.Contains(a.config.Admins, email) {
+		return []string{models.SuperAdminRole}
+	}
+	return nil
+}
+
+// withoutRole returns the roles without a role
+func withoutRole(roles []string, role string) []string {
+	res := []string{}
+	for _, r := range roles {
+		if r != role {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// updateUserRoles replaces the roles of a user, the last super admin can't lose their role
+func (a *App) updateUserRoles(user models.User, roles []string) Response {
+	for _, role := range roles {
+		if _, ok := models.RolePermissions[role]; !ok {
+			return BadRequest(fmt.Errorf("role '%s' is not found", role))
+		}
+	}
+
+	if internal.Contains(user.Roles, models.SuperAdminRole) && !internal.Contains(roles, models.SuperAdminRole) {
+		superAdmins, err := a.db.ListUsersWithPermission(models.PermissionManageRoles)
+		if err != nil {
+			log.Error().Err(err).Send()
+			return InternalServerError(errors.New(internalServerErrorMsg))
+		}
+		if len(superAdmins) <= 1 {
+			return BadRequest(errors.New("the last super admin can't lose their role"))
+		}
+	}
+
+	err := a.db.UpdateUserRoles(user.ID.String(), roles)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	return nil
+}
+
+// ListRolesHandler lists the roles users can have with their permissions
+func (a *App) ListRolesHandler(req *http.Request) (interface{}, Response) {
+	roles := []RoleInfo{}
+	for _, role := range models.Roles() {
+		roles = append(roles, RoleInfo{Name: role, Permissions: models.RolePermissions[role]})
+	}
+
+	return ResponseMsg{
+		Message: "Roles are found",
+		Data:    roles,
+	}, Ok()
+}
+
+// UpdateUserRolesHandler replaces the roles of a user
+func (a *App) UpdateUserRolesHandler(req *http.Request) (interface{}, Response) {
+	id := mux.Vars(req)["id"]
+	var input UpdateUserRolesInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read roles data"))
+	}
+
+	user, err := a.db.GetUserByID(id)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	roles := []string{}
+	for _, role := range input.Roles {
+		if !internal.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+
+	if res := a.updateUserRoles(user, roles); res != nil {
+		return nil, res
+	}
+
+	return ResponseMsg{
+		Message: "Roles of the user are updated successfully",
+		Data:    map[string]interface{}{"roles": roles, "permissions": models.Permissions(roles)},
+	}, Ok()
+}
@@ -0,0 +1,123 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	admin := models.User{Name: "admin", Email: "admin@gmail.com", Verified: true, Roles: []string{models.SuperAdminRole}}
+	err := app.db.CreateUser(&admin)
+	assert.NoError(t, err)
+	adminToken, _, err := app.startSession(admin.ID.String(), admin.Email, "", "")
+	assert.NoError(t, err)
+
+	reviewer := models.User{Name: "reviewer", Email: "reviewer@gmail.com", Verified: true}
+	err = app.db.CreateUser(&reviewer)
+	assert.NoError(t, err)
+	reviewerToken, _, err := app.startSession(reviewer.ID.String(), reviewer.Email, "", "")
+	assert.NoError(t, err)
+
+	request := func(token string, handlerFunc Handler, permission string, vars map[string]string, body string) *httptest.ResponseRecorder {
+		return adminHandler(authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer([]byte(body)),
+				handlerFunc: handlerFunc,
+				api:         fmt.Sprintf("/%s/roles", app.config.Version),
+			},
+			token:      token,
+			vars:       vars,
+			config:     app.config,
+			db:         app.db,
+			permission: permission,
+		})
+	}
+
+	updateRoles := func(token string, user models.User, roles string) *httptest.ResponseRecorder {
+		return request(token, app.UpdateUserRolesHandler, models.PermissionManageRoles, map[string]string{"id": user.ID.String()}, fmt.Sprintf(`{"roles": %s}`, roles))
+	}
+
+	t.Run("List roles: success", func(t *testing.T) {
+		response := request(adminToken, app.ListRolesHandler, models.PermissionManageRoles, nil, "")
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), models.VoucherReviewerRole)
+	})
+
+	t.Run("Update roles: only super admins manage roles", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, updateRoles(reviewerToken, reviewer, `["super_admin"]`).Code)
+	})
+
+	t.Run("Update roles: unknown role", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, updateRoles(adminToken, reviewer, `["owner"]`).Code)
+	})
+
+	t.Run("Update roles: success", func(t *testing.T) {
+		response := updateRoles(adminToken, reviewer, `["voucher_reviewer"]`)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), models.PermissionReviewVouchers)
+
+		got, err := app.db.GetUserByID(reviewer.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{models.VoucherReviewerRole}, got.Roles)
+	})
+
+	t.Run("Permissions: voucher reviewer", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, request(reviewerToken, app.ListVouchersHandler, models.PermissionReadVouchers, nil, "").Code)
+		assert.Equal(t, http.StatusForbidden, request(reviewerToken, app.DeleteAllDeployments, models.PermissionDeleteDeployments, nil, "").Code)
+		assert.Equal(t, http.StatusForbidden, request(reviewerToken, app.SetAdmin, models.PermissionManageRoles, nil, "").Code)
+	})
+
+	t.Run("Update roles: last super admin", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, updateRoles(adminToken, admin, `[]`).Code)
+
+		body := fmt.Sprintf(`{"email": "%s", "admin": false}`, admin.Email)
+		assert.Equal(t, http.StatusBadRequest, request(adminToken, app.SetAdmin, models.PermissionManageRoles, nil, body).Code)
+	})
+
+	t.Run("Set admin: super admin role is given and removed", func(t *testing.T) {
+		body := fmt.Sprintf(`{"email": "%s", "admin": true}`, reviewer.Email)
+		assert.Equal(t, http.StatusOK, request(adminToken, app.SetAdmin, models.PermissionManageRoles, nil, body).Code)
+
+		got, err := app.db.GetUserByID(reviewer.ID.String())
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{models.VoucherReviewerRole, models.SuperAdminRole}, got.Roles)
+
+		body = fmt.Sprintf(`{"email": "%s", "admin": false}`, admin.Email)
+		assert.Equal(t, http.StatusOK, request(adminToken, app.SetAdmin, models.PermissionManageRoles, nil, body).Code)
+
+		got, err = app.db.GetUserByID(admin.ID.String())
+		assert.NoError(t, err)
+		assert.Empty(t, got.Roles)
+	})
+}
+
+func TestBootstrapSuperAdmins(t *testing.T) {
+	app := SetUp(t)
+
+	support := models.User{Name: "support", Email: "support@gmail.com", Verified: true, Roles: []string{models.SupportRole}}
+	err := app.db.CreateUser(&support)
+	assert.NoError(t, err)
+
+	err = bootstrapSuperAdmins(app.db, []string{support.Email, "unknown@gmail.com"})
+	assert.NoError(t, err)
+
+	got, err := app.db.GetUserByID(support.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{models.SupportRole, models.SuperAdminRole}, got.Roles)
+
+	err = bootstrapSuperAdmins(app.db, []string{support.Email})
+	assert.NoError(t, err)
+
+	got, err = app.db.GetUserByID(support.ID.String())
+	assert.NoError(t, err)
+	assert.Len(t, got.Roles, 2)
+}
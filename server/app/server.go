@@ -5,16 +5,11 @@ import (
 	"context"
 	"errors"
 	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
-var substrateBlockDiffInSeconds = 6
-
 // Server struct holds port of server
 type server struct {
 	host string
@@ -26,8 +21,8 @@ func newServer(host, port string) *server {
 	return &server{host, port}
 }
 
-// Start starts the server
-func (s *server) start() (err error) {
+// Start starts the server until the context is done
+func (s *server) start(ctx context.Context) (err error) {
 	log.Info().Msgf("Server is listening on %s%s", s.host, s.port)
 
 	srv := &http.Server{
@@ -41,9 +36,7 @@ func (s *server) start() (err error) {
 		log.Info().Msg("Stopped serving new connections")
 	}()
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	<-ctx.Done()
 
 	shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownRelease()
@@ -0,0 +1,84 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// ListSessionsHandler lists the active sessions of the user, the last used first
+func (a *App) ListSessionsHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	sessionID := req.Context().Value(middlewares.SessionIDKey("SessionID")).(string)
+
+	sessions, err := a.db.ListActiveSessions(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == sessionID
+	}
+
+	return ResponseMsg{
+		Message: "Sessions are found",
+		Data:    sessions,
+	}, Ok()
+}
+
+// RevokeSessionHandler revokes an active session of the user, signing out its device
+func (a *App) RevokeSessionHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	id := mux.Vars(req)["id"]
+
+	session, err := a.db.GetActiveSession(id)
+	if err == gorm.ErrRecordNotFound || session.UserID != userID {
+		return nil, NotFound(errors.New("session is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.db.RevokeSession(session.ID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Session is revoked successfully",
+		Data:    nil,
+	}, Ok()
+}
+
+// ForceLogoutUserHandler revokes all sessions of a user by admin
+func (a *App) ForceLogoutUserHandler(req *http.Request) (interface{}, Response) {
+	id := mux.Vars(req)["id"]
+
+	user, err := a.db.GetUserByID(id)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.db.RevokeUserSessions(user.ID.String(), "")
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "User is logged out of all sessions successfully",
+		Data:    nil,
+	}, Ok()
+}
@@ -0,0 +1,146 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionsHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	token, _, err := app.startSession(user.ID.String(), user.Email, "10.0.0.1", "laptop")
+	assert.NoError(t, err)
+
+	otherToken, _, err := app.startSession(user.ID.String(), user.Email, "10.0.0.2", "phone")
+	assert.NoError(t, err)
+
+	othersSession := models.Session{UserID: "another user", ExpiresAt: time.Now().Add(time.Hour)}
+	err = app.db.CreateSession(&othersSession, "hash")
+	assert.NoError(t, err)
+
+	var listed struct {
+		Data []models.Session `json:"data"`
+	}
+
+	t.Run("List sessions: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ListSessionsHandler,
+				api:         fmt.Sprintf("/%s/user/sessions", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		err := json.Unmarshal(response.Body.Bytes(), &listed)
+		assert.NoError(t, err)
+		assert.Len(t, listed.Data, 2)
+
+		current := 0
+		for _, s := range listed.Data {
+			if s.Current {
+				current++
+				assert.Equal(t, "laptop", s.UserAgent)
+			}
+		}
+		assert.Equal(t, 1, current)
+	})
+
+	t.Run("Revoke session: session of another user", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.RevokeSessionHandler,
+				api:         fmt.Sprintf("/%s/user/sessions/%s", app.config.Version, othersSession.ID),
+			},
+			token:  token,
+			vars:   map[string]string{"id": othersSession.ID},
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("Revoke session: success", func(t *testing.T) {
+		var phone models.Session
+		for _, s := range listed.Data {
+			if !s.Current {
+				phone = s
+			}
+		}
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.RevokeSessionHandler,
+				api:         fmt.Sprintf("/%s/user/sessions/%s", app.config.Version, phone.ID),
+			},
+			token:  token,
+			vars:   map[string]string{"id": phone.ID},
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		req.handlerFunc = app.ListSessionsHandler
+		req.token = otherToken
+		req.vars = nil
+		response = authorizedHandler(req)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("Force logout user: success", func(t *testing.T) {
+		admin := models.User{
+			Name:     "admin",
+			Email:    "admin@gmail.com",
+			Verified: true,
+			Roles:    []string{models.SuperAdminRole},
+		}
+		err := app.db.CreateUser(&admin)
+		assert.NoError(t, err)
+
+		adminToken, _, err := app.startSession(admin.ID.String(), admin.Email, "", "")
+		assert.NoError(t, err)
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ForceLogoutUserHandler,
+				api:         fmt.Sprintf("/%s/user/%s/logout", app.config.Version, user.ID.String()),
+			},
+			token:  adminToken,
+			vars:   map[string]string{"id": user.ID.String()},
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		req.vars = map[string]string{"id": "unknown"}
+		response = adminHandler(req)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+
+		req.handlerFunc = app.ListSessionsHandler
+		req.token = token
+		req.vars = nil
+		response = authorizedHandler(req)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	})
+}
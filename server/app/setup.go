@@ -2,12 +2,12 @@
 package app
 
 import (
-	"context"
 	"fmt"
 	"io"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"time"
 
 	"testing"
 
@@ -28,6 +28,10 @@ type authHandlerConfig struct {
 	config internal.Configuration
 	db     models.DB
 	varID  int
+	// url vars other than id
+	vars map[string]string
+	// permission required by admin handlers, managing roles if it is not set as only super admins have it
+	permission string
 }
 
 type unAuthHandlerConfig struct {
@@ -85,10 +89,16 @@ func SetUp(t testing.TB) *App {
 	err = db.Migrate()
 	assert.NoError(t, err)
 
+	err = seedEmailTemplates(db)
+	assert.NoError(t, err)
+
 	tfPluginClient, err := deployer.NewTFPluginClient(configuration.Account.Mnemonics, deployer.WithNetwork(configuration.Account.Network))
 	assert.NoError(t, err)
 
-	newDeployer, err := c4sDeployer.NewDeployer(db, streams.RedisClient{}, tfPluginClient)
+	newDeployer, err := c4sDeployer.NewDeployer(db, streams.RedisClient{}, tfPluginClient, configuration.Deployment)
+	assert.NoError(t, err)
+
+	mailer, err := internal.NewFileMailer(configuration.MailSender.Email, filepath.Join(dir, "mails"))
 	assert.NoError(t, err)
 
 	app := &App{
@@ -96,8 +106,14 @@ func SetUp(t testing.TB) *App {
 		server:   server{},
 		db:       db,
 		redis:    streams.RedisClient{},
+		limiter:  middlewares.NewMemoryRateLimitStore(),
 		deployer: newDeployer,
+		mailer:   mailer,
+		// test webhook endpoints listen on localhost
+		webhookClient: internal.NewWebhookClient(time.Duration(configuration.Webhooks.TimeoutSeconds)*time.Second, true),
+		oidcClients:   newOIDCClients(configuration.OIDC),
 	}
+	app.deployer.SetNotifier(app.notifyDeploymentResult)
 
 	return app
 }
@@ -110,17 +126,6 @@ func unAuthorizedHandler(req unAuthHandlerConfig) (response *httptest.ResponseRe
 	return
 }
 
-func authorizedNoMiddlewareHandler(req authHandlerConfig) (response *httptest.ResponseRecorder) {
-	request := httptest.NewRequest("GET", req.api, req.body)
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %v", req.token))
-	ctx := context.WithValue(request.Context(), middlewares.UserIDKey("UserID"), req.userID)
-	newRequest := request.WithContext(ctx)
-	response = httptest.NewRecorder()
-
-	WrapFunc(req.handlerFunc).ServeHTTP(response, newRequest)
-	return
-}
-
 func authorizedHandler(req authHandlerConfig) (response *httptest.ResponseRecorder) {
 	request := httptest.NewRequest("GET", req.api, req.body)
 
@@ -130,24 +135,42 @@ func authorizedHandler(req authHandlerConfig) (response *httptest.ResponseRecord
 			"id": fmt.Sprint(req.varID),
 		})
 	}
+	if req.vars != nil {
+		request = mux.SetURLVars(request, req.vars)
+	}
 
 	request.Header.Set("Authorization", fmt.Sprintf("Bearer %v", req.token))
 	response = httptest.NewRecorder()
 
 	handler := WrapFunc(req.handlerFunc)
-	handlerWithAuth := middlewares.Authorization(req.db, req.config.Token.Secret, req.config.Token.Timeout)(handler)
+	handlerWithAuth := middlewares.Authorization(req.db, req.config.Token.Secret)(handler)
 	handlerWithAuth.ServeHTTP(response, request)
 	return
 }
 
 func adminHandler(req authHandlerConfig) (response *httptest.ResponseRecorder) {
 	request := httptest.NewRequest("GET", req.api, req.body)
+
+	// add id to url vars if it has id as last index in the api request
+	if req.varID != 0 {
+		request = mux.SetURLVars(request, map[string]string{
+			"id": fmt.Sprint(req.varID),
+		})
+	}
+	if req.vars != nil {
+		request = mux.SetURLVars(request, req.vars)
+	}
 	request.Header.Set("Authorization", fmt.Sprintf("Bearer %v", req.token))
 	response = httptest.NewRecorder()
 
 	handler := WrapFunc(req.handlerFunc)
-	handlerWithAdmin := middlewares.AdminAccess(req.db)(handler)
-	handlerWithAuth := middlewares.Authorization(req.db, req.config.Token.Secret, req.config.Token.Timeout)(handlerWithAdmin)
+	permission := req.permission
+	if permission == "" {
+		permission = models.PermissionManageRoles
+	}
+
+	handlerWithAdmin := middlewares.RequirePermission(req.db, permission, req.config.TwoFactor.RequireForAdmins)(handler)
+	handlerWithAuth := middlewares.Authorization(req.db, req.config.Token.Secret)(handlerWithAdmin)
 	handlerWithAuth.ServeHTTP(response, request)
 	return
 }
@@ -0,0 +1,346 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	// recoveryCodesCount is the number of recovery codes given to a user
+	recoveryCodesCount = 10
+	// twoFactorChallengeTimeout is the time a user has to enter their code after signing in with their password
+	twoFactorChallengeTimeout = 5 * time.Minute
+	// twoFactorMaxAttempts is the number of wrong codes after which a sign in challenge is dropped
+	twoFactorMaxAttempts = 5
+)
+
+// TwoFactorCodeInput struct for a code of the user's authenticator app or one of their recovery codes
+type TwoFactorCodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TwoFactorSignInInput struct for data needed when user completes a sign in with two-factor authentication
+type TwoFactorSignInInput struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// EnrollTwoFactorHandler generates a new secret for the user's authenticator app,
+// two-factor authentication is enabled once the user confirms a code of the app
+func (a *App) EnrollTwoFactorHandler(req *http.Request) (interface{}, Response) {
+	user, res := a.currentUser(req)
+	if res != nil {
+		return nil, res
+	}
+
+	if user.TOTPEnabled {
+		return nil, BadRequest(errors.New("two-factor authentication is already enabled"))
+	}
+
+	secret, err := internal.GenerateTOTPSecret()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.db.SetTOTPSecret(user.ID.String(), secret)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Add the secret to your authenticator app and confirm it with a code",
+		Data: map[string]string{
+			"secret":      secret,
+			"otpauth_uri": internal.TOTPURI(a.config.TwoFactor.Issuer, user.Email, secret),
+		},
+	}, Ok()
+}
+
+// ConfirmTwoFactorHandler enables two-factor authentication with a code of the enrolled secret,
+// the recovery codes are returned only once
+func (a *App) ConfirmTwoFactorHandler(req *http.Request) (interface{}, Response) {
+	var input TwoFactorCodeInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read two-factor code"))
+	}
+
+	user, res := a.currentUser(req)
+	if res != nil {
+		return nil, res
+	}
+
+	if user.TOTPEnabled {
+		return nil, BadRequest(errors.New("two-factor authentication is already enabled"))
+	}
+
+	if user.TOTPSecret == "" {
+		return nil, BadRequest(errors.New("two-factor authentication is not enrolled yet"))
+	}
+
+	step, ok := internal.ValidateTOTP(user.TOTPSecret, input.Code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return nil, BadRequest(errors.New("invalid two-factor code"))
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.db.EnableTOTP(user.ID.String(), step, hashes)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Two-factor authentication is enabled, keep your recovery codes in a safe place",
+		Data:    map[string][]string{"recovery_codes": codes},
+	}, Ok()
+}
+
+// DisableTwoFactorHandler disables two-factor authentication with a code or a recovery code
+func (a *App) DisableTwoFactorHandler(req *http.Request) (interface{}, Response) {
+	var input TwoFactorCodeInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read two-factor code"))
+	}
+
+	user, res := a.currentUser(req)
+	if res != nil {
+		return nil, res
+	}
+
+	if !user.TOTPEnabled {
+		return nil, BadRequest(errors.New("two-factor authentication is not enabled"))
+	}
+
+	ok, res := a.checkSecondFactor(user, input.Code, a.verifySecondFactor, BadRequest(errors.New("invalid two-factor code")))
+	if !ok {
+		return nil, res
+	}
+
+	err = a.db.DisableTOTP(user.ID.String())
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Two-factor authentication is disabled",
+		Data:    nil,
+	}, Ok()
+}
+
+// RegenerateRecoveryCodesHandler replaces the recovery codes of the user with new ones using a code of their app
+func (a *App) RegenerateRecoveryCodesHandler(req *http.Request) (interface{}, Response) {
+	var input TwoFactorCodeInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read two-factor code"))
+	}
+
+	user, res := a.currentUser(req)
+	if res != nil {
+		return nil, res
+	}
+
+	if !user.TOTPEnabled {
+		return nil, BadRequest(errors.New("two-factor authentication is not enabled"))
+	}
+
+	ok, res := a.checkSecondFactor(user, input.Code, a.verifyTOTP, BadRequest(errors.New("invalid two-factor code")))
+	if !ok {
+		return nil, res
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.db.ReplaceRecoveryCodes(user.ID.String(), hashes)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Recovery codes are regenerated, the old ones can't be used anymore",
+		Data:    map[string][]string{"recovery_codes": codes},
+	}, Ok()
+}
+
+// SignInTwoFactorHandler completes the sign in of a user with two-factor authentication
+// using the challenge token given on sign in and a code or a recovery code
+func (a *App) SignInTwoFactorHandler(req *http.Request) (interface{}, Response) {
+	var input TwoFactorSignInInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read two-factor sign in data"))
+	}
+
+	if strings.TrimSpace(input.ChallengeToken) == "" {
+		return nil, BadRequest(errors.New("challenge token is required"))
+	}
+
+	hash := internal.HashToken(input.ChallengeToken)
+	challenge, err := a.db.GetTwoFactorChallenge(hash)
+	if err == gorm.ErrRecordNotFound || challenge.Attempts >= twoFactorMaxAttempts {
+		return nil, Unauthorized(errors.New("sign in is expired, please sign in again"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	user, err := a.db.GetUserByID(challenge.UserID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	ok, res := a.checkSecondFactor(user, input.Code, a.verifySecondFactor, Unauthorized(errors.New("invalid two-factor code")))
+	if !ok {
+		if err := a.db.FailTwoFactorChallenge(hash); err != nil {
+			log.Error().Err(err).Send()
+		}
+		return nil, res
+	}
+	a.resetAttempts(signInAttempts, user.Email)
+
+	err = a.db.DeleteTwoFactorChallenge(hash)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	accessToken, refreshToken, err := a.startSession(user.ID.String(), user.Email, internal.ClientIP(req), req.UserAgent())
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "You are signed in successfully",
+		Data:    map[string]string{"access_token": accessToken, "refresh_token": refreshToken},
+	}, Ok()
+}
+
+// twoFactorChallenge starts the second step of signing in a user with two-factor authentication
+func (a *App) twoFactorChallenge(user models.User) (interface{}, Response) {
+	token, hash, err := internal.GenerateToken()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	err = a.db.CreateTwoFactorChallenge(&models.TwoFactorChallenge{
+		Hash:      hash,
+		UserID:    user.ID.String(),
+		ExpiresAt: time.Now().Add(twoFactorChallengeTimeout),
+	})
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Two-factor authentication code is required",
+		Data:    map[string]interface{}{"two_factor_required": true, "challenge_token": token},
+	}, Ok()
+}
+
+// currentUser returns the user of the request
+func (a *App) currentUser(req *http.Request) (models.User, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	user, err := a.db.GetUserByID(userID)
+	if err == gorm.ErrRecordNotFound {
+		return models.User{}, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return models.User{}, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return user, nil
+}
+
+// checkSecondFactor verifies a code of the user, wrong codes of all challenges and endpoints
+// count against the same lockout of the user, so codes can't be guessed with new sign ins
+func (a *App) checkSecondFactor(user models.User, code string, verify func(models.User, string) (bool, error), wrongCode Response) (bool, Response) {
+	if res := a.lockedOut(twoFactorAttempts, user.Email); res != nil {
+		return false, res
+	}
+
+	ok, err := verify(user, code)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return false, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if !ok {
+		return false, a.failedAttempt(twoFactorAttempts, user.Email, wrongCode, nil)
+	}
+
+	a.resetAttempts(twoFactorAttempts, user.Email)
+	return true, nil
+}
+
+// verifySecondFactor checks a code of the user's authenticator app or uses one of their recovery codes
+func (a *App) verifySecondFactor(user models.User, code string) (bool, error) {
+	ok, err := a.verifyTOTP(user, code)
+	if ok || err != nil {
+		return ok, err
+	}
+
+	return a.db.UseRecoveryCode(user.ID.String(), internal.HashToken(internal.NormalizeRecoveryCode(code)))
+}
+
+// verifyTOTP checks a code of the user's authenticator app, each code is accepted once
+func (a *App) verifyTOTP(user models.User, code string) (bool, error) {
+	step, ok := internal.ValidateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return false, nil
+	}
+
+	return a.db.UseTOTPStep(user.ID.String(), step)
+}
+
+// generateRecoveryCodes generates new recovery codes and the hashes they are stored with
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes, err := internal.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = internal.HashToken(code)
+	}
+
+	return codes, hashes, nil
+}
@@ -0,0 +1,244 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTwoFactorHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
+	assert.NoError(t, err)
+
+	codeRequest := func(handler Handler, api, code string) authHandlerConfig {
+		body, err := json.Marshal(TwoFactorCodeInput{Code: code})
+		assert.NoError(t, err)
+
+		return authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: handler,
+				api:         fmt.Sprintf("/%s/user/2fa/%s", app.config.Version, api),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+	}
+
+	signIn := func() string {
+		body := []byte(`{"email":"name@gmail.com","password":"1234567"}`)
+		response := unAuthorizedHandler(unAuthHandlerConfig{
+			body:        bytes.NewBuffer(body),
+			handlerFunc: app.SignInHandler,
+			api:         fmt.Sprintf("/%s/user/signin", app.config.Version),
+		})
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		var res struct {
+			Data struct {
+				TwoFactorRequired bool   `json:"two_factor_required"`
+				ChallengeToken    string `json:"challenge_token"`
+			} `json:"data"`
+		}
+		err := json.Unmarshal(response.Body.Bytes(), &res)
+		assert.NoError(t, err)
+		assert.True(t, res.Data.TwoFactorRequired)
+		return res.Data.ChallengeToken
+	}
+
+	signInTwoFactor := func(challenge, code string) int {
+		body, err := json.Marshal(TwoFactorSignInInput{ChallengeToken: challenge, Code: code})
+		assert.NoError(t, err)
+
+		response := unAuthorizedHandler(unAuthHandlerConfig{
+			body:        bytes.NewBuffer(body),
+			handlerFunc: app.SignInTwoFactorHandler,
+			api:         fmt.Sprintf("/%s/user/signin/2fa", app.config.Version),
+		})
+		return response.Code
+	}
+
+	var secret string
+	var recoveryCodes []string
+
+	t.Run("Confirm 2fa: not enrolled", func(t *testing.T) {
+		response := authorizedHandler(codeRequest(app.ConfirmTwoFactorHandler, "confirm", "123456"))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Enroll 2fa: success", func(t *testing.T) {
+		response := authorizedHandler(codeRequest(app.EnrollTwoFactorHandler, "enroll", ""))
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		var res struct {
+			Data map[string]string `json:"data"`
+		}
+		err := json.Unmarshal(response.Body.Bytes(), &res)
+		assert.NoError(t, err)
+		assert.Contains(t, res.Data["otpauth_uri"], "otpauth://totp/")
+		secret = res.Data["secret"]
+	})
+
+	t.Run("Confirm 2fa: wrong code", func(t *testing.T) {
+		response := authorizedHandler(codeRequest(app.ConfirmTwoFactorHandler, "confirm", "000000"))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Confirm 2fa: success", func(t *testing.T) {
+		code, err := internal.TOTPCode(secret, internal.TOTPStep(time.Now()))
+		assert.NoError(t, err)
+
+		response := authorizedHandler(codeRequest(app.ConfirmTwoFactorHandler, "confirm", code))
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		var res struct {
+			Data map[string][]string `json:"data"`
+		}
+		err = json.Unmarshal(response.Body.Bytes(), &res)
+		assert.NoError(t, err)
+		recoveryCodes = res.Data["recovery_codes"]
+		assert.Len(t, recoveryCodes, recoveryCodesCount)
+	})
+
+	t.Run("Sign in: code of the confirmation is used", func(t *testing.T) {
+		code, err := internal.TOTPCode(secret, internal.TOTPStep(time.Now()))
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusUnauthorized, signInTwoFactor(signIn(), code))
+	})
+
+	t.Run("Sign in: recovery code", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, signInTwoFactor(signIn(), recoveryCodes[0]))
+		assert.Equal(t, http.StatusUnauthorized, signInTwoFactor(signIn(), recoveryCodes[0]))
+	})
+
+	t.Run("Sign in: next code", func(t *testing.T) {
+		code, err := internal.TOTPCode(secret, internal.TOTPStep(time.Now())+1)
+		assert.NoError(t, err)
+
+		challenge := signIn()
+		assert.Equal(t, http.StatusOK, signInTwoFactor(challenge, code))
+		assert.Equal(t, http.StatusUnauthorized, signInTwoFactor(challenge, recoveryCodes[1]))
+	})
+
+	t.Run("Sign in: too many wrong codes", func(t *testing.T) {
+		// the challenge is dropped before the user is locked out
+		app.config.RateLimits.MaxFailedAttempts = twoFactorMaxAttempts + 1
+		defer func() { app.limiter = middlewares.NewMemoryRateLimitStore() }()
+
+		challenge := signIn()
+		for i := 0; i < twoFactorMaxAttempts; i++ {
+			assert.Equal(t, http.StatusUnauthorized, signInTwoFactor(challenge, "000000"))
+		}
+		assert.Equal(t, http.StatusUnauthorized, signInTwoFactor(challenge, recoveryCodes[1]))
+	})
+
+	t.Run("Sign in: locked out after wrong codes of new challenges", func(t *testing.T) {
+		app.config.RateLimits.MaxFailedAttempts = 3
+		defer func() { app.limiter = middlewares.NewMemoryRateLimitStore() }()
+
+		assert.Equal(t, http.StatusUnauthorized, signInTwoFactor(signIn(), "000000"))
+		assert.Equal(t, http.StatusUnauthorized, signInTwoFactor(signIn(), "000000"))
+		assert.Equal(t, http.StatusTooManyRequests, signInTwoFactor(signIn(), "000000"))
+		assert.Equal(t, http.StatusTooManyRequests, signInTwoFactor(signIn(), recoveryCodes[1]))
+
+		// codes of the other endpoints are locked out too
+		response := authorizedHandler(codeRequest(app.DisableTwoFactorHandler, "disable", recoveryCodes[1]))
+		assert.Equal(t, http.StatusTooManyRequests, response.Code)
+	})
+
+	t.Run("Sign in: failed passwords are reset after the second factor", func(t *testing.T) {
+		app.config.RateLimits.MaxFailedAttempts = 3
+		defer func() { app.limiter = middlewares.NewMemoryRateLimitStore() }()
+
+		signInWithPassword := func(password string) int {
+			body := []byte(fmt.Sprintf(`{"email":"name@gmail.com","password":"%s"}`, password))
+			response := unAuthorizedHandler(unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: app.SignInHandler,
+				api:         fmt.Sprintf("/%s/user/signin", app.config.Version),
+			})
+			return response.Code
+		}
+
+		assert.Equal(t, http.StatusBadRequest, signInWithPassword("wrong"))
+		challenge := signIn()
+		assert.Equal(t, http.StatusBadRequest, signInWithPassword("wrong"))
+		assert.Equal(t, http.StatusOK, signInTwoFactor(challenge, recoveryCodes[1]))
+
+		assert.Equal(t, http.StatusBadRequest, signInWithPassword("wrong"))
+		assert.Equal(t, http.StatusBadRequest, signInWithPassword("wrong"))
+		assert.Equal(t, http.StatusTooManyRequests, signInWithPassword("wrong"))
+	})
+
+	t.Run("Recovery codes: locked out after wrong codes", func(t *testing.T) {
+		app.config.RateLimits.MaxFailedAttempts = 2
+		defer func() { app.limiter = middlewares.NewMemoryRateLimitStore() }()
+
+		response := authorizedHandler(codeRequest(app.RegenerateRecoveryCodesHandler, "recovery_codes", "000000"))
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+		response = authorizedHandler(codeRequest(app.RegenerateRecoveryCodesHandler, "recovery_codes", "000000"))
+		assert.Equal(t, http.StatusTooManyRequests, response.Code)
+
+		code, err := internal.TOTPCode(secret, internal.TOTPStep(time.Now())+2)
+		assert.NoError(t, err)
+		response = authorizedHandler(codeRequest(app.RegenerateRecoveryCodesHandler, "recovery_codes", code))
+		assert.Equal(t, http.StatusTooManyRequests, response.Code)
+	})
+
+	t.Run("Admin access: two-factor authentication is required", func(t *testing.T) {
+		admin := models.User{Name: "admin", Email: "admin@gmail.com", Verified: true, Roles: []string{models.SuperAdminRole}}
+		err := app.db.CreateUser(&admin)
+		assert.NoError(t, err)
+
+		adminToken, _, err := app.startSession(admin.ID.String(), admin.Email, "", "")
+		assert.NoError(t, err)
+
+		config := app.config
+		config.TwoFactor.RequireForAdmins = true
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ListMailsHandler,
+				api:         fmt.Sprintf("/%s/mails", app.config.Version),
+			},
+			token:  adminToken,
+			config: config,
+			db:     app.db,
+		}
+
+		response := adminHandler(req)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("Disable 2fa: success", func(t *testing.T) {
+		response := authorizedHandler(codeRequest(app.DisableTwoFactorHandler, "disable", recoveryCodes[3]))
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		body := []byte(`{"email":"name@gmail.com","password":"1234567"}`)
+		response = unAuthorizedHandler(unAuthHandlerConfig{
+			body:        bytes.NewBuffer(body),
+			handlerFunc: app.SignInHandler,
+			api:         fmt.Sprintf("/%s/user/signin", app.config.Version),
+		})
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), "access_token")
+	})
+}
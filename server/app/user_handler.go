@@ -13,7 +13,6 @@ import (
 	"github.com/codescalers/cloud4students/middlewares"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/codescalers/cloud4students/validators"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/validator.v2"
@@ -30,6 +29,7 @@ type SignUpInput struct {
 	ProjectDesc     string `json:"project_desc" binding:"required" validate:"nonzero"`
 	College         string `json:"college" binding:"required" validate:"nonzero"`
 	SSHKey          string `json:"ssh_key"  binding:"required"`
+	Language        string `json:"language"`
 }
 
 // VerifyCodeInput struct takes verification code from user
@@ -44,9 +44,13 @@ type SignInInput struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// RefreshTokenInput struct for data needed when user refreshes their access token
+type RefreshTokenInput struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
 // ChangePasswordInput struct for user to change password
 type ChangePasswordInput struct {
-	Email           string `json:"email" binding:"required"`
 	Password        string `json:"password" binding:"required" validate:"password"`
 	ConfirmPassword string `json:"confirm_password" binding:"required" validate:"password"`
 }
@@ -57,6 +61,7 @@ type UpdateUserInput struct {
 	Password        string `json:"password"`
 	ConfirmPassword string `json:"confirm_password"`
 	SSHKey          string `json:"ssh_key"`
+	Language        string `json:"language"`
 }
 
 // EmailInput struct for user when forgetting password
@@ -112,13 +117,17 @@ func (a *App) SignUpHandler(req *http.Request) (interface{}, Response) {
 		}
 	}
 
-	// send verification code if user is not verified or not exist
-	code := internal.GenerateRandomCode()
-	subject, body := internal.SignUpMailContent(code, a.config.MailSender.Timeout, signUp.Name, a.config.Server.Host)
-	err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, signUp.Email, subject, body)
-	if err != nil {
-		log.Error().Err(err).Send()
-		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	if signUp.Language == "" {
+		signUp.Language = internal.DefaultLanguage
+	}
+	if res := a.validateLanguage(signUp.Language); res != nil {
+		return nil, res
+	}
+
+	if getErr == nil {
+		if res := a.verificationCooldown(user.ID.String(), models.SignUpPurpose); res != nil {
+			return nil, res
+		}
 	}
 
 	hashedPassword, err := internal.HashAndSaltPassword([]byte(signUp.Password))
@@ -131,12 +140,12 @@ func (a *App) SignUpHandler(req *http.Request) (interface{}, Response) {
 		Name:           signUp.Name,
 		Email:          signUp.Email,
 		HashedPassword: hashedPassword,
-		Code:           code,
 		SSHKey:         signUp.SSHKey,
 		TeamSize:       signUp.TeamSize,
 		ProjectDesc:    signUp.ProjectDesc,
 		College:        signUp.College,
-		Admin:          internal.Contains(a.config.Admins, signUp.Email),
+		Language:       signUp.Language,
+		Roles:          a.signUpRoles(signUp.Email),
 	}
 
 	// update code if user is not verified but exists
@@ -172,6 +181,13 @@ func (a *App) SignUpHandler(req *http.Request) (interface{}, Response) {
 		}
 	}
 
+	// send verification code if user is not verified or not exist
+	err = a.sendVerification(u, models.SignUpPurpose, u.Email)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
 	return ResponseMsg{
 		Message: "Verification code has been sent to " + signUp.Email,
 		Data:    map[string]int{"timeout": a.config.MailSender.Timeout},
@@ -187,6 +203,10 @@ func (a *App) VerifySignUpCodeHandler(req *http.Request) (interface{}, Response)
 		return nil, BadRequest(errors.New("failed to read sign up code data"))
 	}
 
+	if res := a.lockedOut(codeAttempts, data.Email); res != nil {
+		return nil, res
+	}
+
 	user, err := a.db.GetUserByEmail(data.Email)
 	if err == gorm.ErrRecordNotFound {
 		return nil, NotFound(errors.New("user is not found"))
@@ -200,37 +220,40 @@ func (a *App) VerifySignUpCodeHandler(req *http.Request) (interface{}, Response)
 		return nil, BadRequest(errors.New("account is already created"))
 	}
 
-	if user.Code != data.Code {
-		return nil, BadRequest(errors.New("wrong code"))
+	if _, res := a.useVerificationCode(user, models.SignUpPurpose, data.Code); res != nil {
+		return nil, res
 	}
 
-	if user.UpdatedAt.Add(time.Duration(a.config.MailSender.Timeout) * time.Second).Before(time.Now()) {
-		return nil, BadRequest(errors.New("code has expired"))
-	}
-	err = a.db.UpdateVerification(user.ID.String(), true)
+	return a.completeSignUp(req, user)
+}
+
+// completeSignUp verifies the account of a user once they verify their email and signs them in
+func (a *App) completeSignUp(req *http.Request, user models.User) (interface{}, Response) {
+	err := a.db.UpdateVerification(user.ID.String(), true)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 	middlewares.UserCreations.WithLabelValues(user.ID.String(), user.Email, user.College, fmt.Sprint(user.TeamSize)).Inc()
 
-	// token
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, a.config.Token.Secret, a.config.Token.Timeout)
+	accessToken, refreshToken, err := a.startSession(user.ID.String(), user.Email, internal.ClientIP(req), req.UserAgent())
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
-	subject, body := internal.WelcomeMailContent(user.Name, a.config.Server.Host)
-	err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, user.Email, subject, body)
+	// the account is already verified, a missing welcome mail shouldn't fail the request
+	subject, body, err := internal.WelcomeMailContent(a.mailTemplate(internal.WelcomeMail, user.Language), user.Name, a.config.Server.Host)
+	if err == nil {
+		err = a.db.EnqueueMail(user.Email, subject, body)
+	}
 	if err != nil {
-		log.Error().Err(err).Send()
-		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		log.Error().Err(err).Msgf("failed to queue welcome mail of user %s", user.ID.String())
 	}
 
 	return ResponseMsg{
 		Message: "Account is created successfully.",
-		Data:    map[string]string{"user_id": user.ID.String(), "access_token": token},
+		Data:    map[string]string{"user_id": user.ID.String(), "access_token": accessToken, "refresh_token": refreshToken},
 	}, Ok()
 }
 
@@ -243,6 +266,10 @@ func (a *App) SignInHandler(req *http.Request) (interface{}, Response) {
 		return nil, BadRequest(errors.New("failed to read sign in data"))
 	}
 
+	if res := a.lockedOut(signInAttempts, input.Email); res != nil {
+		return nil, res
+	}
+
 	user, err := a.db.GetUserByEmail(input.Email)
 	if err == gorm.ErrRecordNotFound {
 		return nil, NotFound(errors.New("user is not found"))
@@ -258,10 +285,15 @@ func (a *App) SignInHandler(req *http.Request) (interface{}, Response) {
 
 	match := internal.VerifyPassword(user.HashedPassword, input.Password)
 	if !match {
-		return nil, BadRequest(errors.New("email or password is not correct"))
+		return nil, a.failedAttempt(signInAttempts, input.Email, BadRequest(errors.New("email or password is not correct")), nil)
 	}
+	// failed sign ins are reset once the second factor is verified too
+	if user.TOTPEnabled {
+		return a.twoFactorChallenge(user)
+	}
+	a.resetAttempts(signInAttempts, input.Email)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, a.config.Token.Secret, a.config.Token.Timeout)
+	accessToken, refreshToken, err := a.startSession(user.ID.String(), user.Email, internal.ClientIP(req), req.UserAgent())
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
@@ -269,45 +301,54 @@ func (a *App) SignInHandler(req *http.Request) (interface{}, Response) {
 
 	return ResponseMsg{
 		Message: "You are signed in successfully",
-		Data:    map[string]string{"access_token": token},
+		Data:    map[string]string{"access_token": accessToken, "refresh_token": refreshToken},
 	}, Ok()
 }
 
-// RefreshJWTHandler refreshes the user's token
+// RefreshJWTHandler exchanges a refresh token with a new access token and a new refresh token,
+// reusing a refresh token revokes its session
 func (a *App) RefreshJWTHandler(req *http.Request) (interface{}, Response) {
-	reqToken := req.Header.Get("Authorization")
-	splitToken := strings.Split(reqToken, "Bearer ")
-	if len(splitToken) != 2 {
-		return nil, BadRequest(errors.New("token is required"))
+	var input RefreshTokenInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read refresh token data"))
+	}
+
+	if strings.TrimSpace(input.RefreshToken) == "" {
+		return nil, BadRequest(errors.New("refresh token is required"))
 	}
 
-	if strings.TrimSpace(splitToken[1]) == "" {
-		return nil, BadRequest(errors.New("token is required"))
+	refreshToken, hash, err := internal.GenerateToken()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
-	reqToken = splitToken[1]
 
-	claims := &models.Claims{}
-	tkn, err := jwt.ParseWithClaims(reqToken, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(a.config.Token.Secret), nil
-	})
+	expiresAt := time.Now().Add(time.Duration(a.config.Token.RefreshTimeoutHours) * time.Hour)
+	session, err := a.db.RotateRefreshToken(internal.HashToken(input.RefreshToken), hash, expiresAt)
+	if err == models.ErrRefreshTokenReused {
+		log.Warn().Msgf("refresh token of session %s is reused, the session is revoked", session.ID)
+		return nil, Unauthorized(errors.New("refresh token is already used, please sign in again"))
+	}
+	if err == gorm.ErrRecordNotFound {
+		return nil, Unauthorized(errors.New("refresh token is invalid or expired, please sign in again"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
 
-	// if user doesn't exist
-	if _, err := a.db.GetUserByID(claims.UserID); err == gorm.ErrRecordNotFound {
+	user, err := a.db.GetUserByID(session.UserID)
+	if err == gorm.ErrRecordNotFound {
 		return nil, NotFound(errors.New("user is not found"))
 	}
-
-	// if token didn't expire
-	if err == nil && time.Until(claims.ExpiresAt.Time) < time.Duration(a.config.Token.Timeout)*time.Minute && tkn.Valid {
-		return ResponseMsg{
-			Message: "Access Token is valid",
-			Data:    map[string]string{"access_token": reqToken, "refresh_token": reqToken},
-		}, Ok()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
-	expirationTime := time.Now().Add(time.Duration(a.config.Token.Timeout) * time.Minute)
-	claims.ExpiresAt = jwt.NewNumericDate(expirationTime)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	newToken, err := token.SignedString([]byte(a.config.Token.Secret))
+	accessToken, err := internal.CreateJWT(user.ID.String(), user.Email, session.ID, a.config.Token.Secret, a.config.Token.Timeout)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
@@ -315,10 +356,68 @@ func (a *App) RefreshJWTHandler(req *http.Request) (interface{}, Response) {
 
 	return ResponseMsg{
 		Message: "Token is refreshed successfully",
-		Data:    map[string]string{"access_token": reqToken, "refresh_token": newToken},
+		Data:    map[string]string{"access_token": accessToken, "refresh_token": refreshToken},
 	}, Ok()
 }
 
+// LogoutHandler revokes the session of the user's access token
+func (a *App) LogoutHandler(req *http.Request) (interface{}, Response) {
+	sessionID := req.Context().Value(middlewares.SessionIDKey("SessionID")).(string)
+
+	err := a.db.RevokeSession(sessionID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "You are logged out successfully",
+		Data:    nil,
+	}, Ok()
+}
+
+// LogoutAllHandler revokes all sessions of the user
+func (a *App) LogoutAllHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	err := a.db.RevokeUserSessions(userID, "")
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "All your sessions are logged out successfully",
+		Data:    nil,
+	}, Ok()
+}
+
+// startSession creates a new session of a user on a device and returns its access and refresh tokens
+func (a *App) startSession(userID, email, ip, userAgent string) (string, string, error) {
+	refreshToken, hash, err := internal.GenerateToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	session := models.Session{
+		UserID:     userID,
+		ExpiresAt:  time.Now().Add(time.Duration(a.config.Token.RefreshTimeoutHours) * time.Hour),
+		LastSeenAt: time.Now(),
+		IP:         ip,
+		UserAgent:  userAgent,
+	}
+	if err := a.db.CreateSession(&session, hash); err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := internal.CreateJWT(userID, email, session.ID, a.config.Token.Secret, a.config.Token.Timeout)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
 // ForgotPasswordHandler sends user verification code
 func (a *App) ForgotPasswordHandler(req *http.Request) (interface{}, Response) {
 	var email EmailInput
@@ -341,23 +440,12 @@ func (a *App) ForgotPasswordHandler(req *http.Request) (interface{}, Response) {
 		return nil, BadRequest(errors.New("email is not verified yet, please check the verification email in your inbox"))
 	}
 
-	// send verification code
-	code := internal.GenerateRandomCode()
-	subject, body := internal.ResetPasswordMailContent(code, a.config.MailSender.Timeout, user.Name, a.config.Server.Host)
-	err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, email.Email, subject, body)
-
-	if err != nil {
-		log.Error().Err(err).Send()
-		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	if res := a.verificationCooldown(user.ID.String(), models.ResetPasswordPurpose); res != nil {
+		return nil, res
 	}
 
-	err = a.db.UpdateUserByID(
-		models.User{
-			ID:        user.ID,
-			UpdatedAt: time.Now(),
-			Code:      code,
-		},
-	)
+	// send verification code
+	err = a.sendVerification(user, models.ResetPasswordPurpose, user.Email)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
@@ -378,6 +466,10 @@ func (a *App) VerifyForgetPasswordCodeHandler(req *http.Request) (interface{}, R
 		return nil, BadRequest(errors.New("failed to read password code"))
 	}
 
+	if res := a.lockedOut(codeAttempts, data.Email); res != nil {
+		return nil, res
+	}
+
 	user, err := a.db.GetUserByEmail(data.Email)
 	if err == gorm.ErrRecordNotFound {
 		return nil, NotFound(errors.New("user is not found"))
@@ -391,16 +483,22 @@ func (a *App) VerifyForgetPasswordCodeHandler(req *http.Request) (interface{}, R
 		return nil, BadRequest(errors.New("email is not verified yet, please check the verification email in your inbox"))
 	}
 
-	if user.Code != data.Code {
-		return nil, BadRequest(errors.New("wrong code"))
+	if _, res := a.useVerificationCode(user, models.ResetPasswordPurpose, data.Code); res != nil {
+		return nil, res
 	}
 
-	if user.UpdatedAt.Add(time.Duration(a.config.MailSender.Timeout) * time.Second).Before(time.Now()) {
-		return nil, BadRequest(errors.New("code has expired"))
+	return a.completePasswordReset(req, user)
+}
+
+// completePasswordReset signs in a user who forgot their password once they verify their email,
+// the user changes their password in the session
+func (a *App) completePasswordReset(req *http.Request, user models.User) (interface{}, Response) {
+	// the mailed code replaces the password but not the second factor
+	if user.TOTPEnabled {
+		return a.twoFactorChallenge(user)
 	}
 
-	// token
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, a.config.Token.Secret, a.config.Token.Timeout)
+	accessToken, refreshToken, err := a.startSession(user.ID.String(), user.Email, internal.ClientIP(req), req.UserAgent())
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
@@ -408,7 +506,7 @@ func (a *App) VerifyForgetPasswordCodeHandler(req *http.Request) (interface{}, R
 
 	return ResponseMsg{
 		Message: "Code is verified",
-		Data:    map[string]string{"access_token": token},
+		Data:    map[string]string{"access_token": accessToken, "refresh_token": refreshToken},
 	}, Ok()
 }
 
@@ -437,7 +535,9 @@ func (a *App) ChangePasswordHandler(req *http.Request) (interface{}, Response) {
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
-	err = a.db.UpdatePassword(data.Email, hashedPassword)
+	// the password of the signed in user is changed, not the one of the sent email
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+	user, err := a.db.GetUserByID(userID)
 	if err == gorm.ErrRecordNotFound {
 		return nil, NotFound(errors.New("user is not found"))
 	}
@@ -446,6 +546,26 @@ func (a *App) ChangePasswordHandler(req *http.Request) (interface{}, Response) {
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	err = a.db.UpdatePassword(user.Email, hashedPassword)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	// other sessions may be signed in with the old password
+	sessionID := req.Context().Value(middlewares.SessionIDKey("SessionID")).(string)
+	err = a.db.RevokeUserSessions(user.ID.String(), sessionID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	// failed sign ins with the old password don't count against the new one
+	a.resetAttempts(signInAttempts, user.Email)
+
 	return ResponseMsg{
 		Message: "Password is updated successfully",
 		Data:    nil,
@@ -497,6 +617,13 @@ func (a *App) UpdateUserHandler(req *http.Request) (interface{}, Response) {
 		updates++
 	}
 
+	if len(input.Language) != 0 {
+		updates++
+		if res := a.validateLanguage(input.Language); res != nil {
+			return nil, res
+		}
+	}
+
 	if updates == 0 {
 		return ResponseMsg{
 			Message: "Nothing to update",
@@ -515,6 +642,7 @@ func (a *App) UpdateUserHandler(req *http.Request) (interface{}, Response) {
 			Name:           input.Name,
 			HashedPassword: hashedPassword,
 			SSHKey:         input.SSHKey,
+			Language:       input.Language,
 			UpdatedAt:      time.Now(),
 		},
 	)
@@ -526,6 +654,16 @@ func (a *App) UpdateUserHandler(req *http.Request) (interface{}, Response) {
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	// other sessions may be signed in with the old password
+	if len(hashedPassword) != 0 {
+		sessionID := req.Context().Value(middlewares.SessionIDKey("SessionID")).(string)
+		err = a.db.RevokeUserSessions(userID, sessionID)
+		if err != nil {
+			log.Error().Err(err).Send()
+			return nil, InternalServerError(errors.New(internalServerErrorMsg))
+		}
+	}
+
 	return ResponseMsg{
 		Message: "User is updated successfully",
 		Data:    map[string]string{"user_id": userID},
@@ -546,7 +684,7 @@ func (a *App) GetUserHandler(req *http.Request) (interface{}, Response) {
 
 	return ResponseMsg{
 		Message: "User exists",
-		Data:    map[string]interface{}{"user": user},
+		Data:    map[string]interface{}{"user": user, "permissions": models.Permissions(user.Roles)},
 	}, Ok()
 }
 
@@ -4,15 +4,20 @@ package app
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"time"
 
 	"testing"
 
-	"github.com/codescalers/cloud4students/internal"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
 	"github.com/codescalers/cloud4students/models"
+	"gorm.io/gorm"
 )
 
 var salt = []byte("saltsaltsaltsalt")
@@ -56,7 +61,20 @@ func TestSignUpHandler(t *testing.T) {
 		assert.Equal(t, response.Code, http.StatusCreated)
 	})
 
+	t.Run("Sign up: code was sent recently", func(t *testing.T) {
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer(signUpBody),
+			handlerFunc: app.SignUpHandler,
+			api:         fmt.Sprintf("/%s/user/signup", app.config.Version),
+		}
+
+		response := unAuthorizedHandler(req)
+		assert.Equal(t, http.StatusTooManyRequests, response.Code)
+		assert.NotEmpty(t, response.Header().Get("Retry-After"))
+	})
+
 	t.Run("Sign up: user exists but not verified", func(t *testing.T) {
+		app.config.MailSender.ResendCooldownSeconds = 0
 		req := unAuthHandlerConfig{
 			body:        bytes.NewBuffer(signUpBody),
 			handlerFunc: app.SignUpHandler,
@@ -144,12 +162,13 @@ func TestSignUpHandler(t *testing.T) {
 func TestVerifySignUpCodeHandler(t *testing.T) {
 	app := SetUp(t)
 
-	user.Code = 1234
 	user.Verified = false
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	verifyBody := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, user.Code))
+	code := 1234
+	createVerificationCode(t, app, models.SignUpPurpose, code)
+	verifyBody := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, code))
 
 	t.Run("Verify sign up: success", func(t *testing.T) {
 		req := unAuthHandlerConfig{
@@ -174,7 +193,7 @@ func TestVerifySignUpCodeHandler(t *testing.T) {
 	})
 
 	t.Run("Verify sign up: user not found", func(t *testing.T) {
-		body := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, "", user.Code))
+		body := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, "", code))
 		req := unAuthHandlerConfig{
 			body:        bytes.NewBuffer(body),
 			handlerFunc: app.VerifySignUpCodeHandler,
@@ -203,6 +222,7 @@ func TestVerifySignUpCodeHandler(t *testing.T) {
 	t.Run("Verify sign up: wrong code", func(t *testing.T) {
 		err := app.db.UpdateVerification(user.ID.String(), false)
 		assert.NoError(t, err)
+		createVerificationCode(t, app, models.SignUpPurpose, code)
 
 		body := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, 0))
 
@@ -220,6 +240,7 @@ func TestVerifySignUpCodeHandler(t *testing.T) {
 
 	t.Run("Verify sign up: code expired", func(t *testing.T) {
 		app.config.MailSender.Timeout = 0
+		createVerificationCode(t, app, models.SignUpPurpose, code)
 		req := unAuthHandlerConfig{
 			body:        bytes.NewBuffer(verifyBody),
 			handlerFunc: app.VerifySignUpCodeHandler,
@@ -326,69 +347,164 @@ func TestSignInHandler(t *testing.T) {
 	})
 }
 
+func TestSignInLockout(t *testing.T) {
+	app := SetUp(t)
+	app.config.RateLimits.MaxFailedAttempts = 3
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	signIn := func(password string) *httptest.ResponseRecorder {
+		body := []byte(fmt.Sprintf(`{"email": "%s", "password": "%s"}`, user.Email, password))
+		return unAuthorizedHandler(unAuthHandlerConfig{
+			body:        bytes.NewBuffer(body),
+			handlerFunc: app.SignInHandler,
+			api:         fmt.Sprintf("/%s/user/signin", app.config.Version),
+		})
+	}
+
+	t.Run("Sign in: success resets failed attempts", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, signIn("wrongpass").Code)
+		assert.Equal(t, http.StatusBadRequest, signIn("wrongpass").Code)
+		assert.Equal(t, http.StatusOK, signIn("1234567").Code)
+		assert.Equal(t, http.StatusBadRequest, signIn("wrongpass").Code)
+	})
+
+	t.Run("Sign in: locked out after failed attempts", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, signIn("wrongpass").Code)
+
+		response := signIn("wrongpass")
+		assert.Equal(t, http.StatusTooManyRequests, response.Code)
+		assert.Equal(t, fmt.Sprint(app.config.RateLimits.LockoutMinutes*60), response.Header().Get("Retry-After"))
+
+		response = signIn("1234567")
+		assert.Equal(t, http.StatusTooManyRequests, response.Code)
+		assert.NotEmpty(t, response.Header().Get("Retry-After"))
+	})
+}
+
 func TestRefreshJWTHandler(t *testing.T) {
 	app := SetUp(t)
 
+	user.Verified = true
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	_, refreshToken, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
+	var rotated string
+
 	t.Run("refresh token: success", func(t *testing.T) {
-		token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, 0)
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer([]byte(fmt.Sprintf(`{"refresh_token":"%s"}`, refreshToken))),
+			handlerFunc: app.RefreshJWTHandler,
+			api:         fmt.Sprintf("/%s/user/refresh_token", app.config.Version),
+		}
+
+		response := unAuthorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		var res struct {
+			Data map[string]string `json:"data"`
+		}
+		err := json.Unmarshal(response.Body.Bytes(), &res)
 		assert.NoError(t, err)
+		assert.NotEmpty(t, res.Data["access_token"])
+		assert.NotEqual(t, refreshToken, res.Data["refresh_token"])
+		rotated = res.Data["refresh_token"]
+	})
 
-		req := authHandlerConfig{
-			unAuthHandlerConfig: unAuthHandlerConfig{
-				body:        nil,
-				handlerFunc: app.RefreshJWTHandler,
-				api:         fmt.Sprintf("/%s/user/refresh_token", app.config.Version),
-			},
-			userID: user.ID.String(),
-			token:  token,
-			config: app.config,
+	t.Run("refresh token: reused token revokes the session", func(t *testing.T) {
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer([]byte(fmt.Sprintf(`{"refresh_token":"%s"}`, refreshToken))),
+			handlerFunc: app.RefreshJWTHandler,
+			api:         fmt.Sprintf("/%s/user/refresh_token", app.config.Version),
 		}
 
-		response := authorizedNoMiddlewareHandler(req)
-		assert.Equal(t, response.Code, http.StatusOK)
+		response := unAuthorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
+
+		req.body = bytes.NewBuffer([]byte(fmt.Sprintf(`{"refresh_token":"%s"}`, rotated)))
+		response = unAuthorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
 	})
 
-	t.Run("refresh token: not expired yet", func(t *testing.T) {
-		req := authHandlerConfig{
-			unAuthHandlerConfig: unAuthHandlerConfig{
-				body:        nil,
-				handlerFunc: app.RefreshJWTHandler,
-				api:         fmt.Sprintf("/%s/user/refresh_token", app.config.Version),
-			},
-			userID: user.ID.String(),
-			token:  token,
-			config: app.config,
+	t.Run("refresh token: invalid token", func(t *testing.T) {
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer([]byte(`{"refresh_token":"invalid"}`)),
+			handlerFunc: app.RefreshJWTHandler,
+			api:         fmt.Sprintf("/%s/user/refresh_token", app.config.Version),
 		}
 
-		response := authorizedNoMiddlewareHandler(req)
-		assert.Equal(t, response.Code, http.StatusOK)
+		response := unAuthorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusUnauthorized)
 	})
 
 	t.Run("refresh token: add empty token", func(t *testing.T) {
-		req := authHandlerConfig{
-			unAuthHandlerConfig: unAuthHandlerConfig{
-				body:        nil,
-				handlerFunc: app.RefreshJWTHandler,
-				api:         fmt.Sprintf("/%s/user/refresh_token", app.config.Version),
-			},
-			userID: user.ID.String(),
-			token:  "",
-			config: app.config,
+		req := unAuthHandlerConfig{
+			body:        bytes.NewBuffer([]byte(`{}`)),
+			handlerFunc: app.RefreshJWTHandler,
+			api:         fmt.Sprintf("/%s/user/refresh_token", app.config.Version),
 		}
 
-		response := authorizedNoMiddlewareHandler(req)
-		want := `{"err":"token is required"}` + "\n"
+		response := unAuthorizedHandler(req)
+		want := `{"err":"refresh token is required"}` + "\n"
 		assert.Equal(t, response.Body.String(), want)
 		assert.Equal(t, response.Code, http.StatusBadRequest)
 	})
 }
 
+func TestLogoutHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
+	assert.NoError(t, err)
+
+	otherToken, _, err := app.startSession(user.ID.String(), user.Email, "", "")
+	assert.NoError(t, err)
+
+	lastToken, _, err := app.startSession(user.ID.String(), user.Email, "", "")
+	assert.NoError(t, err)
+
+	logout := func(handler func(*http.Request) (interface{}, Response), token string) *httptest.ResponseRecorder {
+		return authorizedHandler(authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: handler,
+				api:         fmt.Sprintf("/%s/user/logout", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		})
+	}
+
+	t.Run("logout: success", func(t *testing.T) {
+		response := logout(app.LogoutHandler, token)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		// the access token of a revoked session is rejected
+		response = logout(app.LogoutHandler, token)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+		response = logout(app.GetUserHandler, otherToken)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("logout all: success", func(t *testing.T) {
+		response := logout(app.LogoutAllHandler, otherToken)
+		assert.Equal(t, http.StatusOK, response.Code)
+
+		response = logout(app.GetUserHandler, lastToken)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+	})
+}
+
 func TestForgotPasswordHandler(t *testing.T) {
 	app := SetUp(t)
 
@@ -445,12 +561,13 @@ func TestForgotPasswordHandler(t *testing.T) {
 func TestVerifyForgetPasswordCodeHandler(t *testing.T) {
 	app := SetUp(t)
 
-	user.Code = 1234
 	user.Verified = true
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	verifyBody := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, user.Code))
+	code := 1234
+	createVerificationCode(t, app, models.ResetPasswordPurpose, code)
+	verifyBody := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, code))
 
 	t.Run("verify forget password: success", func(t *testing.T) {
 		req := unAuthHandlerConfig{
@@ -464,6 +581,7 @@ func TestVerifyForgetPasswordCodeHandler(t *testing.T) {
 	})
 
 	t.Run("verify forget password: wrong code", func(t *testing.T) {
+		createVerificationCode(t, app, models.ResetPasswordPurpose, code)
 		body := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, 0))
 
 		req := unAuthHandlerConfig{
@@ -516,6 +634,50 @@ func TestVerifyForgetPasswordCodeHandler(t *testing.T) {
 	})
 }
 
+func TestVerifyCodeLockout(t *testing.T) {
+	app := SetUp(t)
+	app.config.RateLimits.MaxFailedAttempts = 3
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	code := 1234
+	createVerificationCode(t, app, models.ResetPasswordPurpose, code)
+
+	verify := func(code int) *httptest.ResponseRecorder {
+		body := []byte(fmt.Sprintf(`{"email": "%s", "code": %d}`, user.Email, code))
+		return unAuthorizedHandler(unAuthHandlerConfig{
+			body:        bytes.NewBuffer(body),
+			handlerFunc: app.VerifyForgetPasswordCodeHandler,
+			api:         fmt.Sprintf("/%s/user/forget_password/verify_email", app.config.Version),
+		})
+	}
+
+	t.Run("verify code: locked out after failed attempts", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, verify(1).Code)
+		assert.Equal(t, http.StatusBadRequest, verify(2).Code)
+
+		response := verify(3)
+		assert.Equal(t, http.StatusTooManyRequests, response.Code)
+		assert.NotEmpty(t, response.Header().Get("Retry-After"))
+
+		assert.Equal(t, http.StatusTooManyRequests, verify(code).Code)
+	})
+
+	t.Run("verify code: code is invalidated", func(t *testing.T) {
+		_, err := app.db.GetVerificationToken(user.ID.String(), models.ResetPasswordPurpose)
+		assert.Equal(t, gorm.ErrRecordNotFound, err)
+
+		app.limiter = middlewares.NewMemoryRateLimitStore()
+		response := verify(code)
+		want := `{"err":"code has expired"}` + "\n"
+		assert.Equal(t, want, response.Body.String())
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	})
+}
+
 func TestChangePasswordHandler(t *testing.T) {
 	app := SetUp(t)
 
@@ -529,7 +691,7 @@ func TestChangePasswordHandler(t *testing.T) {
 		"confirm_password":"newpass"
 		}`)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
 	t.Run("change password: success", func(t *testing.T) {
@@ -620,8 +782,13 @@ func TestChangePasswordHandler(t *testing.T) {
 		assert.Equal(t, response.Code, http.StatusBadRequest)
 	})
 
-	t.Run("change password: user not found", func(t *testing.T) {
+	t.Run("change password: email of the body is ignored", func(t *testing.T) {
+		other := models.User{Name: "other", Email: "other@gmail.com", HashedPassword: []byte("hash"), Verified: true}
+		err := app.db.CreateUser(&other)
+		assert.NoError(t, err)
+
 		body := []byte(`{
+		"email":"other@gmail.com",
 		"password":"1234567",
 		"confirm_password":"1234567"
 		}`)
@@ -639,10 +806,40 @@ func TestChangePasswordHandler(t *testing.T) {
 		}
 
 		response := authorizedHandler(req)
-		want := `{"err":"user is not found"}` + "\n"
-		assert.Equal(t, response.Body.String(), want)
-		assert.Equal(t, response.Code, http.StatusNotFound)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		other, err = app.db.GetUserByEmail(other.Email)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("hash"), other.HashedPassword)
+
+		changed, err := app.db.GetUserByID(user.ID.String())
+		assert.NoError(t, err)
+		assert.True(t, internal.VerifyPassword(changed.HashedPassword, "1234567"))
+	})
 
+	t.Run("change password: failed sign ins are reset", func(t *testing.T) {
+		failuresKey, _ := attemptsKeys(signInAttempts, user.Email)
+		_, _, err := app.limiter.Hit(failuresKey, time.Minute)
+		assert.NoError(t, err)
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer(changePassBody),
+				handlerFunc: app.ChangePasswordHandler,
+				api:         fmt.Sprintf("/%s/user", app.config.Version),
+			},
+			userID: user.ID.String(),
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, response.Code, http.StatusOK)
+
+		count, _, err := app.limiter.Hit(failuresKey, time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
 	})
 }
 
@@ -660,7 +857,7 @@ func TestUpdateUserHandler(t *testing.T) {
 		"confirm_password":"newpass"
 	}`)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
 	t.Run("Update user: success", func(t *testing.T) {
@@ -787,7 +984,7 @@ func TestUpdateUserHandler(t *testing.T) {
 	})
 
 	t.Run("Update user: wrong user ID", func(t *testing.T) {
-		token, err := internal.CreateJWT("", user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+		token, _, err := app.startSession("", user.Email, "", "")
 		assert.NoError(t, err)
 
 		req := authHandlerConfig{
@@ -816,7 +1013,7 @@ func TestGetUserHandler(t *testing.T) {
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
 	t.Run("get user: success", func(t *testing.T) {
@@ -837,7 +1034,7 @@ func TestGetUserHandler(t *testing.T) {
 	})
 
 	t.Run("user not found", func(t *testing.T) {
-		token, err := internal.CreateJWT("", user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+		token, _, err := app.startSession("", user.Email, "", "")
 		assert.NoError(t, err)
 
 		req := authHandlerConfig{
@@ -866,7 +1063,7 @@ func TestApplyForVoucherHandler(t *testing.T) {
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
 	voucherBody := []byte(`{
@@ -964,7 +1161,7 @@ func TestActivateVoucherHandler(t *testing.T) {
 	err = app.db.CreateVoucher(&v)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
 	voucherBody := []byte(fmt.Sprintf(`{"voucher" : "%s"}`, v.Voucher))
@@ -1033,7 +1230,7 @@ func TestActivateVoucherHandler(t *testing.T) {
 		err := app.db.CreateUser(newUser)
 		assert.NoError(t, err)
 
-		token, err := internal.CreateJWT(newUser.ID.String(), newUser.Email, app.config.Token.Secret, app.config.Token.Timeout)
+		token, _, err := app.startSession(newUser.ID.String(), newUser.Email, "", "")
 		assert.NoError(t, err)
 
 		req := authHandlerConfig{
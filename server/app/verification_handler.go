@@ -0,0 +1,250 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// verificationMaxAttempts is the number of wrong codes after which a verification token is dropped
+const verificationMaxAttempts = 5
+
+var errCodeExpired = errors.New("code has expired")
+
+// VerificationLinkInput struct for the token of a verification link
+type VerificationLinkInput struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ResendVerificationInput struct for requesting a new verification code
+type ResendVerificationInput struct {
+	Email   string `json:"email" binding:"required"`
+	Purpose string `json:"purpose" binding:"required"`
+}
+
+// verificationCodeHash returns the hash a verification code of a user is stored with
+func verificationCodeHash(userID string, code int) string {
+	return internal.HashToken(fmt.Sprintf("%s:%d", userID, code))
+}
+
+// verificationCooldown refuses sending a new verification token to a user before the cooldown of their last one ends
+func (a *App) verificationCooldown(userID, purpose string) Response {
+	token, err := a.db.GetVerificationToken(userID, purpose)
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	retryAfter := time.Until(token.CreatedAt.Add(time.Duration(a.config.MailSender.ResendCooldownSeconds) * time.Second))
+	if retryAfter > 0 {
+		return TooManyRequests(errors.New("a code was sent recently, please wait before requesting a new one"), retryAfter)
+	}
+	return nil
+}
+
+// verificationLink generates a signed verification link and the hash its token is stored with
+func (a *App) verificationLink() (link, linkHash string, err error) {
+	linkToken, linkHash, err := internal.GenerateToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	link = fmt.Sprintf("%s/verify_email?token=%s", a.config.Server.Host, url.QueryEscape(internal.SignToken(linkToken, a.config.Token.Secret)))
+	return link, linkHash, nil
+}
+
+// sendVerification mails a new verification code and link of a purpose to a user, their previous token of the purpose is invalidated
+func (a *App) sendVerification(user models.User, purpose, email string) error {
+	code, err := internal.GenerateRandomCode()
+	if err != nil {
+		return err
+	}
+
+	link, linkHash, err := a.verificationLink()
+	if err != nil {
+		return err
+	}
+
+	var subject, body string
+	switch purpose {
+	case models.SignUpPurpose:
+		subject, body, err = internal.SignUpMailContent(a.mailTemplate(internal.SignUpMail, user.Language), code, link, a.config.MailSender.Timeout, user.Name, a.config.Server.Host)
+	case models.ResetPasswordPurpose:
+		subject, body, err = internal.ResetPasswordMailContent(a.mailTemplate(internal.ResetPasswordMail, user.Language), code, link, a.config.MailSender.Timeout, user.Name, a.config.Server.Host)
+	case models.EmailChangePurpose:
+		subject, body, err = internal.EmailChangeMailContent(a.mailTemplate(internal.EmailChangeMail, user.Language), code, link, a.config.MailSender.Timeout, user.Name, a.config.Server.Host)
+	default:
+		err = fmt.Errorf("verification purpose '%s' is not supported", purpose)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = a.db.CreateVerificationToken(&models.VerificationToken{
+		UserID:    user.ID.String(),
+		Purpose:   purpose,
+		Email:     email,
+		CodeHash:  verificationCodeHash(user.ID.String(), code),
+		LinkHash:  linkHash,
+		ExpiresAt: time.Now().Add(time.Duration(a.config.MailSender.Timeout) * time.Second),
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.db.EnqueueMail(email, subject, body)
+}
+
+// useVerificationCode uses the verification code of a purpose a user entered,
+// wrong codes are counted and the token is invalidated once the user is locked out
+func (a *App) useVerificationCode(user models.User, purpose string, code int) (models.VerificationToken, Response) {
+	token, err := a.db.GetActiveVerificationToken(user.ID.String(), purpose)
+	if err == gorm.ErrRecordNotFound || token.Attempts >= verificationMaxAttempts {
+		return models.VerificationToken{}, BadRequest(errCodeExpired)
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return models.VerificationToken{}, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if verificationCodeHash(user.ID.String(), code) != token.CodeHash {
+		if err := a.db.FailVerificationToken(token.ID); err != nil {
+			log.Error().Err(err).Send()
+		}
+
+		return models.VerificationToken{}, a.failedAttempt(codeAttempts, user.Email, BadRequest(errors.New("wrong code")), func() error {
+			return a.db.DeleteVerificationTokens(user.ID.String(), purpose)
+		})
+	}
+
+	used, err := a.db.UseVerificationToken(token.ID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return models.VerificationToken{}, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	// used by a concurrent request
+	if !used {
+		return models.VerificationToken{}, BadRequest(errCodeExpired)
+	}
+	a.resetAttempts(codeAttempts, user.Email)
+
+	return token, nil
+}
+
+// VerifyLinkHandler verifies the email of a user with the token of the link mailed to them,
+// it completes the flow the link is sent for like its code does
+func (a *App) VerifyLinkHandler(req *http.Request) (interface{}, Response) {
+	var input VerificationLinkInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read verification link data"))
+	}
+
+	errInvalidLink := errors.New("link is invalid or has expired")
+
+	linkToken, ok := internal.VerifySignedToken(strings.TrimSpace(input.Token), a.config.Token.Secret)
+	if !ok {
+		return nil, BadRequest(errInvalidLink)
+	}
+
+	token, err := a.db.GetActiveVerificationTokenByLink(internal.HashToken(linkToken))
+	if err == gorm.ErrRecordNotFound {
+		return nil, BadRequest(errInvalidLink)
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	used, err := a.db.UseVerificationToken(token.ID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+	if !used {
+		return nil, BadRequest(errInvalidLink)
+	}
+
+	user, err := a.db.GetUserByID(token.UserID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	switch token.Purpose {
+	case models.SignUpPurpose:
+		if user.Verified {
+			return nil, BadRequest(errors.New("account is already created"))
+		}
+		return a.completeSignUp(req, user)
+	case models.ResetPasswordPurpose:
+		return a.completePasswordReset(req, user)
+	case models.EmailChangePurpose:
+		return a.completeEmailChange(user, token)
+	case models.EmailRevertPurpose:
+		return a.revertEmailChange(user, token)
+	default:
+		return nil, BadRequest(errInvalidLink)
+	}
+}
+
+// ResendVerificationHandler mails a new verification code and link to a user signing up or resetting their password
+func (a *App) ResendVerificationHandler(req *http.Request) (interface{}, Response) {
+	var input ResendVerificationInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read resend data"))
+	}
+
+	if input.Purpose != models.SignUpPurpose && input.Purpose != models.ResetPasswordPurpose {
+		return nil, BadRequest(fmt.Errorf("purpose should be one of %s or %s", models.SignUpPurpose, models.ResetPasswordPurpose))
+	}
+
+	user, err := a.db.GetUserByEmail(input.Email)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	if input.Purpose == models.SignUpPurpose && user.Verified {
+		return nil, BadRequest(errors.New("account is already created"))
+	}
+	if input.Purpose == models.ResetPasswordPurpose && !user.Verified {
+		return nil, BadRequest(errors.New("email is not verified yet, please check the verification email in your inbox"))
+	}
+
+	if res := a.verificationCooldown(user.ID.String(), input.Purpose); res != nil {
+		return nil, res
+	}
+
+	if err := a.sendVerification(user, input.Purpose, user.Email); err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Verification code has been sent to " + user.Email,
+		Data:    map[string]int{"timeout": a.config.MailSender.Timeout},
+	}, Ok()
+}
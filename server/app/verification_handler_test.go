@@ -0,0 +1,149 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var verificationLinkRegex = regexp.MustCompile(`verify_email\?token=([A-Za-z0-9_.%-]+)`)
+
+// createVerificationCode replaces the verification token of a purpose of the test user with one of a known code
+func createVerificationCode(t *testing.T, app *App, purpose string, code int) {
+	_, linkHash, err := internal.GenerateToken()
+	assert.NoError(t, err)
+
+	err = app.db.CreateVerificationToken(&models.VerificationToken{
+		UserID:    user.ID.String(),
+		Purpose:   purpose,
+		Email:     user.Email,
+		CodeHash:  verificationCodeHash(user.ID.String(), code),
+		LinkHash:  linkHash,
+		ExpiresAt: time.Now().Add(time.Duration(app.config.MailSender.Timeout) * time.Second),
+	})
+	assert.NoError(t, err)
+}
+
+// lastVerificationLink returns the token of the link in the last mail queued for the test user
+func lastVerificationLink(t *testing.T, app *App) string {
+	mails, err := app.db.ListOutgoingMails(models.MailsFilter{Status: models.MailPending})
+	assert.NoError(t, err)
+
+	for _, mail := range mails {
+		if mail.Receiver != user.Email {
+			continue
+		}
+		match := verificationLinkRegex.FindStringSubmatch(mail.Body)
+		if match == nil {
+			continue
+		}
+		token, err := url.QueryUnescape(match[1])
+		assert.NoError(t, err)
+		return token
+	}
+
+	t.Fatal("no verification link is queued")
+	return ""
+}
+
+func TestVerifyLinkHandler(t *testing.T) {
+	app := SetUp(t)
+
+	user.Verified = false
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	err = app.sendVerification(*user, models.SignUpPurpose, user.Email)
+	assert.NoError(t, err)
+	token := lastVerificationLink(t, app)
+
+	verifyLink := func(token string) int {
+		body := []byte(fmt.Sprintf(`{"token": "%s"}`, token))
+		return unAuthorizedHandler(unAuthHandlerConfig{
+			body:        bytes.NewBuffer(body),
+			handlerFunc: app.VerifyLinkHandler,
+			api:         fmt.Sprintf("/%s/user/verification/link", app.config.Version),
+		}).Code
+	}
+
+	t.Run("Verify link: forged signature", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, verifyLink(token+"a"))
+	})
+
+	t.Run("Verify link: sign up", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, verifyLink(token))
+
+		got, err := app.db.GetUserByID(user.ID.String())
+		assert.NoError(t, err)
+		assert.True(t, got.Verified)
+	})
+
+	t.Run("Verify link: used once", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, verifyLink(token))
+	})
+
+	t.Run("Verify link: reset password", func(t *testing.T) {
+		err := app.sendVerification(*user, models.ResetPasswordPurpose, user.Email)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, verifyLink(lastVerificationLink(t, app)))
+	})
+
+	t.Run("Verify link: expired", func(t *testing.T) {
+		app.config.MailSender.Timeout = 0
+		err := app.sendVerification(*user, models.ResetPasswordPurpose, user.Email)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusBadRequest, verifyLink(lastVerificationLink(t, app)))
+	})
+}
+
+func TestResendVerificationHandler(t *testing.T) {
+	app := SetUp(t)
+
+	user.Verified = false
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	resend := func(purpose string) *httptest.ResponseRecorder {
+		body := []byte(fmt.Sprintf(`{"email": "%s", "purpose": "%s"}`, user.Email, purpose))
+		return unAuthorizedHandler(unAuthHandlerConfig{
+			body:        bytes.NewBuffer(body),
+			handlerFunc: app.ResendVerificationHandler,
+			api:         fmt.Sprintf("/%s/user/verification/resend", app.config.Version),
+		})
+	}
+
+	t.Run("Resend: unknown purpose", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, resend("login").Code)
+	})
+
+	t.Run("Resend: password reset of unverified user", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, resend(models.ResetPasswordPurpose).Code)
+	})
+
+	t.Run("Resend: sign up code", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, resend(models.SignUpPurpose).Code)
+	})
+
+	t.Run("Resend: code was sent recently", func(t *testing.T) {
+		response := resend(models.SignUpPurpose)
+		assert.Equal(t, http.StatusTooManyRequests, response.Code)
+		assert.NotEmpty(t, response.Header().Get("Retry-After"))
+	})
+
+	t.Run("Resend: after the cooldown", func(t *testing.T) {
+		app.config.MailSender.ResendCooldownSeconds = 0
+		assert.Equal(t, http.StatusOK, resend(models.SignUpPurpose).Code)
+	})
+}
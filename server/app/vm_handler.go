@@ -74,15 +74,25 @@ func (a *App) DeployVMHandler(req *http.Request) (interface{}, Response) {
 		return nil, BadRequest(errors.New("virtual machine name is not available, please choose a different name"))
 	}
 
-	err = a.deployer.Redis.PushVMRequest(streams.VMDeployRequest{User: user, Input: input, AdminSSHKey: a.config.AdminSSHKey})
+	request := models.DeploymentRequest{UserID: userID, Type: models.VMsType, Name: input.Name}
+	err = a.db.CreateDeploymentRequest(&request)
 	if err != nil {
 		log.Error().Err(err).Send()
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
+	err = a.deployer.Redis.PushVMRequest(streams.VMDeployRequest{ID: request.ID, User: user, Input: input, AdminSSHKey: a.config.AdminSSHKey})
+	if err != nil {
+		log.Error().Err(err).Send()
+		if err := a.db.FailDeploymentRequest(request.ID, "failed to queue the request"); err != nil {
+			log.Error().Err(err).Send()
+		}
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
 	return ResponseMsg{
 		Message: "Virtual machine request is being deployed, you'll receive a confirmation notification soon",
-		Data:    nil,
+		Data:    map[string]string{"request_id": request.ID},
 	}, Created()
 }
 
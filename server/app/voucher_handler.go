@@ -4,6 +4,7 @@ package app
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -136,18 +137,7 @@ func (a *App) UpdateVoucherHandler(req *http.Request) (interface{}, Response) {
 		return nil, InternalServerError(errors.New(internalServerErrorMsg))
 	}
 
-	var subject, body string
-	if input.Approved {
-		subject, body = internal.ApprovedVoucherMailContent(updatedVoucher.Voucher, user.Name, a.config.Server.Host)
-	} else {
-		subject, body = internal.RejectedVoucherMailContent(user.Name, a.config.Server.Host)
-	}
-
-	err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, user.Email, subject, body)
-	if err != nil {
-		log.Error().Err(err).Send()
-		return nil, InternalServerError(errors.New(internalServerErrorMsg))
-	}
+	a.notifyVoucherDecision(user, updatedVoucher, input.Approved)
 
 	return ResponseMsg{
 		Message: "Update mail has been sent to the user",
@@ -183,12 +173,7 @@ func (a *App) ApproveAllVouchersHandler(req *http.Request) (interface{}, Respons
 			return nil, InternalServerError(errors.New(internalServerErrorMsg))
 		}
 
-		subject, body := internal.ApprovedVoucherMailContent(v.Voucher, user.Name, a.config.Server.Host)
-		err = internal.SendMail(a.config.MailSender.Email, a.config.MailSender.SendGridKey, user.Email, subject, body)
-		if err != nil {
-			log.Error().Err(err).Send()
-			return nil, InternalServerError(errors.New(internalServerErrorMsg))
-		}
+		a.notifyVoucherDecision(user, v, true)
 	}
 
 	return ResponseMsg{
@@ -196,3 +181,23 @@ func (a *App) ApproveAllVouchersHandler(req *http.Request) (interface{}, Respons
 		Data:    nil,
 	}, Ok()
 }
+
+// notifyVoucherDecision notifies a user that their voucher request is approved or rejected
+func (a *App) notifyVoucherDecision(user models.User, voucher models.Voucher, approved bool) {
+	n := models.Notification{Msg: "Your voucher request is rejected", Type: models.VoucherType, VoucherID: voucher.ID}
+	event, mail := models.EventVoucherRejected, internal.RejectedVoucherMail
+	if approved {
+		n.Msg = fmt.Sprintf("Your voucher request is approved, activate it with the voucher '%s'", voucher.Voucher)
+		event, mail = models.EventVoucherApproved, internal.ApprovedVoucherMail
+	}
+
+	err := a.notify(user, n, event, map[string]interface{}{"voucher_id": voucher.ID}, mail, func(t internal.MailTemplate) (string, string, error) {
+		if approved {
+			return internal.ApprovedVoucherMailContent(t, voucher.Voucher, user.Name, a.config.Server.Host)
+		}
+		return internal.RejectedVoucherMailContent(t, user.Name, a.config.Server.Host)
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to notify user %s with voucher decision", user.ID.String())
+	}
+}
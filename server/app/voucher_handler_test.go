@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"testing"
 
-	"github.com/codescalers/cloud4students/internal"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/stretchr/testify/assert"
 )
@@ -15,12 +14,12 @@ import (
 func TestGenerateVoucherHandler(t *testing.T) {
 	app := SetUp(t)
 
-	user.Admin = true
+	user.Roles = []string{models.SuperAdminRole}
 	user.Verified = true
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
 	voucherBody := []byte(`{
@@ -94,12 +93,12 @@ func TestGenerateVoucherHandler(t *testing.T) {
 func TestListVouchersHandler(t *testing.T) {
 	app := SetUp(t)
 
-	user.Admin = true
+	user.Roles = []string{models.SuperAdminRole}
 	user.Verified = true
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
 	t.Run("List vouchers: no vouchers found", func(t *testing.T) {
@@ -151,12 +150,12 @@ func TestListVouchersHandler(t *testing.T) {
 func TestUpdateVoucherHandler(t *testing.T) {
 	app := SetUp(t)
 
-	user.Admin = true
+	user.Roles = []string{models.SuperAdminRole}
 	user.Verified = true
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
 	v := models.Voucher{
@@ -185,6 +184,11 @@ func TestUpdateVoucherHandler(t *testing.T) {
 		want := `{"msg":"Update mail has been sent to the user"}` + "\n"
 		assert.Equal(t, response.Body.String(), want)
 		assert.Equal(t, response.Code, http.StatusOK)
+
+		notifications, err := app.db.ListNotifications(user.ID.String(), models.NotificationsFilter{})
+		assert.NoError(t, err)
+		assert.Len(t, notifications, 1)
+		assert.Equal(t, models.VoucherType, notifications[0].Type)
 	})
 
 	t.Run("Update voucher: voucher already approved", func(t *testing.T) {
@@ -314,12 +318,12 @@ func TestUpdateVoucherHandler(t *testing.T) {
 func TestApproveAllVouchers(t *testing.T) {
 	app := SetUp(t)
 
-	user.Admin = true
+	user.Roles = []string{models.SuperAdminRole}
 	user.Verified = true
 	err := app.db.CreateUser(user)
 	assert.NoError(t, err)
 
-	token, err := internal.CreateJWT(user.ID.String(), user.Email, app.config.Token.Secret, app.config.Token.Timeout)
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
 	assert.NoError(t, err)
 
 	t.Run("approve all: no vouchers found", func(t *testing.T) {
@@ -0,0 +1,265 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/middlewares"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	// webhooksInterval is the interval of checking for queued webhook deliveries
+	webhooksInterval = 10 * time.Second
+	// webhooksBatchSize is the max number of deliveries sent every interval
+	webhooksBatchSize = 50
+	// webhookClaimTimeout is the time after which a delivery claimed by a stopped worker is sent again
+	webhookClaimTimeout = 5 * time.Minute
+	// webhookDeliveriesLimit is the number of latest deliveries listed for a webhook
+	webhookDeliveriesLimit = 100
+)
+
+// WebhookInput struct for data needed when user registers a webhook
+type WebhookInput struct {
+	URL string `json:"url" binding:"required" validate:"nonzero"`
+	// events the webhook is subscribed to, it is subscribed to all events if empty
+	Events []string `json:"events"`
+	// get the events of all users, for admins only
+	AllUsers bool `json:"all_users"`
+}
+
+// CreatedWebhook struct holds a new webhook with its signing secret, the secret is only shown once
+type CreatedWebhook struct {
+	models.Webhook
+	Secret string `json:"secret"`
+}
+
+// CreateWebhookHandler registers a new webhook for the user
+func (a *App) CreateWebhookHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	var input WebhookInput
+	err := json.NewDecoder(req.Body).Decode(&input)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, BadRequest(errors.New("failed to read webhook data"))
+	}
+
+	if err := internal.ValidateWebhookURL(input.URL); err != nil {
+		return nil, BadRequest(err)
+	}
+
+	user, err := a.db.GetUserByID(userID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, NotFound(errors.New("user is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	for _, event := range input.Events {
+		if !internal.Contains(models.Events, event) {
+			return nil, BadRequest(fmt.Errorf("event '%s' is not supported", event))
+		}
+		if event == models.EventBalanceLow && !models.HasPermission(user.Roles, models.PermissionReadBalance) {
+			return nil, BadRequest(fmt.Errorf("event '%s' is for admins only", event))
+		}
+	}
+
+	if input.AllUsers && !models.HasPermission(user.Roles, models.PermissionReadUsers) {
+		return nil, Forbidden(errors.New("only admins can get the events of all users"))
+	}
+
+	secret, err := internal.GenerateWebhookSecret()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	webhook := models.Webhook{
+		UserID:   userID,
+		URL:      input.URL,
+		Events:   input.Events,
+		AllUsers: input.AllUsers,
+		Secret:   secret,
+	}
+
+	err = a.db.CreateWebhook(&webhook)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Webhook is created successfully, keep its secret to verify the deliveries signatures",
+		Data:    CreatedWebhook{Webhook: webhook, Secret: secret},
+	}, Created()
+}
+
+// ListWebhooksHandler lists the webhooks of the user
+func (a *App) ListWebhooksHandler(req *http.Request) (interface{}, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	webhooks, err := a.db.ListWebhooks(userID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Webhooks are found",
+		Data:    webhooks,
+	}, Ok()
+}
+
+// DeleteWebhookHandler deletes a webhook of the user
+func (a *App) DeleteWebhookHandler(req *http.Request) (interface{}, Response) {
+	webhook, res := a.userWebhook(req)
+	if res != nil {
+		return nil, res
+	}
+
+	err := a.db.DeleteWebhook(webhook.ID)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Webhook is deleted successfully",
+		Data:    nil,
+	}, Ok()
+}
+
+// ListWebhookDeliveriesHandler lists the latest deliveries of a webhook of the user
+func (a *App) ListWebhookDeliveriesHandler(req *http.Request) (interface{}, Response) {
+	webhook, res := a.userWebhook(req)
+	if res != nil {
+		return nil, res
+	}
+
+	deliveries, err := a.db.ListWebhookDeliveries(webhook.ID, webhookDeliveriesLimit)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Webhook deliveries are found",
+		Data:    deliveries,
+	}, Ok()
+}
+
+// PingWebhookHandler queues a ping event to a webhook of the user, it is sent and retried like other deliveries
+func (a *App) PingWebhookHandler(req *http.Request) (interface{}, Response) {
+	webhook, res := a.userWebhook(req)
+	if res != nil {
+		return nil, res
+	}
+
+	payload, err := json.Marshal(models.WebhookEvent{Event: models.EventPing, UserID: webhook.UserID, Data: webhook, CreatedAt: time.Now()})
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	delivery, err := a.db.EnqueueWebhookDelivery(webhook.ID, models.EventPing, string(payload))
+	if err != nil {
+		log.Error().Err(err).Send()
+		return nil, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return ResponseMsg{
+		Message: "Ping is queued, check the webhook deliveries for its result",
+		Data:    delivery,
+	}, Created()
+}
+
+// userWebhook returns the webhook with the id in the request url if it belongs to the user
+func (a *App) userWebhook(req *http.Request) (models.Webhook, Response) {
+	userID := req.Context().Value(middlewares.UserIDKey("UserID")).(string)
+
+	id, err := strconv.Atoi(mux.Vars(req)["id"])
+	if err != nil {
+		log.Error().Err(err).Send()
+		return models.Webhook{}, BadRequest(errors.New("failed to read webhook id"))
+	}
+
+	webhook, err := a.db.GetWebhook(id)
+	if err == gorm.ErrRecordNotFound || webhook.UserID != userID {
+		return models.Webhook{}, NotFound(errors.New("webhook is not found"))
+	}
+	if err != nil {
+		log.Error().Err(err).Send()
+		return models.Webhook{}, InternalServerError(errors.New(internalServerErrorMsg))
+	}
+
+	return webhook, nil
+}
+
+// emitEvent queues the event to the subscribed webhooks, a failure doesn't fail the caller
+func (a *App) emitEvent(userID, event string, data interface{}) {
+	if err := a.db.EmitEvent(userID, event, data); err != nil {
+		log.Error().Err(err).Msgf("failed to emit event %s", event)
+	}
+}
+
+// sendWebhooks sends the queued webhook deliveries until the context is done
+func (a *App) sendWebhooks(ctx context.Context) {
+	ticker := time.NewTicker(webhooksInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		deliveries, err := a.db.ClaimDueWebhookDeliveries(webhooksBatchSize, webhookClaimTimeout)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to claim queued webhook deliveries")
+		}
+
+		for _, delivery := range deliveries {
+			a.sendWebhookDelivery(delivery)
+		}
+	}
+}
+
+func (a *App) sendWebhookDelivery(delivery models.WebhookDelivery) {
+	webhook, err := a.db.GetWebhook(delivery.WebhookID)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to get webhook of delivery with ID: %d", delivery.ID)
+		return
+	}
+
+	code, sendErr := internal.PostWebhook(a.webhookClient, webhook.URL, webhook.Secret, delivery.Event, delivery.ID, []byte(delivery.Payload))
+
+	switch {
+	case sendErr == nil:
+		err = a.db.MarkWebhookDelivered(delivery.ID, code)
+	case delivery.Attempts+1 >= a.config.Webhooks.MaxAttempts:
+		log.Error().Err(sendErr).Msgf("failed to send webhook delivery with ID: %d, no attempts left", delivery.ID)
+		err = a.db.FailWebhookDelivery(delivery.ID, code, sendErr.Error())
+	default:
+		log.Error().Err(sendErr).Msgf("failed to send webhook delivery with ID: %d, it will be retried", delivery.ID)
+		delay := retryDelay(time.Duration(a.config.Webhooks.RetryBaseSeconds)*time.Second, delivery.Attempts)
+		err = a.db.RetryWebhookDelivery(delivery.ID, code, sendErr.Error(), time.Now().Add(delay))
+	}
+
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to update webhook delivery with ID: %d", delivery.ID)
+	}
+}
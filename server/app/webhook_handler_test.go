@@ -0,0 +1,185 @@
+// Package app for c4s backend app
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhooksHandlers(t *testing.T) {
+	app := SetUp(t)
+
+	user.Verified = true
+	err := app.db.CreateUser(user)
+	assert.NoError(t, err)
+
+	token, _, err := app.startSession(user.ID.String(), user.Email, "", "")
+	assert.NoError(t, err)
+
+	var received []*http.Request
+	var bodies [][]byte
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, body)
+	}))
+	defer endpoint.Close()
+
+	others := models.Webhook{UserID: "another user", URL: endpoint.URL, Secret: "secret"}
+	err = app.db.CreateWebhook(&others)
+	assert.NoError(t, err)
+
+	var created struct {
+		Data CreatedWebhook `json:"data"`
+	}
+
+	t.Run("Create webhook: success", func(t *testing.T) {
+		body, err := json.Marshal(WebhookInput{URL: endpoint.URL, Events: []string{models.EventVMDeployed}})
+		assert.NoError(t, err)
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: app.CreateWebhookHandler,
+				api:         fmt.Sprintf("/%s/webhooks", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusCreated, response.Code)
+
+		err = json.Unmarshal(response.Body.Bytes(), &created)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, created.Data.Secret)
+	})
+
+	t.Run("Create webhook: unsupported event", func(t *testing.T) {
+		body, err := json.Marshal(WebhookInput{URL: endpoint.URL, Events: []string{"vm.exploded"}})
+		assert.NoError(t, err)
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: app.CreateWebhookHandler,
+				api:         fmt.Sprintf("/%s/webhooks", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Create webhook: all users for non admin", func(t *testing.T) {
+		body, err := json.Marshal(WebhookInput{URL: endpoint.URL, AllUsers: true})
+		assert.NoError(t, err)
+
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				body:        bytes.NewBuffer(body),
+				handlerFunc: app.CreateWebhookHandler,
+				api:         fmt.Sprintf("/%s/webhooks", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("List webhooks: secrets are hidden", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ListWebhooksHandler,
+				api:         fmt.Sprintf("/%s/webhooks", app.config.Version),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Contains(t, response.Body.String(), endpoint.URL)
+		assert.NotContains(t, response.Body.String(), created.Data.Secret)
+		assert.NotContains(t, response.Body.String(), `"user_id":"another user"`)
+	})
+
+	t.Run("Ping webhook: signed delivery", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.PingWebhookHandler,
+				api:         fmt.Sprintf("/%s/webhooks/%d/ping", app.config.Version, created.Data.ID),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			varID:  created.Data.ID,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusCreated, response.Code)
+
+		deliveries, err := app.db.ClaimDueWebhookDeliveries(webhooksBatchSize, webhookClaimTimeout)
+		assert.NoError(t, err)
+		assert.Len(t, deliveries, 1)
+
+		app.sendWebhookDelivery(deliveries[0])
+
+		delivery, err := app.db.GetWebhookDelivery(deliveries[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, models.DeliveryDelivered, delivery.Status)
+
+		assert.Len(t, received, 1)
+		assert.Equal(t, models.EventPing, received[0].Header.Get(internal.WebhookEventHeader))
+		assert.Equal(t, internal.SignWebhookPayload(created.Data.Secret, bodies[0]), received[0].Header.Get(internal.WebhookSignatureHeader))
+	})
+
+	t.Run("List deliveries: not owned", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.ListWebhookDeliveriesHandler,
+				api:         fmt.Sprintf("/%s/webhooks/%d/deliveries", app.config.Version, others.ID),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			varID:  others.ID,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("Delete webhook: success", func(t *testing.T) {
+		req := authHandlerConfig{
+			unAuthHandlerConfig: unAuthHandlerConfig{
+				handlerFunc: app.DeleteWebhookHandler,
+				api:         fmt.Sprintf("/%s/webhooks/%d", app.config.Version, created.Data.ID),
+			},
+			token:  token,
+			config: app.config,
+			db:     app.db,
+			varID:  created.Data.ID,
+		}
+
+		response := authorizedHandler(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/codescalers/cloud4students/middlewares"
 	"github.com/rs/zerolog/log"
@@ -42,38 +43,42 @@ func WrapFunc(a Handler) http.HandlerFunc {
 		}()
 
 		object, result := a(r)
+		writeResponse(w, r, object, result)
+	}
+}
 
-		w.Header().Set("Content-Type", "application/json")
+// writeResponse writes the json response of a handler
+func writeResponse(w http.ResponseWriter, r *http.Request, object interface{}, result Response) {
+	w.Header().Set("Content-Type", "application/json")
 
-		var status int
-		if result == nil {
-			w.WriteHeader(http.StatusOK)
-			status = http.StatusOK
-		} else {
+	var status int
+	if result == nil {
+		w.WriteHeader(http.StatusOK)
+		status = http.StatusOK
+	} else {
 
-			h := result.Header()
-			for k := range h {
-				for _, v := range h.Values(k) {
-					w.Header().Add(k, v)
-				}
+		h := result.Header()
+		for k := range h {
+			for _, v := range h.Values(k) {
+				w.Header().Add(k, v)
 			}
+		}
 
-			w.WriteHeader(result.Status())
-			if err := result.Err(); err != nil {
-				object = struct {
-					Error string `json:"err"`
-				}{
-					Error: err.Error(),
-				}
+		w.WriteHeader(result.Status())
+		if err := result.Err(); err != nil {
+			object = struct {
+				Error string `json:"err"`
+			}{
+				Error: err.Error(),
 			}
-			status = result.Status()
 		}
+		status = result.Status()
+	}
 
-		if err := json.NewEncoder(w).Encode(object); err != nil {
-			log.Error().Err(err).Msg("failed to encode return object")
-		}
-		middlewares.Requests.WithLabelValues(r.Method, r.RequestURI, fmt.Sprint(status)).Inc()
+	if err := json.NewEncoder(w).Encode(object); err != nil {
+		log.Error().Err(err).Msg("failed to encode return object")
 	}
+	middlewares.Requests.WithLabelValues(r.Method, r.RequestURI, fmt.Sprint(status)).Inc()
 }
 
 type genericResponse struct {
@@ -135,6 +140,11 @@ func BadRequest(err error) Response {
 	return Error(err, http.StatusBadRequest)
 }
 
+// Unauthorized result
+func Unauthorized(err error) Response {
+	return Error(err, http.StatusUnauthorized)
+}
+
 // InternalServerError result
 func InternalServerError(err error) Response {
 	return Error(err, 0)
@@ -155,6 +165,11 @@ func Forbidden(err error) Response {
 	return Error(err, http.StatusForbidden)
 }
 
+// TooManyRequests response with the time the client should wait before retrying
+func TooManyRequests(err error, retryAfter time.Duration) Response {
+	return Error(err, http.StatusTooManyRequests).WithHeader("Retry-After", middlewares.RetryAfter(retryAfter))
+}
+
 // Accepted response
 func Accepted() Response {
 	return genericResponse{status: http.StatusAccepted}
@@ -6,8 +6,11 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/codescalers/cloud4students/app"
 	"github.com/rs/zerolog"
@@ -27,25 +30,33 @@ var rootCmd = &cobra.Command{
 		`,
 
 	RunE: func(cmd *cobra.Command, args []string) error {
-		configFile, err := cmd.Flags().GetString("config")
-		if err != nil {
-			return fmt.Errorf("failed to parse config: %w", err)
-		}
-
-		app, err := app.NewApp(cmd.Context(), configFile)
-		if err != nil {
-			return fmt.Errorf("failed to create new app: %w", err)
-		}
-
-		err = app.Start(cmd.Context())
-		if err != nil {
-			return fmt.Errorf("failed to start app: %w", err)
-		}
-
-		return nil
+		return run(cmd, (*app.App).Start)
 	},
 }
 
+// run creates the app from the config flag and runs it until an interrupt or terminate signal is received
+func run(cmd *cobra.Command, start func(*app.App, context.Context) error) error {
+	configFile, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	c4sApp, err := app.NewApp(ctx, configFile)
+	if err != nil {
+		return fmt.Errorf("failed to create new app: %w", err)
+	}
+
+	err = start(c4sApp, ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start app: %w", err)
+	}
+
+	return nil
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -59,5 +70,5 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.Flags().StringP("config", "c", "./config.json", "Enter your configurations path")
+	rootCmd.PersistentFlags().StringP("config", "c", "./config.json", "Enter your configurations path")
 }
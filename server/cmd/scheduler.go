@@ -0,0 +1,22 @@
+// Package cmd to make it cmd app
+package cmd
+
+import (
+	"github.com/codescalers/cloud4students/app"
+	"github.com/spf13/cobra"
+)
+
+// schedulerCmd represents the scheduler command
+var schedulerCmd = &cobra.Command{
+	Use:   "scheduler",
+	Short: "Start the periodic jobs only",
+	Long: `Start the periodic jobs only, for example notifying admins
+	with pending vouchers and low balance.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run(cmd, (*app.App).Schedule)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(schedulerCmd)
+}
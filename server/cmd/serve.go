@@ -0,0 +1,22 @@
+// Package cmd to make it cmd app
+package cmd
+
+import (
+	"github.com/codescalers/cloud4students/app"
+	"github.com/spf13/cobra"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start the api server only",
+	Long: `Start the api server only, deployment requests are queued
+	to be deployed by the worker command.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run(cmd, (*app.App).Serve)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+}
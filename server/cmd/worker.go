@@ -0,0 +1,22 @@
+// Package cmd to make it cmd app
+package cmd
+
+import (
+	"github.com/codescalers/cloud4students/app"
+	"github.com/spf13/cobra"
+)
+
+// workerCmd represents the worker command
+var workerCmd = &cobra.Command{
+	Use:   "worker",
+	Short: "Start the deployment worker only",
+	Long: `Start the deployment worker only, it deploys the requests
+	queued by the serve command.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run(cmd, (*app.App).Work)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(workerCmd)
+}
@@ -0,0 +1,93 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"time"
+
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+// maxBatchErrorRate is the rate of failed deployments in a batch after which the batch size is shrunk
+const maxBatchErrorRate = 0.2
+
+// batch holds deployments waiting to be deployed together
+type batch[T any] struct {
+	nets       []*workloads.ZNet
+	items      []T
+	requestIDs []string
+	// time the first deployment of the batch is consumed
+	since time.Time
+}
+
+func (b *batch[T]) add(nets []*workloads.ZNet, items []T, requestIDs []string) {
+	if len(b.items) == 0 && len(items) > 0 {
+		b.since = time.Now()
+	}
+
+	b.nets = append(b.nets, nets...)
+	b.items = append(b.items, items...)
+	b.requestIDs = append(b.requestIDs, requestIDs...)
+}
+
+// ready returns true if the batch is full or its oldest deployment waited long enough
+func (b *batch[T]) ready(size int, maxWait time.Duration) bool {
+	if len(b.items) == 0 {
+		return false
+	}
+
+	return len(b.items) >= size || time.Since(b.since) >= maxWait
+}
+
+func (b *batch[T]) reset() {
+	*b = batch[T]{}
+}
+
+// batchSizer adapts the batch size to the observed batch latency and error rate,
+// the size grows by one after fast full batches and is halved after slow or failing ones
+type batchSizer struct {
+	size          int
+	min           int
+	max           int
+	targetLatency time.Duration
+}
+
+func newBatchSizer(size, min, max int, targetLatency time.Duration) *batchSizer {
+	if max < min {
+		max = min
+	}
+
+	if size < min {
+		size = min
+	}
+
+	if size > max {
+		size = max
+	}
+
+	return &batchSizer{size: size, min: min, max: max, targetLatency: targetLatency}
+}
+
+// Observe adjusts the batch size using the result of a deployed batch
+func (s *batchSizer) Observe(deployed, failed int, latency time.Duration) {
+	if deployed == 0 {
+		return
+	}
+
+	errorRate := float64(failed) / float64(deployed)
+	if errorRate > maxBatchErrorRate || latency > s.targetLatency {
+		s.size /= 2
+		if s.size < s.min {
+			s.size = s.min
+		}
+		return
+	}
+
+	if deployed >= s.size && latency < s.targetLatency/2 && s.size < s.max {
+		s.size++
+	}
+}
+
+// Size returns the current batch size
+func (s *batchSizer) Size() int {
+	return s.size
+}
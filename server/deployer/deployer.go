@@ -3,15 +3,18 @@ package deployer
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"time"
 
+	"github.com/codescalers/cloud4students/internal"
 	"github.com/codescalers/cloud4students/models"
 	"github.com/codescalers/cloud4students/streams"
 	"github.com/codescalers/cloud4students/validators"
 	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 	"github.com/threefoldtech/zos/pkg/gridtypes"
 	"gopkg.in/validator.v2"
@@ -51,13 +54,42 @@ type Deployer struct {
 	db             models.DB
 	Redis          streams.RedisClient
 	tfPluginClient deployer.TFPluginClient
+	config         internal.Deployment
+	scheduler      *Scheduler
+	notifier       Notifier
+	// name the deployer consumes the streams with
+	consumer string
+}
+
+// Notifier notifies a user with the result of their deployment and emits its event to their webhooks
+type Notifier func(user models.User, n models.Notification, event string, data map[string]interface{})
+
+// inAppNotifier creates the notification and emits the event without checking the user preferences
+func inAppNotifier(db models.DB) Notifier {
+	return func(user models.User, n models.Notification, event string, data map[string]interface{}) {
+		n.UserID = user.ID.String()
+		if err := db.CreateNotification(&n); err != nil {
+			log.Error().Err(err).Msgf("failed to create notification: %+v", n)
+		}
+
+		if err := db.EmitEvent(user.ID.String(), event, data); err != nil {
+			log.Error().Err(err).Msgf("failed to emit event %s", event)
+		}
+	}
+}
 
-	vmDeployed  chan bool
-	k8sDeployed chan bool
+// SetNotifier sets how users are notified with the results of their deployments
+func (d *Deployer) SetNotifier(notifier Notifier) {
+	d.notifier = notifier
+}
+
+// SetConsumer sets the name the deployer consumes the streams with, each worker needs its own name
+func (d *Deployer) SetConsumer(consumer string) {
+	d.consumer = consumer
 }
 
 // NewDeployer create new deployer
-func NewDeployer(db models.DB, redis streams.RedisClient, tfPluginClient deployer.TFPluginClient) (Deployer, error) {
+func NewDeployer(db models.DB, redis streams.RedisClient, tfPluginClient deployer.TFPluginClient, config internal.Deployment) (Deployer, error) {
 	// validations
 	err := validator.SetValidationFunc("ssh", validators.ValidateSSHKey)
 	if err != nil {
@@ -76,66 +108,325 @@ func NewDeployer(db models.DB, redis streams.RedisClient, tfPluginClient deploye
 		db,
 		redis,
 		tfPluginClient,
-		make(chan bool),
-		make(chan bool),
+		config,
+		NewScheduler(config.MaxInFlight, config.MaxInFlightPerUser),
+		inAppNotifier(db),
+		"",
 	}, nil
 }
 
+// userPriority returns the scheduling priority class of the user
+func (d *Deployer) userPriority(user models.User) int {
+	if internal.Contains(user.Roles, models.SuperAdminRole) {
+		return AdminPriority
+	}
+
+	if models.HasPermission(user.Roles, models.PermissionPrioritizeDeployments) || internal.Contains(d.config.Instructors, user.Email) {
+		return InstructorPriority
+	}
+
+	return StudentPriority
+}
+
 // PeriodicRequests for executing deployment api requests
-func (d *Deployer) PeriodicRequests(ctx context.Context, sec int) {
-	ticker := time.NewTicker(time.Second * time.Duration(sec))
-	for range ticker.C {
-		d.ConsumeVMRequest(ctx, false)
-		d.ConsumeK8sRequest(ctx, false)
+func (d *Deployer) PeriodicRequests(ctx context.Context) {
+	ticker := time.NewTicker(time.Second * time.Duration(d.config.BatchIntervalSeconds))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.ConsumeVMRequest(ctx, false)
+			d.ConsumeK8sRequest(ctx, false)
+		}
 	}
 }
 
-// PeriodicDeploy for executing deployments
-func (d *Deployer) PeriodicDeploy(ctx context.Context, sec int) {
-	ticker := time.NewTicker(time.Second * time.Duration(sec))
+// PeriodicDeploy for executing deployments in batches, a batch is deployed as soon as it is full
+// or when its oldest deployment waited for the configured max wait
+func (d *Deployer) PeriodicDeploy(ctx context.Context) {
+	maxWait := time.Second * time.Duration(d.config.MaxWaitSeconds)
+	targetLatency := time.Second * time.Duration(d.config.TargetBatchSeconds)
+
+	vmSizer := newBatchSizer(d.config.BatchSize, d.config.MinBatchSize, d.config.MaxBatchSize, targetLatency)
+	k8sSizer := newBatchSizer(d.config.BatchSize, d.config.MinBatchSize, d.config.MaxBatchSize, targetLatency)
+
+	var vms batch[*workloads.Deployment]
+	var clusters batch[*workloads.K8sCluster]
+
+	ticker := time.NewTicker(time.Second * time.Duration(d.config.BatchIntervalSeconds))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the waiting deployments are pushed back to be deployed by the next leader
+			if waiting := len(vms.items) + len(clusters.items); waiting > 0 {
+				log.Info().Msgf("deployer is stopped, %d waiting deployments are pushed back", waiting)
+			}
+			d.pushBack(&vms, &clusters)
+			return
+		case <-ticker.C:
+		}
+
+		// full batches are deployed without waiting for the next tick
+		for ctx.Err() == nil {
+			vmsFull := d.fillVMsBatch(&vms, vmSizer.Size())
+			clustersFull := d.fillK8sBatch(&clusters, k8sSizer.Size())
 
-	for range ticker.C {
-		vmNets, vms, err := d.consumeVMs()
+			if vms.ready(vmSizer.Size(), maxWait) {
+				d.deployVMsBatch(ctx, &vms, vmSizer)
+			}
+
+			if clusters.ready(k8sSizer.Size(), maxWait) {
+				d.deployK8sBatch(ctx, &clusters, k8sSizer)
+			}
+
+			if !vmsFull && !clustersFull {
+				break
+			}
+		}
+	}
+}
+
+func (d *Deployer) pushBack(vms *batch[*workloads.Deployment], clusters *batch[*workloads.K8sCluster]) {
+	for i, dl := range vms.items {
+		err := d.Redis.PushVM(streams.VMDeployment{RequestID: vms.requestIDs[i], Net: vms.nets[i], DL: dl})
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to push back vm of request with ID: %s", vms.requestIDs[i])
+		}
+	}
+
+	for i, cluster := range clusters.items {
+		err := d.Redis.PushK8s(streams.K8sDeployment{RequestID: clusters.requestIDs[i], Net: clusters.nets[i], DL: cluster})
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to push back cluster of request with ID: %s", clusters.requestIDs[i])
+		}
+	}
+}
+
+func (d *Deployer) fillVMsBatch(b *batch[*workloads.Deployment], size int) bool {
+	if missing := size - len(b.items); missing > 0 {
+		nets, vms, requestIDs, err := d.consumeVMs(int64(missing))
 		if err != nil {
 			log.Error().Err(err).Msg("failed to consume vms")
 		}
+		b.add(nets, vms, requestIDs)
+	}
 
-		k8sNets, clusters, err := d.consumeK8s()
+	return len(b.items) >= size
+}
+
+func (d *Deployer) fillK8sBatch(b *batch[*workloads.K8sCluster], size int) bool {
+	if missing := size - len(b.items); missing > 0 {
+		nets, clusters, requestIDs, err := d.consumeK8s(int64(missing))
 		if err != nil {
 			log.Error().Err(err).Msg("failed to consume clusters")
 		}
+		b.add(nets, clusters, requestIDs)
+	}
 
-		if len(vms) > 0 {
-			err := d.tfPluginClient.NetworkDeployer.BatchDeploy(ctx, vmNets)
-			if err != nil {
-				log.Error().Err(err).Msg("failed to batch deploy network")
-			}
+	return len(b.items) >= size
+}
 
-			err = d.tfPluginClient.DeploymentDeployer.BatchDeploy(ctx, vms)
-			if err != nil {
-				log.Error().Err(err).Msg("failed to batch deploy vm")
-			}
+func (d *Deployer) deployVMsBatch(ctx context.Context, b *batch[*workloads.Deployment], sizer *batchSizer) {
+	start := time.Now()
 
-			for i := 0; i < len(vms); i++ {
-				d.vmDeployed <- true
-			}
+	d.updateRequestsPhase(models.DeployingNetworkPhase, b.requestIDs)
+	err := d.tfPluginClient.NetworkDeployer.BatchDeploy(ctx, b.nets)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to batch deploy network")
+	}
+
+	d.updateRequestsPhase(models.DeployingVMPhase, b.requestIDs)
+	err = d.tfPluginClient.DeploymentDeployer.BatchDeploy(ctx, b.items)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to batch deploy vm")
+	}
+
+	failed := 0
+	for _, dl := range b.items {
+		if dl.ContractID == 0 {
+			failed++
 		}
+	}
 
-		if len(clusters) > 0 {
-			err := d.tfPluginClient.NetworkDeployer.BatchDeploy(ctx, k8sNets)
-			if err != nil {
-				log.Error().Err(err).Msg("failed to batch deploy network")
-			}
+	latency := time.Since(start)
+	sizer.Observe(len(b.items), failed, latency)
+	d.recordBatchTiming(streams.VMBatchTimingsKey, streams.BatchTiming{Size: len(b.items), Duration: latency})
 
-			err = d.tfPluginClient.K8sDeployer.BatchDeploy(ctx, clusters)
-			if err != nil {
-				log.Error().Err(err).Msg("failed to batch deploy clusters")
-			}
+	// wake the workers waiting for the deployments
+	for i, dl := range b.items {
+		d.notifyDeployed(b.requestIDs[i], vmContracts(b.nets[i], dl))
+	}
+	b.reset()
+}
 
-			for i := 0; i < len(clusters); i++ {
-				d.k8sDeployed <- true
+func (d *Deployer) deployK8sBatch(ctx context.Context, b *batch[*workloads.K8sCluster], sizer *batchSizer) {
+	start := time.Now()
+
+	d.updateRequestsPhase(models.DeployingNetworkPhase, b.requestIDs)
+	err := d.tfPluginClient.NetworkDeployer.BatchDeploy(ctx, b.nets)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to batch deploy network")
+	}
+
+	d.updateRequestsPhase(models.DeployingK8sPhase, b.requestIDs)
+	err = d.tfPluginClient.K8sDeployer.BatchDeploy(ctx, b.items)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to batch deploy clusters")
+	}
+
+	failed := 0
+	for _, cluster := range b.items {
+		if len(cluster.NodeDeploymentID) == 0 {
+			failed++
+		}
+	}
+
+	latency := time.Since(start)
+	sizer.Observe(len(b.items), failed, latency)
+	d.recordBatchTiming(streams.K8sBatchTimingsKey, streams.BatchTiming{Size: len(b.items), Duration: latency})
+
+	// wake the workers waiting for the deployments
+	for i, cluster := range b.items {
+		d.notifyDeployed(b.requestIDs[i], k8sContracts(b.nets[i], cluster))
+	}
+	b.reset()
+}
+
+// notifyDeployed wakes the worker waiting for the deployment of a request with the contracts it is deployed with
+func (d *Deployer) notifyDeployed(requestID string, contracts streams.DeployedContracts) {
+	if err := d.Redis.NotifyDeployed(requestID, contracts); err != nil {
+		log.Error().Err(err).Msgf("failed to notify the deployment of request with ID: %s", requestID)
+	}
+}
+
+// vmContracts returns the contracts of a deployed vm and its network, failed deployments have no contracts
+func vmContracts(net *workloads.ZNet, dl *workloads.Deployment) streams.DeployedContracts {
+	contracts := streams.DeployedContracts{}
+	addContract(contracts, dl.NodeID, net.NodeDeploymentID[dl.NodeID])
+	addContract(contracts, dl.NodeID, dl.ContractID)
+	return contracts
+}
+
+// k8sContracts returns the contracts of a deployed cluster and its network, failed deployments have no contracts
+func k8sContracts(net *workloads.ZNet, cluster *workloads.K8sCluster) streams.DeployedContracts {
+	contracts := streams.DeployedContracts{}
+	for node, contractID := range net.NodeDeploymentID {
+		addContract(contracts, node, contractID)
+	}
+	for node, contractID := range cluster.NodeDeploymentID {
+		addContract(contracts, node, contractID)
+	}
+	return contracts
+}
+
+func addContract(contracts streams.DeployedContracts, node uint32, contractID uint64) {
+	if contractID != 0 {
+		contracts[node] = append(contracts[node], contractID)
+	}
+}
+
+// waitDeployed waits for the deployment of a request and returns a state with its contracts to load it from the grid,
+// the batch may be deployed by the worker leader in another instance so the state of this instance doesn't have them
+func (d *Deployer) waitDeployed(ctx context.Context, requestID string) (*state.State, error) {
+	contracts, err := d.Redis.WaitDeployed(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	st := state.NewState(d.tfPluginClient.NcPool, d.tfPluginClient.SubstrateConn)
+	for node, contractIDs := range contracts {
+		st.StoreContractIDs(node, contractIDs...)
+	}
+	return st, nil
+}
+
+// QueuePosition returns the number of requests deployed before a request, the requests of its stream that are not
+// acknowledged are ordered like the workers' schedulers order them: by priority and per user in round robin
+func (d *Deployer) QueuePosition(r models.DeploymentRequest) (int64, error) {
+	stream, group := streams.ReqVMStreamName, streams.ReqVMConsumerGroupName
+	if r.Type == models.K8sType {
+		stream, group = streams.ReqK8sStreamName, streams.ReqK8sConsumerGroupName
+	}
+
+	messages, err := d.Redis.Unacknowledged(stream, group)
+	if err != nil {
+		return 0, err
+	}
+
+	requests := make([]queuedRequest, 0, len(messages))
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		// the fields of vms and k8s requests needed to schedule them
+		var req struct {
+			ID   string
+			User models.User
+		}
+		for _, v := range message.Values {
+			if err := json.Unmarshal([]byte(v.(string)), &req); err != nil {
+				log.Error().Err(err).Msgf("failed to unmarshal request with ID: %s", message.ID)
 			}
 		}
+
+		if req.ID == "" {
+			continue
+		}
+		requests = append(requests, queuedRequest{id: req.ID, userID: req.User.ID.String(), priority: d.userPriority(req.User)})
+		ids = append(ids, req.ID)
+	}
+
+	started, err := d.db.ListStartedDeploymentRequests(ids)
+	if err != nil {
+		return 0, err
+	}
+	for i := range requests {
+		requests[i].started = internal.Contains(started, requests[i].id)
+	}
+
+	ahead, _ := queuePosition(requests, r.ID)
+	return ahead, nil
+}
+
+// EstimateETA estimates the remaining time for a request with the given number of requests ahead of it
+// using the recent batch deployment timings
+func EstimateETA(ahead int64, interval time.Duration, batchSize int, timings []streams.BatchTiming) time.Duration {
+	var totalSize int
+	var totalDuration time.Duration
+	for _, t := range timings {
+		totalSize += t.Size
+		totalDuration += t.Duration
+	}
+
+	// a new batch is not started before the previous one is done
+	cycle := interval
+	if len(timings) > 0 {
+		batchSize = totalSize / len(timings)
+		if avg := totalDuration / time.Duration(len(timings)); avg > cycle {
+			cycle = avg
+		}
+	}
+
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	batches := ahead/int64(batchSize) + 1
+	return time.Duration(batches) * cycle
+}
+
+func (d *Deployer) updateRequestsPhase(phase string, requestIDs []string) {
+	if err := d.db.UpdateDeploymentRequestPhase(phase, requestIDs...); err != nil {
+		log.Error().Err(err).Msgf("failed to update requests phase to '%s'", phase)
+	}
+}
+
+func (d *Deployer) recordBatchTiming(key string, timing streams.BatchTiming) {
+	if err := d.Redis.PushBatchTiming(key, timing); err != nil {
+		log.Error().Err(err).Msg("failed to record batch deployment timing")
 	}
 }
 
@@ -0,0 +1,141 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/streams"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
+)
+
+func TestEstimateETA(t *testing.T) {
+	interval := 6 * time.Second
+
+	t.Run("no timings", func(t *testing.T) {
+		assert.Equal(t, interval, EstimateETA(0, interval, 5, nil))
+		assert.Equal(t, 2*interval, EstimateETA(5, interval, 5, nil))
+	})
+
+	t.Run("batches shorter than interval", func(t *testing.T) {
+		timings := []streams.BatchTiming{{Size: 5, Duration: time.Second}, {Size: 5, Duration: 3 * time.Second}}
+		assert.Equal(t, 2*interval, EstimateETA(5, interval, 1, timings))
+	})
+
+	t.Run("batches longer than interval", func(t *testing.T) {
+		timings := []streams.BatchTiming{{Size: 2, Duration: 10 * time.Second}, {Size: 4, Duration: 30 * time.Second}}
+		assert.Equal(t, 60*time.Second, EstimateETA(6, interval, 5, timings))
+	})
+}
+
+func TestBatchReady(t *testing.T) {
+	var b batch[int]
+	assert.False(t, b.ready(2, 0))
+
+	b.add(nil, []int{1}, []string{"1"})
+	assert.False(t, b.ready(2, time.Minute))
+	assert.True(t, b.ready(2, 0))
+
+	b.add(nil, []int{2}, []string{"2"})
+	assert.True(t, b.ready(2, time.Minute))
+
+	b.reset()
+	assert.Empty(t, b.items)
+	assert.Empty(t, b.requestIDs)
+}
+
+func TestBatchSizer(t *testing.T) {
+	t.Run("size is bounded", func(t *testing.T) {
+		assert.Equal(t, 2, newBatchSizer(1, 2, 4, time.Minute).Size())
+		assert.Equal(t, 4, newBatchSizer(10, 2, 4, time.Minute).Size())
+	})
+
+	t.Run("grows after fast full batches", func(t *testing.T) {
+		s := newBatchSizer(2, 1, 3, time.Minute)
+		s.Observe(2, 0, time.Second)
+		assert.Equal(t, 3, s.Size())
+		s.Observe(3, 0, time.Second)
+		assert.Equal(t, 3, s.Size())
+	})
+
+	t.Run("does not grow after partial batches", func(t *testing.T) {
+		s := newBatchSizer(4, 1, 10, time.Minute)
+		s.Observe(2, 0, time.Second)
+		assert.Equal(t, 4, s.Size())
+	})
+
+	t.Run("shrinks after slow batches", func(t *testing.T) {
+		s := newBatchSizer(8, 1, 10, time.Minute)
+		s.Observe(8, 0, 2*time.Minute)
+		assert.Equal(t, 4, s.Size())
+	})
+
+	t.Run("shrinks after failing batches", func(t *testing.T) {
+		s := newBatchSizer(3, 2, 10, time.Minute)
+		s.Observe(3, 1, time.Second)
+		assert.Equal(t, 2, s.Size())
+	})
+}
+
+// testRedisClient connects an instance to the redis server at REDIS_ADDR or localhost,
+// tests using it are skipped if no server is running
+func testRedisClient(t *testing.T) streams.RedisClient {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+
+	// the database the streams tests flush
+	client := redis.NewClient(&redis.Options{Addr: addr, DB: 15})
+	if err := client.Ping().Err(); err != nil {
+		_ = client.Close()
+		t.Skipf("redis is not running at %s: %s", addr, err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	return streams.RedisClient{DB: client}
+}
+
+func TestDeployedOnAnotherInstance(t *testing.T) {
+	// the leader deploys the batch, the worker of another instance waits for its request
+	leader := &Deployer{Redis: testRedisClient(t), tfPluginClient: deployer.TFPluginClient{State: state.NewState(nil, nil)}}
+	worker := &Deployer{Redis: testRedisClient(t), tfPluginClient: deployer.TFPluginClient{State: state.NewState(nil, nil)}}
+
+	net := workloads.ZNet{Name: "vmNet", Nodes: []uint32{11}, NodeDeploymentID: map[uint32]uint64{11: 100}}
+	dl := workloads.Deployment{Name: "vm", NodeID: 11, ContractID: 101}
+	leader.tfPluginClient.State.StoreContractIDs(11, 100, 101)
+
+	t.Run("vm", func(t *testing.T) {
+		leader.notifyDeployed("vm-request", vmContracts(&net, &dl))
+
+		deployed, err := worker.waitDeployed(context.Background(), "vm-request")
+		assert.NoError(t, err)
+		assert.Equal(t, state.ContractIDs{100, 101}, deployed.CurrentNodeDeployments[11])
+		// the state of the worker instance is not used
+		assert.Empty(t, worker.tfPluginClient.State.CurrentNodeDeployments)
+	})
+
+	t.Run("k8s", func(t *testing.T) {
+		cluster := workloads.K8sCluster{NodeDeploymentID: map[uint32]uint64{11: 102}}
+		leader.notifyDeployed("k8s-request", k8sContracts(&net, &cluster))
+
+		deployed, err := worker.waitDeployed(context.Background(), "k8s-request")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, state.ContractIDs{100, 102}, deployed.CurrentNodeDeployments[11])
+	})
+
+	t.Run("failed deployment", func(t *testing.T) {
+		failed := workloads.Deployment{Name: "failed", NodeID: 12}
+		leader.notifyDeployed("failed-request", vmContracts(&workloads.ZNet{}, &failed))
+
+		deployed, err := worker.waitDeployed(context.Background(), "failed-request")
+		assert.NoError(t, err)
+		assert.Empty(t, deployed.CurrentNodeDeployments)
+	})
+}
@@ -7,7 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
-	"sync"
+	"time"
 
 	"github.com/codescalers/cloud4students/models"
 	"github.com/codescalers/cloud4students/streams"
@@ -17,9 +17,9 @@ import (
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 )
 
-// ConsumeVMRequest to consume api requests of vm deployments
+// ConsumeVMRequest to consume api requests of vm deployments, requests are deployed by the scheduler
 func (d *Deployer) ConsumeVMRequest(ctx context.Context, pending bool) {
-	result, err := d.Redis.Read(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, 0, pending)
+	result, err := d.Redis.Read(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, d.consumer, 0, pending)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return
@@ -28,62 +28,77 @@ func (d *Deployer) ConsumeVMRequest(ctx context.Context, pending bool) {
 		return
 	}
 
-	var vmWG sync.WaitGroup
-
 	for _, s := range result {
-		for _, message := range s.Messages {
-			vmWG.Add(1)
-			go func(message redis.XMessage) {
-				defer vmWG.Done()
-
-				var codeErr int
-				var resErr error
-				var req streams.VMDeployRequest
-
-				for _, v := range message.Values {
-					err = json.Unmarshal([]byte(v.(string)), &req)
-					if err != nil {
-						log.Error().Err(err).Msg("failed to unmarshal vm request")
-						continue
-					}
-
-					codeErr, resErr = d.deployVMRequest(ctx, req.User, req.Input, req.AdminSSHKey)
-					if resErr != nil {
-						log.Error().Err(resErr).Msg("failed to deploy vm request")
-						continue
-					}
-				}
+		d.submitVMRequests(ctx, s.Messages)
+	}
+}
 
-				if err := d.Redis.DB.XAck(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, message.ID).Err(); err != nil {
-					log.Error().Err(err).Msgf("failed to acknowledge vm request with ID: %s", message.ID)
-					resErr = err
-					codeErr = http.StatusInternalServerError
-				}
+func (d *Deployer) submitVMRequests(ctx context.Context, messages []redis.XMessage) {
+	for _, message := range messages {
+		var req streams.VMDeployRequest
+		var err error
+		for _, v := range message.Values {
+			err = json.Unmarshal([]byte(v.(string)), &req)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to unmarshal vm request")
+			}
+		}
 
-				msg := fmt.Sprintf("Your virtual machine '%s' failed to be deployed with error: %s", req.Input.Name, resErr)
-				if codeErr == 0 {
-					msg = fmt.Sprintf("Your virtual machine '%s' is deployed successfully 🎆", req.Input.Name)
-				}
+		if err != nil {
+			_ = d.ackRequest(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, message.ID)
+			continue
+		}
 
-				notification := models.Notification{
-					UserID: req.User.ID.String(),
-					Msg:    msg,
-					Type:   models.VMsType,
-				}
-				err = d.db.CreateNotification(&notification)
-				if err != nil {
-					log.Error().Err(err).Msgf("failed to create notification: %+v", notification)
-				}
+		messageID := message.ID
+		d.scheduler.Submit(req.User.ID.String(), d.userPriority(req.User), func() {
+			d.handleVMRequest(ctx, messageID, req)
+		})
+	}
+}
 
-			}(message)
-		}
-		vmWG.Wait()
+func (d *Deployer) handleVMRequest(ctx context.Context, messageID string, req streams.VMDeployRequest) {
+	if err := d.db.StartDeploymentRequest(req.ID); err != nil {
+		log.Error().Err(err).Msgf("failed to start vm request with ID: %s", req.ID)
+	}
+
+	vmID, codeErr, resErr := d.deployVMRequest(ctx, req.User, req.Input, req.AdminSSHKey, req.ID)
+	if ctx.Err() != nil {
+		// the request is not acknowledged to be retried on restart or claimed by the worker leader
+		log.Info().Msgf("vm request with ID: %s is interrupted", req.ID)
+		return
+	}
+	if resErr != nil {
+		log.Error().Err(resErr).Msg("failed to deploy vm request")
+	}
+
+	if err := d.ackRequest(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, messageID); err != nil {
+		resErr = err
+		codeErr = http.StatusInternalServerError
 	}
+
+	msg := fmt.Sprintf("Your virtual machine '%s' failed to be deployed with error: %s", req.Input.Name, resErr)
+	if codeErr == 0 {
+		msg = fmt.Sprintf("Your virtual machine '%s' is deployed successfully 🎆", req.Input.Name)
+	}
+	d.finishRequest(req.ID, codeErr, resErr)
+
+	notification := models.Notification{
+		Msg:          msg,
+		Type:         models.VMsType,
+		RequestID:    req.ID,
+		DeploymentID: vmID,
+	}
+
+	event, data := models.EventVMDeployed, map[string]interface{}{"request_id": req.ID, "deployment_id": vmID, "name": req.Input.Name}
+	if codeErr != 0 {
+		event, data["error"] = models.EventVMFailed, fmt.Sprint(resErr)
+	}
+	d.notifier(req.User, notification, event, data)
 }
 
-// ConsumeK8sRequest to consume api requests of k8s deployments
+// ConsumeK8sRequest to consume api requests of k8s deployments, requests are deployed by the scheduler
 func (d *Deployer) ConsumeK8sRequest(ctx context.Context, pending bool) {
-	result, err := d.Redis.Read(streams.ReqK8sStreamName, streams.ReqK8sConsumerGroupName, 0, pending)
+	result, err := d.Redis.Read(streams.ReqK8sStreamName, streams.ReqK8sConsumerGroupName, d.consumer, 0, pending)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			return
@@ -92,66 +107,152 @@ func (d *Deployer) ConsumeK8sRequest(ctx context.Context, pending bool) {
 		return
 	}
 
-	var k8sWG sync.WaitGroup
-
 	for _, s := range result {
-		for _, message := range s.Messages {
-			k8sWG.Add(1)
-			go func(message redis.XMessage) {
-				defer k8sWG.Done()
-
-				var codeErr int
-				var resErr error
-				var req streams.K8sDeployRequest
-
-				for _, v := range message.Values {
-					err = json.Unmarshal([]byte(v.(string)), &req)
-					if err != nil {
-						log.Error().Err(err).Msg("failed to unmarshal k8s request")
-						continue
-					}
-
-					codeErr, resErr = d.deployK8sRequest(ctx, req.User, req.Input, req.AdminSSHKey)
-					if resErr != nil {
-						log.Error().Err(resErr).Msg("failed to deploy k8s request")
-						continue
-					}
-				}
+		d.submitK8sRequests(ctx, s.Messages)
+	}
+}
 
-				if err := d.Redis.DB.XAck(streams.ReqK8sStreamName, streams.ReqK8sConsumerGroupName, message.ID).Err(); err != nil {
-					log.Error().Err(err).Msgf("failed to acknowledge k8s request with ID: %s", message.ID)
-					resErr = err
-					codeErr = http.StatusInternalServerError
-				}
+func (d *Deployer) submitK8sRequests(ctx context.Context, messages []redis.XMessage) {
+	for _, message := range messages {
+		var req streams.K8sDeployRequest
+		var err error
+		for _, v := range message.Values {
+			err = json.Unmarshal([]byte(v.(string)), &req)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to unmarshal k8s request")
+			}
+		}
 
-				msg := fmt.Sprintf("Your kubernetes cluster '%s' failed to be deployed with error: %s", req.Input.MasterName, resErr)
-				if codeErr == 0 {
-					msg = fmt.Sprintf("Your kubernetes cluster '%s' is deployed successfully 🎆", req.Input.MasterName)
-				}
+		if err != nil {
+			_ = d.ackRequest(streams.ReqK8sStreamName, streams.ReqK8sConsumerGroupName, message.ID)
+			continue
+		}
 
-				notification := models.Notification{
-					UserID: req.User.ID.String(),
-					Msg:    msg,
-					Type:   models.K8sType,
-				}
-				err = d.db.CreateNotification(&notification)
-				if err != nil {
-					log.Error().Err(err).Msgf("failed to create notification: %+v", notification)
-				}
+		messageID := message.ID
+		d.scheduler.Submit(req.User.ID.String(), d.userPriority(req.User), func() {
+			d.handleK8sRequest(ctx, messageID, req)
+		})
+	}
+}
 
-			}(message)
+func (d *Deployer) handleK8sRequest(ctx context.Context, messageID string, req streams.K8sDeployRequest) {
+	if err := d.db.StartDeploymentRequest(req.ID); err != nil {
+		log.Error().Err(err).Msgf("failed to start k8s request with ID: %s", req.ID)
+	}
+
+	clusterID, codeErr, resErr := d.deployK8sRequest(ctx, req.User, req.Input, req.AdminSSHKey, req.ID)
+	if ctx.Err() != nil {
+		// the request is not acknowledged to be retried on restart or claimed by the worker leader
+		log.Info().Msgf("k8s request with ID: %s is interrupted", req.ID)
+		return
+	}
+	if resErr != nil {
+		log.Error().Err(resErr).Msg("failed to deploy k8s request")
+	}
+
+	if err := d.ackRequest(streams.ReqK8sStreamName, streams.ReqK8sConsumerGroupName, messageID); err != nil {
+		resErr = err
+		codeErr = http.StatusInternalServerError
+	}
+
+	msg := fmt.Sprintf("Your kubernetes cluster '%s' failed to be deployed with error: %s", req.Input.MasterName, resErr)
+	if codeErr == 0 {
+		msg = fmt.Sprintf("Your kubernetes cluster '%s' is deployed successfully 🎆", req.Input.MasterName)
+	}
+	d.finishRequest(req.ID, codeErr, resErr)
+
+	notification := models.Notification{
+		Msg:          msg,
+		Type:         models.K8sType,
+		RequestID:    req.ID,
+		DeploymentID: clusterID,
+	}
+
+	event, data := models.EventK8sDeployed, map[string]interface{}{"request_id": req.ID, "deployment_id": clusterID, "name": req.Input.MasterName}
+	if codeErr != 0 {
+		event, data["error"] = models.EventK8sFailed, fmt.Sprint(resErr)
+	}
+	d.notifier(req.User, notification, event, data)
+}
+
+// KeepAlive keeps the consumer of the deployer alive until the context is done,
+// the requests it consumed are claimed by the worker leader once it stops
+func (d *Deployer) KeepAlive(ctx context.Context, ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		if err := d.Redis.KeepAlive(d.consumer, ttl); err != nil {
+			log.Error().Err(err).Msgf("failed to keep consumer %s alive", d.consumer)
 		}
-		k8sWG.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PeriodicReclaim claims and deploys the pending requests of the stopped workers
+func (d *Deployer) PeriodicReclaim(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		vms, err := d.Redis.ClaimDead(streams.ReqVMStreamName, streams.ReqVMConsumerGroupName, d.consumer)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to claim vm requests")
+		}
+		d.submitVMRequests(ctx, vms)
+
+		clusters, err := d.Redis.ClaimDead(streams.ReqK8sStreamName, streams.ReqK8sConsumerGroupName, d.consumer)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to claim k8s requests")
+		}
+		d.submitK8sRequests(ctx, clusters)
+
+		if claimed := len(vms) + len(clusters); claimed > 0 {
+			log.Info().Msgf("%d requests of stopped workers are claimed", claimed)
+		}
+	}
+}
+
+func (d *Deployer) ackRequest(stream, group, messageID string) error {
+	err := d.Redis.DB.XAck(stream, group, messageID).Err()
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to acknowledge request with ID: %s", messageID)
+	}
+	return err
+}
+
+// finishRequest updates the request phase with the deployment result
+func (d *Deployer) finishRequest(requestID string, codeErr int, resErr error) {
+	var err error
+	if codeErr == 0 {
+		err = d.db.UpdateDeploymentRequestPhase(models.DeployedPhase, requestID)
+	} else {
+		err = d.db.FailDeploymentRequest(requestID, fmt.Sprint(resErr))
+	}
+
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to update request with ID: %s", requestID)
 	}
 }
 
-func (d *Deployer) consumeVMs() (nets []*workloads.ZNet, vms []*workloads.Deployment, err error) {
-	result, err := d.Redis.Read(streams.DeployVMStreamName, streams.DeployVMConsumerGroupName, 5, false)
+func (d *Deployer) consumeVMs(count int64) (nets []*workloads.ZNet, vms []*workloads.Deployment, requestIDs []string, err error) {
+	result, err := d.Redis.Read(streams.DeployVMStreamName, streams.DeployVMConsumerGroupName, d.consumer, count, false)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			return nets, vms, nil
+			return nets, vms, requestIDs, nil
 		}
-		return nets, vms, errors.Wrap(err, "failed to read vm stream deployment")
+		return nets, vms, requestIDs, errors.Wrap(err, "failed to read vm stream deployment")
 	}
 
 	for _, s := range result {
@@ -168,6 +269,7 @@ func (d *Deployer) consumeVMs() (nets []*workloads.ZNet, vms []*workloads.Deploy
 			if !reflect.DeepEqual(vm, streams.VMDeployment{}) {
 				vms = append(vms, vm.DL)
 				nets = append(nets, vm.Net)
+				requestIDs = append(requestIDs, vm.RequestID)
 			}
 
 			if err = d.Redis.DB.XAck(streams.DeployVMStreamName, streams.DeployVMConsumerGroupName, s.Messages[i].ID).Err(); err != nil {
@@ -179,13 +281,13 @@ func (d *Deployer) consumeVMs() (nets []*workloads.ZNet, vms []*workloads.Deploy
 	return
 }
 
-func (d *Deployer) consumeK8s() (nets []*workloads.ZNet, clusters []*workloads.K8sCluster, err error) {
-	result, err := d.Redis.Read(streams.DeployK8sStreamName, streams.DeployK8sConsumerGroupName, 5, false)
+func (d *Deployer) consumeK8s(count int64) (nets []*workloads.ZNet, clusters []*workloads.K8sCluster, requestIDs []string, err error) {
+	result, err := d.Redis.Read(streams.DeployK8sStreamName, streams.DeployK8sConsumerGroupName, d.consumer, count, false)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			return nets, clusters, nil
+			return nets, clusters, requestIDs, nil
 		}
-		return nets, clusters, errors.Wrap(err, "failed to read clusters stream deployment")
+		return nets, clusters, requestIDs, errors.Wrap(err, "failed to read clusters stream deployment")
 	}
 
 	for _, s := range result {
@@ -202,6 +304,7 @@ func (d *Deployer) consumeK8s() (nets []*workloads.ZNet, clusters []*workloads.K
 			if !reflect.DeepEqual(k8s, streams.K8sDeployment{}) {
 				clusters = append(clusters, k8s.DL)
 				nets = append(nets, k8s.Net)
+				requestIDs = append(requestIDs, k8s.RequestID)
 			}
 
 			if err = d.Redis.DB.XAck(streams.DeployK8sStreamName, streams.DeployK8sConsumerGroupName, s.Messages[i].ID).Err(); err != nil {
@@ -12,6 +12,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/deployer"
+	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/state"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-client/workloads"
 	"github.com/threefoldtech/tfgrid-sdk-go/grid-proxy/pkg/types"
 	"gorm.io/gorm"
@@ -63,11 +64,11 @@ func buildK8sCluster(node uint32, sshKey, network string, k models.K8sDeployInpu
 	return k8sCluster, nil
 }
 
-func (d *Deployer) deployK8sClusterWithNetwork(ctx context.Context, k8sDeployInput models.K8sDeployInput, sshKey string, adminSSHKey string) (uint32, uint64, uint64, error) {
+func (d *Deployer) deployK8sClusterWithNetwork(ctx context.Context, k8sDeployInput models.K8sDeployInput, sshKey string, adminSSHKey string, requestID string) (*state.State, uint32, uint64, uint64, error) {
 	// get available nodes
 	node, err := d.getK8sAvailableNode(ctx, k8sDeployInput)
 	if err != nil {
-		return 0, 0, 0, err
+		return nil, 0, 0, 0, err
 	}
 
 	// build network
@@ -80,39 +81,38 @@ func (d *Deployer) deployK8sClusterWithNetwork(ctx context.Context, k8sDeployInp
 		k8sDeployInput,
 	)
 	if err != nil {
-		return 0, 0, 0, err
+		return nil, 0, 0, 0, err
 	}
 
 	// add network and cluster to be deployed
-	err = d.Redis.PushK8s(streams.K8sDeployment{Net: &network, DL: &cluster})
+	err = d.Redis.PushK8s(streams.K8sDeployment{RequestID: requestID, Net: &network, DL: &cluster})
 	if err != nil {
-		return 0, 0, 0, err
+		return nil, 0, 0, 0, err
 	}
 
 	// wait for deployments
-	for {
-		if <-d.k8sDeployed {
-			break
-		}
+	deployed, err := d.waitDeployed(ctx, requestID)
+	if err != nil {
+		return nil, 0, 0, 0, err
 	}
 
 	// checks that network and k8s are deployed successfully
-	loadedNet, err := d.tfPluginClient.State.LoadNetworkFromGrid(ctx, cluster.NetworkName)
+	loadedNet, err := deployed.LoadNetworkFromGrid(ctx, cluster.NetworkName)
 	if err != nil {
-		return 0, 0, 0, errors.Wrapf(err, "failed to load network '%s' on nodes %v", cluster.NetworkName, network.Nodes)
+		return nil, 0, 0, 0, errors.Wrapf(err, "failed to load network '%s' on nodes %v", cluster.NetworkName, network.Nodes)
 	}
 
-	loadedCluster, err := d.tfPluginClient.State.LoadK8sFromGrid(ctx, []uint32{node}, cluster.Master.Name)
+	loadedCluster, err := deployed.LoadK8sFromGrid(ctx, []uint32{node}, cluster.Master.Name)
 	if err != nil {
-		return 0, 0, 0, errors.Wrapf(err, "failed to load kubernetes cluster '%s' on nodes %v", cluster.Master.Name, network.Nodes)
+		return nil, 0, 0, 0, errors.Wrapf(err, "failed to load kubernetes cluster '%s' on nodes %v", cluster.Master.Name, network.Nodes)
 	}
 
-	return node, loadedNet.NodeDeploymentID[node], loadedCluster.NodeDeploymentID[node], nil
+	return deployed, node, loadedNet.NodeDeploymentID[node], loadedCluster.NodeDeploymentID[node], nil
 }
 
-func (d *Deployer) loadK8s(ctx context.Context, k8sDeployInput models.K8sDeployInput, userID string, node uint32, networkContractID uint64, k8sContractID uint64) (models.K8sCluster, error) {
+func (d *Deployer) loadK8s(ctx context.Context, deployed *state.State, k8sDeployInput models.K8sDeployInput, userID string, node uint32, networkContractID uint64, k8sContractID uint64) (models.K8sCluster, error) {
 	// load cluster
-	resCluster, err := d.tfPluginClient.State.LoadK8sFromGrid(ctx, []uint32{node}, k8sDeployInput.MasterName)
+	resCluster, err := deployed.LoadK8sFromGrid(ctx, []uint32{node}, k8sDeployInput.MasterName)
 	if err != nil {
 		return models.K8sCluster{}, err
 	}
@@ -222,35 +222,36 @@ func ValidateK8sQuota(k models.K8sDeployInput, availableResourcesQuota, availabl
 	return neededQuota, nil
 }
 
-func (d *Deployer) deployK8sRequest(ctx context.Context, user models.User, k8sDeployInput models.K8sDeployInput, adminSSHKey string) (int, error) {
+// deployK8sRequest deploys the cluster of a request and returns the id of the created cluster
+func (d *Deployer) deployK8sRequest(ctx context.Context, user models.User, k8sDeployInput models.K8sDeployInput, adminSSHKey string, requestID string) (int, int, error) {
 	// quota verification
 	quota, err := d.db.GetUserQuota(user.ID.String())
 	if err == gorm.ErrRecordNotFound {
 		log.Error().Err(err).Send()
-		return http.StatusNotFound, errors.New("user quota is not found")
+		return 0, http.StatusNotFound, errors.New("user quota is not found")
 	}
 	if err != nil {
 		log.Error().Err(err).Send()
-		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
+		return 0, http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 
 	neededQuota, err := ValidateK8sQuota(k8sDeployInput, quota.Vms, quota.PublicIPs)
 	if err != nil {
 		log.Error().Err(err).Send()
-		return http.StatusBadRequest, err
+		return 0, http.StatusBadRequest, err
 	}
 
 	// deploy network and cluster
-	node, networkContractID, k8sContractID, err := d.deployK8sClusterWithNetwork(ctx, k8sDeployInput, user.SSHKey, adminSSHKey)
+	deployed, node, networkContractID, k8sContractID, err := d.deployK8sClusterWithNetwork(ctx, k8sDeployInput, user.SSHKey, adminSSHKey, requestID)
 	if err != nil {
 		log.Error().Err(err).Send()
-		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
+		return 0, http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 
-	k8sCluster, err := d.loadK8s(ctx, k8sDeployInput, user.ID.String(), node, networkContractID, k8sContractID)
+	k8sCluster, err := d.loadK8s(ctx, deployed, k8sDeployInput, user.ID.String(), node, networkContractID, k8sContractID)
 	if err != nil {
 		log.Error().Err(err).Send()
-		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
+		return 0, http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 	publicIPsQuota := quota.PublicIPs
 	if k8sDeployInput.Public {
@@ -259,17 +260,17 @@ func (d *Deployer) deployK8sRequest(ctx context.Context, user models.User, k8sDe
 	// update quota
 	err = d.db.UpdateUserQuota(user.ID.String(), quota.Vms-neededQuota, publicIPsQuota)
 	if err == gorm.ErrRecordNotFound {
-		return http.StatusNotFound, errors.New("user quota is not found")
+		return 0, http.StatusNotFound, errors.New("user quota is not found")
 	}
 	if err != nil {
 		log.Error().Err(err).Send()
-		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
+		return 0, http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 
 	err = d.db.CreateK8s(&k8sCluster)
 	if err != nil {
 		log.Error().Err(err).Send()
-		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
+		return 0, http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 
 	// metrics
@@ -278,7 +279,7 @@ func (d *Deployer) deployK8sRequest(ctx context.Context, user models.User, k8sDe
 		middlewares.Deployments.WithLabelValues(user.ID.String(), worker.Resources, "worker").Inc()
 	}
 
-	return 0, nil
+	return k8sCluster.ID, 0, nil
 }
 
 func convertGBToBytes(gb uint64) *uint64 {
@@ -0,0 +1,232 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	// StudentPriority is the priority class of normal users
+	StudentPriority = iota
+	// InstructorPriority is the priority class of instructors
+	InstructorPriority
+	// AdminPriority is the priority class of admins
+	AdminPriority
+)
+
+// job is a deployment request waiting to be deployed
+type job struct {
+	userID string
+	run    func()
+}
+
+// priorityClass holds the queued jobs of users with the same priority
+type priorityClass struct {
+	// users with queued jobs in round robin order
+	users  []string
+	queues map[string][]job
+	// index of the user to be served next
+	next int
+}
+
+// Scheduler runs deployment requests with a limited concurrency per user,
+// higher priority classes are served first and users of the same class are served in round robin
+type Scheduler struct {
+	mu sync.Mutex
+
+	maxInFlight        int
+	maxInFlightPerUser int
+
+	inFlight     int
+	userInFlight map[string]int
+	classes      map[int]*priorityClass
+}
+
+// NewScheduler creates a new deployments scheduler
+func NewScheduler(maxInFlight, maxInFlightPerUser int) *Scheduler {
+	return &Scheduler{
+		maxInFlight:        maxInFlight,
+		maxInFlightPerUser: maxInFlightPerUser,
+		userInFlight:       map[string]int{},
+		classes:            map[int]*priorityClass{},
+	}
+}
+
+// Submit queues a deployment request of a user to be run when it is its turn
+func (s *Scheduler) Submit(userID string, priority int, run func()) {
+	s.mu.Lock()
+	s.push(userID, priority, run)
+	s.mu.Unlock()
+
+	s.dispatch()
+}
+
+// Queued returns the number of requests waiting to be run
+func (s *Scheduler) Queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queued := 0
+	for _, class := range s.classes {
+		for _, queue := range class.queues {
+			queued += len(queue)
+		}
+	}
+	return queued
+}
+
+func (s *Scheduler) dispatch() {
+	for {
+		s.mu.Lock()
+		j, ok := s.pop()
+		s.mu.Unlock()
+
+		if !ok {
+			return
+		}
+
+		go func() {
+			defer func() {
+				s.mu.Lock()
+				s.done(j.userID)
+				s.mu.Unlock()
+
+				s.dispatch()
+			}()
+
+			j.run()
+		}()
+	}
+}
+
+func (s *Scheduler) push(userID string, priority int, run func()) {
+	class, ok := s.classes[priority]
+	if !ok {
+		class = &priorityClass{queues: map[string][]job{}}
+		s.classes[priority] = class
+	}
+
+	if len(class.queues[userID]) == 0 {
+		class.users = append(class.users, userID)
+	}
+	class.queues[userID] = append(class.queues[userID], job{userID: userID, run: run})
+}
+
+// pop returns the next job to run and marks it as in flight
+func (s *Scheduler) pop() (job, bool) {
+	if s.inFlight >= s.maxInFlight {
+		return job{}, false
+	}
+
+	priorities := make([]int, 0, len(s.classes))
+	for p := range s.classes {
+		priorities = append(priorities, p)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(priorities)))
+
+	for _, p := range priorities {
+		class := s.classes[p]
+
+		for i := 0; i < len(class.users); i++ {
+			idx := (class.next + i) % len(class.users)
+			userID := class.users[idx]
+
+			if s.userInFlight[userID] >= s.maxInFlightPerUser {
+				continue
+			}
+
+			j := class.queues[userID][0]
+			class.queues[userID] = class.queues[userID][1:]
+
+			if len(class.queues[userID]) == 0 {
+				delete(class.queues, userID)
+				class.users = append(class.users[:idx], class.users[idx+1:]...)
+				class.next = idx
+			} else {
+				class.next = idx + 1
+			}
+
+			if len(class.users) == 0 {
+				delete(s.classes, p)
+			} else {
+				class.next %= len(class.users)
+			}
+
+			s.inFlight++
+			s.userInFlight[userID]++
+			return j, true
+		}
+	}
+
+	return job{}, false
+}
+
+// done marks a job of the user as finished
+func (s *Scheduler) done(userID string) {
+	s.inFlight--
+	s.userInFlight[userID]--
+	if s.userInFlight[userID] <= 0 {
+		delete(s.userInFlight, userID)
+	}
+}
+
+// queuedRequest is a request that is not deployed yet
+type queuedRequest struct {
+	id       string
+	userID   string
+	priority int
+	// started requests are already run by a scheduler
+	started bool
+}
+
+// queuePosition returns the number of requests deployed before the request with the id, the requests are given
+// in the order they are submitted: the started ones come first then the queued ones in the order of the scheduler
+func queuePosition(requests []queuedRequest, id string) (int64, bool) {
+	var target queuedRequest
+	found := false
+	for _, r := range requests {
+		if r.id == id {
+			target, found = r, true
+			break
+		}
+	}
+	if !found {
+		return 0, false
+	}
+	if target.started {
+		return 0, true
+	}
+
+	s := NewScheduler(len(requests), len(requests))
+	var ahead int64
+	// the request is the turn-th queued request of its user
+	turn, reached := 0, false
+	for _, r := range requests {
+		if r.started {
+			ahead++
+			continue
+		}
+
+		reached = reached || r.id == id
+		if !reached && r.userID == target.userID {
+			turn++
+		}
+		s.push(r.userID, r.priority, nil)
+	}
+
+	for {
+		j, ok := s.pop()
+		if !ok {
+			return ahead, true
+		}
+
+		if j.userID == target.userID {
+			if turn == 0 {
+				return ahead, true
+			}
+			turn--
+		}
+		ahead++
+	}
+}
@@ -0,0 +1,132 @@
+// Package deployer for handling deployments
+package deployer
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func popUsers(s *Scheduler) []string {
+	var users []string
+	for {
+		j, ok := s.pop()
+		if !ok {
+			return users
+		}
+		users = append(users, j.userID)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	t.Run("round robin between users", func(t *testing.T) {
+		s := NewScheduler(10, 10)
+		for i := 0; i < 3; i++ {
+			s.push("flooder", StudentPriority, nil)
+		}
+		s.push("student", StudentPriority, nil)
+
+		assert.Equal(t, []string{"flooder", "student", "flooder", "flooder"}, popUsers(s))
+	})
+
+	t.Run("max in flight per user", func(t *testing.T) {
+		s := NewScheduler(10, 1)
+		for i := 0; i < 3; i++ {
+			s.push("flooder", StudentPriority, nil)
+		}
+		s.push("student", StudentPriority, nil)
+
+		assert.Equal(t, []string{"flooder", "student"}, popUsers(s))
+
+		s.done("flooder")
+		assert.Equal(t, []string{"flooder"}, popUsers(s))
+		assert.Equal(t, 1, s.Queued())
+	})
+
+	t.Run("max in flight", func(t *testing.T) {
+		s := NewScheduler(2, 2)
+		s.push("first", StudentPriority, nil)
+		s.push("second", StudentPriority, nil)
+		s.push("third", StudentPriority, nil)
+
+		assert.Equal(t, []string{"first", "second"}, popUsers(s))
+
+		s.done("first")
+		assert.Equal(t, []string{"third"}, popUsers(s))
+	})
+
+	t.Run("higher priority first", func(t *testing.T) {
+		s := NewScheduler(10, 10)
+		s.push("student", StudentPriority, nil)
+		s.push("instructor", InstructorPriority, nil)
+		s.push("admin", AdminPriority, nil)
+
+		assert.Equal(t, []string{"admin", "instructor", "student"}, popUsers(s))
+	})
+
+	t.Run("submitted jobs run", func(t *testing.T) {
+		s := NewScheduler(2, 1)
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		ran := 0
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			s.Submit("user", StudentPriority, func() {
+				defer wg.Done()
+				mu.Lock()
+				ran++
+				mu.Unlock()
+			})
+		}
+		wg.Wait()
+
+		assert.Equal(t, 5, ran)
+	})
+}
+
+func TestQueuePosition(t *testing.T) {
+	// flooder queued three requests before student queued two
+	requests := []queuedRequest{
+		{id: "f1", userID: "flooder"},
+		{id: "f2", userID: "flooder"},
+		{id: "s1", userID: "student"},
+		{id: "f3", userID: "flooder"},
+		{id: "s2", userID: "student"},
+	}
+
+	t.Run("interleaved users", func(t *testing.T) {
+		// the scheduler runs f1, s1, f2, s2, f3
+		for id, expected := range map[string]int64{"f1": 0, "s1": 1, "f2": 2, "s2": 3, "f3": 4} {
+			position, found := queuePosition(requests, id)
+			assert.True(t, found)
+			assert.Equal(t, expected, position, id)
+		}
+	})
+
+	t.Run("started requests are ahead", func(t *testing.T) {
+		started := append([]queuedRequest{{id: "s0", userID: "student", started: true}}, requests...)
+
+		position, _ := queuePosition(started, "s1")
+		assert.Equal(t, int64(2), position)
+
+		position, _ = queuePosition(started, "s0")
+		assert.Zero(t, position)
+	})
+
+	t.Run("higher priority first", func(t *testing.T) {
+		instructor := append(requests, queuedRequest{id: "i1", userID: "instructor", priority: InstructorPriority})
+
+		position, _ := queuePosition(instructor, "i1")
+		assert.Zero(t, position)
+
+		position, _ = queuePosition(instructor, "s1")
+		assert.Equal(t, int64(2), position)
+	})
+
+	t.Run("not queued", func(t *testing.T) {
+		_, found := queuePosition(requests, "deployed")
+		assert.False(t, found)
+	})
+}
@@ -17,7 +17,7 @@ import (
 	"gorm.io/gorm"
 )
 
-func (d *Deployer) deployVM(ctx context.Context, vmInput models.DeployVMInput, sshKey string, adminSSHKey string) (*workloads.VM, uint64, uint64, uint64, error) {
+func (d *Deployer) deployVM(ctx context.Context, vmInput models.DeployVMInput, sshKey string, adminSSHKey string, requestID string) (*workloads.VM, uint64, uint64, uint64, error) {
 	// filter nodes
 	cru, mru, sru, ips, err := calcNodeResources(vmInput.Resources, vmInput.Public)
 	if err != nil {
@@ -73,25 +73,24 @@ func (d *Deployer) deployVM(ctx context.Context, vmInput models.DeployVMInput, s
 	dl.SolutionType = vmInput.Name
 
 	// add network and deployment to be deployed
-	err = d.Redis.PushVM(streams.VMDeployment{Net: &network, DL: &dl})
+	err = d.Redis.PushVM(streams.VMDeployment{RequestID: requestID, Net: &network, DL: &dl})
 	if err != nil {
 		return nil, 0, 0, 0, err
 	}
 
 	// wait for deployments
-	for {
-		if <-d.vmDeployed {
-			break
-		}
+	deployed, err := d.waitDeployed(ctx, requestID)
+	if err != nil {
+		return nil, 0, 0, 0, err
 	}
 
 	// checks that network and vm are deployed successfully
-	loadedNet, err := d.tfPluginClient.State.LoadNetworkFromGrid(ctx, dl.NetworkName)
+	loadedNet, err := deployed.LoadNetworkFromGrid(ctx, dl.NetworkName)
 	if err != nil {
 		return nil, 0, 0, 0, errors.Wrapf(err, "failed to load network '%s' on node %v", dl.NetworkName, dl.NodeID)
 	}
 
-	loadedDl, err := d.tfPluginClient.State.LoadDeploymentFromGrid(ctx, nodeID, dl.Name)
+	loadedDl, err := deployed.LoadDeploymentFromGrid(ctx, nodeID, dl.Name)
 	if err != nil {
 		return nil, 0, 0, 0, errors.Wrapf(err, "failed to load vm '%s' on node %v", dl.Name, dl.NodeID)
 	}
@@ -116,26 +115,27 @@ func ValidateVMQuota(vm models.DeployVMInput, availableResourcesQuota, available
 	return neededQuota, nil
 }
 
-func (d *Deployer) deployVMRequest(ctx context.Context, user models.User, input models.DeployVMInput, adminSSHKey string) (int, error) {
+// deployVMRequest deploys the vm of a request and returns the id of the created vm
+func (d *Deployer) deployVMRequest(ctx context.Context, user models.User, input models.DeployVMInput, adminSSHKey string, requestID string) (int, int, error) {
 	// check quota of user
 	quota, err := d.db.GetUserQuota(user.ID.String())
 	if err == gorm.ErrRecordNotFound {
-		return http.StatusNotFound, errors.New("user quota is not found")
+		return 0, http.StatusNotFound, errors.New("user quota is not found")
 	}
 	if err != nil {
 		log.Error().Err(err).Send()
-		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
+		return 0, http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 
 	neededQuota, err := ValidateVMQuota(input, quota.Vms, quota.PublicIPs)
 	if err != nil {
-		return http.StatusBadRequest, err
+		return 0, http.StatusBadRequest, err
 	}
 
-	vm, contractID, networkContractID, diskSize, err := d.deployVM(ctx, input, user.SSHKey, adminSSHKey)
+	vm, contractID, networkContractID, diskSize, err := d.deployVM(ctx, input, user.SSHKey, adminSSHKey, requestID)
 	if err != nil {
 		log.Error().Err(err).Send()
-		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
+		return 0, http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 
 	userVM := models.VM{
@@ -155,7 +155,7 @@ func (d *Deployer) deployVMRequest(ctx context.Context, user models.User, input
 	err = d.db.CreateVM(&userVM)
 	if err != nil {
 		log.Error().Err(err).Send()
-		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
+		return 0, http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 
 	publicIPsQuota := quota.PublicIPs
@@ -165,13 +165,13 @@ func (d *Deployer) deployVMRequest(ctx context.Context, user models.User, input
 	// update quota of user
 	err = d.db.UpdateUserQuota(user.ID.String(), quota.Vms-neededQuota, publicIPsQuota)
 	if err == gorm.ErrRecordNotFound {
-		return http.StatusNotFound, errors.New("User quota is not found")
+		return 0, http.StatusNotFound, errors.New("User quota is not found")
 	}
 	if err != nil {
 		log.Error().Err(err).Send()
-		return http.StatusInternalServerError, errors.New(internalServerErrorMsg)
+		return 0, http.StatusInternalServerError, errors.New(internalServerErrorMsg)
 	}
 
 	middlewares.Deployments.WithLabelValues(user.ID.String(), input.Resources, "vm").Inc()
-	return 0, nil
+	return userVM.ID, 0, nil
 }
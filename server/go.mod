@@ -18,6 +18,7 @@ require (
 	github.com/threefoldtech/tfgrid-sdk-go/grid-proxy v0.14.13
 	github.com/threefoldtech/zos v0.5.6-0.20240226114056-364e04acbed3
 	golang.org/x/crypto v0.21.0
+	golang.org/x/net v0.21.0
 	golang.org/x/text v0.14.0
 	gopkg.in/validator.v2 v2.0.1
 	gorm.io/driver/sqlite v1.5.5
@@ -69,7 +70,6 @@ require (
 	github.com/threefoldtech/tfgrid-sdk-go/rmb-sdk-go v0.14.12 // indirect
 	github.com/vedhavyas/go-subkey v1.0.3 // indirect
 	golang.org/x/exp v0.0.0-20231006140011-7918f672742d // indirect
-	golang.org/x/net v0.21.0 // indirect
 	golang.org/x/sync v0.6.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 	golang.zx2c4.com/wireguard/wgctrl v0.0.0-20200609130330-bd2cb7843e1b // indirect
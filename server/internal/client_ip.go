@@ -0,0 +1,100 @@
+// Package internal for internal details
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// TrustedProxies are the networks of the proxies the server is served behind
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses the ips and cidrs of the trusted proxies
+func ParseTrustedProxies(proxies []string) (TrustedProxies, error) {
+	var networks TrustedProxies
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q is not a valid ip or cidr", proxy)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not a valid ip or cidr", proxy)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func (p TrustedProxies) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range p {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveClientIP returns the ip of the client of a request, the forwarded headers are only honored
+// if the request comes from a trusted proxy, so clients can't choose the ip they are seen with
+func (p TrustedProxies) ResolveClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+
+	if !p.trusted(remote) {
+		return remote
+	}
+
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+
+	// the client is the last address not added by a trusted proxy
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip != "" && !p.trusted(ip) {
+			return ip
+		}
+	}
+
+	return remote
+}
+
+// WithClientIP returns the request with the resolved ip of its client
+func WithClientIP(r *http.Request, ip string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+}
+
+// ClientIP returns the resolved ip of the client of a request,
+// it is the remote address of the request if the ip is not resolved
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+
+	return TrustedProxies(nil).ResolveClientIP(r)
+}
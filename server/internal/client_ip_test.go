@@ -0,0 +1,62 @@
+// Package internal for internal details
+package internal
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.1", "172.16.0.0/12"})
+	assert.NoError(t, err)
+
+	t.Run("remote address", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:4321"
+		assert.Equal(t, "10.0.0.1", proxies.ResolveClientIP(req))
+		assert.Equal(t, "10.0.0.1", ClientIP(req))
+	})
+
+	t.Run("real ip header of a trusted proxy", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:4321"
+		req.Header.Set("X-Real-IP", "203.0.113.7")
+		assert.Equal(t, "203.0.113.7", proxies.ResolveClientIP(req))
+	})
+
+	t.Run("spoofed headers of an untrusted peer are ignored", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "198.51.100.3:4321"
+		req.Header.Set("X-Real-IP", "203.0.113.7")
+		req.Header.Set("X-Forwarded-For", "203.0.113.8")
+		assert.Equal(t, "198.51.100.3", proxies.ResolveClientIP(req))
+
+		// no proxy is trusted by default
+		req.RemoteAddr = "10.0.0.1:4321"
+		assert.Equal(t, "10.0.0.1", ClientIP(req))
+	})
+
+	t.Run("forwarded for header of trusted proxies", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "172.16.0.2:4321"
+		// the first address is set by the client
+		req.Header.Set("X-Forwarded-For", "192.0.2.1, 203.0.113.7, 10.0.0.1")
+		assert.Equal(t, "203.0.113.7", proxies.ResolveClientIP(req))
+	})
+
+	t.Run("resolved ip", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:4321"
+		assert.Equal(t, "203.0.113.7", ClientIP(WithClientIP(req, "203.0.113.7")))
+	})
+
+	t.Run("invalid proxies", func(t *testing.T) {
+		_, err := ParseTrustedProxies([]string{"proxy"})
+		assert.Error(t, err)
+
+		_, err = ParseTrustedProxies([]string{"10.0.0.0/40"})
+		assert.Error(t, err)
+	})
+}
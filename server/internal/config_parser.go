@@ -3,6 +3,7 @@ package internal
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 
@@ -16,11 +17,18 @@ type Configuration struct {
 	Database                  DB          `json:"database"`
 	Token                     JwtToken    `json:"token"`
 	Account                   GridAccount `json:"account"`
+	Deployment                Deployment  `json:"deployment"`
+	Webhooks                  Webhooks    `json:"webhooks"`
+	TwoFactor                 TwoFactor   `json:"twoFactor"`
+	OIDC                      OIDC        `json:"oidc"`
+	RateLimits                RateLimits  `json:"rateLimits"`
 	Version                   string      `json:"version" validate:"nonzero"`
 	Admins                    []string    `json:"admins"`
 	NotifyAdminsIntervalHours int         `json:"notifyAdminsIntervalHours"`
 	AdminSSHKey               string      `json:"adminSSHKey"`
 	BalanceThreshold          int         `json:"balanceThreshold"`
+	IdempotencyKeyTTLHours    int         `json:"idempotencyKeyTTLHours" validate:"min=1"`
+	LeaderTTLSeconds          int         `json:"leaderTTLSeconds" validate:"min=3"`
 }
 
 // Server struct to hold server's information
@@ -31,13 +39,39 @@ type Server struct {
 	RedisHost string `json:"redisHost" validate:"nonzero"`
 	RedisPort string `json:"redisPort" validate:"nonzero"`
 	RedisPass string `json:"redisPass"`
+
+	// ips or cidrs of the proxies the server is served behind, the client ip is read from their headers
+	TrustedProxies []string `json:"trustedProxies"`
 }
 
 // MailSender struct to hold sender's email, password
 type MailSender struct {
-	Email       string `json:"email" validate:"nonzero"`
-	SendGridKey string `json:"sendgrid_key" validate:"nonzero"`
-	Timeout     int    `json:"timeout" validate:"min=30"`
+	Email string `json:"email" validate:"nonzero"`
+	// sendgrid, smtp or file
+	Backend     string `json:"backend"`
+	SendGridKey string `json:"sendgrid_key"`
+	SMTP        SMTP   `json:"smtp"`
+	// directory of mails written by the file backend
+	Dir string `json:"dir"`
+	// verification codes and links expire after timeout seconds
+	Timeout int `json:"timeout" validate:"min=30"`
+	// a new verification code can't be requested before resendCooldownSeconds
+	ResendCooldownSeconds int `json:"resendCooldownSeconds" validate:"min=1"`
+	// the old email of a user can revert changing it for emailRevertHours
+	EmailRevertHours int `json:"emailRevertHours" validate:"min=1"`
+	// failed mails are retried with exponential backoff starting from retryBaseSeconds
+	MaxAttempts      int `json:"maxAttempts" validate:"min=1"`
+	RetryBaseSeconds int `json:"retryBaseSeconds" validate:"min=1"`
+	// sent and failed mails are deleted after retentionDays
+	RetentionDays int `json:"retentionDays" validate:"min=1"`
+}
+
+// SMTP struct to hold smtp server's information
+type SMTP struct {
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 // DB struct to hold database file
@@ -47,8 +81,11 @@ type DB struct {
 
 // JwtToken struct to hold JWT information
 type JwtToken struct {
-	Secret  string `json:"secret" validate:"nonzero"`
-	Timeout int    `json:"timeout" validate:"min=5"`
+	Secret string `json:"secret" validate:"nonzero"`
+	// lifetime of access tokens in minutes
+	Timeout int `json:"timeout" validate:"min=5"`
+	// sessions expire if their refresh token isn't used within this time
+	RefreshTimeoutHours int `json:"refreshTimeoutHours" validate:"min=1"`
 }
 
 // GridAccount struct to hold grid account mnemonics
@@ -57,9 +94,154 @@ type GridAccount struct {
 	Network   string `json:"network" validate:"nonzero"`
 }
 
+// Deployment struct to hold deployments scheduling configuration
+type Deployment struct {
+	// max number of requests deployed at the same time
+	MaxInFlight int `json:"maxInFlight" validate:"min=1"`
+	// max number of requests of a single user deployed at the same time
+	MaxInFlightPerUser int `json:"maxInFlightPerUser" validate:"min=1"`
+	// emails of users whose requests are deployed before students requests
+	Instructors []string `json:"instructors"`
+	// initial number of deployments done in a single batch
+	BatchSize int `json:"batchSize" validate:"min=1"`
+	// the batch size is adapted between min and max batch size
+	MinBatchSize int `json:"minBatchSize" validate:"min=1"`
+	MaxBatchSize int `json:"maxBatchSize" validate:"min=1"`
+	// interval of checking for new deployments
+	BatchIntervalSeconds int `json:"batchIntervalSeconds" validate:"min=1"`
+	// max time a deployment waits for its batch to be full
+	MaxWaitSeconds int `json:"maxWaitSeconds" validate:"min=1"`
+	// the batch size is shrunk if a batch takes longer than the target
+	TargetBatchSeconds int `json:"targetBatchSeconds" validate:"min=1"`
+}
+
+// Webhooks struct to hold webhook deliveries configuration
+type Webhooks struct {
+	TimeoutSeconds int `json:"timeoutSeconds" validate:"min=1"`
+	// failed deliveries are retried with exponential backoff starting from retryBaseSeconds
+	MaxAttempts      int `json:"maxAttempts" validate:"min=1"`
+	RetryBaseSeconds int `json:"retryBaseSeconds" validate:"min=1"`
+	// allow webhook endpoints in private networks, e.g. for testing
+	AllowPrivateNetworks bool `json:"allowPrivateNetworks"`
+}
+
+// TwoFactor struct to hold two-factor authentication configuration
+type TwoFactor struct {
+	// name of the service shown in authenticator apps
+	Issuer string `json:"issuer" validate:"nonzero"`
+	// admins can't use admin endpoints before enabling two-factor authentication
+	RequireForAdmins bool `json:"requireForAdmins"`
+}
+
+// OIDC struct to hold the OpenID Connect providers users can sign in with
+type OIDC struct {
+	// the page of the frontend the providers redirect users to after they sign in,
+	// it sends the code and state it gets to the callback endpoint
+	RedirectURL string         `json:"redirectURL"`
+	Providers   []OIDCProvider `json:"providers"`
+	// users without a password and 2FA must have signed in with their provider within these minutes to change their email
+	ReauthMinutes int `json:"reauthMinutes" validate:"min=1"`
+}
+
+// OIDCProvider struct to hold an OpenID Connect provider's information
+type OIDCProvider struct {
+	// name of the provider in the login urls
+	Name         string `json:"name" validate:"nonzero"`
+	Issuer       string `json:"issuer" validate:"nonzero"`
+	ClientID     string `json:"clientID" validate:"nonzero"`
+	ClientSecret string `json:"clientSecret"`
+	// only users with emails of these domains can sign in, all domains are allowed if empty
+	AllowedDomains []string `json:"allowedDomains"`
+	// the claim with the college of new users, the college falls back to college if the claim is missing
+	CollegeClaim string `json:"collegeClaim"`
+	College      string `json:"college"`
+}
+
+// names of the rate limited authentication routes
+const (
+	SignUpRoute               = "signup"
+	VerifySignUpRoute         = "verify_signup"
+	SignInRoute               = "signin"
+	SignInTwoFactorRoute      = "signin_2fa"
+	RefreshTokenRoute         = "refresh_token"
+	ForgotPasswordRoute       = "forgot_password"
+	VerifyForgotPasswordRoute = "verify_forgot_password"
+	ResendVerificationRoute   = "resend_verification"
+	VerifyLinkRoute           = "verify_link"
+)
+
+// RateLimits struct to hold the limits of requests to the authentication routes
+type RateLimits struct {
+	// limits of the routes by their names, routes without limits are not limited
+	Routes map[string]RateLimit `json:"routes"`
+	// an email is locked out of signing in or verifying codes after maxFailedAttempts wrong passwords or codes
+	MaxFailedAttempts int `json:"maxFailedAttempts" validate:"min=1"`
+	LockoutMinutes    int `json:"lockoutMinutes" validate:"min=1"`
+}
+
+// RateLimit struct to hold the number of requests allowed per client ip and per email in a window,
+// zero requests aren't limited
+type RateLimit struct {
+	IPRequests    int `json:"ipRequests" validate:"min=0"`
+	EmailRequests int `json:"emailRequests" validate:"min=0"`
+	WindowSeconds int `json:"windowSeconds" validate:"min=1"`
+}
+
 // ReadConfFile read configurations of json file
 func ReadConfFile(path string) (Configuration, error) {
-	config := Configuration{NotifyAdminsIntervalHours: 6, BalanceThreshold: 2000}
+	config := Configuration{
+		NotifyAdminsIntervalHours: 6,
+		BalanceThreshold:          2000,
+		IdempotencyKeyTTLHours:    24,
+		LeaderTTLSeconds:          15,
+		Token: JwtToken{
+			RefreshTimeoutHours: 7 * 24,
+		},
+		MailSender: MailSender{
+			Backend:               SendGridBackend,
+			ResendCooldownSeconds: 60,
+			EmailRevertHours:      72,
+			MaxAttempts:           8,
+			RetryBaseSeconds:      30,
+			RetentionDays:         30,
+		},
+		Deployment: Deployment{
+			MaxInFlight:          10,
+			MaxInFlightPerUser:   2,
+			BatchSize:            5,
+			MinBatchSize:         1,
+			MaxBatchSize:         10,
+			BatchIntervalSeconds: 6,
+			MaxWaitSeconds:       12,
+			TargetBatchSeconds:   120,
+		},
+		Webhooks: Webhooks{
+			TimeoutSeconds:   10,
+			MaxAttempts:      8,
+			RetryBaseSeconds: 30,
+		},
+		TwoFactor: TwoFactor{
+			Issuer: "Cloud4Students",
+		},
+		OIDC: OIDC{
+			ReauthMinutes: 10,
+		},
+		RateLimits: RateLimits{
+			Routes: map[string]RateLimit{
+				SignUpRoute:               {IPRequests: 10, EmailRequests: 3, WindowSeconds: 3600},
+				VerifySignUpRoute:         {IPRequests: 30, EmailRequests: 10, WindowSeconds: 900},
+				SignInRoute:               {IPRequests: 30, EmailRequests: 10, WindowSeconds: 900},
+				SignInTwoFactorRoute:      {IPRequests: 30, WindowSeconds: 900},
+				RefreshTokenRoute:         {IPRequests: 60, WindowSeconds: 60},
+				ForgotPasswordRoute:       {IPRequests: 10, EmailRequests: 3, WindowSeconds: 3600},
+				VerifyForgotPasswordRoute: {IPRequests: 30, EmailRequests: 10, WindowSeconds: 900},
+				ResendVerificationRoute:   {IPRequests: 10, EmailRequests: 5, WindowSeconds: 3600},
+				VerifyLinkRoute:           {IPRequests: 30, WindowSeconds: 900},
+			},
+			MaxFailedAttempts: 5,
+			LockoutMinutes:    15,
+		},
+	}
 	file, err := os.Open(path)
 	if err != nil {
 		return Configuration{}, fmt.Errorf("failed to open config file: %w", err)
@@ -70,5 +252,58 @@ func ReadConfFile(path string) (Configuration, error) {
 		return Configuration{}, fmt.Errorf("failed to load config: %w", err)
 	}
 
-	return config, validator.Validate(config)
+	if err := validator.Validate(config); err != nil {
+		return config, err
+	}
+
+	if err := validateOIDC(config.OIDC); err != nil {
+		return config, err
+	}
+
+	if _, err := ParseTrustedProxies(config.Server.TrustedProxies); err != nil {
+		return config, err
+	}
+
+	return config, validateMailSender(config.MailSender)
+}
+
+func validateOIDC(config OIDC) error {
+	if len(config.Providers) == 0 {
+		return nil
+	}
+
+	if config.RedirectURL == "" {
+		return errors.New("redirectURL is required for oidc providers")
+	}
+
+	names := map[string]bool{}
+	for _, provider := range config.Providers {
+		if names[provider.Name] {
+			return fmt.Errorf("oidc provider %q is duplicated", provider.Name)
+		}
+		names[provider.Name] = true
+	}
+
+	return nil
+}
+
+func validateMailSender(config MailSender) error {
+	switch config.Backend {
+	case SendGridBackend:
+		if config.SendGridKey == "" {
+			return errors.New("sendgrid_key is required for sendgrid mail backend")
+		}
+	case SMTPBackend:
+		if config.SMTP.Host == "" || config.SMTP.Port == "" {
+			return errors.New("smtp host and port are required for smtp mail backend")
+		}
+	case FileBackend:
+		if config.Dir == "" {
+			return errors.New("dir is required for file mail backend")
+		}
+	default:
+		return fmt.Errorf("mail backend %q is not supported", config.Backend)
+	}
+
+	return nil
 }
@@ -118,12 +118,16 @@ func TestParseConf(t *testing.T) {
 				Mnemonics: "my mnemonics",
 			},
 			Token: JwtToken{
-				Secret:  "secret",
-				Timeout: 10,
+				Secret:              "secret",
+				Timeout:             10,
+				RefreshTimeoutHours: 7 * 24,
 			},
 			Database: DB{
 				File: "testing.db",
 			},
+			TwoFactor: TwoFactor{
+				Issuer: "Cloud4Students",
+			},
 			Version: "v1",
 		}
 
@@ -133,6 +137,7 @@ func TestParseConf(t *testing.T) {
 		assert.Equal(t, got.Account.Mnemonics, expected.Account.Mnemonics)
 		assert.Equal(t, got.Token, expected.Token)
 		assert.Equal(t, got.Database, expected.Database)
+		assert.Equal(t, got.TwoFactor, expected.TwoFactor)
 		assert.Equal(t, got.Version, expected.Version)
 	})
 
@@ -369,4 +374,88 @@ func TestParseConf(t *testing.T) {
 		assert.Error(t, err, "salt is required")
 
 	})
+
+	t.Run("oidc providers without redirect url", func(t *testing.T) {
+		config :=
+			`
+{
+	"server": {
+		"host": "localhost",
+		"port": ":3000",
+		"redisHost": "localhost",
+		"redisPort": "6379"
+	},
+	"mailSender": {
+        "email": "email",
+        "sendgrid_key": "my sendgrid_key",
+        "timeout": 60
+    },
+    "account": {
+        "mnemonics": "my mnemonics",
+		"network": "my network"
+    },
+	"database": {
+        "file": "testing.db"
+    },
+	"token": {
+        "secret": "secret",
+        "timeout": 10
+    },
+	"oidc": {
+		"providers": [{"name": "uni", "issuer": "https://idp.uni.edu", "clientID": "c4s"}]
+	},
+	"version": "v1"
+}
+	`
+		dir := t.TempDir()
+		configPath := filepath.Join(dir, "/config.json")
+
+		err := os.WriteFile(configPath, []byte(config), 0644)
+		assert.NoError(t, err)
+
+		_, err = ReadConfFile(configPath)
+		assert.EqualError(t, err, "redirectURL is required for oidc providers")
+	})
+
+	t.Run("rate limit without window", func(t *testing.T) {
+		config :=
+			`
+{
+	"server": {
+		"host": "localhost",
+		"port": ":3000",
+		"redisHost": "localhost",
+		"redisPort": "6379"
+	},
+	"mailSender": {
+        "email": "email",
+        "sendgrid_key": "my sendgrid_key",
+        "timeout": 60
+    },
+    "account": {
+        "mnemonics": "my mnemonics",
+		"network": "my network"
+    },
+	"database": {
+        "file": "testing.db"
+    },
+	"token": {
+        "secret": "secret",
+        "timeout": 10
+    },
+	"rateLimits": {
+		"routes": {"signin": {"ipRequests": 10}}
+	},
+	"version": "v1"
+}
+	`
+		dir := t.TempDir()
+		configPath := filepath.Join(dir, "/config.json")
+
+		err := os.WriteFile(configPath, []byte(config), 0644)
+		assert.NoError(t, err)
+
+		_, err = ReadConfFile(configPath)
+		assert.ErrorContains(t, err, "WindowSeconds: less than min")
+	})
 }
@@ -2,9 +2,8 @@
 package internal
 
 import (
-	_ "embed"
 	"fmt"
-	"strings"
+	"net/http"
 
 	"github.com/codescalers/cloud4students/validators"
 	"github.com/sendgrid/sendgrid-go"
@@ -13,34 +12,13 @@ import (
 	"golang.org/x/text/language"
 )
 
-var (
-	//go:embed templates/signup.html
-	signUpMail []byte
-
-	//go:embed templates/welcome.html
-	welcomeMail []byte
-
-	//go:embed templates/reset_pass.html
-	resetPassMail []byte
-
-	//go:embed templates/approvedVoucher.html
-	approveVoucherMail []byte
-
-	//go:embed templates/rejectedVoucher.html
-	rejectedVoucherMail []byte
-
-	//go:embed templates/voucherNotification.html
-	notifyVoucherMail []byte
-
-	//go:embed templates/balanceNotification.html
-	balanceMail []byte
-
-	//go:embed templates/adminAnnouncement.html
-	adminAnnouncement []byte
-)
-
 // SendMail sends verification mails
 func SendMail(sender, sendGridKey, receiver, subject, body string) error {
+	return sendGridMail("", sender, sendGridKey, receiver, subject, body)
+}
+
+// sendGridMail sends a mail using the sendgrid api of the host, the default api is used if the host is empty
+func sendGridMail(host, sender, sendGridKey, receiver, subject, body string) error {
 	from := mail.NewEmail("Cloud4Students", sender)
 
 	err := validators.ValidMail(receiver)
@@ -50,102 +28,138 @@ func SendMail(sender, sendGridKey, receiver, subject, body string) error {
 
 	to := mail.NewEmail("Cloud4Students User", receiver)
 
-	message := mail.NewSingleEmail(from, subject, to, "", body)
-	client := sendgrid.NewSendClient(sendGridKey)
-	_, err = client.Send(message)
+	text, err := PlainText(body)
+	if err != nil {
+		return err
+	}
 
-	return err
-}
+	message := mail.NewSingleEmail(from, subject, to, text, body)
+	request := sendgrid.GetRequest(sendGridKey, "/v3/mail/send", host)
+	request.Method = "POST"
+	client := &sendgrid.Client{Request: request}
+	resp, err := client.Send(message)
+	if err != nil {
+		return err
+	}
 
-// SignUpMailContent gets the email content for sign up
-func SignUpMailContent(code int, timeout int, username, host string) (string, string) {
-	subject := "Welcome to Cloud4Students 🎉"
-	body := string(signUpMail)
+	// sendgrid doesn't return an error for rejected mails
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("sendgrid responded with status %d: %s", resp.StatusCode, resp.Body)
+	}
 
-	body = strings.ReplaceAll(body, "-code-", fmt.Sprint(code))
-	body = strings.ReplaceAll(body, "-time-", fmt.Sprint(timeout))
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
+	return nil
+}
 
-	return subject, body
+// SignUpMailContent gets the email content for sign up
+func SignUpMailContent(t MailTemplate, code int, link string, timeout int, username, host string) (string, string, error) {
+	return RenderMail(t, map[string]interface{}{
+		"Code": fmt.Sprint(code),
+		"Link": link,
+		"Time": timeout,
+		"Name": cases.Title(language.Und).String(username),
+		"Host": host,
+	})
 }
 
 // WelcomeMailContent gets the email content for welcome messages
-func WelcomeMailContent(username, host string) (string, string) {
-	subject := "Welcome to Cloud4Students 🎉"
-	body := string(welcomeMail)
-
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
-
-	return subject, body
+func WelcomeMailContent(t MailTemplate, username, host string) (string, string, error) {
+	return RenderMail(t, map[string]interface{}{
+		"Name": cases.Title(language.Und).String(username),
+		"Host": host,
+	})
 }
 
 // ResetPasswordMailContent gets the email content for reset password
-func ResetPasswordMailContent(code int, timeout int, username, host string) (string, string) {
-	subject := "Reset password"
-	body := string(resetPassMail)
+func ResetPasswordMailContent(t MailTemplate, code int, link string, timeout int, username, host string) (string, string, error) {
+	return RenderMail(t, map[string]interface{}{
+		"Code": fmt.Sprint(code),
+		"Link": link,
+		"Time": timeout,
+		"Name": cases.Title(language.Und).String(username),
+		"Host": host,
+	})
+}
 
-	body = strings.ReplaceAll(body, "-code-", fmt.Sprint(code))
-	body = strings.ReplaceAll(body, "-time-", fmt.Sprint(timeout))
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
+// EmailChangeMailContent gets the email content for confirming a new email
+func EmailChangeMailContent(t MailTemplate, code int, link string, timeout int, username, host string) (string, string, error) {
+	return RenderMail(t, map[string]interface{}{
+		"Code": fmt.Sprint(code),
+		"Link": link,
+		"Time": timeout,
+		"Name": cases.Title(language.Und).String(username),
+		"Host": host,
+	})
+}
 
-	return subject, body
+// EmailChangeNoticeMailContent gets the email content for noticing the old email of a user that it is being changed
+func EmailChangeNoticeMailContent(t MailTemplate, newEmail, link string, hours int, username, host string) (string, string, error) {
+	return RenderMail(t, map[string]interface{}{
+		"NewEmail": newEmail,
+		"Link":     link,
+		"Hours":    hours,
+		"Name":     cases.Title(language.Und).String(username),
+		"Host":     host,
+	})
 }
 
 // ApprovedVoucherMailContent gets the content for approved voucher
-func ApprovedVoucherMailContent(voucher string, username, host string) (string, string) {
-	subject := "Your voucher request is approved 🎆"
-	body := string(approveVoucherMail)
-
-	body = strings.ReplaceAll(body, "-voucher-", fmt.Sprint(voucher))
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
-
-	return subject, body
+func ApprovedVoucherMailContent(t MailTemplate, voucher string, username, host string) (string, string, error) {
+	return RenderMail(t, map[string]interface{}{
+		"Voucher": voucher,
+		"Name":    cases.Title(language.Und).String(username),
+		"Host":    host,
+	})
 }
 
 // RejectedVoucherMailContent gets the content for rejected voucher
-func RejectedVoucherMailContent(username, host string) (string, string) {
-	subject := "Your voucher request is rejected 😔"
-	body := string(rejectedVoucherMail)
-
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
-
-	return subject, body
+func RejectedVoucherMailContent(t MailTemplate, username, host string) (string, string, error) {
+	return RenderMail(t, map[string]interface{}{
+		"Name": cases.Title(language.Und).String(username),
+		"Host": host,
+	})
 }
 
 // NotifyAdminsMailContent gets the content for notifying admins
-func NotifyAdminsMailContent(vouchers int, host string) (string, string) {
-	subject := "There're pending voucher requests for you to review"
-	body := string(notifyVoucherMail)
-
-	body = strings.ReplaceAll(body, "-vouchers-", fmt.Sprint(vouchers))
-	body = strings.ReplaceAll(body, "-host-", host)
-
-	return subject, body
+func NotifyAdminsMailContent(t MailTemplate, vouchers int, host string) (string, string, error) {
+	return RenderMail(t, map[string]interface{}{
+		"Vouchers": vouchers,
+		"Host":     host,
+	})
 }
 
 // NotifyAdminsMailLowBalanceContent gets the content for notifying admins when balance becomes low
-func NotifyAdminsMailLowBalanceContent(balance float64, host string) (string, string) {
-	subject := "Your account balance is low"
-	body := string(balanceMail)
+func NotifyAdminsMailLowBalanceContent(t MailTemplate, balance float64, host string) (string, string, error) {
+	return RenderMail(t, map[string]interface{}{
+		"Balance": balance,
+		"Host":    host,
+	})
+}
 
-	body = strings.ReplaceAll(body, "-balance-", fmt.Sprint(balance))
-	body = strings.ReplaceAll(body, "-host-", host)
+// AdminAnnouncementMailContent gets the email content for administrator announcements
+func AdminAnnouncementMailContent(t MailTemplate, adminSubject, announcement, host, username string) (string, string, error) {
+	return RenderMail(t, map[string]interface{}{
+		"Subject":      adminSubject,
+		"Announcement": announcement,
+		"Name":         cases.Title(language.Und).String(username),
+		"Host":         host,
+	})
+}
 
-	return subject, body
+// NotificationMailContent gets the email content of a notification
+func NotificationMailContent(t MailTemplate, title, message, username, host string) (string, string, error) {
+	return RenderMail(t, map[string]interface{}{
+		"Title":   title,
+		"Message": message,
+		"Name":    cases.Title(language.Und).String(username),
+		"Host":    host,
+	})
 }
 
-// AdminAnnouncementMailContent gets the email content for administrator announcements
-func AdminAnnouncementMailContent(adminSubject, announcement, host, username string) (string, string) {
-	subject := "New Announcement! 📢 " + adminSubject
-	body := string(adminAnnouncement)
-	body = strings.ReplaceAll(body, "-subject-", adminSubject)
-	body = strings.ReplaceAll(body, "-announcement-", announcement)
-	body = strings.ReplaceAll(body, "-name-", cases.Title(language.Und).String(username))
-	body = strings.ReplaceAll(body, "-host-", host)
-	return subject, body
+// DigestMailContent gets the email content of a digest of notifications
+func DigestMailContent(t MailTemplate, notifications []string, username, host string) (string, string, error) {
+	return RenderMail(t, map[string]interface{}{
+		"Notifications": notifications,
+		"Name":          cases.Title(language.Und).String(username),
+		"Host":          host,
+	})
 }
@@ -1,18 +1,32 @@
 package internal
 
 import (
-	"fmt"
-	"strings"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
 )
 
+var update = flag.Bool("update", false, "update golden files")
+
 func TestSendMail(t *testing.T) {
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v3/mail/send", r.URL.Path)
+		assert.Equal(t, "Bearer 1234", r.Header.Get("Authorization"))
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"errors":[{"message":"too many requests"}]}`))
+	}))
+	defer server.Close()
+
+	mailer := NewSendGridMailer("sender@gmail.com", "1234", server.URL)
+
 	t.Run("send valid mail", func(t *testing.T) {
-		err := SendMail("sender@gmail.com", "1234", "receiver@gmail.com", "subject", "body")
+		err := mailer.SendMail("receiver@gmail.com", "subject", "body")
 		assert.NoError(t, err)
 	})
 
@@ -20,86 +34,178 @@ func TestSendMail(t *testing.T) {
 		err := SendMail("sender@gmail.com", "1234", "receiver", "subject", "body")
 		assert.Error(t, err)
 	})
+
+	t.Run("rejected mail", func(t *testing.T) {
+		status = http.StatusTooManyRequests
+		err := mailer.SendMail("receiver@gmail.com", "subject", "body")
+		assert.ErrorContains(t, err, "429")
+		assert.ErrorContains(t, err, "too many requests")
+	})
 }
 
 func TestSignUpMailContent(t *testing.T) {
-	subject, body := SignUpMailContent(1234, 60, "user", "")
+	subject, body, err := SignUpMailContent(defaultTemplate(t, SignUpMail), 1234, "https://cloud4students.com/verify_email?token=token", 60, "user", "https://cloud4students.com")
+	assert.NoError(t, err)
 	assert.Equal(t, subject, "Welcome to Cloud4Students 🎉")
+	assertGoldenMail(t, "signup", body)
+}
 
-	want := string(signUpMail)
-	want = strings.ReplaceAll(want, "-code-", fmt.Sprint(1234))
-	want = strings.ReplaceAll(want, "-time-", fmt.Sprint(60))
-	want = strings.ReplaceAll(want, "-name-", cases.Title(language.Und).String("user"))
-	want = strings.ReplaceAll(want, "-host-", "")
-
-	assert.Equal(t, body, want)
+func TestWelcomeMailContent(t *testing.T) {
+	subject, body, err := WelcomeMailContent(defaultTemplate(t, WelcomeMail), "user", "https://cloud4students.com")
+	assert.NoError(t, err)
+	assert.Equal(t, subject, "Welcome to Cloud4Students 🎉")
+	assertGoldenMail(t, "welcome", body)
 }
 
 func TestResetPassMailContent(t *testing.T) {
-	subject, body := ResetPasswordMailContent(1234, 60, "user", "")
+	subject, body, err := ResetPasswordMailContent(defaultTemplate(t, ResetPasswordMail), 1234, "https://cloud4students.com/verify_email?token=token", 60, "user", "https://cloud4students.com")
+	assert.NoError(t, err)
 	assert.Equal(t, subject, "Reset password")
+	assertGoldenMail(t, "reset_pass", body)
+}
 
-	want := string(resetPassMail)
-	want = strings.ReplaceAll(want, "-code-", fmt.Sprint(1234))
-	want = strings.ReplaceAll(want, "-time-", fmt.Sprint(60))
-	want = strings.ReplaceAll(want, "-name-", cases.Title(language.Und).String("user"))
-	want = strings.ReplaceAll(want, "-host-", "")
+func TestEmailChangeMailContent(t *testing.T) {
+	subject, body, err := EmailChangeMailContent(defaultTemplate(t, EmailChangeMail), 1234, "https://cloud4students.com/verify_email?token=token", 60, "user", "https://cloud4students.com")
+	assert.NoError(t, err)
+	assert.Equal(t, subject, "Confirm your new email")
+	assertGoldenMail(t, "email_change", body)
+}
 
-	assert.Equal(t, body, want)
+func TestEmailChangeNoticeMailContent(t *testing.T) {
+	subject, body, err := EmailChangeNoticeMailContent(defaultTemplate(t, EmailChangeNotice), "new@gmail.com", "https://cloud4students.com/verify_email?token=token", 72, "user", "https://cloud4students.com")
+	assert.NoError(t, err)
+	assert.Equal(t, subject, "Your email is being changed")
+	assertGoldenMail(t, "email_change_notice", body)
 }
 
 func TestApprovedVoucherMailContent(t *testing.T) {
-	subject, body := ApprovedVoucherMailContent("1234", "user", "")
+	subject, body, err := ApprovedVoucherMailContent(defaultTemplate(t, ApprovedVoucherMail), "1234", "user", "https://cloud4students.com")
+	assert.NoError(t, err)
 	assert.Equal(t, subject, "Your voucher request is approved 🎆")
-
-	want := string(approveVoucherMail)
-	want = strings.ReplaceAll(want, "-voucher-", fmt.Sprint(1234))
-	want = strings.ReplaceAll(want, "-name-", cases.Title(language.Und).String("user"))
-	want = strings.ReplaceAll(want, "-host-", "")
-
-	assert.Equal(t, body, want)
+	assertGoldenMail(t, "approved_voucher", body)
 }
 
 func TestRejectedVoucherMailContent(t *testing.T) {
-	subject, body := RejectedVoucherMailContent("user", "")
+	subject, body, err := RejectedVoucherMailContent(defaultTemplate(t, RejectedVoucherMail), "user", "https://cloud4students.com")
+	assert.NoError(t, err)
 	assert.Equal(t, subject, "Your voucher request is rejected 😔")
-
-	want := string(rejectedVoucherMail)
-	want = strings.ReplaceAll(want, "-name-", cases.Title(language.Und).String("user"))
-	want = strings.ReplaceAll(want, "-host-", "")
-
-	assert.Equal(t, body, want)
+	assertGoldenMail(t, "rejected_voucher", body)
 }
 
 func TestNotifyVoucherMailContent(t *testing.T) {
-	subject, body := NotifyAdminsMailContent(7, "")
+	subject, body, err := NotifyAdminsMailContent(defaultTemplate(t, PendingVouchersMail), 7, "https://cloud4students.com")
+	assert.NoError(t, err)
 	assert.Equal(t, subject, "There're pending voucher requests for you to review")
-
-	want := string(notifyVoucherMail)
-	want = strings.ReplaceAll(want, "-vouchers-", fmt.Sprint(7))
-	want = strings.ReplaceAll(want, "-host-", "")
-
-	assert.Equal(t, body, want)
+	assertGoldenMail(t, "voucher_notification", body)
 }
 
 func TestNotifyBalanceMailContent(t *testing.T) {
-	subject, body := NotifyAdminsMailLowBalanceContent(200, "")
+	subject, body, err := NotifyAdminsMailLowBalanceContent(defaultTemplate(t, LowBalanceMail), 200, "https://cloud4students.com")
+	assert.NoError(t, err)
 	assert.Equal(t, subject, "Your account balance is low")
-
-	want := string(balanceMail)
-	want = strings.ReplaceAll(want, "-balance-", fmt.Sprint(200))
-	want = strings.ReplaceAll(want, "-host-", "")
-
-	assert.Equal(t, body, want)
+	assertGoldenMail(t, "balance_notification", body)
 }
 
 func TestAdminAnnouncementMailContent(t *testing.T) {
-	subject, body := AdminAnnouncementMailContent("subject!", "announcement!", "", "")
+	subject, body, err := AdminAnnouncementMailContent(defaultTemplate(t, AnnouncementMail), "subject!", "announcement!", "https://cloud4students.com", "user")
+	assert.NoError(t, err)
 	assert.Equal(t, subject, "New Announcement! 📢 subject!")
-	want := string(adminAnnouncement)
-	want = strings.ReplaceAll(want, "-subject-", "subject!")
-	want = strings.ReplaceAll(want, "-announcement-", "announcement!")
-	want = strings.ReplaceAll(want, "-host-", "")
-	want = strings.ReplaceAll(want, "-name-", "")
-	assert.Equal(t, body, want)
+	assertGoldenMail(t, "admin_announcement", body)
+
+	t.Run("values are escaped", func(t *testing.T) {
+		_, body, err := AdminAnnouncementMailContent(defaultTemplate(t, AnnouncementMail), "<b>subject</b>", "<script>alert(1)</script>", "", "<img src=x>")
+		assert.NoError(t, err)
+		assert.NotContains(t, body, "<script>")
+		assert.NotContains(t, body, "<b>")
+		assert.NotContains(t, body, "<img src=x>")
+		assert.Contains(t, body, "&lt;script&gt;alert(1)&lt;/script&gt;")
+	})
+}
+
+func TestNotificationMailContent(t *testing.T) {
+	subject, body, err := NotificationMailContent(defaultTemplate(t, NotificationMail), "Deployment succeeded", "Your virtual machine 'vm' is deployed successfully", "user", "https://cloud4students.com")
+	assert.NoError(t, err)
+	assert.Equal(t, subject, "Deployment succeeded")
+	assertGoldenMail(t, "notification", body)
+}
+
+func TestDigestMailContent(t *testing.T) {
+	subject, body, err := DigestMailContent(defaultTemplate(t, DigestMail), []string{"first notification", "second notification"}, "user", "https://cloud4students.com")
+	assert.NoError(t, err)
+	assert.Equal(t, subject, "Your daily Cloud4Students digest 📬")
+	assertGoldenMail(t, "digest", body)
+}
+
+func TestRenderMail(t *testing.T) {
+	t.Run("language is set on the layout", func(t *testing.T) {
+		tmpl := defaultTemplate(t, WelcomeMail)
+		tmpl.Language = "ar"
+		tmpl.Body = `{{define "title"}}title{{end}}{{define "content"}}مرحبا {{.Name}}{{end}}{{define "reason"}}reason{{end}}`
+
+		_, body, err := RenderMail(tmpl, MailSampleData(WelcomeMail))
+		assert.NoError(t, err)
+		assert.Contains(t, body, `<html lang="ar" dir="rtl">`)
+		assert.Contains(t, body, "مرحبا Student")
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		tmpl := defaultTemplate(t, WelcomeMail)
+		tmpl.Body = `{{define "content"}}{{.Name}`
+
+		_, _, err := RenderMail(tmpl, MailSampleData(WelcomeMail))
+		assert.Error(t, err)
+	})
+
+	t.Run("missing value in subject", func(t *testing.T) {
+		tmpl := defaultTemplate(t, WelcomeMail)
+		tmpl.Subject = "Welcome {{.Voucher}}"
+
+		_, _, err := RenderMail(tmpl, MailSampleData(WelcomeMail))
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateMailTemplate(t *testing.T) {
+	for _, name := range MailTemplateNames() {
+		assert.NoError(t, ValidateMailTemplate(defaultTemplate(t, name)), name)
+	}
+
+	err := ValidateMailTemplate(MailTemplate{Name: "unknown", Subject: "subject", Body: "body"})
+	assert.Error(t, err)
+}
+
+func TestPlainText(t *testing.T) {
+	text, err := PlainText(`<html><head><title>title</title><style>p {}</style></head>
+	<body><h1>Welcome,   user!</h1><p>first
+	line</p><p>second<br/>line</p><a href="https://a.com">link</a> <a href="https://b.com">https://b.com</a></body></html>`)
+	assert.NoError(t, err)
+	assert.Equal(t, "Welcome, user!\n\nfirst line\n\nsecond\nline\n\nlink (https://a.com) https://b.com\n", text)
+}
+
+func defaultTemplate(t *testing.T, name string) MailTemplate {
+	t.Helper()
+
+	tmpl, err := DefaultMailTemplate(name)
+	assert.NoError(t, err)
+	return tmpl
+}
+
+// assertGoldenMail compares the html mail and its plain text alternative with the golden files in testdata,
+// run the tests with -update to regenerate the golden files
+func assertGoldenMail(t *testing.T, name, body string) {
+	t.Helper()
+
+	text, err := PlainText(body)
+	assert.NoError(t, err)
+
+	for file, got := range map[string]string{name + ".html": body, name + ".txt": text} {
+		path := filepath.Join("testdata", file+".golden")
+		if *update {
+			assert.NoError(t, os.WriteFile(path, []byte(got), 0644))
+		}
+
+		want, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, string(want), got)
+	}
 }
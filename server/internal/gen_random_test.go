@@ -10,8 +10,13 @@ func TestGenerateRandomVoucher(t *testing.T) {
 }
 
 func TestGenerateRandomCode(t *testing.T) {
-	code := GenerateRandomCode()
-	if code < 1000 || code > 9999 {
-		t.Errorf("Expected code to be between 1000 and 9999, got %d", code)
+	for i := 0; i < 100; i++ {
+		code, err := GenerateRandomCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code < 100000 || code > 999999 {
+			t.Errorf("Expected code to be between 100000 and 999999, got %d", code)
+		}
 	}
 }
@@ -2,6 +2,8 @@
 package internal
 
 import (
+	crand "crypto/rand"
+	"math/big"
 	"math/rand"
 )
 
@@ -16,9 +18,13 @@ func GenerateRandomVoucher(n int) string {
 	return string(b)
 }
 
-// GenerateRandomCode generates random code of 4 digits
-func GenerateRandomCode() int {
-	min := 1000
-	max := 9999
-	return rand.Intn(max-min) + min
+// GenerateRandomCode generates a random code of 6 digits, it is a secret so it is read from crypto/rand
+func GenerateRandomCode() (int, error) {
+	min := int64(100000)
+	max := int64(999999)
+	n, err := crand.Int(crand.Reader, big.NewInt(max-min+1))
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Int64() + min), nil
 }
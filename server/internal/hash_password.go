@@ -23,6 +23,11 @@ func HashAndSaltPassword(password []byte) ([]byte, error) {
 
 // VerifyPassword checks if given password is same as hashed one
 func VerifyPassword(hashedPassword []byte, password string) bool {
+	// users signed up with a provider have no password
+	if len(hashedPassword) < saltLen {
+		return false
+	}
+
 	hashedPasswordCopy := make([]byte, len(hashedPassword))
 
 	copy(hashedPasswordCopy, hashedPassword)
@@ -29,4 +29,8 @@ func TestHashPassword(t *testing.T) {
 
 	})
 
+	t.Run("user without password", func(t *testing.T) {
+		assert.False(t, VerifyPassword(nil, ""))
+	})
+
 }
@@ -0,0 +1,193 @@
+// Package internal for internal details
+package internal
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"sort"
+	textTemplate "text/template"
+)
+
+//go:embed templates
+var templatesFS embed.FS
+
+// layout is the base of all mails, mail templates define its "title", "hero", "content" and "reason" blocks
+var layout = template.Must(template.ParseFS(templatesFS, "templates/layout.html", "templates/partials.html"))
+
+// DefaultLanguage is the language of mails if there is no template in the user language
+const DefaultLanguage = "en"
+
+// Languages are the supported languages of mails
+var Languages = []string{DefaultLanguage, "ar", "fr"}
+
+// names of mail templates
+const (
+	SignUpMail          = "signup"
+	WelcomeMail         = "welcome"
+	ResetPasswordMail   = "reset_password"
+	ApprovedVoucherMail = "approved_voucher"
+	RejectedVoucherMail = "rejected_voucher"
+	PendingVouchersMail = "pending_vouchers"
+	LowBalanceMail      = "low_balance"
+	AnnouncementMail    = "announcement"
+	NotificationMail    = "notification"
+	DigestMail          = "digest"
+	EmailChangeMail     = "email_change"
+	EmailChangeNotice   = "email_change_notice"
+)
+
+// MailTemplate holds the subject and body templates of a mail in a language
+type MailTemplate struct {
+	Name     string
+	Language string
+	// text template of the mail subject
+	Subject string
+	// html template defining the "title", "hero", "content" and "reason" blocks of the mails layout
+	Body string
+}
+
+type defaultMail struct {
+	file    string
+	subject string
+	// sample values used in previews and validations
+	sample map[string]interface{}
+}
+
+var defaultMails = map[string]defaultMail{
+	SignUpMail: {
+		file:    "signup.html",
+		subject: "Welcome to Cloud4Students 🎉",
+		sample:  map[string]interface{}{"Code": "1234", "Link": "https://cloud4students.com/verify_email?token=token", "Time": 60, "Name": "Student", "Host": "https://cloud4students.com"},
+	},
+	WelcomeMail: {
+		file:    "welcome.html",
+		subject: "Welcome to Cloud4Students 🎉",
+		sample:  map[string]interface{}{"Name": "Student", "Host": "https://cloud4students.com"},
+	},
+	ResetPasswordMail: {
+		file:    "reset_pass.html",
+		subject: "Reset password",
+		sample:  map[string]interface{}{"Code": "1234", "Link": "https://cloud4students.com/verify_email?token=token", "Time": 60, "Name": "Student", "Host": "https://cloud4students.com"},
+	},
+	ApprovedVoucherMail: {
+		file:    "approvedVoucher.html",
+		subject: "Your voucher request is approved 🎆",
+		sample:  map[string]interface{}{"Voucher": "voucher1234", "Name": "Student", "Host": "https://cloud4students.com"},
+	},
+	RejectedVoucherMail: {
+		file:    "rejectedVoucher.html",
+		subject: "Your voucher request is rejected 😔",
+		sample:  map[string]interface{}{"Name": "Student", "Host": "https://cloud4students.com"},
+	},
+	PendingVouchersMail: {
+		file:    "voucherNotification.html",
+		subject: "There're pending voucher requests for you to review",
+		sample:  map[string]interface{}{"Vouchers": 7, "Host": "https://cloud4students.com"},
+	},
+	LowBalanceMail: {
+		file:    "balanceNotification.html",
+		subject: "Your account balance is low",
+		sample:  map[string]interface{}{"Balance": 200.5, "Host": "https://cloud4students.com"},
+	},
+	AnnouncementMail: {
+		file:    "adminAnnouncement.html",
+		subject: "New Announcement! 📢 {{.Subject}}",
+		sample:  map[string]interface{}{"Subject": "Maintenance", "Announcement": "The system will be down for maintenance.", "Name": "Student", "Host": "https://cloud4students.com"},
+	},
+	NotificationMail: {
+		file:    "notification.html",
+		subject: "{{.Title}}",
+		sample:  map[string]interface{}{"Title": "Deployment succeeded", "Message": "Your virtual machine 'vm' is deployed successfully 🎆", "Name": "Student", "Host": "https://cloud4students.com"},
+	},
+	EmailChangeMail: {
+		file:    "email_change.html",
+		subject: "Confirm your new email",
+		sample:  map[string]interface{}{"Code": "1234", "Link": "https://cloud4students.com/verify_email?token=token", "Time": 60, "Name": "Student", "Host": "https://cloud4students.com"},
+	},
+	EmailChangeNotice: {
+		file:    "email_change_notice.html",
+		subject: "Your email is being changed",
+		sample:  map[string]interface{}{"NewEmail": "student@uni.edu", "Link": "https://cloud4students.com/verify_email?token=token", "Hours": 72, "Name": "Student", "Host": "https://cloud4students.com"},
+	},
+	DigestMail: {
+		file:    "digest.html",
+		subject: "Your daily Cloud4Students digest 📬",
+		sample:  map[string]interface{}{"Notifications": []string{"Your virtual machine 'vm' is deployed successfully 🎆", "Your voucher request is approved"}, "Name": "Student", "Host": "https://cloud4students.com"},
+	},
+}
+
+// MailTemplateNames returns the names of all mail templates
+func MailTemplateNames() []string {
+	names := make([]string, 0, len(defaultMails))
+	for name := range defaultMails {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultMailTemplate returns the built in template of a mail in the default language
+func DefaultMailTemplate(name string) (MailTemplate, error) {
+	mail, ok := defaultMails[name]
+	if !ok {
+		return MailTemplate{}, fmt.Errorf("mail template '%s' is not found", name)
+	}
+
+	body, err := templatesFS.ReadFile("templates/" + mail.file)
+	if err != nil {
+		return MailTemplate{}, err
+	}
+
+	return MailTemplate{Name: name, Language: DefaultLanguage, Subject: mail.subject, Body: string(body)}, nil
+}
+
+// MailSampleData returns sample values of a mail template
+func MailSampleData(name string) map[string]interface{} {
+	return defaultMails[name].sample
+}
+
+// ValidateMailTemplate checks that the template can be rendered with the values of its mail
+func ValidateMailTemplate(t MailTemplate) error {
+	if _, ok := defaultMails[t.Name]; !ok {
+		return fmt.Errorf("mail template '%s' is not found", t.Name)
+	}
+
+	_, _, err := RenderMail(t, MailSampleData(t.Name))
+	return err
+}
+
+// RenderMail renders the subject and body of a mail, body values are escaped for html
+func RenderMail(t MailTemplate, data map[string]interface{}) (string, string, error) {
+	subjectTemplate, err := textTemplate.New("subject").Option("missingkey=error").Parse(t.Subject)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid subject: %w", err)
+	}
+
+	var subject bytes.Buffer
+	if err := subjectTemplate.Execute(&subject, data); err != nil {
+		return "", "", fmt.Errorf("invalid subject: %w", err)
+	}
+
+	bodyTemplate, err := layout.Clone()
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err = bodyTemplate.New(t.Name).Option("missingkey=error").Parse(t.Body); err != nil {
+		return "", "", fmt.Errorf("invalid body: %w", err)
+	}
+
+	values := map[string]interface{}{"Language": t.Language}
+	for k, v := range data {
+		values[k] = v
+	}
+
+	var body bytes.Buffer
+	if err := bodyTemplate.ExecuteTemplate(&body, "layout", values); err != nil {
+		return "", "", fmt.Errorf("invalid body: %w", err)
+	}
+
+	return subject.String(), body.String(), nil
+}
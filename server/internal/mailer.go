@@ -0,0 +1,164 @@
+// Package internal for internal details
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/codescalers/cloud4students/validators"
+)
+
+const (
+	// SendGridBackend sends mails using sendgrid api
+	SendGridBackend = "sendgrid"
+	// SMTPBackend sends mails using an smtp server
+	SMTPBackend = "smtp"
+	// FileBackend writes mails as .eml files to a directory instead of sending them
+	FileBackend = "file"
+)
+
+// Mailer sends mails to users
+type Mailer interface {
+	SendMail(receiver, subject, body string) error
+}
+
+// NewMailer creates the mailer of the configured backend
+func NewMailer(config MailSender) (Mailer, error) {
+	switch config.Backend {
+	case SendGridBackend:
+		return NewSendGridMailer(config.Email, config.SendGridKey, ""), nil
+	case SMTPBackend:
+		return &SMTPMailer{sender: config.Email, config: config.SMTP}, nil
+	case FileBackend:
+		return NewFileMailer(config.Email, config.Dir)
+	default:
+		return nil, fmt.Errorf("mail backend %q is not supported", config.Backend)
+	}
+}
+
+// SendGridMailer sends mails using sendgrid api
+type SendGridMailer struct {
+	sender string
+	key    string
+	host   string
+}
+
+// NewSendGridMailer creates a mailer of the sendgrid api of the host, the default api is used if the host is empty
+func NewSendGridMailer(sender, key, host string) *SendGridMailer {
+	return &SendGridMailer{sender: sender, key: key, host: host}
+}
+
+// SendMail sends a mail using sendgrid, a mail rejected by sendgrid is an error
+func (m *SendGridMailer) SendMail(receiver, subject, body string) error {
+	return sendGridMail(m.host, m.sender, m.key, receiver, subject, body)
+}
+
+// SMTPMailer sends mails using an smtp server, STARTTLS is used if the server supports it
+type SMTPMailer struct {
+	sender string
+	config SMTP
+}
+
+// SendMail sends a mail using the smtp server
+func (m *SMTPMailer) SendMail(receiver, subject, body string) error {
+	msg, err := buildMessage(m.sender, receiver, subject, body)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+	}
+
+	addr := net.JoinHostPort(m.config.Host, m.config.Port)
+	return smtp.SendMail(addr, auth, m.sender, []string{receiver}, msg)
+}
+
+// FileMailer writes mails as .eml files to a directory, useful for development and testing
+type FileMailer struct {
+	sender string
+	dir    string
+}
+
+// NewFileMailer creates a new file mailer and its directory
+func NewFileMailer(sender, dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create mails directory: %w", err)
+	}
+
+	return &FileMailer{sender: sender, dir: dir}, nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]`)
+
+// SendMail writes the mail to a new file in the mails directory
+func (m *FileMailer) SendMail(receiver, subject, body string) error {
+	msg, err := buildMessage(m.sender, receiver, subject, body)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), unsafeFileChars.ReplaceAllString(receiver, "_"))
+	return os.WriteFile(filepath.Join(m.dir, name), msg, 0644)
+}
+
+// buildMessage builds a mail message with html and plain text alternatives
+func buildMessage(sender, receiver, subject, body string) ([]byte, error) {
+	if err := validators.ValidMail(receiver); err != nil {
+		return nil, fmt.Errorf("email %v is not valid", receiver)
+	}
+
+	text, err := PlainText(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	parts := multipart.NewWriter(&msg)
+
+	fmt.Fprintf(&msg, "From: %s\r\n", mime.QEncoding.Encode("utf-8", "Cloud4Students")+" <"+sender+">")
+	fmt.Fprintf(&msg, "To: %s\r\n", receiver)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", parts.Boundary())
+
+	// clients show the last alternative they support
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=\"utf-8\"", text},
+		{"text/html; charset=\"utf-8\"", body},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	return msg.Bytes(), nil
+}
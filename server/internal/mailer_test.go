@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMailer(t *testing.T) {
+	t.Run("sendgrid", func(t *testing.T) {
+		mailer, err := NewMailer(MailSender{Backend: SendGridBackend, Email: "sender@gmail.com", SendGridKey: "1234"})
+		assert.NoError(t, err)
+		assert.IsType(t, &SendGridMailer{}, mailer)
+	})
+
+	t.Run("smtp", func(t *testing.T) {
+		mailer, err := NewMailer(MailSender{Backend: SMTPBackend, Email: "sender@gmail.com", SMTP: SMTP{Host: "localhost", Port: "25"}})
+		assert.NoError(t, err)
+		assert.IsType(t, &SMTPMailer{}, mailer)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		mailer, err := NewMailer(MailSender{Backend: FileBackend, Email: "sender@gmail.com", Dir: t.TempDir()})
+		assert.NoError(t, err)
+		assert.IsType(t, &FileMailer{}, mailer)
+	})
+
+	t.Run("unknown backend", func(t *testing.T) {
+		_, err := NewMailer(MailSender{Backend: "pigeon"})
+		assert.Error(t, err)
+	})
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mails")
+	mailer, err := NewFileMailer("sender@gmail.com", dir)
+	require.NoError(t, err)
+
+	t.Run("send valid mail", func(t *testing.T) {
+		err := mailer.SendMail("receiver@gmail.com", "subject", "<p>body</p>")
+		assert.NoError(t, err)
+
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		assert.True(t, strings.HasSuffix(files[0].Name(), "-receiver@gmail.com.eml"))
+
+		content, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "To: receiver@gmail.com\r\n")
+		assert.Contains(t, string(content), "Subject: subject\r\n")
+		assert.Contains(t, string(content), "<p>body</p>")
+	})
+
+	t.Run("send invalid mail", func(t *testing.T) {
+		err := mailer.SendMail("receiver", "subject", "body")
+		assert.Error(t, err)
+	})
+}
+
+func TestSMTPMailer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go serveSMTP(t, listener, received)
+
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+
+	mailer := &SMTPMailer{sender: "sender@gmail.com", config: SMTP{Host: host, Port: port}}
+	err = mailer.SendMail("receiver@gmail.com", "subject", "body")
+	require.NoError(t, err)
+
+	data := <-received
+	assert.Contains(t, data, "To: receiver@gmail.com")
+	assert.Contains(t, data, "body")
+}
+
+// serveSMTP serves a single smtp session and sends the received data
+func serveSMTP(t *testing.T, listener net.Listener, received chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	reply := func(line string) {
+		assert.NoError(t, text.PrintfLine("%s", line))
+	}
+
+	reply("220 localhost ready")
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL", "RCPT":
+			reply("250 ok")
+		case "DATA":
+			reply("354 send data")
+			lines, err := text.ReadDotLines()
+			if err != nil {
+				return
+			}
+			received <- strings.Join(lines, "\n")
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Package internal for internal details
+package internal
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// OIDCClaims struct holds the claims of an ID token used to sign in a user
+type OIDCClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	// all the claims of the token, to read the configured college claim
+	Raw map[string]interface{} `json:"-"`
+}
+
+// oidcDiscovery struct holds the provider metadata used in the authorization code flow
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCClient signs in users with the authorization code flow with PKCE of an OpenID Connect provider,
+// the provider metadata and signing keys are fetched once they are needed
+type OIDCClient struct {
+	provider    OIDCProvider
+	redirectURL string
+	client      *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+// NewOIDCClient creates a new client of an OpenID Connect provider
+func NewOIDCClient(provider OIDCProvider, redirectURL string, client *http.Client) *OIDCClient {
+	return &OIDCClient{
+		provider:    provider,
+		redirectURL: redirectURL,
+		client:      client,
+	}
+}
+
+// Provider returns the configuration of the client's provider
+func (c *OIDCClient) Provider() OIDCProvider {
+	return c.provider
+}
+
+// AuthCodeURL returns the url of the provider's login page the user is redirected to
+func (c *OIDCClient) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	discovery, err := c.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", c.provider.ClientID)
+	query.Set("redirect_uri", c.redirectURL)
+	query.Set("scope", "openid email profile")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", PKCEChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange exchanges an authorization code with the provider's tokens and returns the verified claims of its ID token
+func (c *OIDCClient) Exchange(ctx context.Context, code, codeVerifier, nonce string) (OIDCClaims, error) {
+	discovery, err := c.getDiscovery(ctx)
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.redirectURL)
+	form.Set("client_id", c.provider.ClientID)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return OIDCClaims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.provider.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.provider.ClientID), url.QueryEscape(c.provider.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := c.doJSON(req, &tokens); err != nil {
+		return OIDCClaims{}, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return OIDCClaims{}, errors.New("provider didn't return an id token")
+	}
+
+	return c.verifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry and nonce of an ID token
+func (c *OIDCClient) verifyIDToken(ctx context.Context, idToken, nonce string) (OIDCClaims, error) {
+	claims := OIDCClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+
+	_, err := parser.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return c.getKey(ctx, kid)
+	})
+	if err != nil {
+		return OIDCClaims{}, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if claims.Issuer != c.provider.Issuer {
+		return OIDCClaims{}, fmt.Errorf("id token is issued by %q", claims.Issuer)
+	}
+	if !claims.VerifyAudience(c.provider.ClientID, true) {
+		return OIDCClaims{}, errors.New("id token is not issued to this client")
+	}
+	if claims.ExpiresAt == nil {
+		return OIDCClaims{}, errors.New("id token has no expiry")
+	}
+	if claims.Subject == "" {
+		return OIDCClaims{}, errors.New("id token has no subject")
+	}
+	if claims.Nonce != nonce {
+		return OIDCClaims{}, errors.New("id token nonce doesn't match")
+	}
+
+	// the verified token is decoded again to read the claims that are not known here
+	raw := jwt.MapClaims{}
+	if _, _, err := parser.ParseUnverified(idToken, raw); err != nil {
+		return OIDCClaims{}, err
+	}
+	claims.Raw = raw
+
+	return claims, nil
+}
+
+func (c *OIDCClient) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.discovery != nil {
+		return c.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.provider.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var discovery oidcDiscovery
+	if err := c.doJSON(req, &discovery); err != nil {
+		return nil, fmt.Errorf("failed to discover provider %s: %w", c.provider.Name, err)
+	}
+
+	if discovery.Issuer != c.provider.Issuer {
+		return nil, fmt.Errorf("provider %s issuer %q doesn't match the configured issuer", c.provider.Name, discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("provider %s metadata is missing endpoints", c.provider.Name)
+	}
+
+	c.discovery = &discovery
+	return c.discovery, nil
+}
+
+// getKey returns a signing key of the provider, the keys are fetched again if the key is not known as they may be rotated
+func (c *OIDCClient) getKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	discovery, err := c.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discovery.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := c.doJSON(req, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to get signing keys of provider %s: %w", c.provider.Name, err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.RSAPublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	c.keys = keys
+
+	key, ok := c.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q is not found", kid)
+	}
+	return key, nil
+}
+
+func (c *OIDCClient) doJSON(req *http.Request, v interface{}) error {
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("provider responded with status %d", res.StatusCode)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// JWK struct holds a JSON web key of a provider
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// RSAPublicKey returns the RSA public key of a signing JSON web key
+func (k JWK) RSAPublicKey() (*rsa.PublicKey, error) {
+	if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+		return nil, errors.New("key is not an RSA signing key")
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key exponent: %w", err)
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid key exponent")
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+// PKCEChallenge returns the S256 code challenge of a PKCE code verifier
+func PKCEChallenge(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// EmailDomainAllowed checks if the domain of an email is one of the allowed domains, all domains are allowed if none is set
+func EmailDomainAllowed(email string, allowedDomains []string) bool {
+	if len(allowedDomains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at == -1 {
+		return false
+	}
+
+	domain := strings.ToLower(email[at+1:])
+	return slices.ContainsFunc(allowedDomains, func(allowed string) bool {
+		return strings.ToLower(allowed) == domain
+	})
+}
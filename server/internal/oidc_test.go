@@ -0,0 +1,58 @@
+// Package internal for internal details
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPKCEChallenge(t *testing.T) {
+	// example of RFC 7636
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", PKCEChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
+
+func TestEmailDomainAllowed(t *testing.T) {
+	assert.True(t, EmailDomainAllowed("student@uni.edu", nil))
+	assert.True(t, EmailDomainAllowed("student@UNI.edu", []string{"uni.edu"}))
+	assert.False(t, EmailDomainAllowed("student@gmail.com", []string{"uni.edu"}))
+	assert.False(t, EmailDomainAllowed("student@sub.uni.edu", []string{"uni.edu"}))
+	assert.False(t, EmailDomainAllowed("student", []string{"uni.edu"}))
+}
+
+func TestJWKRSAPublicKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	jwk := JWK{
+		Kty: "RSA",
+		Kid: "key",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+
+	t.Run("valid key", func(t *testing.T) {
+		got, err := jwk.RSAPublicKey()
+		assert.NoError(t, err)
+		assert.True(t, key.PublicKey.Equal(got))
+	})
+
+	t.Run("encryption key", func(t *testing.T) {
+		enc := jwk
+		enc.Use = "enc"
+		_, err := enc.RSAPublicKey()
+		assert.Error(t, err)
+	})
+
+	t.Run("not an RSA key", func(t *testing.T) {
+		ec := jwk
+		ec.Kty = "EC"
+		_, err := ec.RSAPublicKey()
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,80 @@
+// Package internal for internal details
+package internal
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// PlainText generates the text/plain alternative of an html mail
+func PlainText(body string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+	writePlainText(&text, doc)
+
+	// trim lines and keep a single empty line between paragraphs
+	var lines []string
+	for _, line := range strings.Split(text.String(), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" && (len(lines) == 0 || lines[len(lines)-1] == "") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n")) + "\n", nil
+}
+
+func writePlainText(text *strings.Builder, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		text.WriteString(strings.Join(strings.Fields(n.Data), " ") + " ")
+		return
+	case html.ElementNode:
+		switch n.DataAtom {
+		case atom.Head, atom.Style, atom.Script, atom.Img:
+			return
+		case atom.Br:
+			text.WriteString("\n")
+			return
+		}
+	}
+
+	start := text.Len()
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writePlainText(text, c)
+	}
+
+	if n.Type != html.ElementNode {
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.A:
+		// show links that are not already shown as their text, links without text are images
+		href := attr(n, "href")
+		linkText := strings.TrimSpace(text.String()[start:])
+		if href != "" && linkText != "" && linkText != href {
+			text.WriteString("(" + href + ") ")
+		}
+	case atom.P, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		text.WriteString("\n\n")
+	case atom.Div, atom.Tr, atom.Table, atom.Li, atom.Button:
+		text.WriteString("\n")
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -2,21 +2,30 @@
 package internal
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/codescalers/cloud4students/models"
 	"github.com/golang-jwt/jwt/v4"
 )
 
-// CreateJWT create token for user
-func CreateJWT(userID string, email string, secret string, timeout int) (string, error) {
+// CreateJWT create an access token for a session of a user
+func CreateJWT(userID, email, sessionID, secret string, timeout int) (string, error) {
 	expirationTime := time.Now().Add(time.Duration(timeout) * time.Minute)
 	claims := &models.Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:    userID,
+		Email:     email,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
@@ -29,22 +38,82 @@ func CreateJWT(userID string, email string, secret string, timeout int) (string,
 	return signedToken, nil
 }
 
-// ValidateJWTToken validates if the token is valid
-func ValidateJWTToken(token, secret string, timeout int) (models.Claims, error) {
+// ValidateJWTToken validates the signature and the expiry of a token
+func ValidateJWTToken(token, secret string) (models.Claims, error) {
 	claims := &models.Claims{}
 	tkn, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
 		return []byte(secret), nil
 	})
 	if err != nil {
 		return models.Claims{}, err
 	}
 	if !tkn.Valid {
-		return models.Claims{}, fmt.Errorf("token '%s' is invalid", token)
+		return models.Claims{}, errors.New("token is invalid")
 	}
 
-	if time.Until(claims.ExpiresAt.Time) > time.Duration(timeout)*time.Minute {
-		return models.Claims{}, fmt.Errorf("token '%s' is expired", token)
+	// tokens without expiry are valid for the jwt library
+	if claims.ExpiresAt == nil {
+		return models.Claims{}, errors.New("token has no expiry")
 	}
 
 	return *claims, nil
 }
+
+// GenerateToken generates an opaque random token and the hash it is stored with
+func GenerateToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// AccessTokenPrefix is the prefix of personal access tokens, it tells them apart from JWTs
+const AccessTokenPrefix = "c4s_"
+
+// GenerateAccessToken generates a personal access token and the hash it is stored with
+func GenerateAccessToken() (string, string, error) {
+	token, _, err := GenerateToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	token = AccessTokenPrefix + token
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex encoded SHA-256 hash of a token
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// SignToken signs an opaque token with a secret, signed tokens are sent in links
+// so forged links are refused before looking their tokens up
+func SignToken(token, secret string) string {
+	return token + "." + tokenSignature(token, secret)
+}
+
+// VerifySignedToken returns the token of a signed token if its signature is valid
+func VerifySignedToken(signed, secret string) (string, bool) {
+	token, signature, found := strings.Cut(signed, ".")
+	if !found || token == "" {
+		return "", false
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(tokenSignature(token, secret))) {
+		return "", false
+	}
+	return token, true
+}
+
+func tokenSignature(token, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -1,15 +1,106 @@
 package internal
 
 import (
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/codescalers/cloud4students/models"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestCreateJWT(t *testing.T) {
 	t.Run("create jwt token", func(t *testing.T) {
-		token, err := CreateJWT("1", "email@gmail.com", "secret", 60)
+		token, err := CreateJWT("1", "email@gmail.com", "session", "secret", 60)
 		assert.NoError(t, err)
 		assert.NotEmpty(t, token)
 	})
 }
+
+func TestValidateJWTToken(t *testing.T) {
+	t.Run("valid token", func(t *testing.T) {
+		token, err := CreateJWT("1", "email@gmail.com", "session", "secret", 60)
+		assert.NoError(t, err)
+
+		claims, err := ValidateJWTToken(token, "secret")
+		assert.NoError(t, err)
+		assert.Equal(t, "1", claims.UserID)
+		assert.Equal(t, "session", claims.SessionID)
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		token, err := CreateJWT("1", "email@gmail.com", "session", "secret", 60)
+		assert.NoError(t, err)
+
+		_, err = ValidateJWTToken(token, "another secret")
+		assert.Error(t, err)
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		token, err := CreateJWT("1", "email@gmail.com", "session", "secret", -1)
+		assert.NoError(t, err)
+
+		_, err = ValidateJWTToken(token, "secret")
+		assert.Error(t, err)
+	})
+
+	t.Run("token without expiry", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &models.Claims{UserID: "1"}).SignedString([]byte("secret"))
+		assert.NoError(t, err)
+
+		_, err = ValidateJWTToken(token, "secret")
+		assert.Error(t, err)
+	})
+
+	t.Run("unexpected signing method", func(t *testing.T) {
+		claims := &models.Claims{UserID: "1", RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		assert.NoError(t, err)
+
+		_, err = ValidateJWTToken(token, "secret")
+		assert.Error(t, err)
+	})
+}
+
+func TestGenerateToken(t *testing.T) {
+	token, hash, err := GenerateToken()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.Equal(t, HashToken(token), hash)
+	assert.NotEqual(t, token, hash)
+
+	another, _, err := GenerateToken()
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, another)
+}
+
+func TestGenerateAccessToken(t *testing.T) {
+	token, hash, err := GenerateAccessToken()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, AccessTokenPrefix))
+	assert.Equal(t, HashToken(token), hash)
+}
+
+func TestSignToken(t *testing.T) {
+	signed := SignToken("token", "secret")
+
+	t.Run("valid signature", func(t *testing.T) {
+		token, ok := VerifySignedToken(signed, "secret")
+		assert.True(t, ok)
+		assert.Equal(t, "token", token)
+	})
+
+	t.Run("another secret", func(t *testing.T) {
+		_, ok := VerifySignedToken(signed, "another secret")
+		assert.False(t, ok)
+	})
+
+	t.Run("forged token", func(t *testing.T) {
+		_, ok := VerifySignedToken("forged"+signed[len("token"):], "secret")
+		assert.False(t, ok)
+
+		_, ok = VerifySignedToken("token", "secret")
+		assert.False(t, ok)
+	})
+}
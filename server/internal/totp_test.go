@@ -0,0 +1,87 @@
+// Package internal for internal details
+package internal
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// test vectors of RFC 6238 for SHA1, truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, expected := range tests {
+		code, err := TOTPCode(secret, TOTPStep(time.Unix(unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code)
+	}
+
+	_, err := TOTPCode("not base32!", 1)
+	assert.Error(t, err)
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+
+	now := time.Now()
+	code, err := TOTPCode(secret, TOTPStep(now))
+	assert.NoError(t, err)
+
+	t.Run("valid code", func(t *testing.T) {
+		step, ok := ValidateTOTP(secret, code, now, 0)
+		assert.True(t, ok)
+		assert.Equal(t, TOTPStep(now), step)
+	})
+
+	t.Run("code of previous period", func(t *testing.T) {
+		_, ok := ValidateTOTP(secret, code, now.Add(totpPeriod), 0)
+		assert.True(t, ok)
+	})
+
+	t.Run("expired code", func(t *testing.T) {
+		_, ok := ValidateTOTP(secret, code, now.Add(3*totpPeriod), 0)
+		assert.False(t, ok)
+	})
+
+	t.Run("used code", func(t *testing.T) {
+		_, ok := ValidateTOTP(secret, code, now, TOTPStep(now))
+		assert.False(t, ok)
+	})
+
+	t.Run("invalid code", func(t *testing.T) {
+		_, ok := ValidateTOTP(secret, "12345", now, 0)
+		assert.False(t, ok)
+	})
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Cloud4Students", "user@gmail.com", "SECRET")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Cloud4Students:user@gmail.com?"))
+	assert.Contains(t, uri, "secret=SECRET")
+	assert.Contains(t, uri, "issuer=Cloud4Students")
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	assert.NoError(t, err)
+	assert.Len(t, codes, 10)
+
+	for _, code := range codes {
+		assert.Len(t, code, recoveryCodeLength+1)
+		assert.Equal(t, code, NormalizeRecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", ""))))
+	}
+	assert.NotEqual(t, codes[0], codes[1])
+}
@@ -0,0 +1,125 @@
+// Package internal for internal details
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// WebhookEventHeader is the header of the delivered event name
+	WebhookEventHeader = "X-C4S-Event"
+	// WebhookDeliveryHeader is the header of the delivery id, it is the same for the retries of a delivery
+	WebhookDeliveryHeader = "X-C4S-Delivery"
+	// WebhookSignatureHeader is the header of the HMAC-SHA256 signature of the payload
+	WebhookSignatureHeader = "X-C4S-Signature"
+)
+
+var errPrivateNetwork = errors.New("webhook endpoints in private networks are not allowed")
+
+// GenerateWebhookSecret generates a random secret to sign webhook deliveries
+func GenerateWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// SignWebhookPayload returns the signature of a payload in the form sha256=<hex encoded HMAC-SHA256>
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateWebhookURL checks that a webhook url is an absolute http or https url
+func ValidateWebhookURL(webhookURL string) error {
+	u, err := url.ParseRequestURI(webhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook url should be an absolute http or https url")
+	}
+
+	return nil
+}
+
+// NewWebhookClient creates an http client for webhook deliveries,
+// connections to private networks are refused unless they are allowed
+func NewWebhookClient(timeout time.Duration, allowPrivateNetworks bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivateNetworks {
+		// checked after resolving the host so it can't resolve to a private address later
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+				return errPrivateNetwork
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// redirects are not followed so deliveries can't be redirected to other endpoints
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// PostWebhook delivers a signed event payload to a webhook endpoint,
+// it returns the response status code and an error if the endpoint didn't accept the delivery
+func PostWebhook(client *http.Client, webhookURL, secret, event string, deliveryID int, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Cloud4Students-Webhooks")
+	req.Header.Set(WebhookEventHeader, event)
+	req.Header.Set(WebhookDeliveryHeader, strconv.Itoa(deliveryID))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(secret, payload))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook endpoint responded with %s", res.Status)
+	}
+
+	return res.StatusCode, nil
+}
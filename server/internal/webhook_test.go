@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateWebhookURL(t *testing.T) {
+	assert.NoError(t, ValidateWebhookURL("https://lms.example.com/hooks/c4s"))
+	assert.Error(t, ValidateWebhookURL("ftp://lms.example.com"))
+	assert.Error(t, ValidateWebhookURL("/hooks/c4s"))
+	assert.Error(t, ValidateWebhookURL("lms"))
+}
+
+func TestPostWebhook(t *testing.T) {
+	payload := []byte(`{"event":"ping"}`)
+
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("signed delivery", func(t *testing.T) {
+		client := NewWebhookClient(time.Second, true)
+		code, err := PostWebhook(client, server.URL, "secret", "ping", 7, payload)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, code)
+
+		assert.Equal(t, payload, body)
+		assert.Equal(t, "ping", received.Header.Get(WebhookEventHeader))
+		assert.Equal(t, "7", received.Header.Get(WebhookDeliveryHeader))
+		assert.Equal(t, SignWebhookPayload("secret", payload), received.Header.Get(WebhookSignatureHeader))
+	})
+
+	t.Run("endpoint failure", func(t *testing.T) {
+		client := NewWebhookClient(time.Second, true)
+		code, err := PostWebhook(client, server.URL+"/fail", "secret", "ping", 7, payload)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusBadGateway, code)
+	})
+
+	t.Run("private network", func(t *testing.T) {
+		client := NewWebhookClient(time.Second, false)
+		_, err := PostWebhook(client, server.URL, "secret", "ping", 7, payload)
+		assert.ErrorIs(t, err, errPrivateNetwork)
+	})
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	// HMAC-SHA256 of the payload with the secret "key"
+	signature := SignWebhookPayload("key", []byte("The quick brown fox jumps over the lazy dog"))
+	assert.Equal(t, "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", signature)
+}
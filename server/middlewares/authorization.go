@@ -3,19 +3,35 @@ package middlewares
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/codescalers/cloud4students/internal"
 	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
 
+// lastSeenInterval is the min time between the updates of the last seen time of a session or an access token
+const lastSeenInterval = time.Minute
+
 // UserIDKey key saved in request context
 type UserIDKey string
 
-// Authorization to authorize users in requests
-func Authorization(db models.DB, secret string, timeout int) func(http.Handler) http.Handler {
+// SessionIDKey key of the session of the access token saved in request context,
+// it is empty for requests authorized with personal access tokens
+type SessionIDKey string
+
+// AccessTokenScopesKey key of the scopes of the personal access token saved in request context,
+// it is not set for requests authorized with access tokens of sessions
+type AccessTokenScopesKey string
+
+// Authorization to authorize users in requests with access tokens of their sessions or personal access tokens,
+// access tokens of revoked or expired sessions are rejected
+func Authorization(db models.DB, secret string) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			reqToken := r.Header.Get("Authorization")
@@ -31,28 +47,138 @@ func Authorization(db models.DB, secret string, timeout int) func(http.Handler)
 			}
 			reqToken = splitToken[1]
 
-			claims, err := internal.ValidateJWTToken(reqToken, secret, timeout)
+			if strings.HasPrefix(reqToken, internal.AccessTokenPrefix) {
+				authorizeAccessToken(db, reqToken, h, w, r)
+				return
+			}
+
+			claims, err := internal.ValidateJWTToken(reqToken, secret)
 			if err != nil {
 				writeErrResponse(r, w, http.StatusUnauthorized, "user is not authorized")
 				return
 			}
-			ctx := context.WithValue(r.Context(), UserIDKey("UserID"), claims.UserID)
 
-			user, err := db.GetUserByID(claims.UserID)
+			if !verifiedUser(db, claims.UserID, w, r) {
+				return
+			}
+
+			session, err := db.GetActiveSession(claims.SessionID)
 			if err == gorm.ErrRecordNotFound {
-				writeErrResponse(r, w, http.StatusNotFound, "user is not found")
+				writeErrResponse(r, w, http.StatusUnauthorized, "user is not authorized")
 				return
 			}
 			if err != nil {
 				writeErrResponse(r, w, http.StatusInternalServerError, "internal server error")
 				return
 			}
-			if !user.Verified {
-				writeErrResponse(r, w, http.StatusBadRequest, "email is not verified yet, please check the verification email in your inbox")
+			if session.UserID != claims.UserID {
+				writeErrResponse(r, w, http.StatusUnauthorized, "user is not authorized")
 				return
 			}
 
+			if time.Since(session.LastSeenAt) > lastSeenInterval {
+				if err := db.TouchSession(session.ID, internal.ClientIP(r), time.Now()); err != nil {
+					log.Error().Err(err).Msgf("failed to update last seen time of session %s", session.ID)
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), UserIDKey("UserID"), claims.UserID)
+			ctx = context.WithValue(ctx, SessionIDKey("SessionID"), claims.SessionID)
+
 			h.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// authorizeAccessToken authorizes a request with a personal access token if it has the scope of the request
+func authorizeAccessToken(db models.DB, reqToken string, h http.Handler, w http.ResponseWriter, r *http.Request) {
+	token, err := db.GetActiveAccessToken(internal.HashToken(reqToken))
+	if err == gorm.ErrRecordNotFound {
+		writeErrResponse(r, w, http.StatusUnauthorized, "user is not authorized")
+		return
+	}
+	if err != nil {
+		writeErrResponse(r, w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if !verifiedUser(db, token.UserID, w, r) {
+		return
+	}
+
+	scope := requiredScope(r)
+	if scope == "" {
+		writeErrResponse(r, w, http.StatusForbidden, "personal access tokens can't be used for this request, please sign in")
+		return
+	}
+	if !slices.Contains(token.Scopes, scope) {
+		writeErrResponse(r, w, http.StatusForbidden, fmt.Sprintf("access token doesn't have the '%s' scope", scope))
+		return
+	}
+
+	if token.LastUsedAt == nil || time.Since(*token.LastUsedAt) > lastSeenInterval {
+		if err := db.TouchAccessToken(token.ID, time.Now()); err != nil {
+			log.Error().Err(err).Msgf("failed to update last used time of access token %d", token.ID)
+		}
+	}
+
+	ctx := context.WithValue(r.Context(), UserIDKey("UserID"), token.UserID)
+	ctx = context.WithValue(ctx, SessionIDKey("SessionID"), "")
+	ctx = context.WithValue(ctx, AccessTokenScopesKey("Scopes"), token.Scopes)
+
+	h.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// verifiedUser checks that the user of a request exists and is verified, the error response is written otherwise
+func verifiedUser(db models.DB, userID string, w http.ResponseWriter, r *http.Request) bool {
+	user, err := db.GetUserByID(userID)
+	if err == gorm.ErrRecordNotFound {
+		writeErrResponse(r, w, http.StatusNotFound, "user is not found")
+		return false
+	}
+	if err != nil {
+		writeErrResponse(r, w, http.StatusInternalServerError, "internal server error")
+		return false
+	}
+	if !user.Verified {
+		writeErrResponse(r, w, http.StatusBadRequest, "email is not verified yet, please check the verification email in your inbox")
+		return false
+	}
+
+	return true
+}
+
+// requiredScope returns the scope a personal access token needs for a request,
+// it is empty for requests that need a signed in session like managing the account and its tokens
+func requiredScope(r *http.Request) string {
+	// drop the version from the path
+	path := r.URL.Path
+	if parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2); len(parts) == 2 {
+		path = "/" + parts[1]
+	}
+
+	// reading the sessions, tokens, webhooks or 2fa of the account needs a session too
+	switch {
+	case hasPathPrefix(path, "/user/sessions"), hasPathPrefix(path, "/user/access_tokens"),
+		hasPathPrefix(path, "/user/2fa"), hasPathPrefix(path, "/webhooks"), path == "/notification/stream":
+		return ""
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return models.ScopeRead
+	}
+
+	switch {
+	case hasPathPrefix(path, "/vm"), hasPathPrefix(path, "/k8s"):
+		return models.ScopeDeploy
+	case path == "/user/apply_voucher", path == "/user/activate_voucher", hasPathPrefix(path, "/voucher"):
+		return models.ScopeVouchers
+	}
+
+	return ""
+}
+
+// hasPathPrefix checks if a path is the prefix or one of its sub paths
+func hasPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
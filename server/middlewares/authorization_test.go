@@ -0,0 +1,91 @@
+// Package middlewares for middleware between api and backend
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizationAccessTokens(t *testing.T) {
+	db := models.NewDB()
+	err := db.Connect(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	err = db.Migrate()
+	require.NoError(t, err)
+
+	user := models.User{Name: "user", Email: "user@gmail.com", Verified: true}
+	err = db.CreateUser(&user)
+	require.NoError(t, err)
+
+	createToken := func(scopes []string, expiresAt *time.Time) string {
+		token, hash, err := internal.GenerateAccessToken()
+		require.NoError(t, err)
+
+		err = db.CreateAccessToken(&models.AccessToken{UserID: user.ID.String(), Hash: hash, Scopes: scopes, ExpiresAt: expiresAt})
+		require.NoError(t, err)
+		return token
+	}
+
+	var gotUserID string
+	handler := Authorization(db, "secret")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID = r.Context().Value(UserIDKey("UserID")).(string)
+	}))
+
+	send := func(method, path, token string) int {
+		request := httptest.NewRequest(method, path, nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response.Code
+	}
+
+	readToken := createToken([]string{models.ScopeRead}, nil)
+	deployToken := createToken([]string{models.ScopeDeploy, models.ScopeVouchers}, nil)
+	expired := time.Now().Add(-time.Minute)
+	expiredToken := createToken([]string{models.ScopeRead}, &expired)
+
+	t.Run("read scope", func(t *testing.T) {
+		gotUserID = ""
+		assert.Equal(t, http.StatusOK, send(http.MethodGet, "/v1/vm", readToken))
+		assert.Equal(t, user.ID.String(), gotUserID)
+
+		assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "/v1/vm", readToken))
+	})
+
+	t.Run("deploy and vouchers scopes", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send(http.MethodPost, "/v1/vm", deployToken))
+		assert.Equal(t, http.StatusOK, send(http.MethodDelete, "/v1/k8s/1", deployToken))
+		assert.Equal(t, http.StatusOK, send(http.MethodPost, "/v1/user/apply_voucher", deployToken))
+		assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/v1/vm", deployToken))
+	})
+
+	t.Run("requests that need a session", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "/v1/user/access_tokens", deployToken))
+		assert.Equal(t, http.StatusForbidden, send(http.MethodPut, "/v1/user/change_password", deployToken))
+		assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "/v1/vmx", deployToken))
+	})
+
+	t.Run("reading the account needs a session", func(t *testing.T) {
+		for _, path := range []string{
+			"/v1/user/sessions", "/v1/user/access_tokens", "/v1/user/2fa/enroll",
+			"/v1/webhooks", "/v1/webhooks/1/deliveries", "/v1/notification/stream",
+		} {
+			assert.Equal(t, http.StatusForbidden, send(http.MethodGet, path, readToken), path)
+		}
+		assert.Equal(t, http.StatusForbidden, send(http.MethodDelete, "/v1/user/sessions/1", deployToken))
+		assert.Equal(t, http.StatusOK, send(http.MethodGet, "/v1/notification", readToken))
+	})
+
+	t.Run("invalid and expired tokens", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send(http.MethodGet, "/v1/vm", expiredToken))
+		assert.Equal(t, http.StatusUnauthorized, send(http.MethodGet, "/v1/vm", internal.AccessTokenPrefix+"unknown"))
+	})
+}
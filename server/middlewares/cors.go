@@ -20,7 +20,7 @@ func EnableCors(h http.Handler) http.Handler {
 func setupCorsResponse(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Authorization, Idempotency-Key")
 
 	if req.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
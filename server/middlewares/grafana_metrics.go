@@ -56,3 +56,12 @@ var Deletions = prometheus.NewCounterVec(
 	},
 	[]string{"user", "type"}, // labels
 )
+
+// Leader metrics
+var Leader = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "leader", // metric name
+		Help: "1 if the instance is the leader of the role.",
+	},
+	[]string{"role", "instance"}, // labels
+)
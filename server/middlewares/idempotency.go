@@ -0,0 +1,127 @@
+// Package middlewares for middleware between api and backend
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
+)
+
+// IdempotencyKeyHeader is the header clients send to make retrying a request safe
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyRecorder captures the response written by the handler
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *idempotencyRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *idempotencyRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Idempotency replays the stored response of requests retried with the same idempotency key
+// instead of handling them again
+func Idempotency(db models.DB, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > 255 {
+				writeErrResponse(r, w, http.StatusBadRequest, "idempotency key is too long")
+				return
+			}
+
+			userID := r.Context().Value(UserIDKey("UserID")).(string)
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeErrResponse(r, w, http.StatusBadRequest, "failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			hash := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+			requestHash := hex.EncodeToString(hash[:])
+
+			created, err := db.CreateIdempotencyKey(&models.IdempotencyKey{
+				Key:         key,
+				UserID:      userID,
+				RequestHash: requestHash,
+				ExpiresAt:   time.Now().Add(ttl),
+			})
+			if err != nil {
+				log.Error().Err(err).Send()
+				writeErrResponse(r, w, http.StatusInternalServerError, "something went wrong")
+				return
+			}
+
+			if !created {
+				replayIdempotentResponse(db, w, r, userID, key, requestHash)
+				return
+			}
+
+			recorder := &idempotencyRecorder{ResponseWriter: w}
+			h.ServeHTTP(recorder, r)
+
+			// server errors can be retried
+			if recorder.status >= http.StatusInternalServerError {
+				err = db.DeleteIdempotencyKey(userID, key)
+			} else {
+				err = db.UpdateIdempotencyResponse(userID, key, recorder.status, recorder.body.Bytes())
+			}
+			if err != nil {
+				log.Error().Err(err).Msgf("failed to store response of idempotency key '%s'", key)
+			}
+		})
+	}
+}
+
+func replayIdempotentResponse(db models.DB, w http.ResponseWriter, r *http.Request, userID, key, requestHash string) {
+	stored, err := db.GetIdempotencyKey(userID, key)
+	if err != nil {
+		log.Error().Err(err).Send()
+		writeErrResponse(r, w, http.StatusInternalServerError, "something went wrong")
+		return
+	}
+
+	if stored.RequestHash != requestHash {
+		writeErrResponse(r, w, http.StatusUnprocessableEntity, "idempotency key is already used with a different request")
+		return
+	}
+
+	if stored.Status == 0 {
+		writeErrResponse(r, w, http.StatusConflict, "a request with the same idempotency key is still being processed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.Status)
+	if _, err := w.Write(stored.Body); err != nil {
+		log.Error().Err(err).Msg("failed to write stored response")
+	}
+
+	Requests.WithLabelValues(r.Method, r.RequestURI, fmt.Sprint(stored.Status)).Inc()
+}
@@ -0,0 +1,78 @@
+// Package middlewares for middleware between api and backend
+package middlewares
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotency(t *testing.T) {
+	db := models.NewDB()
+	err := db.Connect(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	err = db.Migrate()
+	require.NoError(t, err)
+
+	calls := 0
+	handler := Idempotency(db, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		if string(body) == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(body)
+	}))
+
+	send := func(key, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/vm", strings.NewReader(body))
+		if key != "" {
+			request.Header.Set(IdempotencyKeyHeader, key)
+		}
+		request = request.WithContext(context.WithValue(request.Context(), UserIDKey("UserID"), "user"))
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	t.Run("no key", func(t *testing.T) {
+		calls = 0
+		send("", "vm")
+		send("", "vm")
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("retry gets the stored response", func(t *testing.T) {
+		calls = 0
+		first := send("key", "vm")
+		retry := send("key", "vm")
+
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	})
+
+	t.Run("same key with a different request", func(t *testing.T) {
+		response := send("key", "another vm")
+		assert.Equal(t, http.StatusUnprocessableEntity, response.Code)
+	})
+
+	t.Run("server errors can be retried", func(t *testing.T) {
+		calls = 0
+		send("failing", "fail")
+		send("failing", "fail")
+		assert.Equal(t, 2, calls)
+	})
+}
@@ -0,0 +1,50 @@
+// Package middlewares for middleware between api and backend
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// RequirePermission authorizes users with a role having the permission in requests,
+// if two-factor authentication is required the users must enable it first.
+// Personal access tokens need the admin scope too
+func RequirePermission(db models.DB, permission string, requireTwoFactor bool) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, ok := r.Context().Value(AccessTokenScopesKey("Scopes")).([]string)
+			if ok && !slices.Contains(scopes, models.ScopeAdmin) {
+				writeErrResponse(r, w, http.StatusForbidden, fmt.Sprintf("access token doesn't have the '%s' scope", models.ScopeAdmin))
+				return
+			}
+
+			userID := r.Context().Value(UserIDKey("UserID")).(string)
+			user, err := db.GetUserByID(userID)
+			if err == gorm.ErrRecordNotFound {
+				writeErrResponse(r, w, http.StatusNotFound, "user is not found")
+				return
+			}
+			if err != nil {
+				log.Error().Err(err).Send()
+				writeErrResponse(r, w, http.StatusInternalServerError, "something went wrong")
+				return
+			}
+
+			if !models.HasPermission(user.Roles, permission) {
+				writeErrResponse(r, w, http.StatusForbidden, fmt.Sprintf("user '%s' doesn't have the '%s' permission", user.Name, permission))
+				return
+			}
+
+			if requireTwoFactor && !user.TOTPEnabled {
+				writeErrResponse(r, w, http.StatusForbidden, "admins must enable two-factor authentication first")
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+// Package middlewares for middleware between api and backend
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequirePermission(t *testing.T) {
+	db := models.NewDB()
+	err := db.Connect(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	err = db.Migrate()
+	require.NoError(t, err)
+
+	reviewer := models.User{Name: "reviewer", Email: "reviewer@gmail.com", Verified: true, Roles: []string{models.VoucherReviewerRole}}
+	err = db.CreateUser(&reviewer)
+	require.NoError(t, err)
+
+	send := func(permission, userID string, requireTwoFactor bool) int {
+		handler := RequirePermission(db, permission, requireTwoFactor)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		request := httptest.NewRequest(http.MethodGet, "/v1/voucher", nil)
+		request = request.WithContext(context.WithValue(request.Context(), UserIDKey("UserID"), userID))
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response.Code
+	}
+
+	t.Run("role has the permission", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send(models.PermissionReviewVouchers, reviewer.ID.String(), false))
+	})
+
+	t.Run("role doesn't have the permission", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, send(models.PermissionDeleteDeployments, reviewer.ID.String(), false))
+		assert.Equal(t, http.StatusForbidden, send(models.PermissionManageRoles, reviewer.ID.String(), false))
+	})
+
+	t.Run("two-factor authentication is required", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, send(models.PermissionReviewVouchers, reviewer.ID.String(), true))
+	})
+
+	t.Run("access token without the admin scope", func(t *testing.T) {
+		handler := RequirePermission(db, models.PermissionReviewVouchers, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		sendWithScopes := func(scopes []string) int {
+			request := httptest.NewRequest(http.MethodGet, "/v1/voucher", nil)
+			ctx := context.WithValue(request.Context(), UserIDKey("UserID"), reviewer.ID.String())
+			ctx = context.WithValue(ctx, AccessTokenScopesKey("Scopes"), scopes)
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request.WithContext(ctx))
+			return response.Code
+		}
+
+		assert.Equal(t, http.StatusForbidden, sendWithScopes([]string{models.ScopeRead}))
+		assert.Equal(t, http.StatusOK, sendWithScopes([]string{models.ScopeRead, models.ScopeAdmin}))
+	})
+
+	t.Run("user not found", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, send(models.PermissionReviewVouchers, "unknown", false))
+	})
+}
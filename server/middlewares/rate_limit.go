@@ -0,0 +1,167 @@
+// Package middlewares for middleware between api and backend
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/rs/zerolog/log"
+)
+
+// RateLimitStore counts hits of keys in fixed windows and locks keys out,
+// it is shared by all instances of the server
+type RateLimitStore interface {
+	// Hit counts a hit of the key and returns its count in the current window and the time left of the window
+	Hit(key string, window time.Duration) (int64, time.Duration, error)
+	// Lock locks the key out for a duration
+	Lock(key string, duration time.Duration) error
+	// LockedFor returns the time left of the lockout of the key, zero if it is not locked out
+	LockedFor(key string) (time.Duration, error)
+	// Reset deletes the counters and lockouts of the keys
+	Reset(keys ...string) error
+}
+
+// RateLimit limits the requests to a route per client ip and per the email in the request body,
+// requests over the limit are refused with the time left of the window in the Retry-After header
+func RateLimit(store RateLimitStore, route string, limit internal.RateLimit) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			window := time.Duration(limit.WindowSeconds) * time.Second
+
+			if limit.IPRequests > 0 {
+				key := fmt.Sprintf("ratelimit:%s:ip:%s", route, internal.ClientIP(r))
+				if retryAfter, limited := overLimit(store, key, limit.IPRequests, window); limited {
+					WriteTooManyRequests(r, w, retryAfter)
+					return
+				}
+			}
+
+			if limit.EmailRequests > 0 {
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					writeErrResponse(r, w, http.StatusBadRequest, "failed to read request body")
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+
+				var input struct {
+					Email string `json:"email"`
+				}
+				// invalid bodies are refused by the handler
+				_ = json.Unmarshal(body, &input)
+
+				if email := NormalizeEmail(input.Email); email != "" {
+					key := fmt.Sprintf("ratelimit:%s:email:%s", route, email)
+					if retryAfter, limited := overLimit(store, key, limit.EmailRequests, window); limited {
+						WriteTooManyRequests(r, w, retryAfter)
+						return
+					}
+				}
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// overLimit counts a hit of the key and checks if it exceeds the limit of its window,
+// requests are not limited if the store fails so signing in doesn't depend on it
+func overLimit(store RateLimitStore, key string, limit int, window time.Duration) (time.Duration, bool) {
+	count, retryAfter, err := store.Hit(key, window)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to count hit of %s", key)
+		return 0, false
+	}
+
+	return retryAfter, count > int64(limit)
+}
+
+// NormalizeEmail returns the email in the form used in rate limiting and lockout keys
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// RetryAfter returns the value of the Retry-After header in whole seconds
+func RetryAfter(d time.Duration) string {
+	return fmt.Sprint(int(math.Max(1, math.Ceil(d.Seconds()))))
+}
+
+// WriteTooManyRequests writes a too many requests response with the time the client should wait
+func WriteTooManyRequests(r *http.Request, w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", RetryAfter(retryAfter))
+	writeErrResponse(r, w, http.StatusTooManyRequests, "too many requests, please try again later")
+}
+
+// memoryRateLimitStore keeps the counters in memory
+type memoryRateLimitStore struct {
+	mu       sync.Mutex
+	counters map[string]memoryCounter
+}
+
+type memoryCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// NewMemoryRateLimitStore creates a rate limit store that keeps the counters in memory,
+// it can only be used if a single instance of the server is running
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{counters: map[string]memoryCounter{}}
+}
+
+func (s *memoryRateLimitStore) Hit(key string, window time.Duration) (int64, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, ok := s.counters[key]
+	if !ok || !time.Now().Before(counter.expiresAt) {
+		counter = memoryCounter{expiresAt: time.Now().Add(window)}
+	}
+	counter.count++
+	s.counters[key] = counter
+
+	return counter.count, time.Until(counter.expiresAt), nil
+}
+
+func (s *memoryRateLimitStore) Lock(key string, duration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counters[key] = memoryCounter{count: 1, expiresAt: time.Now().Add(duration)}
+	return nil
+}
+
+func (s *memoryRateLimitStore) LockedFor(key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, ok := s.counters[key]
+	if !ok || !time.Now().Before(counter.expiresAt) {
+		return 0, nil
+	}
+
+	return time.Until(counter.expiresAt), nil
+}
+
+func (s *memoryRateLimitStore) Reset(keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.counters, key)
+	}
+	return nil
+}
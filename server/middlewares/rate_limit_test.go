@@ -0,0 +1,124 @@
+// Package middlewares for middleware between api and backend
+package middlewares
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codescalers/cloud4students/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := internal.RateLimit{IPRequests: 3, EmailRequests: 2, WindowSeconds: 60}
+
+	handler := RateLimit(store, "signin", limit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(ip, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/user/signin", strings.NewReader(body))
+		request.RemoteAddr = ip + ":4321"
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	t.Run("per email", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send("10.0.0.1", `{"email": "user@uni.edu"}`).Code)
+		assert.Equal(t, http.StatusOK, send("10.0.0.2", `{"email": "User@uni.edu "}`).Code)
+
+		response := send("10.0.0.3", `{"email": "user@uni.edu"}`)
+		assert.Equal(t, http.StatusTooManyRequests, response.Code)
+		assert.Equal(t, "60", response.Header().Get("Retry-After"))
+
+		assert.Equal(t, http.StatusOK, send("10.0.0.3", `{"email": "another@uni.edu"}`).Code)
+	})
+
+	t.Run("per ip", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusOK, send("10.0.0.4", `{}`).Code)
+		}
+
+		response := send("10.0.0.4", `{}`)
+		assert.Equal(t, http.StatusTooManyRequests, response.Code)
+		assert.NotEmpty(t, response.Header().Get("Retry-After"))
+	})
+
+	t.Run("spoofed ip headers of untrusted peers", func(t *testing.T) {
+		proxies, err := internal.ParseTrustedProxies([]string{"10.0.0.1"})
+		assert.NoError(t, err)
+		handler := RealIP(proxies)(handler)
+
+		spoof := func(peer, ip string) int {
+			request := httptest.NewRequest("POST", "/user/signin", strings.NewReader(`{}`))
+			request.RemoteAddr = peer + ":4321"
+			request.Header.Set("X-Real-IP", ip)
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+			return response.Code
+		}
+
+		// a new header doesn't give a new bucket
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusOK, spoof("10.0.0.5", fmt.Sprintf("203.0.113.%d", i)))
+		}
+		assert.Equal(t, http.StatusTooManyRequests, spoof("10.0.0.5", "203.0.113.9"))
+
+		// the header of the trusted proxy is honored
+		assert.Equal(t, http.StatusOK, spoof("10.0.0.1", "203.0.113.9"))
+	})
+
+	t.Run("handler reads the body", func(t *testing.T) {
+		handler := RateLimit(store, "signup", limit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			_, _ = w.Write(body)
+		}))
+
+		request := httptest.NewRequest("POST", "/user/signup", strings.NewReader(`{"email": "user@uni.edu"}`))
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		assert.Equal(t, `{"email": "user@uni.edu"}`, response.Body.String())
+	})
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+
+	t.Run("window expires", func(t *testing.T) {
+		count, _, err := store.Hit("key", time.Millisecond)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		time.Sleep(2 * time.Millisecond)
+		count, _, err = store.Hit("key", time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("lock", func(t *testing.T) {
+		lockedFor, err := store.LockedFor("lock")
+		assert.NoError(t, err)
+		assert.Zero(t, lockedFor)
+
+		err = store.Lock("lock", time.Minute)
+		assert.NoError(t, err)
+
+		lockedFor, err = store.LockedFor("lock")
+		assert.NoError(t, err)
+		assert.Greater(t, lockedFor, 59*time.Second)
+
+		err = store.Reset("lock")
+		assert.NoError(t, err)
+
+		lockedFor, err = store.LockedFor("lock")
+		assert.NoError(t, err)
+		assert.Zero(t, lockedFor)
+	})
+}
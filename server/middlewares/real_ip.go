@@ -0,0 +1,17 @@
+// Package middlewares for middleware between api and backend
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/codescalers/cloud4students/internal"
+)
+
+// RealIP resolves the ip of the client of every request, the forwarded headers are only honored from the trusted proxies
+func RealIP(proxies internal.TrustedProxies) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, internal.WithClientIP(r, proxies.ResolveClientIP(r)))
+		})
+	}
+}
@@ -0,0 +1,34 @@
+// Package models for database models
+package models
+
+import "time"
+
+// scopes of personal access tokens
+const (
+	// ScopeRead allows reading requests
+	ScopeRead = "read"
+	// ScopeDeploy allows deploying and deleting vms and kubernetes clusters
+	ScopeDeploy = "deploy"
+	// ScopeVouchers allows applying for and activating vouchers, and managing vouchers for admins
+	ScopeVouchers = "vouchers"
+	// ScopeAdmin allows the requests of admin endpoints along with the scope of each request
+	ScopeAdmin = "admin"
+)
+
+// AccessTokenScopes are the scopes a personal access token can have
+var AccessTokenScopes = []string{ScopeRead, ScopeDeploy, ScopeVouchers, ScopeAdmin}
+
+// AccessToken struct holds a personal access token of a user, only its hash is stored
+type AccessToken struct {
+	ID     int    `json:"id" gorm:"primaryKey"`
+	UserID string `json:"user_id" gorm:"index"`
+	Name   string `json:"name"`
+	// the first characters of the token to recognize it
+	Prefix string   `json:"prefix"`
+	Hash   string   `json:"-" gorm:"uniqueIndex"`
+	Scopes []string `json:"scopes" gorm:"serializer:json"`
+	// the token never expires if it is not set
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
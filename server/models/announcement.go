@@ -0,0 +1,44 @@
+// Package models for database models
+package models
+
+import "time"
+
+const (
+	// AnnouncementScheduled the announcement is waiting for its send time
+	AnnouncementScheduled = "scheduled"
+	// AnnouncementSending the announcement is being sent to its users
+	AnnouncementSending = "sending"
+	// AnnouncementSent the announcement is sent to all its users
+	AnnouncementSent = "sent"
+)
+
+// Announcement struct holds an admin announcement sent to users as mails and notifications
+type Announcement struct {
+	ID      int    `json:"id" gorm:"primaryKey"`
+	Subject string `json:"subject" binding:"required"`
+	Body    string `json:"announcement" binding:"required"`
+	// targeted users, all verified users are targeted if no filter is set
+	College           string   `json:"college,omitempty"`
+	MinTeamSize       int      `json:"min_team_size,omitempty"`
+	MaxTeamSize       int      `json:"max_team_size,omitempty"`
+	ActiveDeployments bool     `json:"active_deployments,omitempty"`
+	Emails            []string `json:"emails,omitempty" gorm:"serializer:json"`
+	// pinned announcements are listed first in the announcements feed
+	Pinned bool `json:"pinned"`
+	// public announcements are shown to unauthenticated visitors too
+	Public bool   `json:"public"`
+	Status string `json:"status" gorm:"index"`
+	// progress of sending the announcement
+	Total  int `json:"total"`
+	Sent   int `json:"sent"`
+	Failed int `json:"failed"`
+	// id of the last user the announcement is sent to, sending is resumed after it
+	LastUserID string    `json:"-"`
+	SendAt     time.Time `json:"send_at" gorm:"index"`
+	// the announcement is hidden from the announcements feed after it expires, it never expires if not set
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// the announcement can be claimed again after this time in case its worker stopped
+	ClaimedUntil time.Time `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
@@ -9,5 +9,7 @@ import (
 type Claims struct {
 	UserID string `json:"user_id"`
 	Email  string `json:"email"`
+	// access tokens of revoked sessions are rejected
+	SessionID string `json:"session_id"`
 	jwt.RegisteredClaims
 }
@@ -2,6 +2,8 @@
 package models
 
 import (
+	"encoding/json"
+	"slices"
 	"time"
 
 	"gorm.io/driver/sqlite"
@@ -13,6 +15,8 @@ import (
 // DB struct hold db instance
 type DB struct {
 	db *gorm.DB
+	// called with every created notification
+	notificationHook func(Notification)
 }
 
 // NewDB creates new DB
@@ -32,11 +36,15 @@ func (d *DB) Connect(file string) error {
 
 // Migrate migrates db schema
 func (d *DB) Migrate() error {
-	err := d.db.AutoMigrate(&User{}, &Quota{}, &VM{}, &K8sCluster{}, &Master{}, &Worker{}, &Voucher{}, &Maintenance{}, &Notification{})
+	err := d.db.AutoMigrate(&User{}, &Quota{}, &VM{}, &K8sCluster{}, &Master{}, &Worker{}, &Voucher{}, &Maintenance{}, &Notification{}, &DeploymentRequest{}, &IdempotencyKey{}, &OutgoingMail{}, &EmailTemplate{}, &Announcement{}, &Webhook{}, &WebhookDelivery{}, &NotificationPreferences{}, &Session{}, &RefreshToken{}, &RecoveryCode{}, &TwoFactorChallenge{}, &AccessToken{}, &OIDCIdentity{}, &OIDCLoginState{}, &VerificationToken{})
 	if err != nil {
 		return err
 	}
 
+	if err := d.migrateAdmins(); err != nil {
+		return err
+	}
+
 	// add maintenance
 	if err := d.db.Delete(&Maintenance{}, "1 = 1").Error; err != nil {
 		return err
@@ -44,6 +52,21 @@ func (d *DB) Migrate() error {
 	return d.db.Create(&Maintenance{}).Error
 }
 
+// migrateAdmins gives the super admin role to the users of the removed admin column
+func (d *DB) migrateAdmins() error {
+	if !d.db.Migrator().HasColumn(&User{}, "admin") {
+		return nil
+	}
+
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("admin = true").Select("roles").Updates(User{Roles: []string{SuperAdminRole}}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn(&User{}, "admin")
+	})
+}
+
 // CreateUser creates new user
 func (d *DB) CreateUser(u *User) error {
 	result := d.db.Create(&u)
@@ -112,20 +135,21 @@ func (d *DB) CountAllDeployments() (DeploymentsCount, error) {
 	}, result.Error
 }
 
-// ListAdmins gets all admins
-func (d *DB) ListAdmins() ([]User, error) {
-	var admins []User
-	return admins, d.db.Where("admin = true and verified = true").Find(&admins).Error
+// ListUsersWithPermission gets all verified users with a role having the permission
+func (d *DB) ListUsersWithPermission(permission string) ([]User, error) {
+	var users []User
+	return users, d.usersWithPermission(permission).Find(&users).Error
 }
 
-// GetCodeByEmail returns verification code for unit testing
-func (d *DB) GetCodeByEmail(email string) (int, error) {
-	var res User
-	query := d.db.First(&res, "email = ?", email)
-	if query.Error != nil {
-		return 0, query.Error
+// usersWithPermission queries the verified users with a role having the permission,
+// all queries of users by their roles go through it
+func (d *DB) usersWithPermission(permission string) *gorm.DB {
+	roles := RolesWithPermission(permission)
+	if len(roles) == 0 {
+		return d.db.Model(&User{}).Where("1 = 0")
 	}
-	return res.Code, nil
+	return d.db.Model(&User{}).Where("verified = true").
+		Where("EXISTS (SELECT 1 FROM json_each(users.roles) WHERE value IN ?)", roles)
 }
 
 // UpdatePassword updates password of user
@@ -144,9 +168,14 @@ func (d *DB) UpdateUserByID(user User) error {
 	return result.Error
 }
 
-// UpdateAdminUserByID updates admin information of user.
-func (d *DB) UpdateAdminUserByID(id string, admin bool) error {
-	return d.db.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{"admin": admin, "updated_at": time.Now()}).Error
+// UpdateUserRoles replaces the roles of a user
+func (d *DB) UpdateUserRoles(id string, roles []string) error {
+	return d.db.Model(&User{}).Where("id = ?", id).Select("roles", "updated_at").Updates(User{Roles: roles, UpdatedAt: time.Now()}).Error
+}
+
+// UpdateUserEmail changes the email of a user
+func (d *DB) UpdateUserEmail(id, email string) error {
+	return d.db.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{"email": email, "updated_at": time.Now()}).Error
 }
 
 // UpdateVerification updates if user is verified or not
@@ -376,19 +405,939 @@ func (d *DB) GetMaintenance() (Maintenance, error) {
 
 // notifications
 
-// ListNotifications returns a list of notifications for a user.
-func (d *DB) ListNotifications(userID string) ([]Notification, error) {
+// ListNotifications returns the notifications of a user matching the filter, newest first
+func (d *DB) ListNotifications(userID string, filter NotificationsFilter) ([]Notification, error) {
 	var res []Notification
-	query := d.db.Where("user_id = ?", userID).Find(&res)
+	query := d.db.Where("user_id = ?", userID)
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.Unread {
+		query = query.Where("seen = false")
+	}
+	if filter.Before != 0 {
+		query = query.Where("id < ?", filter.Before)
+	}
+	if filter.Limit != 0 {
+		query = query.Limit(filter.Limit)
+	}
+	query = query.Order("id desc").Find(&res)
 	return res, query.Error
 }
 
-// UpdateNotification updates seen field for notification
-func (d *DB) UpdateNotification(id int, seen bool) error {
-	return d.db.Model(&Notification{}).Where("id = ?", id).Updates(map[string]interface{}{"seen": seen}).Error
+// CountUnreadNotifications returns the number of unseen notifications of a user, of a type if given
+func (d *DB) CountUnreadNotifications(userID, notificationType string) (int64, error) {
+	var count int64
+	query := d.db.Model(&Notification{}).Where("user_id = ? AND seen = false", userID)
+	if notificationType != "" {
+		query = query.Where("type = ?", notificationType)
+	}
+	return count, query.Count(&count).Error
+}
+
+// UpdateNotification updates seen field for a notification of a user
+func (d *DB) UpdateNotification(userID string, id int, seen bool) error {
+	result := d.db.Model(&Notification{}).Where("id = ? AND user_id = ?", id, userID).Updates(map[string]interface{}{"seen": seen})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+// MarkAllNotificationsSeen marks all notifications of a user as seen, of a type if given
+func (d *DB) MarkAllNotificationsSeen(userID, notificationType string) error {
+	query := d.db.Model(&Notification{}).Where("user_id = ? AND seen = false", userID)
+	if notificationType != "" {
+		query = query.Where("type = ?", notificationType)
+	}
+	return query.Update("seen", true).Error
+}
+
+// DeleteNotification deletes a notification of a user
+func (d *DB) DeleteNotification(userID string, id int) error {
+	result := d.db.Where("id = ? AND user_id = ?", id, userID).Delete(&Notification{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+// ListNotificationsAfter returns the notifications of a user created after the notification with the given id
+func (d *DB) ListNotificationsAfter(userID string, id int) ([]Notification, error) {
+	var res []Notification
+	query := d.db.Where("user_id = ? AND id > ?", userID, id).Order("id").Find(&res)
+	return res, query.Error
+}
+
+// OnNotificationCreated sets a hook called with every created notification, it must be set before the DB is copied
+func (d *DB) OnNotificationCreated(hook func(Notification)) {
+	d.notificationHook = hook
+}
+
+// GetNotificationPreferences returns the notification preferences of a user, all channels are enabled if the user didn't set them
+func (d *DB) GetNotificationPreferences(userID string) (NotificationPreferences, error) {
+	res := NotificationPreferences{UserID: userID}
+	err := d.db.Where("user_id = ?", userID).Limit(1).Find(&res).Error
+	return res, err
+}
+
+// UpdateNotificationPreferences creates or updates the notification preferences of a user
+func (d *DB) UpdateNotificationPreferences(p NotificationPreferences) error {
+	return d.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"categories", "email_digest", "last_digest_notification_id", "last_digest_at"}),
+	}).Create(&p).Error
+}
+
+// ListDueDigests returns the preferences of users with a digest last sent before the given time
+func (d *DB) ListDueDigests(before time.Time) ([]NotificationPreferences, error) {
+	var res []NotificationPreferences
+	query := d.db.Where("email_digest = true AND last_digest_at <= ?", before).Find(&res)
+	return res, query.Error
+}
+
+// SetLastDigest records the last notification sent in the digest of a user
+func (d *DB) SetLastDigest(userID string, notificationID int, sentAt time.Time) error {
+	return d.db.Model(&NotificationPreferences{}).Where("user_id = ?", userID).
+		Updates(map[string]interface{}{"last_digest_notification_id": notificationID, "last_digest_at": sentAt}).Error
 }
 
 // CreateNotification adds a new notification for a user
 func (d *DB) CreateNotification(n *Notification) error {
-	return d.db.Create(&n).Error
+	err := d.db.Create(&n).Error
+	if err == nil && d.notificationHook != nil {
+		d.notificationHook(*n)
+	}
+	return err
+}
+
+// deployment requests
+
+// CreateDeploymentRequest adds a new queued deployment request
+func (d *DB) CreateDeploymentRequest(r *DeploymentRequest) error {
+	r.Phase = QueuedPhase
+	return d.db.Create(&r).Error
+}
+
+// GetDeploymentRequest returns a deployment request by its id
+func (d *DB) GetDeploymentRequest(id string) (DeploymentRequest, error) {
+	var res DeploymentRequest
+	query := d.db.First(&res, "id = ?", id)
+	return res, query.Error
+}
+
+// StartDeploymentRequest marks a request as picked up by the deployer and counts the attempt
+func (d *DB) StartDeploymentRequest(id string) error {
+	return d.db.Model(&DeploymentRequest{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"phase": PickingNodePhase, "attempts": gorm.Expr("attempts + 1"), "updated_at": time.Now()}).Error
+}
+
+// UpdateDeploymentRequestPhase updates the phase of deployment requests
+func (d *DB) UpdateDeploymentRequestPhase(phase string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return d.db.Model(&DeploymentRequest{}).Where("id IN ?", ids).Updates(map[string]interface{}{"phase": phase, "updated_at": time.Now()}).Error
+}
+
+// FailDeploymentRequest marks a request as failed with the failure reason
+func (d *DB) FailDeploymentRequest(id string, reason string) error {
+	return d.db.Model(&DeploymentRequest{}).Where("id = ?", id).Updates(map[string]interface{}{"phase": FailedPhase, "error": reason, "updated_at": time.Now()}).Error
+}
+
+// ListStartedDeploymentRequests returns the ids of the given requests that are started and not finished
+func (d *DB) ListStartedDeploymentRequests(ids []string) ([]string, error) {
+	res := []string{}
+	if len(ids) == 0 {
+		return res, nil
+	}
+	query := d.db.Model(&DeploymentRequest{}).
+		Where("id IN ? AND phase NOT IN ?", ids, []string{QueuedPhase, DeployedPhase, FailedPhase}).
+		Pluck("id", &res)
+	return res, query.Error
+}
+
+// idempotency keys
+
+// CreateIdempotencyKey stores a new idempotency key, it returns false if the key already exists
+func (d *DB) CreateIdempotencyKey(k *IdempotencyKey) (bool, error) {
+	// expired keys can be used again
+	err := d.db.Where("expires_at < ?", time.Now()).Delete(&IdempotencyKey{}).Error
+	if err != nil {
+		return false, err
+	}
+
+	result := d.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&k)
+	return result.RowsAffected == 1, result.Error
+}
+
+// GetIdempotencyKey returns an idempotency key of a user
+func (d *DB) GetIdempotencyKey(userID, key string) (IdempotencyKey, error) {
+	var res IdempotencyKey
+	query := d.db.First(&res, "user_id = ? AND key = ?", userID, key)
+	return res, query.Error
+}
+
+// UpdateIdempotencyResponse stores the response of the request sent with the idempotency key
+func (d *DB) UpdateIdempotencyResponse(userID, key string, status int, body []byte) error {
+	return d.db.Model(&IdempotencyKey{}).Where("user_id = ? AND key = ?", userID, key).Updates(map[string]interface{}{"status": status, "body": body}).Error
+}
+
+// DeleteIdempotencyKey deletes an idempotency key of a user
+func (d *DB) DeleteIdempotencyKey(userID, key string) error {
+	return d.db.Where("user_id = ? AND key = ?", userID, key).Delete(&IdempotencyKey{}).Error
+}
+
+// outgoing mails
+
+// EnqueueMail queues a new mail to be sent by the mails worker
+func (d *DB) EnqueueMail(receiver, subject, body string) error {
+	m := OutgoingMail{
+		Receiver:      receiver,
+		Subject:       subject,
+		Body:          body,
+		Status:        MailPending,
+		NextAttemptAt: time.Now(),
+	}
+	return d.db.Create(&m).Error
+}
+
+// ClaimDueMails claims mails due to be sent so no other worker sends them,
+// a claimed mail is due again after the claim timeout in case its worker stopped
+func (d *DB) ClaimDueMails(limit int, claimTimeout time.Duration) ([]OutgoingMail, error) {
+	now := time.Now()
+	dueQuery := "status IN ? AND next_attempt_at <= ?"
+	dueStatuses := []string{MailPending, MailSending}
+
+	var due []OutgoingMail
+	err := d.db.Where(dueQuery, dueStatuses, now).Order("next_attempt_at").Limit(limit).Find(&due).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []OutgoingMail
+	for _, m := range due {
+		// the mail is not due anymore if another worker claimed it first
+		result := d.db.Model(&OutgoingMail{}).Where("id = ?", m.ID).Where(dueQuery, dueStatuses, now).
+			Updates(map[string]interface{}{"status": MailSending, "next_attempt_at": now.Add(claimTimeout)})
+		if result.Error != nil {
+			return claimed, result.Error
+		}
+
+		if result.RowsAffected == 1 {
+			m.Status = MailSending
+			claimed = append(claimed, m)
+		}
+	}
+
+	return claimed, nil
+}
+
+// MarkMailSent marks a mail as sent and deletes its body, so the codes and links it carries aren't kept
+func (d *DB) MarkMailSent(id int) error {
+	return d.db.Model(&OutgoingMail{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"status": MailSent, "attempts": gorm.Expr("attempts + 1"), "error": "", "sent_at": time.Now(), "body": ""}).Error
+}
+
+// RetryMail records a failed attempt and schedules the next one
+func (d *DB) RetryMail(id int, reason string, nextAttemptAt time.Time) error {
+	return d.db.Model(&OutgoingMail{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"status": MailPending, "attempts": gorm.Expr("attempts + 1"), "error": reason, "next_attempt_at": nextAttemptAt}).Error
+}
+
+// FailMail records the last failed attempt of a mail
+func (d *DB) FailMail(id int, reason string) error {
+	return d.db.Model(&OutgoingMail{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"status": MailFailed, "attempts": gorm.Expr("attempts + 1"), "error": reason}).Error
+}
+
+// ListOutgoingMails returns the filtered page of outgoing mails, newest first
+func (d *DB) ListOutgoingMails(filter MailsFilter) ([]OutgoingMail, error) {
+	var res []OutgoingMail
+	query := d.db.Order("id desc")
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Before != 0 {
+		query = query.Where("id < ?", filter.Before)
+	}
+	if filter.Limit != 0 {
+		query = query.Limit(filter.Limit)
+	}
+	return res, query.Find(&res).Error
+}
+
+// GetOutgoingMail returns an outgoing mail by its id
+func (d *DB) GetOutgoingMail(id int) (OutgoingMail, error) {
+	var res OutgoingMail
+	query := d.db.First(&res, id)
+	return res, query.Error
+}
+
+// DeleteOldMails deletes the sent and failed mails created before a time
+func (d *DB) DeleteOldMails(before time.Time) (int64, error) {
+	result := d.db.Where("status IN ? AND created_at < ?", []string{MailSent, MailFailed}, before).Delete(&OutgoingMail{})
+	return result.RowsAffected, result.Error
+}
+
+// ResendMail queues a mail to be sent again with new attempts
+func (d *DB) ResendMail(id int) error {
+	return d.db.Model(&OutgoingMail{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"status": MailPending, "attempts": 0, "error": "", "next_attempt_at": time.Now()}).Error
+}
+
+// email templates
+
+// SeedEmailTemplates adds the given templates if they don't exist, edited templates are kept
+func (d *DB) SeedEmailTemplates(templates []EmailTemplate) error {
+	if len(templates) == 0 {
+		return nil
+	}
+	return d.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&templates).Error
+}
+
+// GetEmailTemplate returns a mail template in a language
+func (d *DB) GetEmailTemplate(name, language string) (EmailTemplate, error) {
+	var res EmailTemplate
+	query := d.db.First(&res, "name = ? AND language = ?", name, language)
+	return res, query.Error
+}
+
+// ListEmailTemplates returns all mail templates
+func (d *DB) ListEmailTemplates() ([]EmailTemplate, error) {
+	var res []EmailTemplate
+	query := d.db.Order("name, language").Find(&res)
+	return res, query.Error
+}
+
+// CountEmailTemplatesByLanguage returns the number of mail templates in each language
+func (d *DB) CountEmailTemplatesByLanguage() (map[string]int, error) {
+	var rows []struct {
+		Language string
+		Count    int
+	}
+	query := d.db.Model(&EmailTemplate{}).Select("language, count(*) as count").Group("language").Scan(&rows)
+	if query.Error != nil {
+		return nil, query.Error
+	}
+
+	res := make(map[string]int, len(rows))
+	for _, row := range rows {
+		res[row.Language] = row.Count
+	}
+	return res, nil
+}
+
+// UpsertEmailTemplate creates a mail template or updates its subject and body if it exists
+func (d *DB) UpsertEmailTemplate(t *EmailTemplate) error {
+	return d.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}, {Name: "language"}},
+		DoUpdates: clause.AssignmentColumns([]string{"subject", "body", "updated_at"}),
+	}).Create(t).Error
+}
+
+// DeleteEmailTemplate deletes a mail template in a language
+func (d *DB) DeleteEmailTemplate(name, language string) error {
+	result := d.db.Where("name = ? AND language = ?", name, language).Delete(&EmailTemplate{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+// announcements
+
+// CreateAnnouncement adds a new announcement to be sent at its send time
+func (d *DB) CreateAnnouncement(a *Announcement) error {
+	a.Status = AnnouncementScheduled
+	return d.db.Create(a).Error
+}
+
+// GetAnnouncement returns an announcement by its id
+func (d *DB) GetAnnouncement(id int) (Announcement, error) {
+	var res Announcement
+	query := d.db.First(&res, id)
+	return res, query.Error
+}
+
+// ListAnnouncements returns all announcements, newest first
+func (d *DB) ListAnnouncements() ([]Announcement, error) {
+	var res []Announcement
+	query := d.db.Order("id desc").Find(&res)
+	return res, query.Error
+}
+
+// ClaimDueAnnouncements claims the announcements due to be sent so no other worker sends them,
+// a claimed announcement is due again after the claim timeout in case its worker stopped
+func (d *DB) ClaimDueAnnouncements(claimTimeout time.Duration) ([]Announcement, error) {
+	now := time.Now()
+	dueQuery := "status IN ? AND send_at <= ? AND claimed_until <= ?"
+	dueStatuses := []string{AnnouncementScheduled, AnnouncementSending}
+
+	var due []Announcement
+	err := d.db.Where(dueQuery, dueStatuses, now, now).Order("send_at").Find(&due).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []Announcement
+	for _, a := range due {
+		// the announcement is not due anymore if another worker claimed it first
+		result := d.db.Model(&Announcement{}).Where("id = ?", a.ID).Where(dueQuery, dueStatuses, now, now).
+			Updates(map[string]interface{}{"status": AnnouncementSending, "claimed_until": now.Add(claimTimeout)})
+		if result.Error != nil {
+			return claimed, result.Error
+		}
+
+		if result.RowsAffected == 1 {
+			claimed = append(claimed, a)
+		}
+	}
+
+	return claimed, nil
+}
+
+// announcementUsers returns the query of verified users targeted by an announcement
+func (d *DB) announcementUsers(a Announcement) *gorm.DB {
+	query := d.db.Model(&User{}).Where("verified = true")
+	if a.College != "" {
+		query = query.Where("lower(college) = lower(?)", a.College)
+	}
+	if a.MinTeamSize > 0 {
+		query = query.Where("team_size >= ?", a.MinTeamSize)
+	}
+	if a.MaxTeamSize > 0 {
+		query = query.Where("team_size <= ?", a.MaxTeamSize)
+	}
+	if a.ActiveDeployments {
+		query = query.Where("(id IN (?) OR id IN (?))", d.db.Model(&VM{}).Select("user_id"), d.db.Model(&K8sCluster{}).Select("user_id"))
+	}
+	if len(a.Emails) > 0 {
+		query = query.Where("email IN ?", a.Emails)
+	}
+	return query
+}
+
+// CountAnnouncementUsers returns the number of users targeted by an announcement
+func (d *DB) CountAnnouncementUsers(a Announcement) (int64, error) {
+	var count int64
+	return count, d.announcementUsers(a).Count(&count).Error
+}
+
+// ListAnnouncementUsers returns the next users targeted by an announcement after the given user id
+func (d *DB) ListAnnouncementUsers(a Announcement, afterUserID string, limit int) ([]User, error) {
+	var res []User
+	query := d.announcementUsers(a).Where("id > ?", afterUserID).Order("id").Limit(limit).Find(&res)
+	return res, query.Error
+}
+
+// SetAnnouncementTotal sets the number of users an announcement is sent to
+func (d *DB) SetAnnouncementTotal(id int, total int) error {
+	return d.db.Model(&Announcement{}).Where("id = ?", id).Update("total", total).Error
+}
+
+// UpdateAnnouncementProgress records the users an announcement is sent to and extends its claim
+func (d *DB) UpdateAnnouncementProgress(id int, lastUserID string, sent, failed int, claimedUntil time.Time) error {
+	return d.db.Model(&Announcement{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"last_user_id":  lastUserID,
+		"sent":          gorm.Expr("sent + ?", sent),
+		"failed":        gorm.Expr("failed + ?", failed),
+		"claimed_until": claimedUntil,
+	}).Error
+}
+
+// FinishAnnouncement marks an announcement as sent to all its users
+func (d *DB) FinishAnnouncement(id int) error {
+	return d.db.Model(&Announcement{}).Where("id = ?", id).Update("status", AnnouncementSent).Error
+}
+
+// publishedAnnouncements returns the query of announcements that reached their send time and didn't expire,
+// pinned announcements come first
+func (d *DB) publishedAnnouncements() *gorm.DB {
+	now := time.Now()
+	return d.db.Where("send_at <= ? AND (expires_at IS NULL OR expires_at > ?)", now, now).Order("pinned desc, send_at desc")
+}
+
+// ListUserAnnouncements returns the published announcements that are public or target the user,
+// the audience of each announcement is matched in the same query
+func (d *DB) ListUserAnnouncements(u User) ([]Announcement, error) {
+	if !u.Verified {
+		return d.ListPublicAnnouncements()
+	}
+
+	vms := d.db.Model(&VM{}).Select("1").Where("user_id = ?", u.ID.String())
+	clusters := d.db.Model(&K8sCluster{}).Select("1").Where("user_id = ?", u.ID.String())
+	targeted := d.db.
+		Where("college = '' OR lower(college) = lower(?)", u.College).
+		Where("min_team_size = 0 OR min_team_size <= ?", u.TeamSize).
+		Where("max_team_size = 0 OR max_team_size >= ?", u.TeamSize).
+		Where("active_deployments = false OR EXISTS (?) OR EXISTS (?)", vms, clusters).
+		Where("coalesce(json_array_length(emails), 0) = 0 OR EXISTS (SELECT 1 FROM json_each(emails) WHERE value = ?)", u.Email)
+
+	var res []Announcement
+	query := d.publishedAnnouncements().Where(d.db.Where("public = true").Or(targeted)).Find(&res)
+	return res, query.Error
+}
+
+// ListPublicAnnouncements returns the public announcements that reached their send time and didn't expire
+func (d *DB) ListPublicAnnouncements() ([]Announcement, error) {
+	var res []Announcement
+	query := d.publishedAnnouncements().Where("public = true").Find(&res)
+	return res, query.Error
+}
+
+// webhooks
+
+// CreateWebhook adds a new webhook
+func (d *DB) CreateWebhook(w *Webhook) error {
+	return d.db.Create(w).Error
+}
+
+// GetWebhook returns a webhook by its id
+func (d *DB) GetWebhook(id int) (Webhook, error) {
+	var res Webhook
+	query := d.db.First(&res, id)
+	return res, query.Error
+}
+
+// ListWebhooks returns the webhooks of a user
+func (d *DB) ListWebhooks(userID string) ([]Webhook, error) {
+	var res []Webhook
+	query := d.db.Where("user_id = ?", userID).Find(&res)
+	return res, query.Error
+}
+
+// DeleteWebhook deletes a webhook with its deliveries
+func (d *DB) DeleteWebhook(id int) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(&WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Webhook{}, id).Error
+	})
+}
+
+// EmitEvent queues a delivery of the event to the webhooks subscribed to it,
+// events of users are delivered to their webhooks and to the all_users webhooks of users who can read users,
+// events without a user are about the grid account and delivered to the webhooks of users who can read its balance
+func (d *DB) EmitEvent(userID, event string, data interface{}) error {
+	var webhooks []Webhook
+	query := d.db.Where("user_id IN (?)", d.usersWithPermission(PermissionReadBalance).Select("id"))
+	if userID != "" {
+		query = d.db.Where("user_id = ?", userID).Or("all_users = true AND user_id IN (?)", d.usersWithPermission(PermissionReadUsers).Select("id"))
+	}
+	if err := query.Find(&webhooks).Error; err != nil {
+		return err
+	}
+
+	return d.enqueueEvent(webhooks, userID, event, data)
+}
+
+// EmitAdminEvent queues a delivery of an event about the grid account to the webhooks of an admin subscribed to it
+func (d *DB) EmitAdminEvent(adminID, event string, data interface{}) error {
+	var webhooks []Webhook
+	if err := d.db.Where("user_id = ?", adminID).Find(&webhooks).Error; err != nil {
+		return err
+	}
+
+	return d.enqueueEvent(webhooks, "", event, data)
+}
+
+// enqueueEvent queues a delivery of the event to the webhooks subscribed to it
+func (d *DB) enqueueEvent(webhooks []Webhook, userID, event string, data interface{}) error {
+	payload, err := json.Marshal(WebhookEvent{Event: event, UserID: userID, Data: data, CreatedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	for _, w := range webhooks {
+		if len(w.Events) != 0 && !slices.Contains(w.Events, event) {
+			continue
+		}
+
+		if _, err := d.EnqueueWebhookDelivery(w.ID, event, string(payload)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EnqueueWebhookDelivery queues a new delivery of an event payload to a webhook
+func (d *DB) EnqueueWebhookDelivery(webhookID int, event, payload string) (WebhookDelivery, error) {
+	delivery := WebhookDelivery{
+		WebhookID:     webhookID,
+		Event:         event,
+		Payload:       payload,
+		Status:        DeliveryPending,
+		NextAttemptAt: time.Now(),
+	}
+	err := d.db.Create(&delivery).Error
+	return delivery, err
+}
+
+// ClaimDueWebhookDeliveries claims deliveries due to be sent so no other worker sends them,
+// a claimed delivery is due again after the claim timeout in case its worker stopped
+func (d *DB) ClaimDueWebhookDeliveries(limit int, claimTimeout time.Duration) ([]WebhookDelivery, error) {
+	now := time.Now()
+	dueQuery := "status IN ? AND next_attempt_at <= ?"
+	dueStatuses := []string{DeliveryPending, DeliverySending}
+
+	var due []WebhookDelivery
+	err := d.db.Where(dueQuery, dueStatuses, now).Order("next_attempt_at").Limit(limit).Find(&due).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []WebhookDelivery
+	for _, delivery := range due {
+		// the delivery is not due anymore if another worker claimed it first
+		result := d.db.Model(&WebhookDelivery{}).Where("id = ?", delivery.ID).Where(dueQuery, dueStatuses, now).
+			Updates(map[string]interface{}{"status": DeliverySending, "next_attempt_at": now.Add(claimTimeout)})
+		if result.Error != nil {
+			return claimed, result.Error
+		}
+
+		if result.RowsAffected == 1 {
+			delivery.Status = DeliverySending
+			claimed = append(claimed, delivery)
+		}
+	}
+
+	return claimed, nil
+}
+
+// GetWebhookDelivery returns a webhook delivery by its id
+func (d *DB) GetWebhookDelivery(id int) (WebhookDelivery, error) {
+	var res WebhookDelivery
+	query := d.db.First(&res, id)
+	return res, query.Error
+}
+
+// ListWebhookDeliveries returns the latest deliveries of a webhook
+func (d *DB) ListWebhookDeliveries(webhookID int, limit int) ([]WebhookDelivery, error) {
+	var res []WebhookDelivery
+	query := d.db.Where("webhook_id = ?", webhookID).Order("id desc").Limit(limit).Find(&res)
+	return res, query.Error
+}
+
+// MarkWebhookDelivered records the successful attempt of a delivery
+func (d *DB) MarkWebhookDelivered(id int, responseCode int) error {
+	return d.db.Model(&WebhookDelivery{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"status": DeliveryDelivered, "attempts": gorm.Expr("attempts + 1"), "response_code": responseCode, "error": "", "delivered_at": time.Now()}).Error
+}
+
+// RetryWebhookDelivery records a failed attempt of a delivery and schedules the next one
+func (d *DB) RetryWebhookDelivery(id int, responseCode int, reason string, nextAttemptAt time.Time) error {
+	return d.db.Model(&WebhookDelivery{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"status": DeliveryPending, "attempts": gorm.Expr("attempts + 1"), "response_code": responseCode, "error": reason, "next_attempt_at": nextAttemptAt}).Error
+}
+
+// FailWebhookDelivery records the last failed attempt of a delivery
+func (d *DB) FailWebhookDelivery(id int, responseCode int, reason string) error {
+	return d.db.Model(&WebhookDelivery{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"status": DeliveryFailed, "attempts": gorm.Expr("attempts + 1"), "response_code": responseCode, "error": reason}).Error
+}
+
+// CreateSession creates a new session of a user with its first refresh token
+func (d *DB) CreateSession(s *Session, refreshTokenHash string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(s).Error; err != nil {
+			return err
+		}
+		return tx.Create(&RefreshToken{Hash: refreshTokenHash, SessionID: s.ID}).Error
+	})
+}
+
+// GetActiveSession returns a session if it is not revoked and didn't expire
+func (d *DB) GetActiveSession(id string) (Session, error) {
+	var res Session
+	query := d.db.Where("id = ? AND revoked_at IS NULL AND expires_at > ?", id, time.Now()).First(&res)
+	return res, query.Error
+}
+
+// ListActiveSessions returns the sessions of a user that are not revoked and didn't expire, the last used first
+func (d *DB) ListActiveSessions(userID string) ([]Session, error) {
+	var res []Session
+	query := d.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).Order("last_seen_at desc").Find(&res)
+	return res, query.Error
+}
+
+// TouchSession records that a session is used from an ip at the given time
+func (d *DB) TouchSession(id, ip string, seenAt time.Time) error {
+	return d.db.Model(&Session{}).Where("id = ?", id).Updates(map[string]interface{}{"last_seen_at": seenAt, "ip": ip}).Error
+}
+
+// RotateRefreshToken replaces a refresh token of an active session with a new one and extends the session,
+// using a rotated token again revokes its session as the token may be stolen
+func (d *DB) RotateRefreshToken(hash, newHash string, expiresAt time.Time) (Session, error) {
+	var session Session
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		var token RefreshToken
+		if err := tx.Where("hash = ?", hash).First(&token).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("id = ? AND revoked_at IS NULL AND expires_at > ?", token.SessionID, time.Now()).First(&session).Error; err != nil {
+			return err
+		}
+
+		// the token is rotated already, or by a concurrent request
+		result := tx.Model(&RefreshToken{}).Where("hash = ? AND rotated = false", hash).Update("rotated", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrRefreshTokenReused
+		}
+
+		if err := tx.Create(&RefreshToken{Hash: newHash, SessionID: session.ID}).Error; err != nil {
+			return err
+		}
+
+		session.ExpiresAt = expiresAt
+		session.LastSeenAt = time.Now()
+		return tx.Model(&session).Updates(map[string]interface{}{"expires_at": session.ExpiresAt, "last_seen_at": session.LastSeenAt}).Error
+	})
+
+	if err == ErrRefreshTokenReused {
+		if revokeErr := d.RevokeSession(session.ID); revokeErr != nil {
+			return Session{}, revokeErr
+		}
+	}
+
+	return session, err
+}
+
+// RevokeSession revokes a session so its access and refresh tokens are rejected
+func (d *DB) RevokeSession(id string) error {
+	return d.db.Model(&Session{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now()).Error
+}
+
+// RevokeUserSessions revokes all sessions of a user except the given one
+func (d *DB) RevokeUserSessions(userID, exceptID string) error {
+	return d.db.Model(&Session{}).Where("user_id = ? AND id != ? AND revoked_at IS NULL", userID, exceptID).Update("revoked_at", time.Now()).Error
+}
+
+// SetTOTPSecret sets a new secret of a user's authenticator app, it is used once the user confirms it
+func (d *DB) SetTOTPSecret(userID, secret string) error {
+	return d.db.Model(&User{}).Where("id = ? AND totp_enabled = false", userID).
+		UpdateColumns(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error
+}
+
+// EnableTOTP enables two-factor authentication of a user with the step of the confirmation code and new recovery codes
+func (d *DB) EnableTOTP(userID string, step int64, recoveryCodeHashes []string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", userID).
+			UpdateColumns(map[string]interface{}{"totp_enabled": true, "totp_last_step": step}).Error
+		if err != nil {
+			return err
+		}
+
+		return replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	})
+}
+
+// DisableTOTP disables two-factor authentication of a user and deletes their recovery codes
+func (d *DB) DisableTOTP(userID string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", userID).
+			UpdateColumns(map[string]interface{}{"totp_enabled": false, "totp_secret": "", "totp_last_step": 0}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+// UseTOTPStep marks the codes of a time step as used, it returns false if a code of the step or a later one is used already
+func (d *DB) UseTOTPStep(userID string, step int64) (bool, error) {
+	result := d.db.Model(&User{}).Where("id = ? AND totp_last_step < ?", userID, step).UpdateColumn("totp_last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of a user with new ones
+func (d *DB) ReplaceRecoveryCodes(userID string, hashes []string) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, hashes)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID string, hashes []string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return err
+	}
+
+	codes := make([]RecoveryCode, len(hashes))
+	for i, hash := range hashes {
+		codes[i] = RecoveryCode{UserID: userID, Hash: hash}
+	}
+	return tx.Create(&codes).Error
+}
+
+// UseRecoveryCode deletes a recovery code of a user, it returns false if the user has no such code
+func (d *DB) UseRecoveryCode(userID, hash string) (bool, error) {
+	result := d.db.Where("user_id = ? AND hash = ?", userID, hash).Delete(&RecoveryCode{})
+	return result.RowsAffected == 1, result.Error
+}
+
+// CreateTwoFactorChallenge creates a new two-factor sign in challenge and deletes the expired ones
+func (d *DB) CreateTwoFactorChallenge(c *TwoFactorChallenge) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at <= ?", time.Now()).Delete(&TwoFactorChallenge{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(c).Error
+	})
+}
+
+// GetTwoFactorChallenge returns a two-factor sign in challenge if it didn't expire
+func (d *DB) GetTwoFactorChallenge(hash string) (TwoFactorChallenge, error) {
+	var res TwoFactorChallenge
+	query := d.db.Where("hash = ? AND expires_at > ?", hash, time.Now()).First(&res)
+	return res, query.Error
+}
+
+// FailTwoFactorChallenge records a wrong code entered for a challenge
+func (d *DB) FailTwoFactorChallenge(hash string) error {
+	return d.db.Model(&TwoFactorChallenge{}).Where("hash = ?", hash).UpdateColumn("attempts", gorm.Expr("attempts + 1")).Error
+}
+
+// DeleteTwoFactorChallenge deletes a two-factor sign in challenge
+func (d *DB) DeleteTwoFactorChallenge(hash string) error {
+	return d.db.Where("hash = ?", hash).Delete(&TwoFactorChallenge{}).Error
+}
+
+// CreateAccessToken creates a new personal access token
+func (d *DB) CreateAccessToken(t *AccessToken) error {
+	return d.db.Create(t).Error
+}
+
+// ListAccessTokens returns the personal access tokens of a user, the newest first
+func (d *DB) ListAccessTokens(userID string) ([]AccessToken, error) {
+	var res []AccessToken
+	query := d.db.Where("user_id = ?", userID).Order("id desc").Find(&res)
+	return res, query.Error
+}
+
+// GetAccessToken returns a personal access token by its id
+func (d *DB) GetAccessToken(id int) (AccessToken, error) {
+	var res AccessToken
+	query := d.db.First(&res, id)
+	return res, query.Error
+}
+
+// GetActiveAccessToken returns a personal access token by its hash if it didn't expire
+func (d *DB) GetActiveAccessToken(hash string) (AccessToken, error) {
+	var res AccessToken
+	query := d.db.Where("hash = ? AND (expires_at IS NULL OR expires_at > ?)", hash, time.Now()).First(&res)
+	return res, query.Error
+}
+
+// TouchAccessToken records that a personal access token is used at the given time
+func (d *DB) TouchAccessToken(id int, usedAt time.Time) error {
+	return d.db.Model(&AccessToken{}).Where("id = ?", id).UpdateColumn("last_used_at", usedAt).Error
+}
+
+// DeleteAccessToken deletes a personal access token so it can't be used anymore
+func (d *DB) DeleteAccessToken(id int) error {
+	return d.db.Delete(&AccessToken{}, id).Error
+}
+
+// CreateOIDCLoginState creates a new sign in state and deletes the expired ones
+func (d *DB) CreateOIDCLoginState(s *OIDCLoginState) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at <= ?", time.Now()).Delete(&OIDCLoginState{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(s).Error
+	})
+}
+
+// PopOIDCLoginState returns a sign in state if it didn't expire and deletes it so it can't be used again
+func (d *DB) PopOIDCLoginState(hash string) (OIDCLoginState, error) {
+	var res OIDCLoginState
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("hash = ? AND expires_at > ?", hash, time.Now()).First(&res).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("hash = ?", hash).Delete(&OIDCLoginState{})
+		if result.Error != nil {
+			return result.Error
+		}
+		// used by a concurrent request
+		if result.RowsAffected != 1 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	return res, err
+}
+
+// GetOIDCIdentity returns the link of an account at a provider to a user
+func (d *DB) GetOIDCIdentity(provider, subject string) (OIDCIdentity, error) {
+	var res OIDCIdentity
+	query := d.db.Where("provider = ? AND subject = ?", provider, subject).First(&res)
+	return res, query.Error
+}
+
+// CreateOIDCIdentity links an account at a provider to a user
+func (d *DB) CreateOIDCIdentity(i *OIDCIdentity) error {
+	return d.db.Create(i).Error
+}
+
+// VerifyUserWithoutPassword verifies a user whose email is verified by a provider, their password is removed
+// as it is set by whoever signed up with the email before it was verified
+func (d *DB) VerifyUserWithoutPassword(id string) error {
+	return d.db.Model(&User{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"verified": true, "hashed_password": nil}).Error
+}
+
+// CreateVerificationToken creates a new verification token of a user, replacing their token of the same purpose
+func (d *DB) CreateVerificationToken(t *VerificationToken) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND purpose = ?", t.UserID, t.Purpose).Delete(&VerificationToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(t).Error
+	})
+}
+
+// GetVerificationToken returns the verification token of a user with a purpose
+func (d *DB) GetVerificationToken(userID, purpose string) (VerificationToken, error) {
+	var res VerificationToken
+	query := d.db.Where("user_id = ? AND purpose = ?", userID, purpose).First(&res)
+	return res, query.Error
+}
+
+// GetActiveVerificationToken returns the unused and unexpired verification token of a user with a purpose
+func (d *DB) GetActiveVerificationToken(userID, purpose string) (VerificationToken, error) {
+	var res VerificationToken
+	query := d.db.Where("user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", userID, purpose, time.Now()).First(&res)
+	return res, query.Error
+}
+
+// GetActiveVerificationTokenByLink returns the unused and unexpired verification token of a link
+func (d *DB) GetActiveVerificationTokenByLink(linkHash string) (VerificationToken, error) {
+	var res VerificationToken
+	query := d.db.Where("link_hash = ? AND used_at IS NULL AND expires_at > ?", linkHash, time.Now()).First(&res)
+	return res, query.Error
+}
+
+// FailVerificationToken counts a wrong code of a verification token
+func (d *DB) FailVerificationToken(id int) error {
+	return d.db.Model(&VerificationToken{}).Where("id = ?", id).UpdateColumn("attempts", gorm.Expr("attempts + 1")).Error
+}
+
+// UseVerificationToken marks a verification token as used, it returns false if it is already used
+func (d *DB) UseVerificationToken(id int) (bool, error) {
+	result := d.db.Model(&VerificationToken{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// DeleteVerificationTokens deletes the verification tokens of a user with a purpose
+func (d *DB) DeleteVerificationTokens(userID, purpose string) error {
+	return d.db.Where("user_id = ? AND purpose = ?", userID, purpose).Delete(&VerificationToken{}).Error
 }
@@ -2,7 +2,9 @@
 package models
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
@@ -120,31 +122,6 @@ func TestListAllUsers(t *testing.T) {
 	})
 }
 
-func TestGetCodeByEmail(t *testing.T) {
-	db := setupDB(t)
-	t.Run("user not found", func(t *testing.T) {
-		_, err := db.GetCodeByEmail("email@gmail.com")
-		require.Equal(t, err, gorm.ErrRecordNotFound)
-	})
-
-	t.Run("get code of user", func(t *testing.T) {
-		user := User{
-			Name:           "user",
-			Email:          "user@gmail.com",
-			HashedPassword: []byte{},
-			Verified:       true,
-			Code:           1234,
-		}
-
-		err := db.CreateUser(&user)
-		require.NoError(t, err)
-		code, err := db.GetCodeByEmail("user@gmail.com")
-		require.NoError(t, err)
-		require.Equal(t, code, user.Code)
-	})
-
-}
-
 func TestUpdatePassword(t *testing.T) {
 	db := setupDB(t)
 	t.Run("user not found so nothing updated", func(t *testing.T) {
@@ -232,6 +209,29 @@ func TestUpdateVerification(t *testing.T) {
 		require.Equal(t, u.Verified, true)
 	})
 }
+func TestUpdateUserEmail(t *testing.T) {
+	db := setupDB(t)
+	user := User{Email: "old@gmail.com"}
+	err := db.CreateUser(&user)
+	require.NoError(t, err)
+
+	t.Run("email is changed", func(t *testing.T) {
+		err := db.UpdateUserEmail(user.ID.String(), "new@gmail.com")
+		require.NoError(t, err)
+
+		u, err := db.GetUserByEmail("new@gmail.com")
+		require.NoError(t, err)
+		require.Equal(t, user.ID, u.ID)
+	})
+	t.Run("email of another user", func(t *testing.T) {
+		other := User{Email: "other@gmail.com"}
+		err := db.CreateUser(&other)
+		require.NoError(t, err)
+
+		err = db.UpdateUserEmail(user.ID.String(), other.Email)
+		require.Error(t, err)
+	})
+}
 func TestAddUserVoucher(t *testing.T) {
 	db := setupDB(t)
 	t.Run("user and voucher not found so nothing updated", func(t *testing.T) {
@@ -929,3 +929,1162 @@ func TestGetMaintenance(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, true, m.Active)
 }
+
+func TestDeploymentRequests(t *testing.T) {
+	db := setupDB(t)
+
+	first := DeploymentRequest{UserID: "user", Type: VMsType, Name: "first"}
+	err := db.CreateDeploymentRequest(&first)
+	require.NoError(t, err)
+	require.NotEmpty(t, first.ID)
+	require.Equal(t, QueuedPhase, first.Phase)
+
+	second := DeploymentRequest{UserID: "user", Type: VMsType, Name: "second", CreatedAt: first.CreatedAt.Add(time.Second)}
+	err = db.CreateDeploymentRequest(&second)
+	require.NoError(t, err)
+
+	t.Run("request not found", func(t *testing.T) {
+		_, err := db.GetDeploymentRequest("id")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("no started requests", func(t *testing.T) {
+		started, err := db.ListStartedDeploymentRequests([]string{first.ID, second.ID})
+		require.NoError(t, err)
+		require.Empty(t, started)
+	})
+
+	t.Run("start request", func(t *testing.T) {
+		err := db.StartDeploymentRequest(first.ID)
+		require.NoError(t, err)
+
+		err = db.StartDeploymentRequest(first.ID)
+		require.NoError(t, err)
+
+		r, err := db.GetDeploymentRequest(first.ID)
+		require.NoError(t, err)
+		require.Equal(t, PickingNodePhase, r.Phase)
+		require.Equal(t, 2, r.Attempts)
+	})
+
+	t.Run("update phase", func(t *testing.T) {
+		err := db.UpdateDeploymentRequestPhase(DeployingNetworkPhase, first.ID, second.ID)
+		require.NoError(t, err)
+
+		r, err := db.GetDeploymentRequest(second.ID)
+		require.NoError(t, err)
+		require.Equal(t, DeployingNetworkPhase, r.Phase)
+
+		started, err := db.ListStartedDeploymentRequests([]string{first.ID, second.ID})
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{first.ID, second.ID}, started)
+	})
+
+	t.Run("finished requests are not started", func(t *testing.T) {
+		err := db.FailDeploymentRequest(first.ID, "no nodes available")
+		require.NoError(t, err)
+
+		r, err := db.GetDeploymentRequest(first.ID)
+		require.NoError(t, err)
+		require.True(t, r.Finished())
+		require.Equal(t, "no nodes available", r.Error)
+
+		started, err := db.ListStartedDeploymentRequests([]string{first.ID, second.ID})
+		require.NoError(t, err)
+		require.Equal(t, []string{second.ID}, started)
+	})
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	db := setupDB(t)
+
+	key := IdempotencyKey{Key: "key", UserID: "user", RequestHash: "hash", ExpiresAt: time.Now().Add(time.Hour)}
+	created, err := db.CreateIdempotencyKey(&key)
+	require.NoError(t, err)
+	require.True(t, created)
+
+	t.Run("key already exists", func(t *testing.T) {
+		created, err := db.CreateIdempotencyKey(&IdempotencyKey{Key: "key", UserID: "user", ExpiresAt: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		require.False(t, created)
+	})
+
+	t.Run("same key for another user", func(t *testing.T) {
+		created, err := db.CreateIdempotencyKey(&IdempotencyKey{Key: "key", UserID: "another-user", ExpiresAt: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		require.True(t, created)
+	})
+
+	t.Run("update response", func(t *testing.T) {
+		err := db.UpdateIdempotencyResponse("user", "key", 201, []byte("body"))
+		require.NoError(t, err)
+
+		k, err := db.GetIdempotencyKey("user", "key")
+		require.NoError(t, err)
+		require.Equal(t, "hash", k.RequestHash)
+		require.Equal(t, 201, k.Status)
+		require.Equal(t, []byte("body"), k.Body)
+	})
+
+	t.Run("expired key can be used again", func(t *testing.T) {
+		created, err := db.CreateIdempotencyKey(&IdempotencyKey{Key: "expired", UserID: "user", ExpiresAt: time.Now().Add(-time.Hour)})
+		require.NoError(t, err)
+		require.True(t, created)
+
+		created, err = db.CreateIdempotencyKey(&IdempotencyKey{Key: "expired", UserID: "user", ExpiresAt: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		require.True(t, created)
+	})
+
+	t.Run("delete key", func(t *testing.T) {
+		err := db.DeleteIdempotencyKey("user", "key")
+		require.NoError(t, err)
+
+		_, err = db.GetIdempotencyKey("user", "key")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+}
+
+func TestOutgoingMails(t *testing.T) {
+	db := setupDB(t)
+
+	err := db.EnqueueMail("user@gmail.com", "subject", "body")
+	require.NoError(t, err)
+
+	t.Run("claim due mails", func(t *testing.T) {
+		claimed, err := db.ClaimDueMails(10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+		require.Equal(t, MailSending, claimed[0].Status)
+		require.Equal(t, "body", claimed[0].Body)
+
+		// claimed mails are not due until the claim times out
+		claimed, err = db.ClaimDueMails(10, time.Minute)
+		require.NoError(t, err)
+		require.Empty(t, claimed)
+	})
+
+	t.Run("retry mail", func(t *testing.T) {
+		mails, err := db.ListOutgoingMails(MailsFilter{Status: MailSending})
+		require.NoError(t, err)
+		require.Len(t, mails, 1)
+
+		err = db.RetryMail(mails[0].ID, "timeout", time.Now().Add(-time.Second))
+		require.NoError(t, err)
+
+		claimed, err := db.ClaimDueMails(10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+		require.Equal(t, 1, claimed[0].Attempts)
+		require.Equal(t, "timeout", claimed[0].Error)
+	})
+
+	t.Run("fail and resend mail", func(t *testing.T) {
+		mails, err := db.ListOutgoingMails(MailsFilter{})
+		require.NoError(t, err)
+		require.Len(t, mails, 1)
+
+		err = db.FailMail(mails[0].ID, "rejected")
+		require.NoError(t, err)
+
+		failed, err := db.ListOutgoingMails(MailsFilter{Status: MailFailed})
+		require.NoError(t, err)
+		require.Len(t, failed, 1)
+		require.Equal(t, 2, failed[0].Attempts)
+
+		err = db.ResendMail(mails[0].ID)
+		require.NoError(t, err)
+
+		m, err := db.GetOutgoingMail(mails[0].ID)
+		require.NoError(t, err)
+		require.Equal(t, MailPending, m.Status)
+		require.Equal(t, 0, m.Attempts)
+	})
+
+	t.Run("mark mail sent", func(t *testing.T) {
+		claimed, err := db.ClaimDueMails(10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+
+		err = db.MarkMailSent(claimed[0].ID)
+		require.NoError(t, err)
+
+		m, err := db.GetOutgoingMail(claimed[0].ID)
+		require.NoError(t, err)
+		require.Equal(t, MailSent, m.Status)
+		require.Empty(t, m.Error)
+		require.Empty(t, m.Body)
+	})
+
+	t.Run("paginate mails", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			err := db.EnqueueMail("user@gmail.com", "subject", "body")
+			require.NoError(t, err)
+		}
+
+		page, err := db.ListOutgoingMails(MailsFilter{Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+		require.Greater(t, page[0].ID, page[1].ID)
+
+		next, err := db.ListOutgoingMails(MailsFilter{Before: page[1].ID, Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, next, 1)
+		require.Equal(t, MailSent, next[0].Status)
+
+		pending, err := db.ListOutgoingMails(MailsFilter{Status: MailPending, Before: page[0].ID})
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+	})
+
+	t.Run("delete old mails", func(t *testing.T) {
+		pending, err := db.ListOutgoingMails(MailsFilter{Status: MailPending})
+		require.NoError(t, err)
+		require.Len(t, pending, 2)
+
+		err = db.FailMail(pending[0].ID, "rejected")
+		require.NoError(t, err)
+
+		deleted, err := db.DeleteOldMails(time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		require.Zero(t, deleted)
+
+		// pending mails are kept
+		deleted, err = db.DeleteOldMails(time.Now().Add(time.Second))
+		require.NoError(t, err)
+		require.Equal(t, int64(2), deleted)
+
+		mails, err := db.ListOutgoingMails(MailsFilter{})
+		require.NoError(t, err)
+		require.Len(t, mails, 1)
+		require.Equal(t, pending[1].ID, mails[0].ID)
+	})
+}
+
+func TestEmailTemplates(t *testing.T) {
+	db := setupDB(t)
+
+	defaults := []EmailTemplate{
+		{Name: "welcome", Language: "en", Subject: "Welcome", Body: "body"},
+		{Name: "signup", Language: "en", Subject: "Sign up", Body: "body"},
+	}
+
+	err := db.SeedEmailTemplates(defaults)
+	require.NoError(t, err)
+
+	t.Run("seeding keeps edited templates", func(t *testing.T) {
+		err := db.UpsertEmailTemplate(&EmailTemplate{Name: "welcome", Language: "en", Subject: "Hello", Body: "new body"})
+		require.NoError(t, err)
+
+		err = db.SeedEmailTemplates(defaults)
+		require.NoError(t, err)
+
+		tmpl, err := db.GetEmailTemplate("welcome", "en")
+		require.NoError(t, err)
+		require.Equal(t, "Hello", tmpl.Subject)
+		require.Equal(t, "new body", tmpl.Body)
+	})
+
+	t.Run("add and delete language", func(t *testing.T) {
+		err := db.UpsertEmailTemplate(&EmailTemplate{Name: "welcome", Language: "fr", Subject: "Bienvenue", Body: "body"})
+		require.NoError(t, err)
+
+		templates, err := db.ListEmailTemplates()
+		require.NoError(t, err)
+		require.Len(t, templates, 3)
+
+		counts, err := db.CountEmailTemplatesByLanguage()
+		require.NoError(t, err)
+		require.Equal(t, map[string]int{"en": 2, "fr": 1}, counts)
+
+		err = db.DeleteEmailTemplate("welcome", "fr")
+		require.NoError(t, err)
+
+		_, err = db.GetEmailTemplate("welcome", "fr")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+
+		err = db.DeleteEmailTemplate("welcome", "fr")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+}
+
+func TestAnnouncements(t *testing.T) {
+	db := setupDB(t)
+
+	users := []User{
+		{Name: "user1", Email: "user1@gmail.com", College: "Cairo", TeamSize: 2, Verified: true},
+		{Name: "user2", Email: "user2@gmail.com", College: "cairo", TeamSize: 5, Verified: true},
+		{Name: "user3", Email: "user3@gmail.com", College: "Paris", TeamSize: 5, Verified: true},
+		{Name: "user4", Email: "user4@gmail.com", College: "Cairo", TeamSize: 5},
+	}
+	for i := range users {
+		err := db.CreateUser(&users[i])
+		require.NoError(t, err)
+	}
+
+	err := db.CreateVM(&VM{UserID: users[2].ID.String(), Name: "vm"})
+	require.NoError(t, err)
+
+	t.Run("target users", func(t *testing.T) {
+		cases := []struct {
+			name         string
+			announcement Announcement
+			count        int64
+		}{
+			{"all", Announcement{}, 3},
+			{"college", Announcement{College: "CAIRO"}, 2},
+			{"team size", Announcement{MinTeamSize: 3, MaxTeamSize: 5}, 2},
+			{"active deployments", Announcement{ActiveDeployments: true}, 1},
+			{"emails", Announcement{Emails: []string{"user1@gmail.com", "user4@gmail.com"}}, 1},
+			{"college and team size", Announcement{College: "cairo", MaxTeamSize: 2}, 1},
+		}
+
+		for _, c := range cases {
+			count, err := db.CountAnnouncementUsers(c.announcement)
+			require.NoError(t, err, c.name)
+			require.Equal(t, c.count, count, c.name)
+		}
+	})
+
+	scheduled := Announcement{Subject: "later", Body: "body", SendAt: time.Now().Add(time.Hour)}
+	err = db.CreateAnnouncement(&scheduled)
+	require.NoError(t, err)
+
+	announcement := Announcement{Subject: "now", Body: "body", SendAt: time.Now()}
+	err = db.CreateAnnouncement(&announcement)
+	require.NoError(t, err)
+
+	t.Run("claim due announcements", func(t *testing.T) {
+		claimed, err := db.ClaimDueAnnouncements(time.Minute)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+		require.Equal(t, announcement.ID, claimed[0].ID)
+		require.Equal(t, AnnouncementScheduled, claimed[0].Status)
+
+		// already claimed
+		claimed, err = db.ClaimDueAnnouncements(time.Minute)
+		require.NoError(t, err)
+		require.Empty(t, claimed)
+	})
+
+	t.Run("send in batches", func(t *testing.T) {
+		err := db.SetAnnouncementTotal(announcement.ID, 3)
+		require.NoError(t, err)
+
+		batch, err := db.ListAnnouncementUsers(announcement, "", 2)
+		require.NoError(t, err)
+		require.Len(t, batch, 2)
+
+		err = db.UpdateAnnouncementProgress(announcement.ID, batch[1].ID.String(), 1, 1, time.Now())
+		require.NoError(t, err)
+
+		batch, err = db.ListAnnouncementUsers(announcement, batch[1].ID.String(), 2)
+		require.NoError(t, err)
+		require.Len(t, batch, 1)
+
+		err = db.UpdateAnnouncementProgress(announcement.ID, batch[0].ID.String(), 1, 0, time.Now())
+		require.NoError(t, err)
+
+		// the claim expired so the announcement can be resumed
+		claimed, err := db.ClaimDueAnnouncements(time.Minute)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+		require.Equal(t, batch[0].ID.String(), claimed[0].LastUserID)
+
+		err = db.FinishAnnouncement(announcement.ID)
+		require.NoError(t, err)
+
+		a, err := db.GetAnnouncement(announcement.ID)
+		require.NoError(t, err)
+		require.Equal(t, AnnouncementSent, a.Status)
+		require.Equal(t, 3, a.Total)
+		require.Equal(t, 2, a.Sent)
+		require.Equal(t, 1, a.Failed)
+	})
+
+	t.Run("list announcements", func(t *testing.T) {
+		announcements, err := db.ListAnnouncements()
+		require.NoError(t, err)
+		require.Len(t, announcements, 2)
+		require.Equal(t, announcement.ID, announcements[0].ID)
+	})
+}
+
+func TestPublishedAnnouncements(t *testing.T) {
+	db := setupDB(t)
+
+	user := User{Name: "user", Email: "user@gmail.com", College: "Cairo", TeamSize: 3, Verified: true}
+	err := db.CreateUser(&user)
+	require.NoError(t, err)
+
+	expired := time.Now().Add(-time.Minute)
+	announcements := []Announcement{
+		{Subject: "old", SendAt: time.Now().Add(-2 * time.Hour)},
+		{Subject: "pinned", SendAt: time.Now().Add(-3 * time.Hour), Pinned: true, Public: true},
+		{Subject: "new", SendAt: time.Now().Add(-time.Hour), College: "Paris"},
+		{Subject: "expired", SendAt: time.Now().Add(-time.Hour), ExpiresAt: &expired, Public: true},
+		{Subject: "scheduled", SendAt: time.Now().Add(time.Hour), Public: true},
+		{Subject: "college", SendAt: time.Now().Add(-4 * time.Hour), College: "cairo", MinTeamSize: 2, MaxTeamSize: 3},
+		{Subject: "big teams", SendAt: time.Now().Add(-4 * time.Hour), MinTeamSize: 4},
+		{Subject: "deployments", SendAt: time.Now().Add(-5 * time.Hour), ActiveDeployments: true},
+		{Subject: "emails", SendAt: time.Now().Add(-6 * time.Hour), Emails: []string{"other@gmail.com", user.Email}},
+		{Subject: "other emails", SendAt: time.Now().Add(-6 * time.Hour), Emails: []string{"other@gmail.com"}},
+	}
+	for i := range announcements {
+		err := db.CreateAnnouncement(&announcements[i])
+		require.NoError(t, err)
+	}
+
+	subjects := func(announcements []Announcement) []string {
+		var res []string
+		for _, a := range announcements {
+			res = append(res, a.Subject)
+		}
+		return res
+	}
+
+	t.Run("public announcements", func(t *testing.T) {
+		public, err := db.ListPublicAnnouncements()
+		require.NoError(t, err)
+		require.Equal(t, []string{"pinned"}, subjects(public))
+	})
+
+	t.Run("announcements of user", func(t *testing.T) {
+		feed, err := db.ListUserAnnouncements(user)
+		require.NoError(t, err)
+		require.Equal(t, []string{"pinned", "old", "college", "emails"}, subjects(feed))
+	})
+
+	t.Run("announcements of user with deployments", func(t *testing.T) {
+		err := db.CreateVM(&VM{UserID: user.ID.String(), Name: "vm"})
+		require.NoError(t, err)
+
+		feed, err := db.ListUserAnnouncements(user)
+		require.NoError(t, err)
+		require.Equal(t, []string{"pinned", "old", "college", "deployments", "emails"}, subjects(feed))
+	})
+
+	t.Run("announcements of unverified user", func(t *testing.T) {
+		unverified := User{Name: "unverified", Email: "unverified@gmail.com"}
+		err := db.CreateUser(&unverified)
+		require.NoError(t, err)
+
+		feed, err := db.ListUserAnnouncements(unverified)
+		require.NoError(t, err)
+		require.Equal(t, []string{"pinned"}, subjects(feed))
+	})
+}
+
+func TestNotificationHook(t *testing.T) {
+	db := setupDB(t)
+
+	var created []Notification
+	db.OnNotificationCreated(func(n Notification) {
+		created = append(created, n)
+	})
+
+	for _, msg := range []string{"first", "second", "third"} {
+		err := db.CreateNotification(&Notification{UserID: "user", Msg: msg})
+		require.NoError(t, err)
+	}
+	err := db.CreateNotification(&Notification{UserID: "another user", Msg: "other"})
+	require.NoError(t, err)
+
+	require.Len(t, created, 4)
+	require.NotZero(t, created[0].ID)
+
+	missed, err := db.ListNotificationsAfter("user", created[0].ID)
+	require.NoError(t, err)
+	require.Len(t, missed, 2)
+	require.Equal(t, "second", missed[0].Msg)
+	require.Equal(t, "third", missed[1].Msg)
+}
+
+func TestNotifications(t *testing.T) {
+	db := setupDB(t)
+
+	notifications := []Notification{
+		{UserID: "user", Msg: "vm", Type: VMsType},
+		{UserID: "user", Msg: "voucher", Type: VoucherType, VoucherID: 1},
+		{UserID: "user", Msg: "k8s", Type: K8sType},
+		{UserID: "another user", Msg: "vm", Type: VMsType},
+	}
+	for i := range notifications {
+		err := db.CreateNotification(&notifications[i])
+		require.NoError(t, err)
+		require.False(t, notifications[i].CreatedAt.IsZero())
+	}
+
+	t.Run("list pages", func(t *testing.T) {
+		page, err := db.ListNotifications("user", NotificationsFilter{Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+		require.Equal(t, "k8s", page[0].Msg)
+		require.Equal(t, "voucher", page[1].Msg)
+
+		page, err = db.ListNotifications("user", NotificationsFilter{Limit: 2, Before: page[1].ID})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		require.Equal(t, "vm", page[0].Msg)
+	})
+
+	t.Run("list by type", func(t *testing.T) {
+		page, err := db.ListNotifications("user", NotificationsFilter{Type: VoucherType})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		require.Equal(t, 1, page[0].VoucherID)
+	})
+
+	t.Run("update notification of another user", func(t *testing.T) {
+		err := db.UpdateNotification("another user", notifications[0].ID, true)
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+
+		err = db.UpdateNotification("user", notifications[0].ID, true)
+		require.NoError(t, err)
+
+		unread, err := db.CountUnreadNotifications("user", "")
+		require.NoError(t, err)
+		require.Equal(t, int64(2), unread)
+	})
+
+	t.Run("mark all seen by type", func(t *testing.T) {
+		err := db.MarkAllNotificationsSeen("user", K8sType)
+		require.NoError(t, err)
+
+		unread, err := db.ListNotifications("user", NotificationsFilter{Unread: true})
+		require.NoError(t, err)
+		require.Len(t, unread, 1)
+		require.Equal(t, "voucher", unread[0].Msg)
+
+		err = db.MarkAllNotificationsSeen("user", "")
+		require.NoError(t, err)
+
+		count, err := db.CountUnreadNotifications("user", "")
+		require.NoError(t, err)
+		require.Zero(t, count)
+
+		count, err = db.CountUnreadNotifications("another user", VMsType)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), count)
+	})
+
+	t.Run("delete notification", func(t *testing.T) {
+		err := db.DeleteNotification("another user", notifications[0].ID)
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+
+		err = db.DeleteNotification("user", notifications[0].ID)
+		require.NoError(t, err)
+
+		page, err := db.ListNotifications("user", NotificationsFilter{})
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+	})
+}
+
+func TestEmitAdminEvent(t *testing.T) {
+	db := setupDB(t)
+
+	adminHook := Webhook{UserID: "admin", URL: "https://admin.com", Secret: "secret"}
+	anotherAdminHook := Webhook{UserID: "another admin", URL: "https://another.com", Secret: "secret"}
+	for _, w := range []*Webhook{&adminHook, &anotherAdminHook} {
+		err := db.CreateWebhook(w)
+		require.NoError(t, err)
+	}
+
+	err := db.EmitAdminEvent("admin", EventBalanceLow, map[string]int{"threshold": 10})
+	require.NoError(t, err)
+
+	deliveries, err := db.ListWebhookDeliveries(adminHook.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Contains(t, deliveries[0].Payload, `"event":"balance.low"`)
+	require.NotContains(t, deliveries[0].Payload, `"user_id"`)
+
+	deliveries, err = db.ListWebhookDeliveries(anotherAdminHook.ID, 10)
+	require.NoError(t, err)
+	require.Empty(t, deliveries)
+}
+
+func TestWebhooks(t *testing.T) {
+	db := setupDB(t)
+
+	admin := User{Name: "admin", Email: "admin@gmail.com", Roles: []string{SuperAdminRole}, Verified: true}
+	err := db.CreateUser(&admin)
+	require.NoError(t, err)
+
+	userHook := Webhook{UserID: "user", URL: "https://user.com", Events: []string{EventVMDeployed}, Secret: "secret"}
+	anotherUserHook := Webhook{UserID: "another user", URL: "https://another.com", Secret: "secret"}
+	adminHook := Webhook{UserID: admin.ID.String(), URL: "https://admin.com", Secret: "secret"}
+	allUsersHook := Webhook{UserID: admin.ID.String(), URL: "https://all.com", AllUsers: true, Secret: "secret"}
+	for _, w := range []*Webhook{&userHook, &anotherUserHook, &adminHook, &allUsersHook} {
+		err := db.CreateWebhook(w)
+		require.NoError(t, err)
+	}
+
+	deliveries := func(t *testing.T, w Webhook) []WebhookDelivery {
+		res, err := db.ListWebhookDeliveries(w.ID, 10)
+		require.NoError(t, err)
+		return res
+	}
+
+	t.Run("emit user event", func(t *testing.T) {
+		err := db.EmitEvent("user", EventVMDeployed, map[string]string{"name": "vm"})
+		require.NoError(t, err)
+
+		require.Len(t, deliveries(t, userHook), 1)
+		require.Len(t, deliveries(t, allUsersHook), 1)
+		require.Empty(t, deliveries(t, anotherUserHook))
+		require.Empty(t, deliveries(t, adminHook))
+
+		require.Contains(t, deliveries(t, userHook)[0].Payload, `"event":"vm.deployed"`)
+		require.Contains(t, deliveries(t, userHook)[0].Payload, `"name":"vm"`)
+	})
+
+	t.Run("emit unsubscribed event", func(t *testing.T) {
+		err := db.EmitEvent("user", EventVMFailed, nil)
+		require.NoError(t, err)
+
+		require.Len(t, deliveries(t, userHook), 1)
+		require.Len(t, deliveries(t, allUsersHook), 2)
+	})
+
+	t.Run("emit admin event", func(t *testing.T) {
+		err := db.EmitEvent("", EventBalanceLow, nil)
+		require.NoError(t, err)
+
+		require.Len(t, deliveries(t, adminHook), 1)
+		require.Len(t, deliveries(t, allUsersHook), 3)
+		require.Empty(t, deliveries(t, anotherUserHook))
+	})
+
+	t.Run("claim and retry delivery", func(t *testing.T) {
+		claimed, err := db.ClaimDueWebhookDeliveries(10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, claimed, 5)
+
+		// claimed deliveries are not due until the claim times out
+		again, err := db.ClaimDueWebhookDeliveries(10, time.Minute)
+		require.NoError(t, err)
+		require.Empty(t, again)
+
+		err = db.RetryWebhookDelivery(claimed[0].ID, 500, "server error", time.Now().Add(-time.Second))
+		require.NoError(t, err)
+
+		again, err = db.ClaimDueWebhookDeliveries(10, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, again, 1)
+		require.Equal(t, 1, again[0].Attempts)
+		require.Equal(t, 500, again[0].ResponseCode)
+
+		err = db.MarkWebhookDelivered(claimed[1].ID, 200)
+		require.NoError(t, err)
+
+		err = db.FailWebhookDelivery(claimed[2].ID, 0, "timeout")
+		require.NoError(t, err)
+
+		delivered, err := db.GetWebhookDelivery(claimed[1].ID)
+		require.NoError(t, err)
+		require.Equal(t, DeliveryDelivered, delivered.Status)
+
+		failed, err := db.GetWebhookDelivery(claimed[2].ID)
+		require.NoError(t, err)
+		require.Equal(t, DeliveryFailed, failed.Status)
+		require.Equal(t, "timeout", failed.Error)
+	})
+
+	t.Run("delete webhook with deliveries", func(t *testing.T) {
+		err := db.DeleteWebhook(allUsersHook.ID)
+		require.NoError(t, err)
+
+		_, err = db.GetWebhook(allUsersHook.ID)
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+		require.Empty(t, deliveries(t, allUsersHook))
+	})
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	db := setupDB(t)
+
+	t.Run("default preferences", func(t *testing.T) {
+		preferences, err := db.GetNotificationPreferences("user")
+		require.NoError(t, err)
+		require.Equal(t, "user", preferences.UserID)
+		require.False(t, preferences.EmailDigest)
+		require.Equal(t, NotificationChannels{InApp: true, Email: true, Webhook: true}, preferences.Channels(DeploymentsCategory))
+	})
+
+	t.Run("update preferences", func(t *testing.T) {
+		err := db.UpdateNotificationPreferences(NotificationPreferences{
+			UserID:     "user",
+			Categories: map[string]NotificationChannels{DeploymentsCategory: {InApp: true}},
+		})
+		require.NoError(t, err)
+
+		err = db.UpdateNotificationPreferences(NotificationPreferences{
+			UserID:      "user",
+			Categories:  map[string]NotificationChannels{VouchersCategory: {Email: true}},
+			EmailDigest: true,
+		})
+		require.NoError(t, err)
+
+		preferences, err := db.GetNotificationPreferences("user")
+		require.NoError(t, err)
+		require.True(t, preferences.EmailDigest)
+		require.Equal(t, NotificationChannels{Email: true}, preferences.Channels(VouchersCategory))
+		require.Equal(t, NotificationChannels{InApp: true, Email: true, Webhook: true}, preferences.Channels(DeploymentsCategory))
+	})
+
+	t.Run("due digests", func(t *testing.T) {
+		due, err := db.ListDueDigests(time.Now())
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+
+		err = db.SetLastDigest("user", 3, time.Now())
+		require.NoError(t, err)
+
+		due, err = db.ListDueDigests(time.Now().Add(-time.Hour))
+		require.NoError(t, err)
+		require.Empty(t, due)
+
+		preferences, err := db.GetNotificationPreferences("user")
+		require.NoError(t, err)
+		require.Equal(t, 3, preferences.LastDigestNotificationID)
+	})
+}
+
+func TestNotificationCategory(t *testing.T) {
+	require.Equal(t, DeploymentsCategory, NotificationCategory(VMsType))
+	require.Equal(t, DeploymentsCategory, NotificationCategory(K8sType))
+	require.Equal(t, VouchersCategory, NotificationCategory(VoucherType))
+	require.Equal(t, AnnouncementsCategory, NotificationCategory(AnnouncementType))
+	require.Equal(t, ExpiryCategory, NotificationCategory(BalanceType))
+}
+
+func TestSessions(t *testing.T) {
+	db := setupDB(t)
+
+	session := Session{UserID: "user", ExpiresAt: time.Now().Add(time.Hour)}
+	err := db.CreateSession(&session, "hash1")
+	require.NoError(t, err)
+	require.NotEmpty(t, session.ID)
+
+	t.Run("rotate refresh token", func(t *testing.T) {
+		expiresAt := time.Now().Add(2 * time.Hour)
+		rotated, err := db.RotateRefreshToken("hash1", "hash2", expiresAt)
+		require.NoError(t, err)
+		require.Equal(t, session.ID, rotated.ID)
+		require.WithinDuration(t, expiresAt, rotated.ExpiresAt, time.Second)
+
+		_, err = db.RotateRefreshToken("unknown", "hash3", expiresAt)
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("reused refresh token revokes the session", func(t *testing.T) {
+		_, err := db.RotateRefreshToken("hash1", "hash3", time.Now().Add(time.Hour))
+		require.Equal(t, ErrRefreshTokenReused, err)
+
+		_, err = db.GetActiveSession(session.ID)
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+
+		_, err = db.RotateRefreshToken("hash2", "hash3", time.Now().Add(time.Hour))
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("revoke user sessions", func(t *testing.T) {
+		current := Session{UserID: "user", ExpiresAt: time.Now().Add(time.Hour)}
+		other := Session{UserID: "user", ExpiresAt: time.Now().Add(time.Hour)}
+		for i, s := range []*Session{&current, &other} {
+			err := db.CreateSession(s, fmt.Sprintf("session%d", i))
+			require.NoError(t, err)
+		}
+
+		err := db.RevokeUserSessions("user", current.ID)
+		require.NoError(t, err)
+
+		_, err = db.GetActiveSession(current.ID)
+		require.NoError(t, err)
+
+		_, err = db.GetActiveSession(other.ID)
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("list and touch active sessions", func(t *testing.T) {
+		first := Session{UserID: "device user", ExpiresAt: time.Now().Add(time.Hour), LastSeenAt: time.Now().Add(-time.Hour)}
+		second := Session{UserID: "device user", ExpiresAt: time.Now().Add(time.Hour), LastSeenAt: time.Now()}
+		revoked := Session{UserID: "device user", ExpiresAt: time.Now().Add(time.Hour)}
+		for i, s := range []*Session{&first, &second, &revoked} {
+			err := db.CreateSession(s, fmt.Sprintf("device%d", i))
+			require.NoError(t, err)
+		}
+		err := db.RevokeSession(revoked.ID)
+		require.NoError(t, err)
+
+		sessions, err := db.ListActiveSessions("device user")
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		require.Equal(t, second.ID, sessions[0].ID)
+
+		err = db.TouchSession(first.ID, "10.0.0.1", time.Now().Add(time.Minute))
+		require.NoError(t, err)
+
+		sessions, err = db.ListActiveSessions("device user")
+		require.NoError(t, err)
+		require.Equal(t, first.ID, sessions[0].ID)
+		require.Equal(t, "10.0.0.1", sessions[0].IP)
+	})
+
+	t.Run("expired session", func(t *testing.T) {
+		expired := Session{UserID: "user", ExpiresAt: time.Now().Add(-time.Minute)}
+		err := db.CreateSession(&expired, "expired")
+		require.NoError(t, err)
+
+		_, err = db.GetActiveSession(expired.ID)
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+}
+
+func TestTwoFactor(t *testing.T) {
+	db := setupDB(t)
+
+	u := User{Name: "user", Email: "user@gmail.com"}
+	err := db.CreateUser(&u)
+	require.NoError(t, err)
+
+	err = db.SetTOTPSecret(u.ID.String(), "SECRET")
+	require.NoError(t, err)
+
+	err = db.EnableTOTP(u.ID.String(), 10, []string{"code1", "code2"})
+	require.NoError(t, err)
+
+	got, err := db.GetUserByID(u.ID.String())
+	require.NoError(t, err)
+	require.True(t, got.TOTPEnabled)
+	require.Equal(t, "SECRET", got.TOTPSecret)
+	require.Equal(t, int64(10), got.TOTPLastStep)
+
+	t.Run("secret can't be replaced once enabled", func(t *testing.T) {
+		err := db.SetTOTPSecret(u.ID.String(), "ANOTHER")
+		require.NoError(t, err)
+
+		got, err := db.GetUserByID(u.ID.String())
+		require.NoError(t, err)
+		require.Equal(t, "SECRET", got.TOTPSecret)
+	})
+
+	t.Run("steps are used once", func(t *testing.T) {
+		used, err := db.UseTOTPStep(u.ID.String(), 10)
+		require.NoError(t, err)
+		require.False(t, used)
+
+		used, err = db.UseTOTPStep(u.ID.String(), 11)
+		require.NoError(t, err)
+		require.True(t, used)
+	})
+
+	t.Run("recovery codes are used once", func(t *testing.T) {
+		used, err := db.UseRecoveryCode(u.ID.String(), "code1")
+		require.NoError(t, err)
+		require.True(t, used)
+
+		used, err = db.UseRecoveryCode(u.ID.String(), "code1")
+		require.NoError(t, err)
+		require.False(t, used)
+
+		err = db.ReplaceRecoveryCodes(u.ID.String(), []string{"code3", "code4", "code5"})
+		require.NoError(t, err)
+
+		used, err = db.UseRecoveryCode(u.ID.String(), "code2")
+		require.NoError(t, err)
+		require.False(t, used)
+	})
+
+	t.Run("challenges", func(t *testing.T) {
+		expired := TwoFactorChallenge{Hash: "expired", UserID: u.ID.String(), ExpiresAt: time.Now().Add(-time.Minute)}
+		err := db.CreateTwoFactorChallenge(&expired)
+		require.NoError(t, err)
+
+		_, err = db.GetTwoFactorChallenge("expired")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+
+		challenge := TwoFactorChallenge{Hash: "hash", UserID: u.ID.String(), ExpiresAt: time.Now().Add(time.Minute)}
+		err = db.CreateTwoFactorChallenge(&challenge)
+		require.NoError(t, err)
+
+		err = db.FailTwoFactorChallenge("hash")
+		require.NoError(t, err)
+
+		got, err := db.GetTwoFactorChallenge("hash")
+		require.NoError(t, err)
+		require.Equal(t, 1, got.Attempts)
+
+		err = db.DeleteTwoFactorChallenge("hash")
+		require.NoError(t, err)
+
+		_, err = db.GetTwoFactorChallenge("hash")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		err := db.DisableTOTP(u.ID.String())
+		require.NoError(t, err)
+
+		got, err := db.GetUserByID(u.ID.String())
+		require.NoError(t, err)
+		require.False(t, got.TOTPEnabled)
+		require.Empty(t, got.TOTPSecret)
+
+		used, err := db.UseRecoveryCode(u.ID.String(), "code3")
+		require.NoError(t, err)
+		require.False(t, used)
+	})
+}
+
+func TestAccessTokens(t *testing.T) {
+	db := setupDB(t)
+
+	expired := time.Now().Add(-time.Minute)
+	tokens := []AccessToken{
+		{UserID: "user", Name: "ci", Hash: "hash1", Scopes: []string{ScopeRead, ScopeDeploy}},
+		{UserID: "user", Name: "old", Hash: "hash2", Scopes: []string{ScopeRead}, ExpiresAt: &expired},
+		{UserID: "another user", Name: "cli", Hash: "hash3", Scopes: []string{ScopeVouchers}},
+	}
+	for i := range tokens {
+		err := db.CreateAccessToken(&tokens[i])
+		require.NoError(t, err)
+	}
+
+	t.Run("list", func(t *testing.T) {
+		got, err := db.ListAccessTokens("user")
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		require.Equal(t, "old", got[0].Name)
+		require.Equal(t, []string{ScopeRead, ScopeDeploy}, got[1].Scopes)
+	})
+
+	t.Run("get active", func(t *testing.T) {
+		got, err := db.GetActiveAccessToken("hash1")
+		require.NoError(t, err)
+		require.Equal(t, tokens[0].ID, got.ID)
+
+		_, err = db.GetActiveAccessToken("hash2")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("touch", func(t *testing.T) {
+		err := db.TouchAccessToken(tokens[0].ID, time.Now())
+		require.NoError(t, err)
+
+		got, err := db.GetAccessToken(tokens[0].ID)
+		require.NoError(t, err)
+		require.NotNil(t, got.LastUsedAt)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		err := db.DeleteAccessToken(tokens[0].ID)
+		require.NoError(t, err)
+
+		_, err = db.GetActiveAccessToken("hash1")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+}
+
+func TestOIDC(t *testing.T) {
+	db := setupDB(t)
+
+	t.Run("login state is used once", func(t *testing.T) {
+		err := db.CreateOIDCLoginState(&OIDCLoginState{Hash: "hash", Provider: "uni", Nonce: "nonce", CodeVerifier: "verifier", ExpiresAt: time.Now().Add(time.Minute)})
+		require.NoError(t, err)
+
+		state, err := db.PopOIDCLoginState("hash")
+		require.NoError(t, err)
+		require.Equal(t, "verifier", state.CodeVerifier)
+
+		_, err = db.PopOIDCLoginState("hash")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("expired login state", func(t *testing.T) {
+		err := db.CreateOIDCLoginState(&OIDCLoginState{Hash: "expired", Provider: "uni", ExpiresAt: time.Now().Add(-time.Minute)})
+		require.NoError(t, err)
+
+		_, err = db.PopOIDCLoginState("expired")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("identity", func(t *testing.T) {
+		err := db.CreateOIDCIdentity(&OIDCIdentity{UserID: "user", Provider: "uni", Subject: "subject"})
+		require.NoError(t, err)
+
+		err = db.CreateOIDCIdentity(&OIDCIdentity{UserID: "another user", Provider: "uni", Subject: "subject"})
+		require.Error(t, err)
+
+		identity, err := db.GetOIDCIdentity("uni", "subject")
+		require.NoError(t, err)
+		require.Equal(t, "user", identity.UserID)
+
+		_, err = db.GetOIDCIdentity("another", "subject")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("verify user without password", func(t *testing.T) {
+		user := User{Name: "user", Email: "user@uni.edu", HashedPassword: []byte("password")}
+		err := db.CreateUser(&user)
+		require.NoError(t, err)
+
+		err = db.VerifyUserWithoutPassword(user.ID.String())
+		require.NoError(t, err)
+
+		got, err := db.GetUserByID(user.ID.String())
+		require.NoError(t, err)
+		require.True(t, got.Verified)
+		require.Empty(t, got.HashedPassword)
+	})
+}
+
+func TestVerificationTokens(t *testing.T) {
+	db := setupDB(t)
+
+	token := VerificationToken{UserID: "user", Purpose: SignUpPurpose, Email: "user@uni.edu", CodeHash: "code1", LinkHash: "link1", ExpiresAt: time.Now().Add(time.Minute)}
+	err := db.CreateVerificationToken(&token)
+	require.NoError(t, err)
+
+	t.Run("get active", func(t *testing.T) {
+		got, err := db.GetActiveVerificationToken("user", SignUpPurpose)
+		require.NoError(t, err)
+		require.Equal(t, token.ID, got.ID)
+
+		got, err = db.GetActiveVerificationTokenByLink("link1")
+		require.NoError(t, err)
+		require.Equal(t, token.ID, got.ID)
+
+		_, err = db.GetActiveVerificationToken("user", ResetPasswordPurpose)
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		err := db.FailVerificationToken(token.ID)
+		require.NoError(t, err)
+
+		got, err := db.GetVerificationToken("user", SignUpPurpose)
+		require.NoError(t, err)
+		require.Equal(t, 1, got.Attempts)
+	})
+
+	t.Run("used once", func(t *testing.T) {
+		used, err := db.UseVerificationToken(token.ID)
+		require.NoError(t, err)
+		require.True(t, used)
+
+		used, err = db.UseVerificationToken(token.ID)
+		require.NoError(t, err)
+		require.False(t, used)
+
+		_, err = db.GetActiveVerificationTokenByLink("link1")
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("new token replaces the old one", func(t *testing.T) {
+		err := db.CreateVerificationToken(&VerificationToken{UserID: "user", Purpose: SignUpPurpose, CodeHash: "code2", LinkHash: "link2", ExpiresAt: time.Now().Add(time.Minute)})
+		require.NoError(t, err)
+
+		got, err := db.GetActiveVerificationToken("user", SignUpPurpose)
+		require.NoError(t, err)
+		require.Equal(t, "code2", got.CodeHash)
+
+		err = db.DeleteVerificationTokens("user", SignUpPurpose)
+		require.NoError(t, err)
+
+		_, err = db.GetVerificationToken("user", SignUpPurpose)
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		err := db.CreateVerificationToken(&VerificationToken{UserID: "user", Purpose: ResetPasswordPurpose, CodeHash: "code3", LinkHash: "link3", ExpiresAt: time.Now().Add(-time.Minute)})
+		require.NoError(t, err)
+
+		_, err = db.GetActiveVerificationToken("user", ResetPasswordPurpose)
+		require.Equal(t, gorm.ErrRecordNotFound, err)
+	})
+}
+
+func TestRoles(t *testing.T) {
+	db := setupDB(t)
+
+	t.Run("admin column is migrated to super admin role", func(t *testing.T) {
+		admin := User{Name: "admin", Email: "admin@gmail.com", Verified: true}
+		err := db.CreateUser(&admin)
+		require.NoError(t, err)
+		student := User{Name: "student", Email: "student@gmail.com", Verified: true}
+		err = db.CreateUser(&student)
+		require.NoError(t, err)
+
+		err = db.db.Exec("ALTER TABLE `users` ADD `admin` numeric").Error
+		require.NoError(t, err)
+		err = db.db.Exec("UPDATE users SET admin = true WHERE id = ?", admin.ID.String()).Error
+		require.NoError(t, err)
+
+		err = db.Migrate()
+		require.NoError(t, err)
+		require.False(t, db.db.Migrator().HasColumn(&User{}, "admin"))
+
+		got, err := db.GetUserByID(admin.ID.String())
+		require.NoError(t, err)
+		require.Equal(t, []string{SuperAdminRole}, got.Roles)
+
+		got, err = db.GetUserByID(student.ID.String())
+		require.NoError(t, err)
+		require.Empty(t, got.Roles)
+	})
+
+	t.Run("update roles", func(t *testing.T) {
+		reviewer := User{Name: "reviewer", Email: "reviewer@gmail.com", Verified: true}
+		err := db.CreateUser(&reviewer)
+		require.NoError(t, err)
+
+		err = db.UpdateUserRoles(reviewer.ID.String(), []string{VoucherReviewerRole, SupportRole})
+		require.NoError(t, err)
+
+		got, err := db.GetUserByID(reviewer.ID.String())
+		require.NoError(t, err)
+		require.Equal(t, []string{VoucherReviewerRole, SupportRole}, got.Roles)
+
+		users, err := db.ListAllUsers()
+		require.NoError(t, err)
+		for _, u := range users {
+			if u.Email == reviewer.Email {
+				require.Equal(t, got.Roles, u.Roles)
+			}
+		}
+	})
+
+	t.Run("list users with permission", func(t *testing.T) {
+		users, err := db.ListUsersWithPermission(PermissionReviewVouchers)
+		require.NoError(t, err)
+		require.Len(t, users, 2)
+
+		users, err = db.ListUsersWithPermission(PermissionManageRoles)
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		require.Equal(t, "admin@gmail.com", users[0].Email)
+
+		users, err = db.ListUsersWithPermission("unknown")
+		require.NoError(t, err)
+		require.Empty(t, users)
+	})
+
+	t.Run("list users with permission: unverified users and lookalike roles", func(t *testing.T) {
+		unverified := User{Name: "unverified", Email: "unverified@gmail.com", Roles: []string{SuperAdminRole}}
+		err := db.CreateUser(&unverified)
+		require.NoError(t, err)
+
+		// roles only match a whole role of the list
+		lookalike := User{Name: "lookalike", Email: "lookalike@gmail.com", Verified: true, Roles: []string{"not_" + SuperAdminRole}}
+		err = db.CreateUser(&lookalike)
+		require.NoError(t, err)
+
+		users, err := db.ListUsersWithPermission(PermissionManageRoles)
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		require.Equal(t, "admin@gmail.com", users[0].Email)
+	})
+}
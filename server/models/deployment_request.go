@@ -0,0 +1,60 @@
+// Package models for database models
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// QueuedPhase the request is waiting in the requests stream
+	QueuedPhase = "queued"
+	// PickingNodePhase the request is looking for an available node
+	PickingNodePhase = "picking node"
+	// DeployingNetworkPhase the network of the request is being deployed
+	DeployingNetworkPhase = "deploying network"
+	// DeployingVMPhase the virtual machine of the request is being deployed
+	DeployingVMPhase = "deploying vm"
+	// DeployingK8sPhase the kubernetes cluster of the request is being deployed
+	DeployingK8sPhase = "deploying cluster"
+	// DeployedPhase the request is deployed successfully
+	DeployedPhase = "deployed"
+	// FailedPhase the request failed to be deployed
+	FailedPhase = "failed"
+)
+
+// DeploymentRequest struct holds the status of a deployment request
+type DeploymentRequest struct {
+	ID       string `json:"id" gorm:"primaryKey"`
+	UserID   string `json:"user_id" binding:"required"`
+	Type     string `json:"type" binding:"required"`
+	Name     string `json:"name" binding:"required"`
+	Phase    string `json:"phase"`
+	Attempts int    `json:"attempts"`
+	// failure reason if the request failed
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// BeforeCreate generates a new uuid
+func (r *DeploymentRequest) BeforeCreate(tx *gorm.DB) (err error) {
+	if r.ID != "" {
+		return
+	}
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return err
+	}
+
+	r.ID = id.String()
+	return
+}
+
+// Finished returns true if the request is deployed or failed
+func (r *DeploymentRequest) Finished() bool {
+	return r.Phase == DeployedPhase || r.Phase == FailedPhase
+}
@@ -0,0 +1,15 @@
+// Package models for database models
+package models
+
+import "time"
+
+// EmailTemplate struct holds an admin editable mail template in a language
+type EmailTemplate struct {
+	Name     string `json:"name" gorm:"primaryKey"`
+	Language string `json:"language" gorm:"primaryKey"`
+	// text template of the mail subject
+	Subject string `json:"subject" binding:"required"`
+	// html template of the mail content
+	Body      string    `json:"body" binding:"required"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
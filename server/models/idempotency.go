@@ -0,0 +1,16 @@
+// Package models for database models
+package models
+
+import "time"
+
+// IdempotencyKey struct holds a request sent with an idempotency key and its stored response
+type IdempotencyKey struct {
+	Key         string `gorm:"primaryKey"`
+	UserID      string `gorm:"primaryKey"`
+	RequestHash string
+	// zero until the request is handled
+	Status    int
+	Body      []byte
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
@@ -1,19 +1,100 @@
 // Package models for database models
 package models
 
+import "time"
+
 const (
 	// VMsType deployment
 	VMsType = "vms"
 	// K8sType deployment
 	K8sType = "k8s"
+	// VoucherType notification
+	VoucherType = "voucher"
+	// AnnouncementType notification
+	AnnouncementType = "announcement"
+	// BalanceType notification of admins when the balance of the account is low
+	BalanceType = "balance"
 )
 
 // Notification struct holds data of notifications
 type Notification struct {
 	ID     int    `json:"id" gorm:"primaryKey"`
-	UserID string `json:"user_id"  binding:"required"`
+	UserID string `json:"user_id"  binding:"required" gorm:"index"`
 	Msg    string `json:"msg" binding:"required"`
 	Seen   bool   `json:"seen" binding:"required"`
 	// to allow redirecting from notifications to the right pages
 	Type string `json:"type" binding:"required"`
+	// ids of what the notification is about, to link to it
+	RequestID      string    `json:"request_id,omitempty"`
+	DeploymentID   int       `json:"deployment_id,omitempty"`
+	VoucherID      int       `json:"voucher_id,omitempty"`
+	AnnouncementID int       `json:"announcement_id,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// NotificationsFilter struct filters and paginates the notifications of a user
+type NotificationsFilter struct {
+	Type   string
+	Unread bool
+	// only notifications older than the notification with this id are listed if set
+	Before int
+	// all notifications are listed if not set
+	Limit int
+}
+
+// categories of notifications users set their preferences for
+const (
+	// DeploymentsCategory results of vms and kubernetes clusters deployments
+	DeploymentsCategory = "deployments"
+	// VouchersCategory decisions on voucher requests
+	VouchersCategory = "vouchers"
+	// AnnouncementsCategory announcements of admins
+	AnnouncementsCategory = "announcements"
+	// ExpiryCategory warnings before deployments expire, like the low balance of the account paying for them
+	ExpiryCategory = "expiry"
+)
+
+// NotificationCategories are the categories of notifications
+var NotificationCategories = []string{DeploymentsCategory, VouchersCategory, AnnouncementsCategory, ExpiryCategory}
+
+// NotificationCategory returns the category of a notification type
+func NotificationCategory(notificationType string) string {
+	switch notificationType {
+	case VMsType, K8sType:
+		return DeploymentsCategory
+	case VoucherType:
+		return VouchersCategory
+	case AnnouncementType:
+		return AnnouncementsCategory
+	case BalanceType:
+		return ExpiryCategory
+	}
+	return notificationType
+}
+
+// NotificationChannels struct holds the channels a user gets the notifications of a category on
+type NotificationChannels struct {
+	InApp   bool `json:"in_app"`
+	Email   bool `json:"email"`
+	Webhook bool `json:"webhook"`
+}
+
+// NotificationPreferences struct holds the notification preferences of a user
+type NotificationPreferences struct {
+	UserID string `json:"user_id" gorm:"primaryKey"`
+	// channels of each category, all channels are enabled for categories that are not set
+	Categories map[string]NotificationChannels `json:"categories" gorm:"serializer:json"`
+	// emails of notifications are batched into a daily digest of the unread notifications
+	EmailDigest bool `json:"email_digest"`
+	// the last notification sent in a digest and when it was sent
+	LastDigestNotificationID int       `json:"-"`
+	LastDigestAt             time.Time `json:"-"`
+}
+
+// Channels returns the channels of a category
+func (p NotificationPreferences) Channels(category string) NotificationChannels {
+	if channels, ok := p.Categories[category]; ok {
+		return channels
+	}
+	return NotificationChannels{InApp: true, Email: true, Webhook: true}
 }
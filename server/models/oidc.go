@@ -0,0 +1,24 @@
+// Package models for database models
+package models
+
+import "time"
+
+// OIDCIdentity struct links a user to their account at an OpenID Connect provider
+type OIDCIdentity struct {
+	ID     int    `gorm:"primaryKey"`
+	UserID string `gorm:"index"`
+	// the provider name and the subject of the user's account at it
+	Provider  string `gorm:"uniqueIndex:idx_oidc_identity"`
+	Subject   string `gorm:"uniqueIndex:idx_oidc_identity"`
+	CreatedAt time.Time
+}
+
+// OIDCLoginState struct holds a started sign in with an OpenID Connect provider, it is used once
+type OIDCLoginState struct {
+	// hash of the state sent to the provider
+	Hash         string `gorm:"primaryKey"`
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
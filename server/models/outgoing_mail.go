@@ -0,0 +1,41 @@
+// Package models for database models
+package models
+
+import "time"
+
+const (
+	// MailPending the mail is waiting to be sent
+	MailPending = "pending"
+	// MailSending the mail is claimed by the mails worker
+	MailSending = "sending"
+	// MailSent the mail is sent successfully
+	MailSent = "sent"
+	// MailFailed the mail failed to be sent after all attempts
+	MailFailed = "failed"
+)
+
+// OutgoingMail struct holds a mail queued to be sent
+type OutgoingMail struct {
+	ID       int    `json:"id" gorm:"primaryKey"`
+	Receiver string `json:"receiver" binding:"required"`
+	Subject  string `json:"subject" binding:"required"`
+	// the body is deleted once the mail is sent, it may carry codes and links
+	Body     string `json:"-"`
+	Status   string `json:"status" gorm:"index"`
+	Attempts int    `json:"attempts"`
+	// error of the last failed attempt
+	Error         string    `json:"error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at" gorm:"index"`
+	SentAt        time.Time `json:"sent_at,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// MailsFilter struct filters and paginates the outgoing mails
+type MailsFilter struct {
+	// all statuses are listed if not set
+	Status string
+	// only mails older than the mail with this id are listed if set
+	Before int
+	// all mails are listed if not set
+	Limit int
+}
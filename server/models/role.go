@@ -0,0 +1,113 @@
+// Package models for database models
+package models
+
+import (
+	"slices"
+	"sort"
+)
+
+// roles of users
+const (
+	// SuperAdminRole has all permissions
+	SuperAdminRole = "super_admin"
+	// VoucherReviewerRole reviews the vouchers users apply for
+	VoucherReviewerRole = "voucher_reviewer"
+	// SupportRole reads users, vouchers, deployments and mails to help users
+	SupportRole = "support"
+	// InstructorRole gets their deployments scheduled before students
+	InstructorRole = "instructor"
+)
+
+// permissions of roles
+const (
+	// PermissionReadUsers allows listing users
+	PermissionReadUsers = "users:read"
+	// PermissionManageUsers allows resetting quotas and signing users out
+	PermissionManageUsers = "users:manage"
+	// PermissionManageRoles allows changing the roles of users
+	PermissionManageRoles = "roles:manage"
+	// PermissionReadVouchers allows listing vouchers
+	PermissionReadVouchers = "vouchers:read"
+	// PermissionReviewVouchers allows generating, approving and rejecting vouchers
+	PermissionReviewVouchers = "vouchers:review"
+	// PermissionReadDeployments allows listing and counting the deployments of all users
+	PermissionReadDeployments = "deployments:read"
+	// PermissionDeleteDeployments allows deleting the deployments of all users
+	PermissionDeleteDeployments = "deployments:delete"
+	// PermissionPrioritizeDeployments schedules the deployments of the user before students
+	PermissionPrioritizeDeployments = "deployments:prioritize"
+	// PermissionManageAnnouncements allows creating and listing announcements
+	PermissionManageAnnouncements = "announcements:manage"
+	// PermissionManageMaintenance allows setting the maintenance
+	PermissionManageMaintenance = "maintenance:manage"
+	// PermissionReadBalance allows reading the balance of the grid account
+	PermissionReadBalance = "balance:read"
+	// PermissionReadMails allows listing mails and previewing mail templates
+	PermissionReadMails = "mails:read"
+	// PermissionManageMails allows resending mails and editing mail templates
+	PermissionManageMails = "mails:manage"
+	// PermissionReadCluster allows listing the leaders of the server instances
+	PermissionReadCluster = "cluster:read"
+)
+
+// RolePermissions are the permissions of each role
+var RolePermissions = map[string][]string{
+	SuperAdminRole: {
+		PermissionReadUsers, PermissionManageUsers, PermissionManageRoles,
+		PermissionReadVouchers, PermissionReviewVouchers,
+		PermissionReadDeployments, PermissionDeleteDeployments, PermissionPrioritizeDeployments,
+		PermissionManageAnnouncements, PermissionManageMaintenance, PermissionReadBalance,
+		PermissionReadMails, PermissionManageMails, PermissionReadCluster,
+	},
+	VoucherReviewerRole: {PermissionReadUsers, PermissionReadVouchers, PermissionReviewVouchers},
+	SupportRole: {
+		PermissionReadUsers, PermissionReadVouchers, PermissionReadDeployments,
+		PermissionReadBalance, PermissionReadMails, PermissionReadCluster,
+	},
+	InstructorRole: {PermissionPrioritizeDeployments},
+}
+
+// Roles returns the names of all roles
+func Roles() []string {
+	roles := make([]string, 0, len(RolePermissions))
+	for role := range RolePermissions {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// HasPermission checks if any of the roles has the permission
+func HasPermission(roles []string, permission string) bool {
+	for _, role := range roles {
+		if slices.Contains(RolePermissions[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions returns the permissions of the roles
+func Permissions(roles []string) []string {
+	permissions := []string{}
+	for _, role := range roles {
+		for _, permission := range RolePermissions[role] {
+			if !slices.Contains(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+// RolesWithPermission returns the roles that have the permission
+func RolesWithPermission(permission string) []string {
+	roles := []string{}
+	for _, role := range Roles() {
+		if slices.Contains(RolePermissions[role], permission) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
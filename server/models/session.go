@@ -0,0 +1,50 @@
+// Package models for database models
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrRefreshTokenReused is returned when a rotated refresh token is used again, its session is revoked
+var ErrRefreshTokenReused = errors.New("refresh token is already used")
+
+// Session struct holds a signed in session of a user, access tokens of revoked sessions are rejected
+type Session struct {
+	ID     string `json:"id" gorm:"primaryKey"`
+	UserID string `json:"user_id" gorm:"index"`
+	// the session expires if its refresh token isn't used before this time
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	// the last time the session was used, it is updated at most once per minute
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// the address the session was last used from
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	// whether the session is the one listing the sessions
+	Current bool `json:"current" gorm:"-"`
+}
+
+// BeforeCreate generates a new uuid
+func (s *Session) BeforeCreate(tx *gorm.DB) (err error) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return err
+	}
+
+	s.ID = id.String()
+	return
+}
+
+// RefreshToken struct holds the hash of a refresh token of a session,
+// rotated tokens are kept to detect their reuse
+type RefreshToken struct {
+	Hash      string `gorm:"primaryKey"`
+	SessionID string `gorm:"index"`
+	Rotated   bool
+	CreatedAt time.Time
+}
@@ -0,0 +1,21 @@
+// Package models for database models
+package models
+
+import "time"
+
+// RecoveryCode struct holds the hash of a one-time recovery code of a user with two-factor authentication
+type RecoveryCode struct {
+	ID        int    `gorm:"primaryKey"`
+	UserID    string `gorm:"index"`
+	Hash      string `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// TwoFactorChallenge struct holds the hash of a token given on sign in to users with two-factor authentication,
+// it is exchanged with a session with the user's code
+type TwoFactorChallenge struct {
+	Hash      string `gorm:"primaryKey"`
+	UserID    string
+	Attempts  int
+	ExpiresAt time.Time
+}
@@ -15,14 +15,20 @@ type User struct {
 	Email          string    `json:"email" gorm:"unique" binding:"required"`
 	HashedPassword []byte    `json:"hashed_password" binding:"required"`
 	UpdatedAt      time.Time `json:"updated_at"`
-	Code           int       `json:"code"`
 	SSHKey         string    `json:"ssh_key"`
 	Verified       bool      `json:"verified"`
 	TeamSize       int       `json:"team_size" binding:"required"`
 	ProjectDesc    string    `json:"project_desc" binding:"required"`
 	College        string    `json:"college" binding:"required"`
-	// checks if user type is admin
-	Admin bool `json:"admin"`
+	// language of the mails sent to the user
+	Language string `json:"language" gorm:"default:en"`
+	// roles giving the user their permissions
+	Roles []string `json:"roles" gorm:"serializer:json"`
+	// secret of the user's authenticator app, it is set on enrollment and used once confirmed
+	TOTPSecret  string `json:"-"`
+	TOTPEnabled bool   `json:"totp_enabled"`
+	// the time step of the last used code, used codes are rejected
+	TOTPLastStep int64 `json:"-"`
 }
 
 // BeforeCreate generates a new uuid
@@ -44,13 +50,13 @@ type UserUsedQuota struct {
 	HashedPassword []byte    `json:"hashed_password"`
 	Voucher        string    `json:"voucher"`
 	UpdatedAt      time.Time `json:"updated_at"`
-	Code           int       `json:"code"`
 	SSHKey         string    `json:"ssh_key"`
 	Verified       bool      `json:"verified"`
 	TeamSize       int       `json:"team_size"`
 	ProjectDesc    string    `json:"project_desc"`
 	College        string    `json:"college"`
-	Admin          bool      `json:"admin"`
+	Language       string    `json:"language"`
+	Roles          []string  `json:"roles" gorm:"serializer:json"`
 	Vms            int       `json:"vms"`
 	PublicIPs      int       `json:"public_ips"`
 	UsedVms        int       `json:"used_vms"`
@@ -0,0 +1,29 @@
+// Package models for database models
+package models
+
+import "time"
+
+// purposes of verification tokens
+const (
+	SignUpPurpose        = "signup"
+	ResetPasswordPurpose = "reset_password"
+	EmailChangePurpose   = "email_change"
+	// EmailRevertPurpose tokens are sent to the old email of a user to revert changing it
+	EmailRevertPurpose = "email_revert"
+)
+
+// VerificationToken struct holds the hashes of a code and a link token mailed to a user to verify their email,
+// a user has one token of each purpose and it is used once
+type VerificationToken struct {
+	ID      int    `gorm:"primaryKey"`
+	UserID  string `gorm:"index"`
+	Purpose string
+	// the email the token is sent to
+	Email     string
+	CodeHash  string
+	LinkHash  string `gorm:"uniqueIndex"`
+	Attempts  int
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
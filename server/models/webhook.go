@@ -0,0 +1,76 @@
+// Package models for database models
+package models
+
+import "time"
+
+const (
+	// EventVMDeployed a vm of the user is deployed
+	EventVMDeployed = "vm.deployed"
+	// EventVMFailed a vm of the user failed to be deployed
+	EventVMFailed = "vm.failed"
+	// EventK8sDeployed a kubernetes cluster of the user is deployed
+	EventK8sDeployed = "k8s.deployed"
+	// EventK8sFailed a kubernetes cluster of the user failed to be deployed
+	EventK8sFailed = "k8s.failed"
+	// EventVoucherApproved a voucher request of the user is approved
+	EventVoucherApproved = "voucher.approved"
+	// EventVoucherRejected a voucher request of the user is rejected
+	EventVoucherRejected = "voucher.rejected"
+	// EventBalanceLow the account balance is below the threshold, it is sent to admins only
+	EventBalanceLow = "balance.low"
+	// EventPing a test event sent on request
+	EventPing = "ping"
+)
+
+// Events are the events webhooks can subscribe to
+var Events = []string{EventVMDeployed, EventVMFailed, EventK8sDeployed, EventK8sFailed, EventVoucherApproved, EventVoucherRejected, EventBalanceLow}
+
+const (
+	// DeliveryPending the delivery is waiting to be sent
+	DeliveryPending = "pending"
+	// DeliverySending the delivery is claimed by the webhooks worker
+	DeliverySending = "sending"
+	// DeliveryDelivered the endpoint accepted the delivery
+	DeliveryDelivered = "delivered"
+	// DeliveryFailed the delivery failed after all attempts
+	DeliveryFailed = "failed"
+)
+
+// Webhook struct holds an endpoint notified with the events of its user
+type Webhook struct {
+	ID     int    `json:"id" gorm:"primaryKey"`
+	UserID string `json:"user_id" gorm:"index"`
+	URL    string `json:"url" binding:"required"`
+	// events the webhook is subscribed to, it is subscribed to all events if empty
+	Events []string `json:"events" gorm:"serializer:json"`
+	// admin webhooks can get the events of all users
+	AllUsers bool `json:"all_users"`
+	// deliveries are signed with the secret using HMAC-SHA256
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookEvent struct is the payload of webhook deliveries
+type WebhookEvent struct {
+	Event string `json:"event"`
+	// user the event is about, empty for admin events
+	UserID    string      `json:"user_id,omitempty"`
+	Data      interface{} `json:"data"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// WebhookDelivery struct holds an event delivery to a webhook
+type WebhookDelivery struct {
+	ID        int    `json:"id" gorm:"primaryKey"`
+	WebhookID int    `json:"webhook_id" gorm:"index"`
+	Event     string `json:"event"`
+	Payload   string `json:"payload"`
+	Status    string `json:"status" gorm:"index"`
+	Attempts  int    `json:"attempts"`
+	// response status code and error of the last attempt
+	ResponseCode  int       `json:"response_code,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at" gorm:"index"`
+	DeliveredAt   time.Time `json:"delivered_at,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
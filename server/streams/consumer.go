@@ -2,21 +2,29 @@
 package streams
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
 )
 
-func (r *RedisClient) Read(stream, group string, count int64, pending bool) (result []redis.XStream, err error) {
+const consumerKeyPrefix = "consumer:"
+
+// Read reads the messages of the stream delivered to the consumer of the group,
+// the new messages or the pending messages of the consumer if pending is set
+func (r *RedisClient) Read(stream, group, consumer string, count int64, pending bool) (result []redis.XStream, err error) {
 	IDs := ">"
 	if pending {
 		IDs = "0"
 	}
 
 	args := redis.XReadGroupArgs{
-		Streams: []string{stream, IDs},
-		Group:   group,
-		Block:   1 * time.Second,
+		Streams:  []string{stream, IDs},
+		Group:    group,
+		Consumer: consumer,
+		Block:    1 * time.Second,
 	}
 
 	if count != 0 {
@@ -26,3 +34,154 @@ func (r *RedisClient) Read(stream, group string, count int64, pending bool) (res
 	result, err = r.DB.XReadGroup(&args).Result()
 	return
 }
+
+// KeepAlive marks the consumer as alive for the ttl, the pending messages of
+// consumers that are not alive are claimed by the other consumers
+func (r *RedisClient) KeepAlive(consumer string, ttl time.Duration) error {
+	return r.DB.Set(consumerKeyPrefix+consumer, 1, ttl).Err()
+}
+
+// ClaimDead claims the pending messages of the group's consumers that are not alive to the consumer
+func (r *RedisClient) ClaimDead(stream, group, consumer string) ([]redis.XMessage, error) {
+	pending, err := r.DB.XPending(stream, group).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []redis.XMessage
+	for name, count := range pending.Consumers {
+		if name == consumer {
+			continue
+		}
+
+		alive, err := r.DB.Exists(consumerKeyPrefix + name).Result()
+		if err != nil {
+			return claimed, err
+		}
+		if alive == 1 {
+			continue
+		}
+
+		entries, err := r.DB.XPendingExt(&redis.XPendingExtArgs{
+			Stream:   stream,
+			Group:    group,
+			Start:    "-",
+			End:      "+",
+			Count:    count,
+			Consumer: name,
+		}).Result()
+		if err != nil {
+			return claimed, err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		ids := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			ids = append(ids, entry.Id)
+		}
+
+		messages, err := r.DB.XClaim(&redis.XClaimArgs{
+			Stream:   stream,
+			Group:    group,
+			Consumer: consumer,
+			Messages: ids,
+		}).Result()
+		if err != nil {
+			return claimed, err
+		}
+		claimed = append(claimed, messages...)
+	}
+
+	return claimed, nil
+}
+
+// Unacknowledged returns the messages of the stream the group didn't acknowledge in the stream order,
+// the messages delivered to consumers of the group and the messages not delivered yet
+func (r *RedisClient) Unacknowledged(stream, group string) ([]redis.XMessage, error) {
+	lastDelivered, err := r.lastDeliveredID(stream, group)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := r.DB.XPending(stream, group).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	start := lastDelivered
+	delivered := map[string]bool{}
+	if pending.Count > 0 {
+		start = pending.Lower
+
+		entries, err := r.DB.XPendingExt(&redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  group,
+			Start:  "-",
+			End:    "+",
+			Count:  pending.Count,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			delivered[entry.Id] = true
+		}
+	}
+
+	messages, err := r.DB.XRange(stream, start, "+").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var res []redis.XMessage
+	for _, message := range messages {
+		if delivered[message.ID] || streamIDAfter(message.ID, lastDelivered) {
+			res = append(res, message)
+		}
+	}
+
+	return res, nil
+}
+
+// lastDeliveredID returns the id of the last message of the stream delivered to the group
+func (r *RedisClient) lastDeliveredID(stream, group string) (string, error) {
+	groups, err := r.DB.Do("XINFO", "GROUPS", stream).Result()
+	if err != nil {
+		return "", err
+	}
+
+	infos, _ := groups.([]interface{})
+	for _, info := range infos {
+		fields, _ := info.([]interface{})
+
+		values := map[string]string{}
+		for i := 0; i+1 < len(fields); i += 2 {
+			key, _ := fields[i].(string)
+			value, _ := fields[i+1].(string)
+			values[key] = value
+		}
+
+		if values["name"] == group {
+			return values["last-delivered-id"], nil
+		}
+	}
+
+	return "", fmt.Errorf("group %s of stream %s is not found", group, stream)
+}
+
+// streamIDAfter returns true if the stream id is after the other one, ids are <milliseconds>-<sequence>
+func streamIDAfter(id, other string) bool {
+	ms, seq := parseStreamID(id)
+	otherMS, otherSeq := parseStreamID(other)
+	return ms > otherMS || (ms == otherMS && seq > otherSeq)
+}
+
+func parseStreamID(id string) (uint64, uint64) {
+	msPart, seqPart, _ := strings.Cut(id, "-")
+	ms, _ := strconv.ParseUint(msPart, 10, 64)
+	seq, _ := strconv.ParseUint(seqPart, 10, 64)
+	return ms, seq
+}
@@ -0,0 +1,103 @@
+package streams
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimDead(t *testing.T) {
+	client := testRedis(t)
+	stream, group := "test-req", "test-req-group"
+
+	err := client.DB.XGroupCreateMkStream(stream, group, "$").Err()
+	assert.NoError(t, err)
+
+	for _, value := range []string{"first", "second"} {
+		err := client.DB.XAdd(&redis.XAddArgs{Stream: stream, Values: map[string]interface{}{"request": value}}).Err()
+		assert.NoError(t, err)
+	}
+
+	// each worker consumes a request and doesn't acknowledge it
+	_, err = client.Read(stream, group, "alive", 1, false)
+	assert.NoError(t, err)
+	_, err = client.Read(stream, group, "dead", 1, false)
+	assert.NoError(t, err)
+
+	err = client.KeepAlive("alive", time.Minute)
+	assert.NoError(t, err)
+	err = client.KeepAlive("leader", time.Minute)
+	assert.NoError(t, err)
+
+	claimed, err := client.ClaimDead(stream, group, "leader")
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 1)
+	assert.Equal(t, "second", claimed[0].Values["request"])
+
+	// claimed requests are pending for the leader now
+	pending, err := client.Read(stream, group, "leader", 0, true)
+	assert.NoError(t, err)
+	assert.Len(t, pending[0].Messages, 1)
+
+	claimed, err = client.ClaimDead(stream, group, "leader")
+	assert.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+func TestUnacknowledged(t *testing.T) {
+	client := testRedis(t)
+	stream, group := "test-unacked", "test-unacked-group"
+
+	err := client.DB.XGroupCreateMkStream(stream, group, "$").Err()
+	assert.NoError(t, err)
+
+	for _, value := range []string{"acked", "pending", "undelivered"} {
+		err := client.DB.XAdd(&redis.XAddArgs{Stream: stream, Values: map[string]interface{}{"request": value}}).Err()
+		assert.NoError(t, err)
+	}
+
+	result, err := client.Read(stream, group, "worker", 2, false)
+	assert.NoError(t, err)
+	err = client.DB.XAck(stream, group, result[0].Messages[0].ID).Err()
+	assert.NoError(t, err)
+
+	messages, err := client.Unacknowledged(stream, group)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 2)
+	assert.Equal(t, "pending", messages[0].Values["request"])
+	assert.Equal(t, "undelivered", messages[1].Values["request"])
+
+	_, err = client.Unacknowledged(stream, "missing-group")
+	assert.Error(t, err)
+}
+
+func TestStreamIDAfter(t *testing.T) {
+	assert.True(t, streamIDAfter("1700000000001-0", "1700000000000-5"))
+	assert.True(t, streamIDAfter("1700000000000-10", "1700000000000-9"))
+	assert.False(t, streamIDAfter("1700000000000-5", "1700000000000-5"))
+	assert.False(t, streamIDAfter("0-0", "1700000000000-0"))
+}
+
+func TestDeployedNotifications(t *testing.T) {
+	client := testRedis(t)
+
+	t.Run("notified before waiting", func(t *testing.T) {
+		err := client.NotifyDeployed("first", DeployedContracts{12: {1, 2}})
+		assert.NoError(t, err)
+
+		contracts, err := client.WaitDeployed(context.Background(), "first")
+		assert.NoError(t, err)
+		assert.Equal(t, DeployedContracts{12: {1, 2}}, contracts)
+	})
+
+	t.Run("context is done", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		_, err := client.WaitDeployed(ctx, "second")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
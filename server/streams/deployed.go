@@ -0,0 +1,65 @@
+// Package streams for redis streams
+package streams
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	deployedKeyPrefix = "deployed:"
+	// deployedTTL is how long a deployment notification waits for its worker
+	deployedTTL = time.Hour
+)
+
+// DeployedContracts holds the contracts a request is deployed with on each node
+type DeployedContracts map[uint32][]uint64
+
+// NotifyDeployed wakes the worker waiting for the batch deployment of the request with its contracts,
+// the worker may run in another process than the one deploying the batch so it loads the deployments with them
+func (r *RedisClient) NotifyDeployed(requestID string, contracts DeployedContracts) error {
+	key := deployedKeyPrefix + requestID
+
+	value, err := json.Marshal(contracts)
+	if err != nil {
+		return err
+	}
+
+	pipe := r.DB.TxPipeline()
+	pipe.RPush(key, value)
+	pipe.Expire(key, deployedTTL)
+	_, err = pipe.Exec()
+	return err
+}
+
+// WaitDeployed blocks until the batch deployment of the request is notified or the context is done
+// and returns the contracts the request is deployed with
+func (r *RedisClient) WaitDeployed(ctx context.Context, requestID string) (DeployedContracts, error) {
+	for {
+		res, err := r.DB.BLPop(time.Second, deployedKeyPrefix+requestID).Result()
+		if err == nil {
+			var contracts DeployedContracts
+			// the value follows the key
+			err = json.Unmarshal([]byte(res[1]), &contracts)
+			return contracts, err
+		}
+
+		if !errors.Is(err, redis.Nil) {
+			// the notification is kept until redis is reachable again
+			log.Error().Err(err).Msgf("failed to wait for the deployment of request with ID: %s", requestID)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Package streams for redis streams
+package streams
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/rs/zerolog/log"
+)
+
+const leaderKeyPrefix = "leader:"
+
+// acquire the lock if it is free or already held by the same instance
+var acquireLeaderScript = redis.NewScript(`
+local holder = redis.call("get", KEYS[1])
+if holder == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+if holder == false then
+	redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0`)
+
+// renew the lock ttl only if it is still held by the same instance
+var renewLeaderScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// release the lock only if it is still held by the same instance
+var releaseLeaderScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+// leaderLock is the lock held by the leader of a role
+type leaderLock interface {
+	// acquire takes the lock for the ttl if it is free or already held by the instance
+	acquire(key, instance string, ttl time.Duration) (bool, error)
+	// renew extends the lock for the ttl if it is still held by the instance
+	renew(key, instance string, ttl time.Duration) (bool, error)
+	// release frees the lock if it is still held by the instance
+	release(key, instance string) error
+}
+
+type redisLeaderLock struct {
+	db *redis.Client
+}
+
+func (l redisLeaderLock) acquire(key, instance string, ttl time.Duration) (bool, error) {
+	acquired, err := acquireLeaderScript.Run(l.db, []string{key}, instance, ttl.Milliseconds()).Int64()
+	return acquired == 1, err
+}
+
+func (l redisLeaderLock) renew(key, instance string, ttl time.Duration) (bool, error) {
+	renewed, err := renewLeaderScript.Run(l.db, []string{key}, instance, ttl.Milliseconds()).Int64()
+	return renewed == 1, err
+}
+
+func (l redisLeaderLock) release(key, instance string) error {
+	return releaseLeaderScript.Run(l.db, []string{key}, instance).Err()
+}
+
+// LeaderElection elects a single instance to run the jobs of a role using a redis lock,
+// the lock expires if the leader fails to renew it so another instance takes over
+type LeaderElection struct {
+	lock     leaderLock
+	role     string
+	instance string
+	ttl      time.Duration
+	leading  atomic.Bool
+}
+
+// NewLeaderElection creates a new leader election of the instance for the role
+func NewLeaderElection(redis RedisClient, role, instance string, ttl time.Duration) *LeaderElection {
+	return &LeaderElection{
+		lock:     redisLeaderLock{redis.DB},
+		role:     role,
+		instance: instance,
+		ttl:      ttl,
+	}
+}
+
+// IsLeader returns true if the instance is currently the leader
+func (e *LeaderElection) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// Run campaigns for leadership until the context is done, lead is called each time
+// the instance becomes the leader with a context that is cancelled once the leadership is lost.
+// If renewing fails, the leader steps down about a third of the ttl before its lock expires
+// and gives up the leadership once lead returns, so lead has to return quickly once its context is cancelled.
+// If lead returns while the instance still leads, the lock is released so any instance can be elected again.
+func (e *LeaderElection) Run(ctx context.Context, lead func(ctx context.Context)) {
+	interval := e.ttl / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var stop func()
+	// closed once lead returns
+	var leadDone <-chan struct{}
+	// time the lock was last acquired or renewed
+	var heldSince time.Time
+
+	stepDown := func() {
+		if stop != nil {
+			stop()
+			stop, leadDone = nil, nil
+		}
+		e.leading.Store(false)
+	}
+
+	release := func() {
+		stepDown()
+		if err := e.lock.release(e.key(), e.instance); err != nil {
+			log.Error().Err(err).Msgf("failed to release %s leadership", e.role)
+		}
+	}
+	defer release()
+
+	for {
+		// the lock expires at least a ttl after the attempt started
+		attempt := time.Now()
+		if e.IsLeader() {
+			renewed, err := e.lock.renew(e.key(), e.instance, e.ttl)
+			switch {
+			case err != nil && time.Since(heldSince) < e.ttl/2:
+				// the lock is still held until its ttl, renewing is retried on the next tick
+				log.Error().Err(err).Msgf("failed to renew %s leadership", e.role)
+			case err != nil || !renewed:
+				log.Info().Err(err).Msgf("instance %s lost %s leadership", e.instance, e.role)
+				stepDown()
+			default:
+				heldSince = attempt
+			}
+		} else {
+			acquired, err := e.lock.acquire(e.key(), e.instance, e.ttl)
+			if err != nil {
+				log.Error().Err(err).Msgf("failed to campaign for %s leadership", e.role)
+			}
+
+			if acquired {
+				log.Info().Msgf("instance %s is elected as %s leader", e.instance, e.role)
+				heldSince = attempt
+				e.leading.Store(true)
+
+				stop, leadDone = startLeading(ctx, lead)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-leadDone:
+			log.Info().Msgf("instance %s stopped leading %s", e.instance, e.role)
+			release()
+			// wait for the next tick before campaigning again
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// startLeading runs lead until the returned stop is called, stop waits for lead to return
+// and done is closed once lead returns
+func startLeading(ctx context.Context, lead func(ctx context.Context)) (stop func(), done <-chan struct{}) {
+	ctx, cancel := context.WithCancel(ctx)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		lead(ctx)
+	}()
+
+	return func() {
+		cancel()
+		<-finished
+	}, finished
+}
+
+func (e *LeaderElection) key() string {
+	return leaderKeyPrefix + e.role
+}
+
+// Leader returns the instance holding the leadership of the role, empty if there is no leader
+func (r *RedisClient) Leader(role string) (string, error) {
+	instance, err := r.DB.Get(leaderKeyPrefix + role).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+
+	return instance, err
+}
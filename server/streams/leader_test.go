@@ -0,0 +1,303 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testLeaderTTL = 150 * time.Millisecond
+
+// memoryLeaderLock is an in memory lock with the same semantics as the redis lock
+type memoryLeaderLock struct {
+	mu      sync.Mutex
+	holder  string
+	expires time.Time
+	// instances that can't reach the lock
+	unreachable sync.Map
+}
+
+func (l *memoryLeaderLock) reach(instance string) error {
+	if _, ok := l.unreachable.Load(instance); ok {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (l *memoryLeaderLock) held() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Now().After(l.expires) {
+		return ""
+	}
+	return l.holder
+}
+
+func (l *memoryLeaderLock) acquire(key, instance string, ttl time.Duration) (bool, error) {
+	if err := l.reach(instance); err != nil {
+		return false, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holder != instance && time.Now().Before(l.expires) {
+		return false, nil
+	}
+	l.holder, l.expires = instance, time.Now().Add(ttl)
+	return true, nil
+}
+
+func (l *memoryLeaderLock) renew(key, instance string, ttl time.Duration) (bool, error) {
+	if err := l.reach(instance); err != nil {
+		return false, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holder != instance || time.Now().After(l.expires) {
+		return false, nil
+	}
+	l.expires = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (l *memoryLeaderLock) release(key, instance string) error {
+	if err := l.reach(instance); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holder == instance {
+		l.holder, l.expires = "", time.Time{}
+	}
+	return nil
+}
+
+// testLeader counts the leadership terms of elections and the instances leading at the same time
+type testLeader struct {
+	terms   atomic.Int32
+	leading atomic.Int32
+	overlap atomic.Bool
+}
+
+func (l *testLeader) lead(ctx context.Context) {
+	l.terms.Add(1)
+	if l.leading.Add(1) > 1 {
+		l.overlap.Store(true)
+	}
+
+	<-ctx.Done()
+	// jobs take some time to stop
+	time.Sleep(testLeaderTTL / 10)
+	l.leading.Add(-1)
+}
+
+func runElection(ctx context.Context, lock leaderLock, instance string, leader *testLeader) (*LeaderElection, chan struct{}) {
+	election := &LeaderElection{lock: lock, role: "test", instance: instance, ttl: testLeaderTTL}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		election.Run(ctx, leader.lead)
+	}()
+
+	return election, done
+}
+
+func TestLeaderElection(t *testing.T) {
+	t.Run("acquire and renew", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		lock := &memoryLeaderLock{}
+		leader := &testLeader{}
+		election, _ := runElection(ctx, lock, "first", leader)
+
+		assert.Eventually(t, election.IsLeader, testLeaderTTL, time.Millisecond)
+		assert.Equal(t, "first", lock.held())
+
+		// the lock is renewed past its ttl in the same term
+		time.Sleep(3 * testLeaderTTL)
+		assert.True(t, election.IsLeader())
+		assert.Equal(t, "first", lock.held())
+		assert.Equal(t, int32(1), leader.terms.Load())
+	})
+
+	t.Run("only one instance leads", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		lock := &memoryLeaderLock{}
+		leader := &testLeader{}
+		first, _ := runElection(ctx, lock, "first", leader)
+		assert.Eventually(t, first.IsLeader, testLeaderTTL, time.Millisecond)
+
+		second, _ := runElection(ctx, lock, "second", leader)
+		time.Sleep(2 * testLeaderTTL)
+		assert.False(t, second.IsLeader())
+		assert.Equal(t, int32(1), leader.terms.Load())
+	})
+
+	t.Run("release on stop and takeover", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		lock := &memoryLeaderLock{}
+		leader := &testLeader{}
+		firstCtx, stopFirst := context.WithCancel(ctx)
+		first, firstDone := runElection(firstCtx, lock, "first", leader)
+		assert.Eventually(t, first.IsLeader, testLeaderTTL, time.Millisecond)
+
+		second, _ := runElection(ctx, lock, "second", leader)
+
+		stopFirst()
+		<-firstDone
+		// the jobs are stopped before the lock is released
+		assert.Equal(t, int32(0), leader.leading.Load())
+		assert.False(t, first.IsLeader())
+
+		assert.Eventually(t, second.IsLeader, testLeaderTTL, time.Millisecond)
+		assert.Equal(t, "second", lock.held())
+		assert.False(t, leader.overlap.Load())
+	})
+
+	t.Run("transient renew errors are tolerated", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		lock := &memoryLeaderLock{}
+		leader := &testLeader{}
+		election, _ := runElection(ctx, lock, "first", leader)
+		assert.Eventually(t, election.IsLeader, testLeaderTTL, time.Millisecond)
+
+		// a single failed renewal
+		lock.unreachable.Store("first", true)
+		time.Sleep(testLeaderTTL / 4)
+		lock.unreachable.Delete("first")
+
+		time.Sleep(testLeaderTTL)
+		assert.True(t, election.IsLeader())
+		assert.Equal(t, int32(1), leader.terms.Load())
+	})
+
+	t.Run("takeover after the leader fails to renew", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		lock := &memoryLeaderLock{}
+		leader := &testLeader{}
+		first, _ := runElection(ctx, lock, "first", leader)
+		assert.Eventually(t, first.IsLeader, testLeaderTTL, time.Millisecond)
+
+		second, _ := runElection(ctx, lock, "second", leader)
+
+		lock.unreachable.Store("first", true)
+		assert.Eventually(t, second.IsLeader, 3*testLeaderTTL, time.Millisecond)
+		assert.False(t, first.IsLeader())
+		assert.False(t, leader.overlap.Load())
+	})
+
+	t.Run("the lock is released once lead returns", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		lock := &memoryLeaderLock{}
+		var terms atomic.Int32
+		election := &LeaderElection{lock: lock, role: "test", instance: "first", ttl: testLeaderTTL}
+		go election.Run(ctx, func(ctx context.Context) { terms.Add(1) })
+
+		assert.Eventually(t, func() bool { return terms.Load() == 1 }, testLeaderTTL, time.Millisecond)
+		assert.Eventually(t, func() bool { return !election.IsLeader() && lock.held() == "" }, testLeaderTTL/3, time.Millisecond)
+
+		// the instance campaigns again
+		assert.Eventually(t, func() bool { return terms.Load() == 2 }, testLeaderTTL, time.Millisecond)
+	})
+
+	t.Run("the leader acquires its own lock again", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		lock := &memoryLeaderLock{}
+		acquired, err := lock.acquire("", "first", time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, acquired)
+
+		leader := &testLeader{}
+		election, _ := runElection(ctx, lock, "first", leader)
+		assert.Eventually(t, election.IsLeader, testLeaderTTL, time.Millisecond)
+	})
+}
+
+func TestRedisLeaderLock(t *testing.T) {
+	client := testRedis(t)
+	lock := redisLeaderLock{client.DB}
+	key := leaderKeyPrefix + "test"
+
+	acquired, err := lock.acquire(key, "first", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	leader, err := client.Leader("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "first", leader)
+
+	t.Run("acquire held lock", func(t *testing.T) {
+		acquired, err := lock.acquire(key, "second", time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, acquired)
+
+		// the holder acquires its unexpired lock again
+		acquired, err = lock.acquire(key, "first", time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, acquired)
+	})
+
+	t.Run("renew", func(t *testing.T) {
+		renewed, err := lock.renew(key, "second", time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, renewed)
+
+		renewed, err = lock.renew(key, "first", 2*time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, renewed)
+		assert.Greater(t, client.DB.PTTL(key).Val(), time.Minute)
+	})
+
+	t.Run("release", func(t *testing.T) {
+		err := lock.release(key, "second")
+		assert.NoError(t, err)
+		leader, err := client.Leader("test")
+		assert.NoError(t, err)
+		assert.Equal(t, "first", leader)
+
+		err = lock.release(key, "first")
+		assert.NoError(t, err)
+		leader, err = client.Leader("test")
+		assert.NoError(t, err)
+		assert.Empty(t, leader)
+	})
+
+	t.Run("takeover after expiry", func(t *testing.T) {
+		acquired, err := lock.acquire(key, "first", 50*time.Millisecond)
+		assert.NoError(t, err)
+		assert.True(t, acquired)
+
+		time.Sleep(100 * time.Millisecond)
+		acquired, err = lock.acquire(key, "second", time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, acquired)
+
+		renewed, err := lock.renew(key, "first", time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, renewed)
+	})
+}
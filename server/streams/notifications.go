@@ -0,0 +1,38 @@
+// Package streams for redis streams
+package streams
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/codescalers/cloud4students/models"
+	"github.com/go-redis/redis"
+)
+
+// notificationsChannel is the pub/sub channel of the notifications of a user
+func notificationsChannel(userID string) string {
+	return fmt.Sprintf("notifications:%s", userID)
+}
+
+// PublishNotification publishes a notification to the channel of its user
+func (r *RedisClient) PublishNotification(n models.Notification) error {
+	bytes, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	return r.DB.Publish(notificationsChannel(n.UserID), bytes).Err()
+}
+
+// SubscribeNotifications subscribes to the notifications of a user, the subscription must be closed when done
+func (r *RedisClient) SubscribeNotifications(userID string) (*redis.PubSub, error) {
+	pubsub := r.DB.Subscribe(notificationsChannel(userID))
+
+	// wait for the subscription so notifications published after it returns are not missed
+	if _, err := pubsub.Receive(); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+
+	return pubsub, nil
+}
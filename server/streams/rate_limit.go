@@ -0,0 +1,54 @@
+// Package streams for redis streams
+package streams
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// count a hit of the key, its window starts with its first hit
+var hitScript = redis.NewScript(`
+local count = redis.call("incr", KEYS[1])
+if count == 1 then
+	redis.call("pexpire", KEYS[1], ARGV[1])
+end
+return {count, redis.call("pttl", KEYS[1])}`)
+
+// Hit counts a hit of the key and returns its count in the current window and the time left of the window
+func (r *RedisClient) Hit(key string, window time.Duration) (int64, time.Duration, error) {
+	res, err := hitScript.Run(r.DB, []string{key}, window.Milliseconds()).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 2 {
+		return 0, 0, fmt.Errorf("unexpected hit result %v", res)
+	}
+	count, _ := values[0].(int64)
+	ttl, _ := values[1].(int64)
+
+	return count, time.Duration(ttl) * time.Millisecond, nil
+}
+
+// Lock locks the key out for a duration
+func (r *RedisClient) Lock(key string, duration time.Duration) error {
+	return r.DB.Set(key, 1, duration).Err()
+}
+
+// LockedFor returns the time left of the lockout of the key, zero if it is not locked out
+func (r *RedisClient) LockedFor(key string) (time.Duration, error) {
+	ttl, err := r.DB.PTTL(key).Result()
+	if err != nil || ttl < 0 {
+		return 0, err
+	}
+
+	return ttl, nil
+}
+
+// Reset deletes the counters and lockouts of the keys
+func (r *RedisClient) Reset(keys ...string) error {
+	return r.DB.Del(keys...).Err()
+}
@@ -0,0 +1,62 @@
+package streams
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisRateLimitStore(t *testing.T) {
+	client := testRedis(t)
+
+	t.Run("hits of a window", func(t *testing.T) {
+		count, ttl, err := client.Hit("hits", time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+		assert.Greater(t, ttl, 59*time.Second)
+
+		// later hits don't extend the window
+		count, ttl, err = client.Hit("hits", 2*time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+		assert.LessOrEqual(t, ttl, time.Minute)
+	})
+
+	t.Run("window expires", func(t *testing.T) {
+		count, _, err := client.Hit("expires", 50*time.Millisecond)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		time.Sleep(100 * time.Millisecond)
+		count, _, err = client.Hit("expires", time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("lock", func(t *testing.T) {
+		lockedFor, err := client.LockedFor("lock")
+		assert.NoError(t, err)
+		assert.Zero(t, lockedFor)
+
+		err = client.Lock("lock", time.Minute)
+		assert.NoError(t, err)
+
+		lockedFor, err = client.LockedFor("lock")
+		assert.NoError(t, err)
+		assert.Greater(t, lockedFor, 59*time.Second)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		err := client.Reset("hits", "lock")
+		assert.NoError(t, err)
+
+		lockedFor, err := client.LockedFor("lock")
+		assert.NoError(t, err)
+		assert.Zero(t, lockedFor)
+
+		count, _, err := client.Hit("hits", time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+}
@@ -0,0 +1,34 @@
+package streams
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+// testRedisDB is the database the tests flush, so they don't touch the data of the default database
+const testRedisDB = 15
+
+// testRedis connects to the redis server at REDIS_ADDR or localhost,
+// tests using it are skipped if no server is running
+func testRedis(t *testing.T) RedisClient {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr, DB: testRedisDB})
+	if err := client.Ping().Err(); err != nil {
+		_ = client.Close()
+		t.Skipf("redis is not running at %s: %s", addr, err)
+	}
+
+	client.FlushDB()
+	t.Cleanup(func() {
+		client.FlushDB()
+		_ = client.Close()
+	})
+
+	return RedisClient{client}
+}
@@ -0,0 +1,49 @@
+// Package streams for redis streams
+package streams
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// batchTimingsLength is the number of batch timings kept for estimations
+const batchTimingsLength = 20
+
+// BatchTiming holds the size and duration of a deployed batch
+type BatchTiming struct {
+	Size     int
+	Duration time.Duration
+}
+
+// PushBatchTiming records the timing of a batch deployment
+func (r *RedisClient) PushBatchTiming(key string, timing BatchTiming) error {
+	bytes, err := json.Marshal(timing)
+	if err != nil {
+		return err
+	}
+
+	if err := r.DB.LPush(key, bytes).Err(); err != nil {
+		return err
+	}
+
+	return r.DB.LTrim(key, 0, batchTimingsLength-1).Err()
+}
+
+// BatchTimings returns the latest recorded batch deployment timings
+func (r *RedisClient) BatchTimings(key string) ([]BatchTiming, error) {
+	values, err := r.DB.LRange(key, 0, batchTimingsLength-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var timings []BatchTiming
+	for _, v := range values {
+		var timing BatchTiming
+		if err := json.Unmarshal([]byte(v), &timing); err != nil {
+			return nil, err
+		}
+		timings = append(timings, timing)
+	}
+
+	return timings, nil
+}
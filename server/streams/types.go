@@ -26,10 +26,16 @@ const (
 	ReqVMStreamName = "vms-req"
 	// ReqK8sStreamName stream name
 	ReqK8sStreamName = "k8s-req"
+
+	// VMBatchTimingsKey list of the latest vms batch deployment durations
+	VMBatchTimingsKey = "vms-batch-timings"
+	// K8sBatchTimingsKey list of the latest k8s batch deployment durations
+	K8sBatchTimingsKey = "k8s-batch-timings"
 )
 
 // VMDeployRequest type for redis vm deployment request
 type VMDeployRequest struct {
+	ID          string
 	User        models.User
 	Input       models.DeployVMInput
 	AdminSSHKey string
@@ -37,6 +43,7 @@ type VMDeployRequest struct {
 
 // K8sDeployRequest type for redis k8s deployment request
 type K8sDeployRequest struct {
+	ID          string
 	User        models.User
 	Input       models.K8sDeployInput
 	AdminSSHKey string
@@ -44,12 +51,14 @@ type K8sDeployRequest struct {
 
 // VMDeployment type for redis vm deployment
 type VMDeployment struct {
-	Net *workloads.ZNet
-	DL  *workloads.Deployment
+	RequestID string
+	Net       *workloads.ZNet
+	DL        *workloads.Deployment
 }
 
 // K8sDeployment type for redis k8s deployment
 type K8sDeployment struct {
-	Net *workloads.ZNet
-	DL  *workloads.K8sCluster
+	RequestID string
+	Net       *workloads.ZNet
+	DL        *workloads.K8sCluster
 }